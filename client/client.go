@@ -0,0 +1,210 @@
+// Package client 是此服务器的 Go 客户端, 使用项目自己的 RESP 编码与解析.
+//
+// Client 维护一个连接池, 可以被多个协程同时使用. 命令的截止时间与取消由 context 控制,
+// 连接发生错误时被丢弃, 命令在退避之后使用新的连接重试. 回复可以是 RESP2 或 RESP3 的任意类型, 包括嵌套的数组.
+package client
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"simple_kvstorage/resp/reply"
+	"simple_kvstorage/util/pool"
+	"time"
+)
+
+// Options 客户端的配置, 值为 0 的字段使用默认值
+type Options struct {
+	// Addr 服务器的地址, 默认为 127.0.0.1:6379
+	Addr string
+	// Dialer 建立连接的函数, 为 nil 时以 TCP 连接 Addr. 嵌入模式使用 server.Server.Conn 建立内存中的连接.
+	Dialer func() (net.Conn, error)
+	// DB 连接使用的数据库, ClientName 连接的名字 (CLIENT SETNAME)
+	DB         int
+	ClientName string
+	// Protocol 连接使用的协议版本, 2 (默认) 或 3. 为 3 时建立连接之后先发送 HELLO 3.
+	Protocol int
+	// InitCommands 建立连接之后依次执行的命令, 在 HELLO, CLIENT SETNAME 与 SELECT 之后发送.
+	// 任何一条命令失败时连接建立失败, 如集群节点之间的连接用它声明自己是转发连接.
+	InitCommands [][]any
+
+	// DialTimeout 建立连接的超时时间, 默认 5 秒. ReadTimeout 一批命令从发送到收到全部回复的超时时间, 默认不超时,
+	// 命令的 ctx 有截止时间时取两者中较早的一个.
+	DialTimeout time.Duration
+	ReadTimeout time.Duration
+
+	// PoolSize 最多同时存在的连接数量, 默认 10. MaxIdle 最多保留的空闲连接数量, 默认与 PoolSize 相同.
+	PoolSize int
+	MaxIdle  int
+
+	// MaxRetries 连接发生错误时最多重试的次数, 默认 3, -1 表示不重试.
+	// 注意重试可能使已经被服务端执行的写命令再执行一次.
+	// MinRetryBackoff 与 MaxRetryBackoff 两次重试之间的最短与最长等待时间, 默认 8 毫秒与 512 毫秒, 等待时间按指数增长.
+	MaxRetries      int
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+}
+
+func (o *Options) init() {
+	if o.Addr == "" {
+		o.Addr = "127.0.0.1:6379"
+	}
+	if o.DialTimeout == 0 {
+		o.DialTimeout = 5 * time.Second
+	}
+	if o.PoolSize <= 0 {
+		o.PoolSize = 10
+	}
+	if o.MaxIdle <= 0 {
+		o.MaxIdle = o.PoolSize
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = 3
+	} else if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.MinRetryBackoff <= 0 {
+		o.MinRetryBackoff = 8 * time.Millisecond
+	}
+	if o.MaxRetryBackoff <= 0 {
+		o.MaxRetryBackoff = 512 * time.Millisecond
+	}
+}
+
+// Client 带有连接池的客户端
+type Client struct {
+	opts Options
+	pool *pool.Pool[*conn]
+}
+
+// New 创建客户端, 连接在第一次使用时才建立
+func New(opts Options) *Client {
+	opts.init()
+	c := &Client{opts: opts}
+	c.pool = pool.New(c.dial, (*conn).close, pool.Config{MaxIdle: opts.MaxIdle, MaxActive: opts.PoolSize})
+	return c
+}
+
+// Do 执行一条命令, 如 Do(ctx, "set", "key", 1). 结果与错误都记录在返回的 Cmd 中.
+func (c *Client) Do(ctx context.Context, args ...any) *Cmd {
+	cmd := newCmd(args)
+	if cmd.err == nil {
+		_ = c.process(ctx, []*Cmd{cmd})
+	}
+	return cmd
+}
+
+// process 使用一个连接发送一批命令并读取回复. 连接发生错误时丢弃它, 退避之后用新的连接重新发送这批命令.
+func (c *Client) process(ctx context.Context, cmds []*Cmd) error {
+	var err error
+	for attempt := 0; attempt <= c.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if err = sleep(ctx, c.retryBackoff(attempt)); err != nil {
+				break
+			}
+		} else if err = ctx.Err(); err != nil {
+			// ctx 已经结束时不发送命令, 否则空闲的连接可能在监视 ctx 的协程生效之前完成往返
+			break
+		}
+
+		var cn *conn
+		if cn, err = c.pool.GetContext(ctx); err == nil {
+			if err = cn.roundTrip(ctx, cmds, c.opts.ReadTimeout); err == nil {
+				c.pool.Put(cn)
+				return nil
+			}
+			c.pool.Discard(cn)
+		}
+		if !shouldRetry(ctx, err) {
+			break
+		}
+	}
+
+	for _, cmd := range cmds {
+		cmd.reply, cmd.err = nil, err
+	}
+	return err
+}
+
+// shouldRetry 连接的错误可以重试; ctx 结束, 连接池已经关闭, 或无法解析服务端的回复时不重试
+func shouldRetry(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || err == pool.ErrClosed {
+		return false
+	}
+	_, isProtocolError := err.(reply.ErrorReply)
+	return !isProtocolError
+}
+
+// retryBackoff 第 attempt 次重试之前等待的时间, 在指数增长的时间的一半到全部之间随机选择
+func (c *Client) retryBackoff(attempt int) time.Duration {
+	backoff := c.opts.MinRetryBackoff << (attempt - 1)
+	if backoff > c.opts.MaxRetryBackoff || backoff <= 0 {
+		backoff = c.opts.MaxRetryBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pipeline 创建一个管道, 其中的命令在 Exec 时一次发送
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{client: c}
+}
+
+// Close 关闭客户端与全部空闲连接, 正在使用的连接在归还时关闭
+func (c *Client) Close() error {
+	c.pool.Close()
+	return nil
+}
+
+// Pipeline 管道, 多条命令在一次写入中发送, 回复在一次往返中读取. 不能被多个协程同时使用.
+type Pipeline struct {
+	client *Client
+	cmds   []*Cmd
+}
+
+// Do 将一条命令加入管道, 返回的 Cmd 在 Exec 之后才有结果
+func (p *Pipeline) Do(args ...any) *Cmd {
+	cmd := newCmd(args)
+	p.cmds = append(p.cmds, cmd)
+	return cmd
+}
+
+// Len 返回管道中命令的数量
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+// Exec 发送管道中的全部命令并清空管道. 返回连接的错误, 或第一条失败的命令的错误.
+// 有命令的参数不合法时不发送任何命令.
+func (p *Pipeline) Exec(ctx context.Context) ([]*Cmd, error) {
+	cmds := p.cmds
+	p.cmds = nil
+	if len(cmds) == 0 {
+		return cmds, nil
+	}
+	for _, cmd := range cmds {
+		if cmd.err != nil {
+			return cmds, cmd.err
+		}
+	}
+
+	if err := p.client.process(ctx, cmds); err != nil {
+		return cmds, err
+	}
+	for _, cmd := range cmds {
+		if cmd.err != nil {
+			return cmds, cmd.err
+		}
+	}
+	return cmds, nil
+}
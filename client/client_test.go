@@ -0,0 +1,348 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"simple_kvstorage/client"
+	"simple_kvstorage/core"
+	"simple_kvstorage/database"
+	_ "simple_kvstorage/executor/command"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer 在回环地址上启动的服务器
+type testServer struct {
+	listener net.Listener
+	handler  *core.Handler
+	dbs      []database.DB
+
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+func newTestServer(t *testing.T) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbs := []database.DB{database.NewConcurrentDB(0), database.NewConcurrentDB(1)}
+	s := &testServer{listener: listener, handler: core.NewHandler(dbs, nil), dbs: dbs}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mutex.Lock()
+			s.conns = append(s.conns, conn)
+			s.mutex.Unlock()
+			go s.handler.Handle(conn, context.Background())
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		s.dropConnections()
+		_ = s.handler.Close()
+	})
+	return s
+}
+
+// dropConnections 断开全部已经建立的连接
+func (s *testServer) dropConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func newTestClient(t *testing.T, s *testServer, opts client.Options) *client.Client {
+	opts.Addr = s.listener.Addr().String()
+	c := client.New(opts)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestClient_Commands(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s, client.Options{})
+	ctx := context.Background()
+
+	if pong, err := c.Ping(ctx); err != nil || pong != "PONG" {
+		t.Error("PING 错误.", pong, err)
+	}
+	if err := c.Set(ctx, "a", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "a"); err != nil || v != "hello" {
+		t.Error("GET 错误.", v, err)
+	}
+	if _, err := c.Get(ctx, "missing"); err != client.ErrNil {
+		t.Error("不存在的 key 应该返回 client.ErrNil.", err)
+	}
+	if ok, err := c.SetNX(ctx, "a", 1); err != nil || ok {
+		t.Error("SETNX 错误.", ok, err)
+	}
+	if old, err := c.GetSet(ctx, "a", 3.5); err != nil || old != "hello" {
+		t.Error("GETSET 错误.", old, err)
+	}
+	if n, err := c.StrLen(ctx, "a"); err != nil || n != 3 {
+		t.Error("STRLEN 错误.", n, err)
+	}
+	if n, err := c.StrLen(ctx, "missing"); err != nil || n != 0 {
+		t.Error("不存在的 key 的长度应该是 0.", n, err)
+	}
+	if typ, err := c.Type(ctx, "a"); err != nil || typ != "string" {
+		t.Error("TYPE 错误.", typ, err)
+	}
+	if err := c.Rename(ctx, "a", "b"); err != nil {
+		t.Error("RENAME 错误.", err)
+	}
+	var serverError client.Error
+	if err := c.Rename(ctx, "a", "b"); !errors.As(err, &serverError) {
+		t.Error("重命名不存在的 key 应该返回服务端的错误.", err)
+	}
+	if ok, err := c.RenameNX(ctx, "b", "c"); err != nil || !ok {
+		t.Error("RENAMENX 错误.", ok, err)
+	}
+	if n, err := c.MemoryUsage(ctx, "c"); err != nil || n <= 0 {
+		t.Error("MEMORY USAGE 错误.", n, err)
+	}
+
+	payload, err := c.Dump(ctx, "c")
+	if err != nil {
+		t.Fatal("DUMP 错误.", err)
+	}
+	if err := c.Restore(ctx, "c", 0, payload); err == nil {
+		t.Error("key 已经存在时 RESTORE 应该失败.")
+	}
+	if err := c.RestoreReplace(ctx, "c", 0, payload); err != nil {
+		t.Error("RESTORE REPLACE 错误.", err)
+	}
+	if err := c.Restore(ctx, "d", 0, payload); err != nil {
+		t.Error("RESTORE 错误.", err)
+	}
+	if v, err := c.Get(ctx, "d"); err != nil || v != "3.5" {
+		t.Error("RESTORE 之后的值错误.", v, err)
+	}
+
+	if keys, err := c.Keys(ctx, "*"); err != nil || len(keys) != 2 {
+		t.Error("KEYS 错误.", keys, err)
+	}
+	if n, err := c.Exists(ctx, "c", "d", "e"); err != nil || n != 2 {
+		t.Error("EXISTS 错误.", n, err)
+	}
+	if n, err := c.Del(ctx, "c", "e"); err != nil || n != 1 {
+		t.Error("DEL 错误.", n, err)
+	}
+	if err := c.FlushDB(ctx); err != nil {
+		t.Error("FLUSHDB 错误.", err)
+	}
+	if keys, err := c.Keys(ctx, "*"); err != nil || len(keys) != 0 {
+		t.Error("FLUSHDB 之后不应该有 key.", keys, err)
+	}
+	if info, err := c.Info(ctx, "keyspace"); err != nil || info == "" {
+		t.Error("INFO 错误.", info, err)
+	}
+	if err := c.Do(ctx, "set", "a", struct{}{}).Err(); err == nil {
+		t.Error("不支持的参数类型应该返回错误.")
+	}
+}
+
+// 嵌套的, 以及元素不是字符串的回复
+func TestClient_NestedReplies(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.SetSlowLog(0, 16)
+	c := newTestClient(t, s, client.Options{PoolSize: 1})
+	ctx := context.Background()
+
+	infos, err := c.CommandInfo(ctx, "get", "missing")
+	if err != nil || len(infos) != 2 || infos[1] != nil {
+		t.Fatal("COMMAND INFO 错误.", infos, err)
+	}
+	if get := infos[0]; get.Name != "get" || get.Arity != 2 || get.FirstKey != 1 || len(get.Flags) == 0 || len(get.Categories) == 0 {
+		t.Error("GET 的信息错误.", get)
+	}
+	if n, err := c.CommandCount(ctx); err != nil || n == 0 {
+		t.Error("COMMAND COUNT 错误.", n, err)
+	}
+
+	// RESP2 的 HELLO 回复是平铺的数组
+	if info, err := c.Do(ctx, "hello", "2").Map(); err != nil || info["proto"] != int64(2) || info["server"] != "simple_kvstorage" {
+		t.Error("HELLO 2 错误.", info, err)
+	}
+
+	if err := c.SlowLogReset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Set(ctx, "a", "1")
+	entries, err := c.SlowLogGet(ctx, -1)
+	if err != nil || len(entries) == 0 {
+		t.Fatal("SLOWLOG GET 错误.", entries, err)
+	}
+	if args := entries[len(entries)-1].Args; len(args) == 0 || args[0] != "slowlog" {
+		t.Error("最早的记录应该是 SLOWLOG RESET.", entries)
+	}
+	if entries[0].ClientAddr == "" || entries[0].Time.IsZero() {
+		t.Error("慢查询记录错误.", entries[0])
+	}
+
+	// 解析回复之后连接仍然可用, 没有被丢弃重建
+	id, err := c.Do(ctx, "client", "id").Int64()
+	if again, _ := c.Do(ctx, "client", "id").Int64(); err != nil || again != id {
+		t.Error("连接不应该被重建.", id, again)
+	}
+}
+
+func TestClient_Resp3(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s, client.Options{Protocol: 3})
+	ctx := context.Background()
+
+	info, err := c.Hello(ctx)
+	if err != nil || info["proto"] != int64(3) || info["modules"] == nil {
+		t.Fatal("HELLO 3 错误.", info, err)
+	}
+	if r, _ := c.Do(ctx, "hello").Reply(); !isMapReply(r) {
+		t.Error("RESP3 的 HELLO 应该回复 Map.")
+	}
+	infos, err := c.CommandInfo(ctx, "set")
+	if err != nil || len(infos) != 1 || infos[0].Arity != -3 {
+		t.Error("RESP3 的 COMMAND INFO 错误.", infos, err)
+	}
+	if _, err := c.Get(ctx, "missing"); err != client.ErrNil {
+		t.Error("RESP3 的 nil 应该返回 client.ErrNil.", err)
+	}
+}
+
+func TestClient_Options(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s, client.Options{DB: 1, ClientName: "worker-1"})
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.dbs[1].Get("a"); !ok {
+		t.Error("应该写入 DB 1.")
+	}
+	if name, err := c.Do(ctx, "client", "getname").Text(); err != nil || name != "worker-1" {
+		t.Error("连接的名字错误.", name, err)
+	}
+
+	// InitCommands 在建立连接时执行, 失败时连接建立失败
+	c = newTestClient(t, s, client.Options{InitCommands: [][]any{{"select", 1}}})
+	if v, err := c.Get(ctx, "a"); err != nil || v != "1" {
+		t.Error("InitCommands 应该在建立连接时执行.", v, err)
+	}
+	c = newTestClient(t, s, client.Options{InitCommands: [][]any{{"nosuchcommand"}}, MaxRetries: -1})
+	if _, err := c.Ping(ctx); err == nil {
+		t.Error("InitCommands 失败时不应该建立连接.")
+	}
+}
+
+func TestClient_Pipeline(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s, client.Options{})
+	ctx := context.Background()
+
+	pipeline := c.Pipeline()
+	for i := 0; i < 100; i++ {
+		pipeline.Do("set", "key:"+strconv.Itoa(i), i)
+	}
+	get := pipeline.Do("get", "key:42")
+	if pipeline.Len() != 101 {
+		t.Fatal("管道中应该有 101 条命令.", pipeline.Len())
+	}
+	cmds, err := pipeline.Exec(ctx)
+	if err != nil || len(cmds) != 101 || pipeline.Len() != 0 {
+		t.Fatal("执行管道失败.", err)
+	}
+	if v, err := get.Int64(); err != nil || v != 42 {
+		t.Error("管道中的回复错误.", v, err)
+	}
+
+	// 第一条失败的命令的错误作为 Exec 的错误
+	pipeline.Do("set", "a", 1)
+	pipeline.Do("rename", "missing", "b")
+	pipeline.Do("get", "a")
+	cmds, err = pipeline.Exec(ctx)
+	if _, ok := err.(client.Error); !ok || cmds[0].Err() != nil || cmds[2].Err() != nil {
+		t.Error("管道中的错误不应该影响其他命令.", err)
+	}
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s, client.Options{PoolSize: 1})
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	// 服务端断开连接之后, 下一条命令使用新的连接重试
+	s.dropConnections()
+	if v, err := c.Get(ctx, "a"); err != nil || v != "1" {
+		t.Error("应该自动重新连接.", v, err)
+	}
+
+	// 不重试时返回连接的错误
+	noRetry := newTestClient(t, s, client.Options{MaxRetries: -1})
+	if err := noRetry.Set(ctx, "a", 2); err != nil {
+		t.Fatal(err)
+	}
+	s.dropConnections()
+	if _, err := noRetry.Get(ctx, "a"); err == nil {
+		t.Error("不重试时应该返回连接的错误.")
+	}
+}
+
+func TestClient_Context(t *testing.T) {
+	// 接受连接但从不回复的服务器
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				for _, conn := range conns {
+					_ = conn.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	c := client.New(client.Options{Addr: listener.Addr().String(), PoolSize: 1})
+	defer func() { _ = c.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.Set(ctx, "a", 1); err != context.DeadlineExceeded {
+		t.Error("应该在截止时间返回.", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	if err := c.Set(ctx, "a", 1); err != context.Canceled {
+		t.Error("应该在取消时返回.", err)
+	}
+}
+
+func isMapReply(r reply.Reply) bool {
+	_, ok := r.(*reply.MapReply)
+	return ok
+}
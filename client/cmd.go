@@ -0,0 +1,254 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+)
+
+// ErrNil 服务端回复了 nil, 如 GET 一个不存在的 key
+var ErrNil = errors.New("client: nil reply")
+
+// Error 服务端回复的错误, 如 "ERROR unknown command 'foo'". 这样的错误不会使连接被关闭, 也不会被重试.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Cmd 一条命令及其回复. 回复中的错误 (包括 Error) 由 Err 返回, 其他方法将回复转换为 Go 的类型.
+type Cmd struct {
+	args  [][]byte
+	reply reply.Reply
+	err   error
+}
+
+// newCmd 创建一条命令, 参数可以是 string, []byte, 整数, 浮点数与 bool
+func newCmd(args []any) *Cmd {
+	cmd := &Cmd{args: make([][]byte, len(args))}
+	if len(args) == 0 {
+		cmd.err = errors.New("client: empty command")
+		return cmd
+	}
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			cmd.args[i] = []byte(v)
+		case []byte:
+			cmd.args[i] = v
+		case int:
+			cmd.args[i] = strconv.AppendInt(nil, int64(v), 10)
+		case int64:
+			cmd.args[i] = strconv.AppendInt(nil, v, 10)
+		case uint64:
+			cmd.args[i] = strconv.AppendUint(nil, v, 10)
+		case float64:
+			cmd.args[i] = strconv.AppendFloat(nil, v, 'f', -1, 64)
+		case bool:
+			if v {
+				cmd.args[i] = []byte("1")
+			} else {
+				cmd.args[i] = []byte("0")
+			}
+		default:
+			cmd.err = fmt.Errorf("client: unsupported argument type %T", arg)
+			return cmd
+		}
+	}
+	return cmd
+}
+
+// setReply 记录服务端的回复, 错误回复转换为 Error
+func (c *Cmd) setReply(r reply.Reply) {
+	c.reply = r
+	if errorReply, ok := r.(reply.ErrorReply); ok {
+		c.err = Error(errorReply.Error())
+	}
+}
+
+// Args 返回命令的参数
+func (c *Cmd) Args() [][]byte {
+	return c.args
+}
+
+// Reply 返回服务端的原始回复
+func (c *Cmd) Reply() (reply.Reply, error) {
+	return c.reply, c.err
+}
+
+// Err 返回执行命令时发生的错误, 包括连接的错误与服务端回复的错误
+func (c *Cmd) Err() error {
+	return c.err
+}
+
+// Bytes 将字符串回复转换为字节数组
+func (c *Cmd) Bytes() ([]byte, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	switch r := c.reply.(type) {
+	case *reply.BulkReply:
+		if r.Bytes() == nil {
+			return nil, ErrNil
+		}
+		return r.Bytes(), nil
+	case *reply.StatusReply:
+		return []byte(r.Status), nil
+	case *reply.NullBulkReply:
+		return nil, ErrNil
+	}
+	return nil, c.unexpected()
+}
+
+// Text 将字符串回复转换为 string
+func (c *Cmd) Text() (string, error) {
+	b, err := c.Bytes()
+	return string(b), err
+}
+
+// Int64 将整数回复转换为 int64, 内容是整数的字符串回复也可以被转换
+func (c *Cmd) Int64() (int64, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	switch r := c.reply.(type) {
+	case *reply.IntReply:
+		return r.Code, nil
+	case *reply.NullBulkReply:
+		return 0, ErrNil
+	case *reply.BulkReply:
+		n, err := strconv.ParseInt(string(r.Bytes()), 10, 64)
+		if err != nil {
+			return 0, c.unexpected()
+		}
+		return n, nil
+	}
+	return 0, c.unexpected()
+}
+
+// Bool 将整数回复 1 和 0 转换为 bool
+func (c *Cmd) Bool() (bool, error) {
+	n, err := c.Int64()
+	return n != 0, err
+}
+
+// Strings 将数组回复转换为字符串的切片, 数组中的 nil 元素转换为空字符串
+func (c *Cmd) Strings() ([]string, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	switch r := c.reply.(type) {
+	case *reply.MultiBulkReply:
+		result := make([]string, len(r.Args))
+		for i, arg := range r.Args {
+			result[i] = string(arg)
+		}
+		return result, nil
+	case *reply.EmptyMultiBulkReply:
+		return []string{}, nil
+	case *reply.NullMultiBulkReply:
+		return nil, ErrNil
+	}
+	return nil, c.unexpected()
+}
+
+// Slice 将数组, 集合或 Push 回复转换为切片, 元素按 Value 的规则转换, 可以是嵌套的切片
+func (c *Cmd) Slice() ([]any, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	switch c.reply.(type) {
+	case *reply.MultiBulkReply, *reply.MultiRawReply, *reply.EmptyMultiBulkReply, *reply.SetReply, *reply.PushReply:
+		return Value(c.reply).([]any), nil
+	case *reply.NullMultiBulkReply:
+		return nil, ErrNil
+	}
+	return nil, c.unexpected()
+}
+
+// Map 将 RESP3 的 Map 回复, 或 RESP2 中由键值对平铺而成的数组转换为 map, 如 HELLO 的回复.
+// 键转换为字符串, 值按 Value 的规则转换.
+func (c *Cmd) Map() (map[string]any, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if m, ok := c.reply.(*reply.MapReply); ok {
+		return Value(m).(map[string]any), nil
+	}
+	elements, err := c.Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(elements)%2 != 0 {
+		return nil, c.unexpected()
+	}
+	result := make(map[string]any, len(elements)/2)
+	for i := 0; i < len(elements); i += 2 {
+		result[fmt.Sprint(elements[i])] = elements[i+1]
+	}
+	return result, nil
+}
+
+// Value 将回复转换为 Go 的值: 字符串为 string, 整数为 int64, nil 为 nil, 数组与集合为 []any,
+// Map 为 map[string]any, 浮点数为 float64, 布尔值为 bool, 大数为 *big.Int, 错误为 Error
+func Value(r reply.Reply) any {
+	switch r := r.(type) {
+	case *reply.BulkReply:
+		if r.Bytes() == nil {
+			return nil
+		}
+		return string(r.Bytes())
+	case *reply.StatusReply:
+		return r.Status
+	case *reply.IntReply:
+		return r.Code
+	case *reply.NullBulkReply, *reply.NullMultiBulkReply:
+		return nil
+	case *reply.EmptyMultiBulkReply:
+		return []any{}
+	case *reply.MultiBulkReply:
+		result := make([]any, len(r.Args))
+		for i, arg := range r.Args {
+			if arg != nil {
+				result[i] = string(arg)
+			}
+		}
+		return result
+	case *reply.MultiRawReply:
+		return values(r.Replies)
+	case *reply.SetReply:
+		return values(r.Members)
+	case *reply.PushReply:
+		return values(r.Data)
+	case *reply.MapReply:
+		result := make(map[string]any, len(r.Entries))
+		for _, entry := range r.Entries {
+			result[fmt.Sprint(Value(entry.Key))] = Value(entry.Value)
+		}
+		return result
+	case *reply.DoubleReply:
+		return r.Value
+	case *reply.BooleanReply:
+		return r.Value
+	case *reply.BigNumberReply:
+		return r.Value
+	case *reply.VerbatimReply:
+		return string(r.Text)
+	case reply.ErrorReply:
+		return Error(r.Error())
+	}
+	return string(r.ToBytes())
+}
+
+func values(replies []reply.Reply) []any {
+	result := make([]any, len(replies))
+	for i, r := range replies {
+		result[i] = Value(r)
+	}
+	return result
+}
+
+func (c *Cmd) unexpected() error {
+	return fmt.Errorf("client: unexpected reply %q", c.reply.ToBytes())
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// keysArgs 将命令名与多个 key 组成参数
+func keysArgs(cmdName string, keys []string) []any {
+	args := make([]any, 0, 1+len(keys))
+	args = append(args, cmdName)
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	return args
+}
+
+// Ping PING, 返回 PONG
+func (c *Client) Ping(ctx context.Context) (string, error) {
+	return c.Do(ctx, "ping").Text()
+}
+
+// Get GET key, key 不存在时返回 ErrNil
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	return c.Do(ctx, "get", key).Text()
+}
+
+// Set SET key value
+func (c *Client) Set(ctx context.Context, key string, value any) error {
+	return c.Do(ctx, "set", key, value).Err()
+}
+
+// SetNX SETNX key value, 返回是否设置成功
+func (c *Client) SetNX(ctx context.Context, key string, value any) (bool, error) {
+	return c.Do(ctx, "setnx", key, value).Bool()
+}
+
+// GetSet GETSET key value, 返回旧值, key 不存在时返回 ErrNil
+func (c *Client) GetSet(ctx context.Context, key string, value any) (string, error) {
+	return c.Do(ctx, "getset", key, value).Text()
+}
+
+// StrLen STRLEN key, key 不存在时返回 0
+func (c *Client) StrLen(ctx context.Context, key string) (int64, error) {
+	n, err := c.Do(ctx, "strlen", key).Int64()
+	if err == ErrNil {
+		return 0, nil
+	}
+	return n, err
+}
+
+// Del DEL key [key ...], 返回删除的 key 的数量
+func (c *Client) Del(ctx context.Context, keys ...string) (int64, error) {
+	return c.Do(ctx, keysArgs("del", keys)...).Int64()
+}
+
+// Exists EXISTS key [key ...], 返回存在的 key 的数量
+func (c *Client) Exists(ctx context.Context, keys ...string) (int64, error) {
+	return c.Do(ctx, keysArgs("exists", keys)...).Int64()
+}
+
+// Keys KEYS pattern
+func (c *Client) Keys(ctx context.Context, pattern string) ([]string, error) {
+	return c.Do(ctx, "keys", pattern).Strings()
+}
+
+// FlushDB FLUSHDB, 清空连接使用的数据库
+func (c *Client) FlushDB(ctx context.Context) error {
+	return c.Do(ctx, "flushdb").Err()
+}
+
+// Type TYPE key, key 不存在时返回 none
+func (c *Client) Type(ctx context.Context, key string) (string, error) {
+	return c.Do(ctx, "type", key).Text()
+}
+
+// Rename RENAME key newkey
+func (c *Client) Rename(ctx context.Context, key, newKey string) error {
+	return c.Do(ctx, "rename", key, newKey).Err()
+}
+
+// RenameNX RENAMENX key newkey, 返回是否重命名成功
+func (c *Client) RenameNX(ctx context.Context, key, newKey string) (bool, error) {
+	return c.Do(ctx, "renamenx", key, newKey).Bool()
+}
+
+// MemoryUsage MEMORY USAGE key, key 不存在时返回 ErrNil
+func (c *Client) MemoryUsage(ctx context.Context, key string) (int64, error) {
+	return c.Do(ctx, "memory", "usage", key).Int64()
+}
+
+// Dump DUMP key, 返回序列化的值, key 不存在时返回 ErrNil
+func (c *Client) Dump(ctx context.Context, key string) (string, error) {
+	return c.Do(ctx, "dump", key).Text()
+}
+
+// Restore RESTORE key ttl serialized-value, ttl 只能是 0
+func (c *Client) Restore(ctx context.Context, key string, ttl int64, value string) error {
+	return c.Do(ctx, "restore", key, ttl, value).Err()
+}
+
+// RestoreReplace RESTORE key ttl serialized-value REPLACE, 覆盖已经存在的 key
+func (c *Client) RestoreReplace(ctx context.Context, key string, ttl int64, value string) error {
+	return c.Do(ctx, "restore", key, ttl, value, "replace").Err()
+}
+
+// Info INFO [section], 返回服务器的状态
+func (c *Client) Info(ctx context.Context, section ...string) (string, error) {
+	return c.Do(ctx, keysArgs("info", section)...).Text()
+}
+
+// Hello HELLO, 返回服务器与连接的信息, 如 server, version, proto, id, mode, role.
+// 连接使用的协议版本由 Options.Protocol 决定, 不要用 Do 执行带版本的 HELLO 改变连接池中某个连接的协议.
+func (c *Client) Hello(ctx context.Context) (map[string]any, error) {
+	return c.Do(ctx, "hello").Map()
+}
+
+// CommandInfo COMMAND INFO 中一个命令的信息
+type CommandInfo struct {
+	Name string
+	// Arity 参数的数量 (包括命令名), 负数 -N 表示至少 N 个
+	Arity int64
+	Flags []string
+	// FirstKey, LastKey, Step 参数中 key 的位置, 见 COMMAND GETKEYS
+	FirstKey, LastKey, Step int64
+	// Categories ACL 分类, 如 @keyspace
+	Categories []string
+}
+
+// CommandCount COMMAND COUNT, 返回命令的数量
+func (c *Client) CommandCount(ctx context.Context) (int64, error) {
+	return c.Do(ctx, "command", "count").Int64()
+}
+
+// CommandInfo COMMAND INFO [name ...], 没有 name 时返回全部命令. 未知的命令对应的元素为 nil.
+func (c *Client) CommandInfo(ctx context.Context, names ...string) ([]*CommandInfo, error) {
+	cmd := c.Do(ctx, keysArgs("command", append([]string{"info"}, names...))...)
+	elements, err := cmd.Slice()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*CommandInfo, len(elements))
+	for i, element := range elements {
+		if element == nil {
+			continue
+		}
+		fields, ok := element.([]any)
+		if !ok || len(fields) < 7 {
+			return nil, cmd.unexpected()
+		}
+		info := &CommandInfo{Flags: toStrings(fields[2]), Categories: toStrings(fields[6])}
+		info.Name, _ = fields[0].(string)
+		info.Arity, _ = fields[1].(int64)
+		info.FirstKey, _ = fields[3].(int64)
+		info.LastKey, _ = fields[4].(int64)
+		info.Step, _ = fields[5].(int64)
+		infos[i] = info
+	}
+	return infos, nil
+}
+
+// SlowLogEntry 慢查询日志中的一条记录
+type SlowLogEntry struct {
+	ID       int64
+	Time     time.Time
+	Duration time.Duration
+	Args     []string
+	// ClientAddr 与 ClientName 执行命令的客户端的地址与名字
+	ClientAddr string
+	ClientName string
+}
+
+// SlowLogGet SLOWLOG GET count, 返回最近的 count 条记录, -1 表示全部
+func (c *Client) SlowLogGet(ctx context.Context, count int) ([]SlowLogEntry, error) {
+	cmd := c.Do(ctx, "slowlog", "get", count)
+	elements, err := cmd.Slice()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]SlowLogEntry, len(elements))
+	for i, element := range elements {
+		fields, ok := element.([]any)
+		if !ok || len(fields) < 6 {
+			return nil, cmd.unexpected()
+		}
+		id, _ := fields[0].(int64)
+		unix, _ := fields[1].(int64)
+		micros, _ := fields[2].(int64)
+		entries[i] = SlowLogEntry{
+			ID:       id,
+			Time:     time.Unix(unix, 0),
+			Duration: time.Duration(micros) * time.Microsecond,
+			Args:     toStrings(fields[3]),
+		}
+		entries[i].ClientAddr, _ = fields[4].(string)
+		entries[i].ClientName, _ = fields[5].(string)
+	}
+	return entries, nil
+}
+
+// SlowLogLen SLOWLOG LEN, 返回慢查询日志中记录的数量
+func (c *Client) SlowLogLen(ctx context.Context) (int64, error) {
+	return c.Do(ctx, "slowlog", "len").Int64()
+}
+
+// SlowLogReset SLOWLOG RESET, 清空慢查询日志
+func (c *Client) SlowLogReset(ctx context.Context) error {
+	return c.Do(ctx, "slowlog", "reset").Err()
+}
+
+// toStrings 将 Value 转换得到的切片转换为字符串的切片, 不是字符串的元素为空字符串
+func toStrings(v any) []string {
+	elements, _ := v.([]any)
+	result := make([]string, len(elements))
+	for i, element := range elements {
+		result[i], _ = element.(string)
+	}
+	return result
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"simple_kvstorage/resp"
+	"simple_kvstorage/resp/reply"
+	"time"
+)
+
+// conn 连接池中的一个连接
+type conn struct {
+	netConn net.Conn
+	decoder *resp.Decoder
+	// buffer 复用的写缓冲区, 一批命令编码之后一次写入连接
+	buffer []byte
+}
+
+// dial 建立连接, 设置连接的名字与数据库, 然后执行 InitCommands
+func (c *Client) dial() (*conn, error) {
+	var netConn net.Conn
+	var err error
+	if c.opts.Dialer != nil {
+		netConn, err = c.opts.Dialer()
+	} else {
+		netConn, err = net.DialTimeout("tcp", c.opts.Addr, c.opts.DialTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{netConn: netConn, decoder: resp.NewDecoder(netConn)}
+
+	var cmds []*Cmd
+	if c.opts.Protocol == 3 {
+		cmds = append(cmds, newCmd([]any{"hello", 3}))
+	}
+	if c.opts.ClientName != "" {
+		cmds = append(cmds, newCmd([]any{"client", "setname", c.opts.ClientName}))
+	}
+	if c.opts.DB != 0 {
+		cmds = append(cmds, newCmd([]any{"select", c.opts.DB}))
+	}
+	for _, args := range c.opts.InitCommands {
+		cmd := newCmd(args)
+		if cmd.err != nil {
+			_ = netConn.Close()
+			return nil, cmd.err
+		}
+		cmds = append(cmds, cmd)
+	}
+	if len(cmds) > 0 {
+		err = cn.roundTrip(context.Background(), cmds, c.opts.DialTimeout)
+		for _, cmd := range cmds {
+			if err == nil {
+				err = cmd.Err()
+			}
+		}
+		if err != nil {
+			_ = netConn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// roundTrip 发送一批命令, 然后依次读取它们的回复. 服务端回复的错误记录在命令中;
+// 返回 error 时连接已经不可用. timeout 为 0 时只使用 ctx 的截止时间.
+func (cn *conn) roundTrip(ctx context.Context, cmds []*Cmd, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	_ = cn.netConn.SetDeadline(deadline)
+
+	// ctx 被取消时使阻塞的读写立即返回. 等待监视的协程退出, 以免它修改下一个使用者设置的截止时间.
+	if done := ctx.Done(); done != nil {
+		stop, exited := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(exited)
+			select {
+			case <-done:
+				_ = cn.netConn.SetDeadline(time.Unix(1, 0))
+			case <-stop:
+			}
+		}()
+		defer func() {
+			close(stop)
+			<-exited
+		}()
+	}
+
+	cn.buffer = cn.buffer[:0]
+	for _, cmd := range cmds {
+		// 重试时清除上一次得到的部分回复
+		cmd.reply, cmd.err = nil, nil
+		cn.buffer = append(cn.buffer, reply.NewMultiBulkReply(cmd.args).ToBytes()...)
+	}
+	if _, err := cn.netConn.Write(cn.buffer); err != nil {
+		return contextError(ctx, err)
+	}
+
+	for _, cmd := range cmds {
+		r, err := cn.decoder.Decode()
+		if err != nil {
+			return contextError(ctx, err)
+		}
+		cmd.setReply(r)
+	}
+	return nil
+}
+
+// contextError ctx 已经被取消或超时时返回 ctx 的错误, 而不是读写连接的错误.
+// 连接的截止时间可能比 ctx 的计时器先到, 此时 ctx.Err 还是 nil.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if deadline, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+func (cn *conn) close() {
+	_ = cn.netConn.Close()
+}
@@ -0,0 +1,236 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"simple_kvstorage/client"
+	"simple_kvstorage/core"
+	"simple_kvstorage/database"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/resp/reply"
+	"simple_kvstorage/util/logger"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// forwardTimeout 转发命令时连接与等待回复的超时时间
+	forwardTimeout = 5 * time.Second
+	// poolMaxIdle, poolMaxActive 与每个节点之间的连接池的大小
+	poolMaxIdle   = 8
+	poolMaxActive = 64
+)
+
+// Config 集群的配置
+type Config struct {
+	// Self 本节点的地址 host:port, 同时作为节点的 ID
+	Self string
+	// Peers 其他节点的地址
+	Peers []string
+	// Forward 为 true 时将不属于本节点的命令透明地转发给负责的节点, 否则回复 MOVED / ASK 让客户端重定向
+	Forward bool
+	// Password 其他节点设置的密码 (requirepass), 转发的连接先以它认证, 空字符串表示不认证
+	Password string
+}
+
+// Cluster 集群模式下位于 core.Handler 之前的路由层.
+// 全部节点按地址排序后平分 16384 个哈希槽, 每个节点独立地计算出相同的分配.
+// 集群模式下只能使用 0 号数据库.
+type Cluster struct {
+	self     string
+	nodes    []string
+	forward  bool
+	password string
+	db       database.DB
+
+	mutex sync.RWMutex
+	// owners 每个槽所属的节点
+	owners [SlotCount]string
+	// migrating 正在从本节点迁出的槽及其目标节点, importing 正在迁入本节点的槽及其来源节点
+	migrating map[int]string
+	importing map[int]string
+
+	peersMutex sync.Mutex
+	peers      map[string]*client.Client
+}
+
+// New 创建集群的路由层, db 是本节点的 0 号数据库
+func New(config *Config, db database.DB) (*Cluster, error) {
+	if config.Self == "" {
+		return nil, errors.New("cluster self address is empty")
+	}
+	nodes := []string{config.Self}
+	for _, peer := range config.Peers {
+		peer = strings.TrimSpace(peer)
+		if peer != "" && !contains(nodes, peer) {
+			nodes = append(nodes, peer)
+		}
+	}
+	sort.Strings(nodes)
+
+	c := &Cluster{
+		self:      config.Self,
+		nodes:     nodes,
+		forward:   config.Forward,
+		password:  config.Password,
+		db:        db,
+		migrating: make(map[int]string),
+		importing: make(map[int]string),
+		peers:     make(map[string]*client.Client),
+	}
+	for i, node := range nodes {
+		start, end := i*SlotCount/len(nodes), (i+1)*SlotCount/len(nodes)
+		for slot := start; slot < end; slot++ {
+			c.owners[slot] = node
+		}
+	}
+	return c, nil
+}
+
+func contains(nodes []string, node string) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// Route 实现 core.Router. 带有 key 的命令只有在 key 所属的槽由本节点负责时才在本节点执行.
+func (c *Cluster) Route(conn *core.Client, cmdLine executor.CmdLine) reply.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	// ASKING 只对下一条命令有效
+	asking := conn.Asking()
+	conn.SetAsking(false)
+
+	switch cmdName {
+	case "cluster":
+		return c.execCluster(conn, cmdLine)
+	case "asking":
+		if len(cmdLine) != 1 {
+			return reply.NewArgNumberErrorReply("asking")
+		}
+		conn.SetAsking(true)
+		return reply.GetOkReply()
+	case "select":
+		if len(cmdLine) == 2 && string(cmdLine[1]) != "0" {
+			return reply.NewStandardErrorReply("ERROR SELECT is not allowed in cluster mode")
+		}
+		return nil
+	}
+
+	keys := commandKeys(cmdName, cmdLine)
+	if len(keys) == 0 {
+		return nil
+	}
+	slot := HashSlot(keys[0])
+	for _, key := range keys[1:] {
+		if HashSlot(key) != slot {
+			return reply.NewStandardErrorReply("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+
+	c.mutex.RLock()
+	owner := c.owners[slot]
+	target, migrating := c.migrating[slot]
+	_, importing := c.importing[slot]
+	c.mutex.RUnlock()
+
+	switch {
+	case owner == c.self && migrating:
+		// 迁移中的槽: key 都还在本节点时在本节点执行, 都已经迁走时让客户端去目标节点
+		missing := 0
+		for _, key := range keys {
+			if _, exists := c.db.Get(key); !exists {
+				missing++
+			}
+		}
+		if missing == 0 {
+			return nil
+		}
+		if missing < len(keys) {
+			return reply.NewStandardErrorReply("TRYAGAIN Multiple keys request during rehashing of slot")
+		}
+		return c.redirect(conn, "ASK", slot, target, cmdLine)
+	case owner == c.self:
+		return nil
+	case importing && asking:
+		return nil
+	default:
+		return c.redirect(conn, "MOVED", slot, owner, cmdLine)
+	}
+}
+
+// redirect 让客户端去 addr 执行命令: 开启了转发时替客户端转发, 否则回复 MOVED / ASK 错误
+func (c *Cluster) redirect(conn *core.Client, kind string, slot int, addr string, cmdLine executor.CmdLine) reply.Reply {
+	// 其他节点转发来的命令不再转发, 避免两个节点对槽的归属看法不一致时互相转发
+	if !c.forward || conn.Forwarded() {
+		return reply.NewStandardErrorReply(kind + " " + strconv.Itoa(slot) + " " + addr)
+	}
+
+	theReply, err := c.forwardTo(addr, kind == "ASK", cmdLine)
+	if err != nil {
+		logger.Warn("向节点", addr, "转发命令失败.", err)
+		return reply.NewStandardErrorReply("ERROR forward to " + addr + " failed: " + err.Error())
+	}
+	return theReply
+}
+
+// forwardTo 通过连接池将命令转发给 addr, asking 为 true 时在同一个连接上先发送 ASKING.
+// 目标节点回复的错误原样返回给客户端.
+func (c *Cluster) forwardTo(addr string, asking bool, cmdLine executor.CmdLine) (reply.Reply, error) {
+	args := make([]any, len(cmdLine))
+	for i, arg := range cmdLine {
+		args[i] = arg
+	}
+	pipeline := c.getPeer(addr).Pipeline()
+	if asking {
+		pipeline.Do("asking")
+	}
+	cmd := pipeline.Do(args...)
+	_, _ = pipeline.Exec(context.Background())
+
+	theReply, err := cmd.Reply()
+	if theReply == nil {
+		return nil, err
+	}
+	return theReply, nil
+}
+
+// getPeer 返回与 addr 之间的客户端. 新的连接先认证, 再声明自己是用于转发的连接. 转发的命令不重试.
+func (c *Cluster) getPeer(addr string) *client.Client {
+	c.peersMutex.Lock()
+	defer c.peersMutex.Unlock()
+
+	if peer, ok := c.peers[addr]; ok {
+		return peer
+	}
+	opts := client.Options{
+		Addr:        addr,
+		DialTimeout: forwardTimeout,
+		ReadTimeout: forwardTimeout,
+		PoolSize:    poolMaxActive,
+		MaxIdle:     poolMaxIdle,
+		MaxRetries:  -1,
+	}
+	if c.password != "" {
+		opts.InitCommands = append(opts.InitCommands, []any{"auth", c.password})
+	}
+	opts.InitCommands = append(opts.InitCommands, []any{"cluster", "forwarded"})
+	peer := client.New(opts)
+	c.peers[addr] = peer
+	return peer
+}
+
+// Close 关闭与其他节点的全部连接
+func (c *Cluster) Close() {
+	c.peersMutex.Lock()
+	defer c.peersMutex.Unlock()
+	for addr, peer := range c.peers {
+		_ = peer.Close()
+		delete(c.peers, addr)
+	}
+}
@@ -0,0 +1,219 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"simple_kvstorage/client"
+	"simple_kvstorage/core"
+	"simple_kvstorage/database"
+	_ "simple_kvstorage/executor/command"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testNode 在回环地址上运行的集群节点
+type testNode struct {
+	addr    string
+	cluster *Cluster
+	db      database.DB
+}
+
+// startTestCluster 在回环地址的不同端口上启动 n 个节点, password 不为空时每个节点都设置这个密码
+func startTestCluster(t *testing.T, n int, forward bool, password string) []*testNode {
+	listeners := make([]net.Listener, n)
+	addrs := make([]string, n)
+	for i := range listeners {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i] = listener
+		addrs[i] = listener.Addr().String()
+	}
+
+	nodes := make([]*testNode, n)
+	for i, listener := range listeners {
+		db := database.NewConcurrentDB(0)
+		c, err := New(&Config{Self: addrs[i], Peers: addrs, Forward: forward, Password: password}, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := core.NewHandler([]database.DB{db}, nil)
+		handler.SetRouter(c)
+		handler.SetRequirePass(password)
+		nodes[i] = &testNode{addr: addrs[i], cluster: c, db: db}
+
+		go func(listener net.Listener) {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go handler.Handle(conn, context.Background())
+			}
+		}(listener)
+		t.Cleanup(func() {
+			_ = listener.Close()
+			handler.CloseDatabase()
+		})
+	}
+	return nodes
+}
+
+// keyOwnedBy 返回一个属于 node 的 key
+func keyOwnedBy(node *testNode, prefix string) string {
+	for i := 0; ; i++ {
+		key := prefix + strconv.Itoa(i)
+		if node.cluster.owners[HashSlot(key)] == node.addr {
+			return key
+		}
+	}
+}
+
+// dialTestNode 连接 node, 只使用一个连接, 以便 ASKING 等连接的状态在命令之间保留
+func dialTestNode(t *testing.T, node *testNode) *client.Client {
+	c := client.New(client.Options{Addr: node.addr, DialTimeout: time.Second, ReadTimeout: time.Second, PoolSize: 1, MaxRetries: -1})
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func send(t *testing.T, c *client.Client, args ...string) string {
+	cmdArgs := make([]any, len(args))
+	for i, arg := range args {
+		cmdArgs[i] = arg
+	}
+	theReply, err := c.Do(context.Background(), cmdArgs...).Reply()
+	if theReply == nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(theReply.ToBytes()))
+}
+
+func TestCluster_Moved(t *testing.T) {
+	nodes := startTestCluster(t, 3, false, "")
+	conn := dialTestNode(t, nodes[0])
+
+	local, remote := keyOwnedBy(nodes[0], "a"), keyOwnedBy(nodes[1], "b")
+	if r := send(t, conn, "set", local, "1"); r != "+OK" {
+		t.Fatal("本节点的 key 应该直接执行.", r)
+	}
+	slot := strconv.Itoa(HashSlot(remote))
+	if r := send(t, conn, "set", remote, "1"); r != "-MOVED "+slot+" "+nodes[1].addr {
+		t.Fatal("其他节点的 key 应该回复 MOVED.", r)
+	}
+	if r := send(t, conn, "del", local, remote); !strings.HasPrefix(r, "-CROSSSLOT") {
+		t.Error("不同槽的 key 应该回复 CROSSSLOT.", r)
+	}
+	if r := send(t, conn, "exists", "{"+local+"}x", local); r != ":1" {
+		t.Error("hash tag 相同的 key 属于同一个槽.", r)
+	}
+	if r := send(t, conn, "select", "1"); !strings.HasPrefix(r, "-ERROR") {
+		t.Error("集群模式下不能切换数据库.", r)
+	}
+	if r := send(t, conn, "cluster", "keyslot", remote); r != ":"+slot {
+		t.Error("CLUSTER KEYSLOT 错误.", r)
+	}
+
+	slots := string(nodes[0].cluster.execSlots().ToBytes())
+	if !strings.HasPrefix(slots, "*3\r\n*3\r\n:0\r\n:5460\r\n") {
+		t.Error("CLUSTER SLOTS 错误.", slots)
+	}
+}
+
+func TestCluster_Forward(t *testing.T) {
+	nodes := startTestCluster(t, 3, true, "")
+	conn := dialTestNode(t, nodes[0])
+
+	remote := keyOwnedBy(nodes[2], "c")
+	if r := send(t, conn, "set", remote, "forwarded"); r != "+OK" {
+		t.Fatal("转发失败.", r)
+	}
+	if _, ok := nodes[2].db.Get(remote); !ok {
+		t.Fatal("命令没有在负责的节点上执行.")
+	}
+	if r := send(t, conn, "get", remote); r != "$9\r\nforwarded" {
+		t.Error("转发的回复错误.", r)
+	}
+
+	// 两个节点对槽的归属看法不一致时, 被转发的命令不会被再次转发
+	slot := strconv.Itoa(HashSlot(remote))
+	send(t, dialTestNode(t, nodes[2]), "cluster", "setslot", slot, "node", nodes[0].addr)
+	if r := send(t, conn, "get", remote); r != "-MOVED "+slot+" "+nodes[0].addr {
+		t.Error("应该回复对方节点的 MOVED.", r)
+	}
+}
+
+func TestCluster_ForwardAuth(t *testing.T) {
+	nodes := startTestCluster(t, 2, true, "secret")
+	conn := dialTestNode(t, nodes[0])
+
+	remote := keyOwnedBy(nodes[1], "c")
+	if r := send(t, conn, "set", remote, "1"); !strings.HasPrefix(r, "-NOAUTH") {
+		t.Fatal("认证之前应该回复 NOAUTH.", r)
+	}
+	send(t, conn, "auth", "secret")
+	if r := send(t, conn, "set", remote, "1"); r != "+OK" {
+		t.Fatal("转发的连接应该以节点的密码认证.", r)
+	}
+	if _, ok := nodes[1].db.Get(remote); !ok {
+		t.Error("命令没有在负责的节点上执行.")
+	}
+}
+
+func TestCluster_Migrating(t *testing.T) {
+	nodes := startTestCluster(t, 2, false, "")
+	source, target := dialTestNode(t, nodes[0]), dialTestNode(t, nodes[1])
+
+	// 两个 key 的 hash tag 相同, 属于同一个槽
+	tag := keyOwnedBy(nodes[0], "t")
+	moved, kept := "{"+tag+"}moved", "{"+tag+"}kept"
+	slot := strconv.Itoa(HashSlot(moved))
+	send(t, source, "set", moved, "1")
+	send(t, source, "set", kept, "2")
+
+	if r := send(t, target, "cluster", "setslot", slot, "importing", nodes[0].addr); r != "+OK" {
+		t.Fatal(r)
+	}
+	if r := send(t, source, "cluster", "setslot", slot, "migrating", nodes[1].addr); r != "+OK" {
+		t.Fatal(r)
+	}
+
+	// 模拟 moved 已经迁移到目标节点
+	nodes[0].db.Remove(moved)
+	nodes[1].db.Put(moved, &database.DataEntity{Data: []byte("1")})
+
+	if r := send(t, source, "get", kept); r != "$1\r\n2" {
+		t.Error("尚未迁移的 key 应该在源节点执行.", r)
+	}
+	if r := send(t, source, "get", moved); r != "-ASK "+slot+" "+nodes[1].addr {
+		t.Fatal("已经迁移的 key 应该回复 ASK.", r)
+	}
+	if r := send(t, source, "exists", moved, kept); !strings.HasPrefix(r, "-TRYAGAIN") {
+		t.Error("部分迁移的多个 key 应该回复 TRYAGAIN.", r)
+	}
+	if r := send(t, target, "get", moved); !strings.HasPrefix(r, "-MOVED") {
+		t.Error("没有 ASKING 时目标节点应该回复 MOVED.", r)
+	}
+	send(t, target, "asking")
+	if r := send(t, target, "get", moved); r != "$1\r\n1" {
+		t.Error("ASKING 之后目标节点应该执行命令.", r)
+	}
+	if r := send(t, target, "get", moved); !strings.HasPrefix(r, "-MOVED") {
+		t.Error("ASKING 只对下一条命令有效.", r)
+	}
+
+	if r := send(t, target, "cluster", "countkeysinslot", slot); r != ":1" {
+		t.Error("CLUSTER COUNTKEYSINSLOT 错误.", r)
+	}
+	for _, conn := range []*client.Client{source, target} {
+		send(t, conn, "cluster", "setslot", slot, "node", nodes[1].addr)
+	}
+	if r := send(t, source, "get", moved); r != "-MOVED "+slot+" "+nodes[1].addr {
+		t.Error("迁移完成后源节点应该回复 MOVED.", r)
+	}
+	if r := send(t, target, "get", moved); r != "$1\r\n1" {
+		t.Error("迁移完成后目标节点应该直接执行.", r)
+	}
+}
@@ -0,0 +1,263 @@
+package cluster
+
+import (
+	"net"
+	"simple_kvstorage/core"
+	"simple_kvstorage/database"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	// CLUSTER 与 ASKING 由 Route 直接执行, 只注册元数据
+	executor.RegisterCommand("cluster", nil, -2, 0, executor.NoKeys)
+	executor.RegisterCommand("asking", nil, 1, executor.FlagFast, executor.NoKeys, "connection")
+}
+
+// slotRange 一段连续的, 属于同一个节点的槽
+type slotRange struct {
+	start int
+	end   int
+	owner string
+}
+
+// slotRanges 返回全部槽的归属, 调用者需要持有 mutex 的读锁
+func (c *Cluster) slotRanges() []slotRange {
+	var ranges []slotRange
+	for slot := 0; slot < SlotCount; slot++ {
+		if n := len(ranges); n > 0 && ranges[n-1].owner == c.owners[slot] {
+			ranges[n-1].end = slot
+			continue
+		}
+		ranges = append(ranges, slotRange{start: slot, end: slot, owner: c.owners[slot]})
+	}
+	return ranges
+}
+
+// execCluster CLUSTER subcommand [args ...]
+// 参考: https://redis.io/commands/cluster
+func (c *Cluster) execCluster(conn *core.Client, cmdLine executor.CmdLine) reply.Reply {
+	if len(cmdLine) < 2 {
+		return reply.NewArgNumberErrorReply("cluster")
+	}
+
+	subcommand := strings.ToLower(string(cmdLine[1]))
+	args := cmdLine[2:]
+	switch subcommand {
+	case "info":
+		return c.execInfo()
+	case "myid":
+		return reply.NewBulkReply([]byte(c.self))
+	case "nodes":
+		return c.execNodes()
+	case "slots":
+		return c.execSlots()
+	case "keyslot":
+		if len(args) != 1 {
+			return reply.NewArgNumberErrorReply("cluster keyslot")
+		}
+		return reply.NewIntReply(int64(HashSlot(string(args[0]))))
+	case "countkeysinslot":
+		if len(args) != 1 {
+			return reply.NewArgNumberErrorReply("cluster countkeysinslot")
+		}
+		slot, err := parseSlot(args[0])
+		if err != nil {
+			return err
+		}
+		return reply.NewIntReply(int64(len(c.keysInSlot(slot, -1))))
+	case "getkeysinslot":
+		if len(args) != 2 {
+			return reply.NewArgNumberErrorReply("cluster getkeysinslot")
+		}
+		slot, err := parseSlot(args[0])
+		if err != nil {
+			return err
+		}
+		count, parseErr := strconv.Atoi(string(args[1]))
+		if parseErr != nil || count < 0 {
+			return reply.NewStandardErrorReply("ERROR invalid number of keys")
+		}
+		return reply.NewMultiBulkReply(c.keysInSlot(slot, count))
+	case "setslot":
+		return c.execSetSlot(args)
+	case "forwarded":
+		// 其他节点建立的用于转发命令的连接
+		conn.SetForwarded(true)
+		return reply.GetOkReply()
+	default:
+		return reply.NewStandardErrorReply("ERROR unknown subcommand '" + subcommand + "'. Try CLUSTER HELP.")
+	}
+}
+
+func parseSlot(arg []byte) (int, reply.ErrorReply) {
+	slot, err := strconv.Atoi(string(arg))
+	if err != nil || slot < 0 || slot >= SlotCount {
+		return 0, reply.NewStandardErrorReply("ERROR Invalid or out of range slot")
+	}
+	return slot, nil
+}
+
+// keysInSlot 返回本节点中属于 slot 的 key, limit 为负数表示不限制数量
+func (c *Cluster) keysInSlot(slot int, limit int) [][]byte {
+	var keys [][]byte
+	if limit == 0 {
+		return keys
+	}
+	c.db.ForEach(func(key string, _ *database.DataEntity) bool {
+		if HashSlot(key) == slot {
+			keys = append(keys, []byte(key))
+		}
+		return limit < 0 || len(keys) < limit
+	})
+	return keys
+}
+
+// execInfo CLUSTER INFO
+func (c *Cluster) execInfo() reply.Reply {
+	c.mutex.RLock()
+	fields := [][2]string{
+		{"cluster_enabled", "1"},
+		{"cluster_state", "ok"},
+		{"cluster_slots_assigned", strconv.Itoa(SlotCount)},
+		{"cluster_known_nodes", strconv.Itoa(len(c.nodes))},
+		{"cluster_slots_migrating", strconv.Itoa(len(c.migrating))},
+		{"cluster_slots_importing", strconv.Itoa(len(c.importing))},
+		{"cluster_forward", yesNo(c.forward)},
+	}
+	c.mutex.RUnlock()
+
+	var builder strings.Builder
+	for _, field := range fields {
+		builder.WriteString(field[0] + ":" + field[1] + reply.CRLF)
+	}
+	return reply.NewVerbatimReply("txt", []byte(builder.String()))
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// execNodes CLUSTER NODES, 每行一个节点: id addr flags master ping-sent pong-recv config-epoch link-state slot ...
+// 节点的 ID 就是其地址. 正在迁移的槽以 [slot->-node] 和 [slot-<-node] 表示.
+func (c *Cluster) execNodes() reply.Reply {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	slots := make(map[string][]string)
+	for _, r := range c.slotRanges() {
+		if r.start == r.end {
+			slots[r.owner] = append(slots[r.owner], strconv.Itoa(r.start))
+		} else {
+			slots[r.owner] = append(slots[r.owner], strconv.Itoa(r.start)+"-"+strconv.Itoa(r.end))
+		}
+	}
+	for slot, target := range c.migrating {
+		slots[c.self] = append(slots[c.self], "["+strconv.Itoa(slot)+"->-"+target+"]")
+	}
+	for slot, source := range c.importing {
+		slots[c.self] = append(slots[c.self], "["+strconv.Itoa(slot)+"-<-"+source+"]")
+	}
+
+	var builder strings.Builder
+	for _, node := range c.nodes {
+		flags := "master"
+		if node == c.self {
+			flags = "myself,master"
+		}
+		builder.WriteString(node + " " + node + "@0 " + flags + " - 0 0 0 connected")
+		for _, s := range slots[node] {
+			builder.WriteString(" " + s)
+		}
+		builder.WriteString("\n")
+	}
+	return reply.NewVerbatimReply("txt", []byte(builder.String()))
+}
+
+// execSlots CLUSTER SLOTS, 返回 [[start, end, [host, port, id]], ...]
+func (c *Cluster) execSlots() reply.Reply {
+	c.mutex.RLock()
+	ranges := c.slotRanges()
+	c.mutex.RUnlock()
+
+	replies := make([]reply.Reply, 0, len(ranges))
+	for _, r := range ranges {
+		host, port, _ := net.SplitHostPort(r.owner)
+		portNumber, _ := strconv.Atoi(port)
+		node := reply.NewMultiRawReply([]reply.Reply{
+			reply.NewBulkReply([]byte(host)),
+			reply.NewIntReply(int64(portNumber)),
+			reply.NewBulkReply([]byte(r.owner)),
+		})
+		replies = append(replies, reply.NewMultiRawReply([]reply.Reply{
+			reply.NewIntReply(int64(r.start)),
+			reply.NewIntReply(int64(r.end)),
+			node,
+		}))
+	}
+	return reply.NewMultiRawReply(replies)
+}
+
+// execSetSlot CLUSTER SETSLOT slot IMPORTING node | MIGRATING node | NODE node | STABLE
+// 与 Redis 相同, 迁移时需要在相关的节点上分别执行, 节点之间不会互相通知.
+func (c *Cluster) execSetSlot(args [][]byte) reply.Reply {
+	if len(args) < 2 {
+		return reply.NewArgNumberErrorReply("cluster setslot")
+	}
+	slot, errReply := parseSlot(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	action := strings.ToLower(string(args[1]))
+	if action == "stable" {
+		if len(args) != 2 {
+			return reply.NewArgNumberErrorReply("cluster setslot")
+		}
+		c.mutex.Lock()
+		delete(c.migrating, slot)
+		delete(c.importing, slot)
+		c.mutex.Unlock()
+		return reply.GetOkReply()
+	}
+
+	if len(args) != 3 {
+		return reply.NewArgNumberErrorReply("cluster setslot")
+	}
+	node := string(args[2])
+	if !contains(c.nodes, node) {
+		return reply.NewStandardErrorReply("ERROR Unknown node " + node)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch action {
+	case "migrating":
+		if c.owners[slot] != c.self {
+			return reply.NewStandardErrorReply("ERROR I'm not the owner of hash slot " + strconv.Itoa(slot))
+		}
+		if node == c.self {
+			return reply.NewStandardErrorReply("ERROR can't migrate a slot to myself")
+		}
+		c.migrating[slot] = node
+	case "importing":
+		if c.owners[slot] == c.self {
+			return reply.NewStandardErrorReply("ERROR I'm already the owner of hash slot " + strconv.Itoa(slot))
+		}
+		if node == c.self {
+			return reply.NewStandardErrorReply("ERROR can't import a slot from myself")
+		}
+		c.importing[slot] = node
+	case "node":
+		c.owners[slot] = node
+		delete(c.migrating, slot)
+		delete(c.importing, slot)
+	default:
+		return reply.GetSyntaxErrReply()
+	}
+	return reply.GetOkReply()
+}
@@ -0,0 +1,19 @@
+package cluster
+
+import (
+	"simple_kvstorage/executor"
+)
+
+// commandKeys 返回命令中的全部 key, key 的位置由注册命令时的 KeySpec 决定.
+// 没有 key 的命令 (如 PING, KEYS, FLUSHDB) 返回 nil, 总是在本节点执行.
+func commandKeys(cmdName string, cmdLine executor.CmdLine) []string {
+	info, ok := executor.Lookup(cmdName)
+	if !ok {
+		return nil
+	}
+	var keys []string
+	for _, key := range info.Keys.Keys(cmdLine) {
+		keys = append(keys, string(key))
+	}
+	return keys
+}
@@ -0,0 +1,42 @@
+package cluster
+
+import "strings"
+
+// SlotCount 哈希槽的数量, 与 Redis Cluster 相同
+const SlotCount = 16384
+
+// crc16Table CRC16 (XMODEM, 多项式 0x1021) 的查找表
+var crc16Table [256]uint16
+
+func init() {
+	for i := range crc16Table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^data[i]]
+	}
+	return crc
+}
+
+// HashSlot 计算 key 所属的哈希槽.
+// key 中包含非空的 {hash tag} 时只使用第一个 { 与其后第一个 } 之间的部分计算, 使相关的 key 落在同一个槽中.
+func HashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % SlotCount
+}
@@ -0,0 +1,25 @@
+package cluster
+
+import "testing"
+
+func TestHashSlot(t *testing.T) {
+	// 与 Redis 的 CLUSTER KEYSLOT 结果相同
+	for key, slot := range map[string]int{
+		"":                     0,
+		"foo":                  12182,
+		"bar":                  5061,
+		"123456789":            0x31C3 % SlotCount,
+		"{user1000}.following": HashSlot("user1000"),
+		"{}user1000":           HashSlot("{}user1000"),
+		"foo{}{bar}":           HashSlot("foo{}{bar}"),
+		"foo{{bar}}zap":        HashSlot("{bar"),
+		"foo{bar}{zap}":        HashSlot("bar"),
+	} {
+		if got := HashSlot(key); got != slot {
+			t.Errorf("HashSlot(%q) = %d, 应该是 %d", key, got, slot)
+		}
+	}
+	if HashSlot("{}user1000") == HashSlot("user1000") {
+		t.Error("空的 hash tag 应该被忽略.")
+	}
+}
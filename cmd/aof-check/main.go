@@ -0,0 +1,353 @@
+// aof-check 离线检查 AOF.
+//
+// 用法:
+//
+//	aof-check [-dump] [-fix] <appenddirname | manifest | appendfilename>
+//
+// 参数是 AOF 目录或其中的清单时, 按清单的顺序检查每个文件: 快照格式的基础文件校验其 crc64, 其他文件逐条解析命令.
+// 参数是单个文件时只检查这个文件. 输出各命令与各数据库的命令数量, 以及基础文件中各数据库的 key 数量.
+// 遇到无法解析的内容时, 给出损坏的文件, 第一条损坏命令的偏移量和序号.
+// -dump 以可读的形式逐条输出命令及其偏移量, -fix 将损坏的文件截断到最后一条完整的命令 (截断前会备份原文件).
+// 与加载时相同, 只有最后一个文件可以被修复.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"simple_kvstorage/database"
+	"simple_kvstorage/persistent"
+	"simple_kvstorage/resp"
+	"simple_kvstorage/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// record AOF 文件中的一条命令
+type record struct {
+	// Offset 命令在文件中的起始字节偏移
+	Offset int64
+	// DB 命令所在的数据库
+	DB      int
+	CmdLine [][]byte
+}
+
+// report 检查的结果
+type report struct {
+	// Records 解析出的命令总数, 包括 select
+	Records int
+	// Commands 数据命令的总数, 不包括 select
+	Commands      int
+	CommandsByCmd map[string]int
+	CommandsPerDB map[int]int
+	// KeysPerDB 快照格式的基础文件中各数据库的 key 数量
+	KeysPerDB map[int]int
+	// ValidOffset 最后一条完整的命令结束的位置, 文件完好时等于文件大小
+	ValidOffset int64
+	// Err 第一处损坏, 文件完好时为 nil
+	Err error
+	// Truncated 损坏是否是因为文件末尾的命令不完整
+	Truncated bool
+	// File 损坏的文件, BadCommand 第一条损坏的命令在这个文件中的序号, 只由 checkFiles 设置.
+	// Fixable 是否可以用 -fix 修复: 只有最后一个命令格式的文件可以.
+	File       string
+	BadCommand int
+	Fixable    bool
+}
+
+func newReport() *report {
+	return &report{
+		CommandsByCmd: make(map[string]int),
+		CommandsPerDB: make(map[int]int),
+		KeysPerDB:     make(map[int]int),
+	}
+}
+
+// merge 将一个文件的检查结果合并到 r 中, 损坏的信息以 other 为准
+func (r *report) merge(other *report) {
+	r.Records += other.Records
+	r.Commands += other.Commands
+	for name, n := range other.CommandsByCmd {
+		r.CommandsByCmd[name] += n
+	}
+	for db, n := range other.CommandsPerDB {
+		r.CommandsPerDB[db] += n
+	}
+	for db, n := range other.KeysPerDB {
+		r.KeysPerDB[db] += n
+	}
+	r.ValidOffset, r.Err, r.Truncated = other.ValidOffset, other.Err, other.Truncated
+}
+
+// listFiles 返回 path 对应的待检查的文件: path 是目录时读取其中唯一的清单, 是清单时读取这个清单, 否则是单个文件
+func listFiles(path string) ([]persistent.AofFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		manifests, err := filepath.Glob(filepath.Join(path, "*.manifest"))
+		if err != nil {
+			return nil, err
+		}
+		if len(manifests) != 1 {
+			return nil, fmt.Errorf("expected exactly one manifest in %s, found %d", path, len(manifests))
+		}
+		path = manifests[0]
+	}
+	if strings.HasSuffix(path, ".manifest") {
+		return persistent.ReadAofManifest(filepath.Dir(path), strings.TrimSuffix(filepath.Base(path), ".manifest"))
+	}
+	return []persistent.AofFile{{Path: path, Rdb: strings.HasSuffix(path, ".rdb")}}, nil
+}
+
+// checkFiles 按顺序检查 files, 返回合计的结果. 遇到第一个损坏的文件时停止.
+func checkFiles(files []persistent.AofFile, onRecord func(*record)) *report {
+	total := newReport()
+	for i, f := range files {
+		var r *report
+		if f.Rdb {
+			r = checkRdb(f.Path)
+		} else {
+			r = checkAofFile(f.Path, onRecord)
+		}
+		total.merge(r)
+		if r.Err != nil {
+			total.File, total.BadCommand = f.Path, r.Records+1
+			total.Fixable = !f.Rdb && i == len(files)-1
+			return total
+		}
+	}
+	return total
+}
+
+// checkRdb 读取快照格式的基础文件, 统计各数据库的 key 数量并校验 crc64
+func checkRdb(path string) *report {
+	r := newReport()
+	file, err := os.Open(path)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	defer func() { _ = file.Close() }()
+	r.Err = persistent.ScanRdb(file, func(dbIndex int, _ string, _ *database.DataEntity, _ int64) error {
+		r.KeysPerDB[dbIndex]++
+		return nil
+	})
+	if errors.Is(r.Err, io.EOF) || errors.Is(r.Err, io.ErrUnexpectedEOF) {
+		r.Truncated = true
+	}
+	return r
+}
+
+// checkAofFile 逐条解析一个命令格式的文件
+func checkAofFile(path string, onRecord func(*record)) *report {
+	file, err := os.Open(path)
+	if err != nil {
+		r := newReport()
+		r.Err = err
+		return r
+	}
+	defer func() { _ = file.Close() }()
+	return check(file, onRecord)
+}
+
+// check 逐条解析 reader 中的命令, 对每条命令调用 onRecord. 遇到第一处损坏时停止.
+func check(reader io.Reader, onRecord func(*record)) *report {
+	r := newReport()
+
+	dbIndex := 0
+	parseChan := resp.CreateParser(reader)
+	defer func() {
+		// 提前退出时解析协程可能还在等待发送, 清空管道使其退出
+		for range parseChan {
+		}
+	}()
+	for payload := range parseChan {
+		if payload.Error != nil {
+			if payload.Error == io.EOF && payload.Offset == r.ValidOffset {
+				return r
+			}
+			r.Err = payload.Error
+			r.Truncated = payload.Error == io.EOF || payload.Error == io.ErrUnexpectedEOF
+			return r
+		}
+
+		multiBulk, ok := payload.Data.(*reply.MultiBulkReply)
+		if !ok || len(multiBulk.Args) == 0 {
+			r.Err = fmt.Errorf("unexpected reply '%s'", strings.TrimSpace(string(payload.Data.ToBytes())))
+			return r
+		}
+
+		r.Records++
+		cmdLine := multiBulk.Args
+		name := strings.ToLower(string(cmdLine[0]))
+		if name == "select" {
+			index := -1
+			if len(cmdLine) == 2 {
+				index, _ = strconv.Atoi(string(cmdLine[1]))
+			}
+			if index < 0 {
+				r.Err = fmt.Errorf("invalid DB index '%s'", cmdLine[1:])
+				return r
+			}
+			dbIndex = index
+		} else {
+			r.Commands++
+			r.CommandsByCmd[name]++
+			r.CommandsPerDB[dbIndex]++
+		}
+
+		if onRecord != nil {
+			onRecord(&record{Offset: r.ValidOffset, DB: dbIndex, CmdLine: cmdLine})
+		}
+		r.ValidOffset = payload.Offset
+	}
+	return r
+}
+
+// formatCmdLine 将命令格式化为可读的形式, 包含不可打印字符的参数会被转义
+func formatCmdLine(cmdLine [][]byte) string {
+	args := make([]string, len(cmdLine))
+	for i, arg := range cmdLine {
+		s := string(arg)
+		if i > 0 && (s == "" || strings.ContainsAny(s, " \"") || strconv.Quote(s) != `"`+s+`"`) {
+			s = strconv.Quote(s)
+		}
+		args[i] = s
+	}
+	return strings.Join(args, " ")
+}
+
+func printReport(w io.Writer, r *report) {
+	_, _ = fmt.Fprintf(w, "commands: %d\n", r.Commands)
+
+	names := make([]string, 0, len(r.CommandsByCmd))
+	for name := range r.CommandsByCmd {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, _ = fmt.Fprintf(w, "  %-16s %d\n", name, r.CommandsByCmd[name])
+	}
+
+	printPerDB(w, r.CommandsPerDB)
+
+	if len(r.KeysPerDB) > 0 {
+		keys := 0
+		for _, n := range r.KeysPerDB {
+			keys += n
+		}
+		_, _ = fmt.Fprintf(w, "base keys: %d\n", keys)
+		printPerDB(w, r.KeysPerDB)
+	}
+}
+
+func printPerDB(w io.Writer, perDB map[int]int) {
+	dbs := make([]int, 0, len(perDB))
+	for db := range perDB {
+		dbs = append(dbs, db)
+	}
+	sort.Ints(dbs)
+	for _, db := range dbs {
+		_, _ = fmt.Fprintf(w, "  db%-14d %d\n", db, perDB[db])
+	}
+}
+
+// truncate 备份文件后, 将其截断到最后一条完整的命令. 备份以流的方式复制, 不将整个文件读入内存.
+func truncate(filename string, offset int64) (string, error) {
+	backup := filename + ".bak-" + strconv.FormatInt(time.Now().Unix(), 10)
+	if err := copyFile(filename, backup); err != nil {
+		_ = os.Remove(backup)
+		return "", err
+	}
+	return backup, os.Truncate(filename, offset)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func main() {
+	dump := flag.Bool("dump", false, "print every command with its offset")
+	fix := flag.Bool("fix", false, "truncate the corrupted file to the last valid command")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintln(flag.CommandLine.Output(), "Usage: aof-check [-dump] [-fix] <appenddirname | manifest | appendfilename>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	files, err := listFiles(flag.Arg(0))
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var onRecord func(*record)
+	if *dump {
+		onRecord = func(r *record) {
+			fmt.Printf("%10d  db%-3d %s\n", r.Offset, r.DB, formatCmdLine(r.CmdLine))
+		}
+	}
+	r := checkFiles(files, onRecord)
+
+	printReport(os.Stdout, r)
+	if r.Err == nil {
+		fmt.Printf("AOF is valid, %d files\n", len(files))
+		return
+	}
+
+	kind := "corrupted"
+	if r.Truncated {
+		kind = "truncated"
+	}
+	info, err := os.Stat(r.File)
+	if err != nil {
+		fmt.Printf("%s: %v\n", r.File, r.Err)
+		os.Exit(1)
+	}
+	if !r.Fixable {
+		fmt.Printf("%s is %s: %v\n", r.File, kind, r.Err)
+		fmt.Println("only the last command file can be fixed")
+		os.Exit(1)
+	}
+	fmt.Printf("%s is %s at offset %d (command #%d): %v\n", r.File, kind, r.ValidOffset, r.BadCommand, r.Err)
+	fmt.Printf("%d bytes after the last valid command will be discarded by -fix\n", info.Size()-r.ValidOffset)
+	if !*fix {
+		os.Exit(1)
+	}
+
+	backup, err := truncate(r.File, r.ValidOffset)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "fix failed:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Backed up to %s, truncated to %d bytes\n", backup, r.ValidOffset)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"simple_kvstorage/database"
+	"simple_kvstorage/persistent"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const validAof = "*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n" +
+	"*2\r\n$6\r\nselect\r\n$1\r\n1\r\n" +
+	"*3\r\n$3\r\nset\r\n$1\r\nb\r\n$1\r\n2\r\n" +
+	"*2\r\n$3\r\ndel\r\n$1\r\nb\r\n"
+
+func TestCheck(t *testing.T) {
+	var records []*record
+	r := check(strings.NewReader(validAof), func(r *record) {
+		records = append(records, r)
+	})
+	if r.Err != nil || r.ValidOffset != int64(len(validAof)) || r.Records != 4 || r.Commands != 3 {
+		t.Fatal("检查完好的文件失败.", r)
+	}
+	if r.CommandsByCmd["set"] != 2 || r.CommandsByCmd["del"] != 1 || r.CommandsPerDB[0] != 1 || r.CommandsPerDB[1] != 2 {
+		t.Error("统计数据错误.", r.CommandsByCmd, r.CommandsPerDB)
+	}
+	if len(records) != 4 || records[1].Offset != 27 || records[2].DB != 1 {
+		t.Error("命令的偏移量或数据库错误.")
+	}
+}
+
+func TestCheck_Truncated(t *testing.T) {
+	r := check(strings.NewReader(validAof+"*3\r\n$3\r\nset\r\n$1\r"), nil)
+	if r.Err == nil || !r.Truncated || r.ValidOffset != int64(len(validAof)) || r.Records != 4 {
+		t.Error("没有检查出被截断的文件.", r)
+	}
+}
+
+func TestCheck_Corrupted(t *testing.T) {
+	r := check(strings.NewReader("*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$x\r\nfoo\r\n"+validAof), nil)
+	if r.Err == nil || r.Truncated || r.ValidOffset != 27 || r.Records != 1 {
+		t.Error("没有检查出损坏的文件.", r)
+	}
+}
+
+func TestFormatCmdLine(t *testing.T) {
+	got := formatCmdLine([][]byte{[]byte("set"), []byte("key"), []byte("hello world"), []byte("\x00\xff"), []byte("")})
+	if got != `set key "hello world" "\x00\xff" ""` {
+		t.Error(got)
+	}
+}
+
+// writeAofDir 在临时目录中写入快照格式的基础文件, 增量文件和清单, 返回目录
+func writeAofDir(t *testing.T, incrs ...string) string {
+	dir := t.TempDir()
+	dbs := []database.DB{database.NewConcurrentDB(0), database.NewConcurrentDB(1)}
+	dbs[0].Put("x", &database.DataEntity{Data: []byte("1")})
+	dbs[0].Put("y", &database.DataEntity{Data: []byte("2")})
+	dbs[1].Put("z", &database.DataEntity{Data: []byte("3")})
+	var base bytes.Buffer
+	if err := persistent.WriteRdb(&base, dbs); err != nil {
+		t.Fatal(err)
+	}
+	manifest := "file appendonly.aof.1.base.rdb seq 1 type b\n"
+	files := map[string][]byte{"appendonly.aof.1.base.rdb": base.Bytes()}
+	for i, incr := range incrs {
+		name := "appendonly.aof." + strconv.Itoa(i+1) + ".incr.aof"
+		manifest += "file " + name + " seq " + strconv.Itoa(i+1) + " type i\n"
+		files[name] = []byte(incr)
+	}
+	files["appendonly.aof.manifest"] = []byte(manifest)
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCheckFiles(t *testing.T) {
+	dir := writeAofDir(t, validAof, "*3\r\n$3\r\nset\r\n$1\r\nc\r\n$1\r\n3\r\n")
+	files, err := listFiles(dir)
+	if err != nil || len(files) != 3 || !files[0].Rdb || files[2].Path != filepath.Join(dir, "appendonly.aof.2.incr.aof") {
+		t.Fatal("应该按清单的顺序列出文件.", files, err)
+	}
+	if manifestFiles, err := listFiles(filepath.Join(dir, "appendonly.aof.manifest")); err != nil || len(manifestFiles) != 3 {
+		t.Error("参数可以是清单.", manifestFiles, err)
+	}
+
+	r := checkFiles(files, nil)
+	if r.Err != nil || r.Commands != 4 || r.CommandsByCmd["set"] != 3 || r.KeysPerDB[0] != 2 || r.KeysPerDB[1] != 1 {
+		t.Error("检查完好的 AOF 失败.", r)
+	}
+}
+
+func TestCheckFiles_Corrupted(t *testing.T) {
+	// 基础文件的校验和错误, 不能修复
+	dir := writeAofDir(t, validAof)
+	basePath := filepath.Join(dir, "appendonly.aof.1.base.rdb")
+	data, _ := os.ReadFile(basePath)
+	data[len(data)-1] ^= 0xff
+	_ = os.WriteFile(basePath, data, 0600)
+	files, _ := listFiles(dir)
+	if r := checkFiles(files, nil); !errors.Is(r.Err, persistent.ErrRdbChecksum) || r.File != basePath || r.Fixable {
+		t.Error("应该检查出基础文件的校验和错误.", r)
+	}
+
+	// 中间的增量文件被截断时不能修复, 最后一个文件可以
+	dir = writeAofDir(t, validAof+"*3\r\n$3\r\nset\r\n$1\r", validAof)
+	files, _ = listFiles(dir)
+	if r := checkFiles(files, nil); r.Err == nil || !r.Truncated || r.Fixable || r.File != files[1].Path || r.BadCommand != 5 {
+		t.Error("中间的文件损坏时不能修复.", r)
+	}
+	dir = writeAofDir(t, validAof, validAof+"*3\r\n$3\r\nset\r\n$1\r")
+	files, _ = listFiles(dir)
+	r := checkFiles(files, nil)
+	if r.Err == nil || !r.Fixable || r.File != files[2].Path || r.ValidOffset != int64(len(validAof)) || r.Commands != 6 {
+		t.Fatal("最后一个文件被截断时可以修复.", r)
+	}
+
+	backup, err := truncate(r.File, r.ValidOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(backup); string(data) != validAof+"*3\r\n$3\r\nset\r\n$1\r" {
+		t.Error("备份的内容错误.", string(data))
+	}
+	if r := checkFiles(files, nil); r.Err != nil {
+		t.Error("修复之后应该完好.", r.Err)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"simple_kvstorage/resp"
+	"simple_kvstorage/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// config 一次压测的配置
+type config struct {
+	addr string
+	db   int
+	// clients 并发的连接数, requests 全部连接发送的请求总数
+	clients  int
+	requests int
+	// pipeline 每个连接一次发送的请求数
+	pipeline int
+	// dataSize SET 的值的字节数
+	dataSize int
+	// keyspace 大于 0 时 key 在 [0, keyspace) 中随机选择, 否则所有请求使用同一个 key
+	keyspace int
+	// mixed mixed 负载中各命令的权重
+	mixed []weightedWorkload
+}
+
+// workload 生成一条命令, 每个连接使用自己的随机数源
+type workload func(cfg *config, rnd *rand.Rand, value []byte) [][]byte
+
+// workloads 支持的负载, key 与 redis-benchmark 相同. 只包括服务器已经实现的命令.
+var workloads = map[string]workload{
+	"ping": func(cfg *config, rnd *rand.Rand, value []byte) [][]byte {
+		return [][]byte{[]byte("ping")}
+	},
+	"set": func(cfg *config, rnd *rand.Rand, value []byte) [][]byte {
+		return [][]byte{[]byte("set"), randomKey("key:", cfg, rnd), value}
+	},
+	"get": func(cfg *config, rnd *rand.Rand, value []byte) [][]byte {
+		return [][]byte{[]byte("get"), randomKey("key:", cfg, rnd)}
+	},
+	"mixed": func(cfg *config, rnd *rand.Rand, value []byte) [][]byte {
+		return pickWorkload(cfg.mixed, rnd)(cfg, rnd, value)
+	},
+}
+
+// randomKey 与 redis-benchmark 相同, key 的数字部分是 12 位
+func randomKey(prefix string, cfg *config, rnd *rand.Rand) []byte {
+	n := 0
+	if cfg.keyspace > 0 {
+		n = rnd.Intn(cfg.keyspace)
+	}
+	return []byte(fmt.Sprintf("%s%012d", prefix, n))
+}
+
+// weightedWorkload mixed 负载中的一种命令及其权重
+type weightedWorkload struct {
+	name   string
+	weight int
+	work   workload
+}
+
+// parseMixed 解析 mixed 负载的比例, 如 get:80,set:20
+func parseMixed(spec string) ([]weightedWorkload, error) {
+	var mixed []weightedWorkload
+	for _, part := range strings.Split(spec, ",") {
+		name, weightText, ok := strings.Cut(strings.TrimSpace(part), ":")
+		name = strings.ToLower(name)
+		weight, err := strconv.Atoi(weightText)
+		if !ok || err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid mixed ratio %q, expected name:weight", part)
+		}
+		work, exists := workloads[name]
+		if !exists || name == "mixed" {
+			return nil, fmt.Errorf("unknown test %q in mixed ratio", name)
+		}
+		if weight > 0 {
+			mixed = append(mixed, weightedWorkload{name: name, weight: weight, work: work})
+		}
+	}
+	if len(mixed) == 0 {
+		return nil, errors.New("mixed ratio must have at least one positive weight")
+	}
+	return mixed, nil
+}
+
+func pickWorkload(mixed []weightedWorkload, rnd *rand.Rand) workload {
+	total := 0
+	for _, w := range mixed {
+		total += w.weight
+	}
+	n := rnd.Intn(total)
+	for _, w := range mixed {
+		if n < w.weight {
+			return w.work
+		}
+		n -= w.weight
+	}
+	return mixed[len(mixed)-1].work
+}
+
+// parseTests 解析以逗号分隔的负载名, 保持输入的顺序
+func parseTests(spec string) ([]string, error) {
+	var tests []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := workloads[name]; !ok {
+			names := make([]string, 0, len(workloads))
+			for name := range workloads {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown test %q, expected one of %s", name, strings.Join(names, ","))
+		}
+		tests = append(tests, name)
+	}
+	if len(tests) == 0 {
+		return nil, errors.New("no tests to run")
+	}
+	return tests, nil
+}
+
+// result 一个负载的压测结果
+type result struct {
+	name     string
+	requests int64
+	// errors 服务端回复错误的请求数
+	errors  int64
+	elapsed time.Duration
+	latency *histogram
+}
+
+func (r *result) requestsPerSecond() float64 {
+	if r.elapsed <= 0 {
+		return 0
+	}
+	return float64(r.requests) / r.elapsed.Seconds()
+}
+
+// run 建立 clients 个连接, 由它们一共发送 requests 个请求, 每个连接每次发送 pipeline 个请求并等待全部回复.
+// 一批请求中每个请求的延迟都记为这一批的往返时间. 全部请求都失败时 (如没有认证) 返回第一个错误, 不输出没有意义的结果.
+func run(cfg *config, name string) (*result, error) {
+	work := workloads[name]
+	conns := make([]net.Conn, 0, cfg.clients)
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	for i := 0; i < cfg.clients; i++ {
+		conn, err := dial(cfg)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+
+	value := bytes.Repeat([]byte("x"), cfg.dataSize)
+	var issued, errorCount int64
+	histograms := make([]histogram, len(conns))
+	errs := make([]error, len(conns))
+	// errorReplies 每个连接收到的第一个错误回复
+	errorReplies := make([]reply.ErrorReply, len(conns))
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(i int, conn net.Conn) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
+			decoder := resp.NewDecoder(conn)
+			var buf []byte
+			for {
+				// 领取一批请求, 最后一批可能不足 pipeline 个
+				end := atomic.AddInt64(&issued, int64(cfg.pipeline))
+				n := int64(cfg.pipeline)
+				if over := end - int64(cfg.requests); over > 0 {
+					n -= over
+				}
+				if n <= 0 {
+					return
+				}
+
+				buf = buf[:0]
+				for j := int64(0); j < n; j++ {
+					buf = append(buf, reply.NewMultiBulkReply(work(cfg, rnd, value)).ToBytes()...)
+				}
+				batchStart := time.Now()
+				if _, err := conn.Write(buf); err != nil {
+					errs[i] = err
+					return
+				}
+				for j := int64(0); j < n; j++ {
+					r, err := decoder.Decode()
+					if err != nil {
+						errs[i] = err
+						return
+					}
+					if errReply, ok := r.(reply.ErrorReply); ok {
+						atomic.AddInt64(&errorCount, 1)
+						if errorReplies[i] == nil {
+							errorReplies[i] = errReply
+						}
+					}
+				}
+				histograms[i].record(time.Since(batchStart), n)
+			}
+		}(i, conn)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := &result{name: name, errors: errorCount, elapsed: elapsed, latency: &histogram{}}
+	for i := range histograms {
+		res.latency.merge(&histograms[i])
+	}
+	res.requests = res.latency.count
+	if res.requests > 0 && res.errors == res.requests {
+		for _, errReply := range errorReplies {
+			if errReply != nil {
+				return nil, fmt.Errorf("all %d requests of %s failed: %s", res.requests, displayName(cfg, name), errReply.Error())
+			}
+		}
+	}
+	return res, nil
+}
+
+// dial 建立一个连接, db 不为 0 时选择数据库
+func dial(cfg *config) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", cfg.addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.db == 0 {
+		return conn, nil
+	}
+	_, err = conn.Write(reply.NewMultiBulkReply([][]byte{[]byte("select"), []byte(strconv.Itoa(cfg.db))}).ToBytes())
+	if err == nil {
+		var r reply.Reply
+		if r, err = resp.NewDecoder(conn).Decode(); err == nil {
+			if errReply, ok := r.(reply.ErrorReply); ok {
+				err = errReply
+			}
+		}
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
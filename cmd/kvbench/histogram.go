@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math/bits"
+	"time"
+)
+
+// 直方图的桶: 小于 2^linearBits 纳秒的值每个值一个桶, 更大的值按 2 的幂分段, 每段再平均分为 2^(linearBits-1) 个桶,
+// 因此记录的值的相对误差不超过 1/64.
+const (
+	linearBits    = 7
+	linearBuckets = 1 << linearBits
+	subBuckets    = linearBuckets / 2
+	bucketCount   = linearBuckets + (64-linearBits)*subBuckets
+)
+
+// histogram 延迟的直方图, 不能被多个协程同时使用, 各协程分别记录之后用 merge 合并
+type histogram struct {
+	counts [bucketCount]int64
+	count  int64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+func bucketIndex(v uint64) int {
+	if v < linearBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - linearBits
+	return linearBuckets + (shift-1)*subBuckets + int(v>>shift) - subBuckets
+}
+
+// bucketUpperBound 桶中最大的值
+func bucketUpperBound(index int) uint64 {
+	if index < linearBuckets {
+		return uint64(index)
+	}
+	shift := (index-linearBuckets)/subBuckets + 1
+	sub := uint64((index-linearBuckets)%subBuckets + subBuckets)
+	return (sub+1)<<shift - 1
+}
+
+// record 记录 n 个延迟为 d 的请求, 一批管道中的请求使用整批的往返时间
+func (h *histogram) record(d time.Duration, n int64) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[bucketIndex(uint64(d))] += n
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count += n
+	h.sum += d * time.Duration(n)
+}
+
+func (h *histogram) merge(other *histogram) {
+	if other.count == 0 {
+		return
+	}
+	for i, n := range other.counts {
+		h.counts[i] += n
+	}
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.count += other.count
+	h.sum += other.sum
+}
+
+// percentile 返回不小于 q% 的请求的延迟的最小值, q 的范围是 0 到 100
+func (h *histogram) percentile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return h.min
+	}
+	target := int64(q / 100 * float64(h.count))
+	if float64(target) < q/100*float64(h.count) || target == 0 {
+		target++
+	}
+	var cumulative int64
+	for i, n := range h.counts {
+		cumulative += n
+		if cumulative >= target {
+			// 桶的上界可能超过实际的最大值
+			if d := time.Duration(bucketUpperBound(i)); d < h.max {
+				return d
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// countBelow 返回延迟不超过 d 的请求的数量, 误差在一个桶之内
+func (h *histogram) countBelow(d time.Duration) int64 {
+	if d < 0 {
+		return 0
+	}
+	last := bucketIndex(uint64(d))
+	var cumulative int64
+	for i := 0; i <= last; i++ {
+		cumulative += h.counts[i]
+	}
+	return cumulative
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
@@ -0,0 +1,177 @@
+// kvbench 此服务器的压测工具, 参考 redis-benchmark.
+//
+// 用法:
+//
+//	kvbench [-h host] [-p port] [-c clients] [-n requests] [-P pipeline] [-d size] [-r keyspace]
+//	        [-t ping,set,get,mixed] [-mixed get:80,set:20] [-q | -csv]
+//
+// 依次运行 -t 中的负载. 每个负载建立 -c 个连接, 一共发送 -n 个请求, 每个连接每次发送 -P 个请求.
+// 输出吞吐量, 平均/最小/最大延迟, 延迟的分位数 (p50, p99, p99.9 等) 与累积分布. -csv 每个负载输出一行, 便于在 CI 中比较.
+// -r 大于 0 时 key 在 -r 个 key 中随机选择, mixed 负载按 -mixed 中的权重随机选择命令.
+// 某个负载的请求全部失败时输出第一个错误并以状态 1 退出.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportPercentiles 文本输出中的延迟分位数
+var reportPercentiles = []float64{0, 50, 75, 90, 95, 99, 99.9, 99.99, 100}
+
+// reportBounds 文本输出中的累积分布的边界
+var reportBounds = []time.Duration{
+	100 * time.Microsecond, 200 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond, time.Second,
+}
+
+// csvHeader -csv 输出的表头, 延迟的单位是毫秒
+const csvHeader = `"test","rps","avg_latency_ms","min_latency_ms","p50_latency_ms","p99_latency_ms","p999_latency_ms","max_latency_ms","errors"`
+
+func milliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+// writeReport 以文本输出一个负载的结果
+func writeReport(w io.Writer, cfg *config, r *result) {
+	h := r.latency
+	_, _ = fmt.Fprintf(w, "====== %s ======\n", displayName(cfg, r.name))
+	_, _ = fmt.Fprintf(w, "  %d requests completed in %.2f seconds\n", r.requests, r.elapsed.Seconds())
+	_, _ = fmt.Fprintf(w, "  %d parallel clients\n", cfg.clients)
+	_, _ = fmt.Fprintf(w, "  %d bytes payload\n", cfg.dataSize)
+	_, _ = fmt.Fprintf(w, "  pipeline %d\n", cfg.pipeline)
+	if r.errors > 0 {
+		_, _ = fmt.Fprintf(w, "  %d errors\n", r.errors)
+	}
+	_, _ = fmt.Fprintln(w)
+
+	_, _ = fmt.Fprintln(w, "Latency by percentile distribution:")
+	for _, q := range reportPercentiles {
+		_, _ = fmt.Fprintf(w, "%7.3f%% <= %s milliseconds\n", q, milliseconds(h.percentile(q)))
+	}
+	_, _ = fmt.Fprintln(w)
+
+	_, _ = fmt.Fprintln(w, "Cumulative distribution of latencies:")
+	for _, bound := range reportBounds {
+		below := h.countBelow(bound)
+		_, _ = fmt.Fprintf(w, "%7.3f%% <= %s milliseconds\n", percent(below, h.count), milliseconds(bound))
+		if below == h.count {
+			break
+		}
+	}
+	_, _ = fmt.Fprintln(w)
+
+	_, _ = fmt.Fprintln(w, "Summary:")
+	_, _ = fmt.Fprintf(w, "  throughput summary: %.2f requests per second\n", r.requestsPerSecond())
+	_, _ = fmt.Fprintln(w, "  latency summary (msec):")
+	_, _ = fmt.Fprintf(w, "  %9s %9s %9s %9s %9s %9s\n", "avg", "min", "p50", "p99", "p99.9", "max")
+	_, _ = fmt.Fprintf(w, "  %9s %9s %9s %9s %9s %9s\n\n", milliseconds(h.mean()), milliseconds(h.min),
+		milliseconds(h.percentile(50)), milliseconds(h.percentile(99)), milliseconds(h.percentile(99.9)), milliseconds(h.max))
+}
+
+// writeQuiet 以一行输出一个负载的结果
+func writeQuiet(w io.Writer, cfg *config, r *result) {
+	_, _ = fmt.Fprintf(w, "%s: %.2f requests per second, p50=%s msec", displayName(cfg, r.name), r.requestsPerSecond(), milliseconds(r.latency.percentile(50)))
+	if r.errors > 0 {
+		_, _ = fmt.Fprintf(w, ", %d errors", r.errors)
+	}
+	_, _ = fmt.Fprintln(w)
+}
+
+// writeCSV 以 CSV 的一行输出一个负载的结果, 列与 csvHeader 相同
+func writeCSV(w io.Writer, cfg *config, r *result) {
+	h := r.latency
+	_, _ = fmt.Fprintf(w, "%q,\"%.2f\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%d\"\n", displayName(cfg, r.name), r.requestsPerSecond(),
+		milliseconds(h.mean()), milliseconds(h.min), milliseconds(h.percentile(50)), milliseconds(h.percentile(99)),
+		milliseconds(h.percentile(99.9)), milliseconds(h.max), r.errors)
+}
+
+// displayName 输出中负载的名字, 如 SET 与 MIXED (get:80,set:20)
+func displayName(cfg *config, name string) string {
+	switch name {
+	case "ping":
+		return "PING_MBULK"
+	case "mixed":
+		ratios := make([]string, len(cfg.mixed))
+		for i, w := range cfg.mixed {
+			ratios[i] = w.name + ":" + strconv.Itoa(w.weight)
+		}
+		return "MIXED (" + strings.Join(ratios, ",") + ")"
+	}
+	return strings.ToUpper(name)
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	host := flag.String("h", "127.0.0.1", "server hostname")
+	port := flag.Int("p", 6379, "server port")
+	db := flag.Int("dbnum", 0, "database number")
+	clients := flag.Int("c", 50, "number of parallel connections")
+	requests := flag.Int("n", 100000, "total number of requests per test")
+	pipeline := flag.Int("P", 1, "number of requests sent together by each connection")
+	dataSize := flag.Int("d", 3, "data size of SET values in bytes")
+	keyspace := flag.Int("r", 0, "use random keys in the range [0, keyspace), 0 uses a single key")
+	tests := flag.String("t", "ping,set,get", "comma separated list of tests: ping,set,get,mixed")
+	mixed := flag.String("mixed", "get:80,set:20", "command ratios of the mixed test")
+	quiet := flag.Bool("q", false, "quiet, only show the throughput and p50 latency of each test")
+	csv := flag.Bool("csv", false, "output in CSV format")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintln(flag.CommandLine.Output(), "Usage: kvbench [options]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *clients <= 0 || *requests <= 0 || *pipeline <= 0 || *dataSize < 0 || *keyspace < 0 {
+		exitOnError(fmt.Errorf("-c, -n and -P must be positive, -d and -r must not be negative"))
+	}
+
+	names, err := parseTests(*tests)
+	exitOnError(err)
+	cfg := &config{
+		addr:     net.JoinHostPort(*host, strconv.Itoa(*port)),
+		db:       *db,
+		clients:  *clients,
+		requests: *requests,
+		pipeline: *pipeline,
+		dataSize: *dataSize,
+		keyspace: *keyspace,
+	}
+	cfg.mixed, err = parseMixed(*mixed)
+	exitOnError(err)
+
+	if *csv {
+		fmt.Println(csvHeader)
+	}
+	for _, name := range names {
+		r, err := run(cfg, name)
+		exitOnError(err)
+		switch {
+		case *csv:
+			writeCSV(os.Stdout, cfg, r)
+		case *quiet:
+			writeQuiet(os.Stdout, cfg, r)
+		default:
+			writeReport(os.Stdout, cfg, r)
+		}
+	}
+}
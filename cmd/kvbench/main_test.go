@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net"
+	"simple_kvstorage/core"
+	"simple_kvstorage/database"
+	_ "simple_kvstorage/executor/command"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 129, 1000, 123456, 1 << 40, 1<<62 + 12345} {
+		index := bucketIndex(v)
+		upper := bucketUpperBound(index)
+		if upper < v || (v >= linearBuckets && float64(upper-v)/float64(v) > 1.0/64) {
+			t.Errorf("%d 所在的桶的上界 %d 错误.", v, upper)
+		}
+		if index > 0 && bucketUpperBound(index-1) >= v {
+			t.Errorf("%d 应该在更小的桶中.", v)
+		}
+	}
+
+	h := &histogram{}
+	for i := 1; i <= 1000; i++ {
+		h.record(time.Duration(i)*time.Microsecond, 1)
+	}
+	other := &histogram{}
+	other.record(time.Second, 10)
+	h.merge(other)
+
+	if h.count != 1010 || h.min != time.Microsecond || h.max != time.Second {
+		t.Error("请求数或最小, 最大延迟错误.", h.count, h.min, h.max)
+	}
+	within := func(got, want time.Duration) bool {
+		return got >= want && float64(got-want) <= float64(want)/64
+	}
+	if p50 := h.percentile(50); !within(p50, 505*time.Microsecond) {
+		t.Error("p50 错误.", p50)
+	}
+	if p99 := h.percentile(99); !within(p99, 1000*time.Microsecond) {
+		t.Error("p99 错误.", p99)
+	}
+	if p999 := h.percentile(99.9); p999 != time.Second || h.percentile(100) != time.Second {
+		t.Error("p99.9 错误.", p999)
+	}
+	if h.percentile(0) != time.Microsecond {
+		t.Error("p0 应该是最小延迟.", h.percentile(0))
+	}
+	if n := h.countBelow(500 * time.Microsecond); n < 500 || n > 508 {
+		t.Error("累积分布错误.", n)
+	}
+	if (&histogram{}).percentile(99) != 0 {
+		t.Error("空的直方图的分位数应该是 0.")
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests, err := parseTests("SET, get,,mixed")
+	if err != nil || strings.Join(tests, ",") != "set,get,mixed" {
+		t.Error("解析负载错误.", tests, err)
+	}
+	for _, spec := range []string{"set,hset", "incr", "lpush"} {
+		if _, err := parseTests(spec); err == nil {
+			t.Errorf("%q: 未知的负载应该返回错误.", spec)
+		}
+	}
+
+	mixed, err := parseMixed("get:3,set:1,ping:0")
+	if err != nil || len(mixed) != 2 {
+		t.Fatal("解析比例错误.", mixed, err)
+	}
+	for _, spec := range []string{"get", "get:x", "get:-1", "mixed:1", "hset:1", "get:0"} {
+		if _, err := parseMixed(spec); err == nil {
+			t.Errorf("%q 应该返回错误.", spec)
+		}
+	}
+
+	// 按权重选择命令
+	cfg := &config{mixed: mixed, keyspace: 10}
+	rnd := rand.New(rand.NewSource(1))
+	gets := 0
+	for i := 0; i < 10000; i++ {
+		args := workloads["mixed"](cfg, rnd, []byte("v"))
+		if string(args[0]) == "get" {
+			gets++
+		}
+		if key := string(args[1]); len(key) != len("key:000000000000") || key > "key:000000000009" {
+			t.Fatal("随机的 key 错误.", key)
+		}
+	}
+	if gets < 7000 || gets > 8000 {
+		t.Error("mixed 负载的比例错误.", gets)
+	}
+	if displayName(cfg, "mixed") != "MIXED (get:3,set:1)" || displayName(cfg, "set") != "SET" {
+		t.Error("负载的名字错误.", displayName(cfg, "mixed"))
+	}
+}
+
+func TestRun(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbs := []database.DB{database.NewConcurrentDB(0), database.NewConcurrentDB(1)}
+	handler := core.NewHandler(dbs, nil)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handler.Handle(conn, context.Background())
+		}
+	}()
+	defer func() {
+		_ = listener.Close()
+		_ = handler.Close()
+	}()
+
+	cfg := &config{addr: listener.Addr().String(), db: 1, clients: 4, requests: 1001, pipeline: 16, dataSize: 10, keyspace: 100}
+	r, err := run(cfg, "set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.requests != 1001 || r.errors != 0 || r.latency.count != 1001 || r.requestsPerSecond() <= 0 {
+		t.Error("压测结果错误.", r.requests, r.errors)
+	}
+	if size := dbs[1].Size(); size == 0 || size > 100 || dbs[0].Size() != 0 {
+		t.Error("应该在 DB 1 中写入随机的 key.", size)
+	}
+
+	// 部分请求失败时输出失败的数量
+	r.errors = 3
+	var out bytes.Buffer
+	writeReport(&out, cfg, r)
+	for _, want := range []string{"====== SET ======", "1001 requests completed", "3 errors", "100.000% <=", "requests per second"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("输出中应该包括 %q.\n%s", want, out.String())
+		}
+	}
+	out.Reset()
+	writeCSV(&out, cfg, r)
+	if fields := strings.Split(strings.TrimSpace(out.String()), ","); len(fields) != strings.Count(csvHeader, ",")+1 ||
+		fields[0] != `"SET"` || fields[len(fields)-1] != `"3"` {
+		t.Error("CSV 输出错误.", out.String())
+	}
+	out.Reset()
+	writeQuiet(&out, cfg, r)
+	if !strings.HasPrefix(out.String(), "SET: ") || !strings.HasSuffix(out.String(), ", 3 errors\n") {
+		t.Error("-q 输出错误.", out.String())
+	}
+
+	// 全部请求都失败时返回第一个错误
+	handler.SetRequirePass("secret")
+	cfg.db = 0
+	if _, err := run(cfg, "get"); err == nil || !strings.Contains(err.Error(), "all 1001 requests of GET failed: NOAUTH") {
+		t.Error("全部请求失败时应该返回错误.", err)
+	}
+
+	cfg.addr = "127.0.0.1:1"
+	if _, err := run(cfg, "get"); err == nil {
+		t.Error("无法连接时应该返回错误.")
+	}
+}
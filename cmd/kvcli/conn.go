@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net"
+	"simple_kvstorage/resp"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"time"
+)
+
+// conn 与服务器的一个连接, 命令按 RESP 数组编码, 回复由项目的解析器解析
+type conn struct {
+	netConn net.Conn
+	decoder *resp.Decoder
+}
+
+// dial 建立连接, password 不为空时先认证, db 不为 0 时选择数据库
+func dial(addr, password string, db int, timeout time.Duration) (*conn, error) {
+	netConn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{netConn: netConn, decoder: resp.NewDecoder(netConn)}
+	var setup [][][]byte
+	if password != "" {
+		setup = append(setup, [][]byte{[]byte("auth"), []byte(password)})
+	}
+	if db != 0 {
+		setup = append(setup, [][]byte{[]byte("select"), []byte(strconv.Itoa(db))})
+	}
+	for _, args := range setup {
+		r, err := c.do(args)
+		if err == nil {
+			if errReply, ok := r.(reply.ErrorReply); ok {
+				err = errReply
+			}
+		}
+		if err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// do 发送一条命令并读取它的回复. 服务端回复的错误作为回复返回; 返回 error 时连接已经不可用.
+func (c *conn) do(args [][]byte) (reply.Reply, error) {
+	if _, err := c.netConn.Write(reply.NewMultiBulkReply(args).ToBytes()); err != nil {
+		return nil, err
+	}
+	return c.decoder.Decode()
+}
+
+func (c *conn) close() {
+	_ = c.netConn.Close()
+}
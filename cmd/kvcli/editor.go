@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxHistory 最多保存的历史记录条数
+const maxHistory = 1000
+
+// errInterrupted 输入时按下了 Ctrl-C
+var errInterrupted = errors.New("interrupted")
+
+// editor 交互模式的行编辑器. 输入是终端时进入原始模式, 支持光标移动, 历史记录, 命令名补全与参数提示;
+// 否则逐行读取输入.
+type editor struct {
+	fd  int
+	in  *bufio.Reader
+	out io.Writer
+
+	history []string
+	// historyFile 保存历史记录的文件, 为空时不保存
+	historyFile string
+
+	// hint 返回显示在输入之后的提示, complete 返回补全的候选, 都可以为 nil
+	hint     func(line string) string
+	complete func(line string) []string
+}
+
+func newEditor(in *os.File, out io.Writer, historyFile string) *editor {
+	e := &editor{fd: int(in.Fd()), in: bufio.NewReader(in), out: out, historyFile: historyFile}
+	e.loadHistory()
+	return e
+}
+
+// readLine 输出提示符并读取一行输入. 在空行上按 Ctrl-D 或输入结束时返回 io.EOF, 按 Ctrl-C 时返回 errInterrupted.
+func (e *editor) readLine(prompt string) (string, error) {
+	if isTerminal(e.fd) {
+		if state, err := makeRaw(e.fd); err == nil {
+			defer func() { _ = restoreTerminal(e.fd, state) }()
+			return e.edit(prompt)
+		}
+	}
+
+	_, _ = io.WriteString(e.out, prompt)
+	line, err := e.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// lineState 正在编辑的一行
+type lineState struct {
+	prompt string
+	buf    []rune
+	pos    int
+	// historyIndex 正在显示的历史记录, 等于历史记录的条数时显示的是新的输入, 它被保存在 pending 中
+	historyIndex int
+	pending      []rune
+}
+
+// edit 在原始模式下读取一行, 每次按键之后重新绘制整行
+func (e *editor) edit(prompt string) (string, error) {
+	s := &lineState{prompt: prompt, historyIndex: len(e.history)}
+	e.refresh(s)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			// 提交之前去掉提示
+			s.pos = len(s.buf)
+			e.draw(s, false)
+			_, _ = io.WriteString(e.out, "\n")
+			return string(s.buf), nil
+		case 3: // Ctrl-C
+			_, _ = io.WriteString(e.out, "^C\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(s.buf) == 0 {
+				_, _ = io.WriteString(e.out, "\n")
+				return "", io.EOF
+			}
+			s.deleteAt(s.pos)
+		case 127, 8: // Backspace, Ctrl-H
+			if s.pos > 0 {
+				s.pos--
+				s.deleteAt(s.pos)
+			}
+		case 1: // Ctrl-A
+			s.pos = 0
+		case 5: // Ctrl-E
+			s.pos = len(s.buf)
+		case 2: // Ctrl-B
+			s.move(-1)
+		case 6: // Ctrl-F
+			s.move(1)
+		case 21: // Ctrl-U
+			s.buf = append(s.buf[:0], s.buf[s.pos:]...)
+			s.pos = 0
+		case 11: // Ctrl-K
+			s.buf = s.buf[:s.pos]
+		case 23: // Ctrl-W
+			s.deleteWord()
+		case 12: // Ctrl-L
+			_, _ = io.WriteString(e.out, "\x1b[H\x1b[2J")
+		case 16: // Ctrl-P
+			e.showHistory(s, -1)
+		case 14: // Ctrl-N
+			e.showHistory(s, 1)
+		case '\t':
+			e.completeLine(s)
+		case 27: // ESC, 方向键等转义序列
+			if err := e.escape(s); err != nil {
+				return "", err
+			}
+		default:
+			if r >= ' ' {
+				s.insert(r)
+			}
+		}
+		e.refresh(s)
+	}
+}
+
+// escape 处理 ESC [ X, ESC O X 与 ESC [ n ~ 形式的转义序列
+func (e *editor) escape(s *lineState) error {
+	r, _, err := e.in.ReadRune()
+	if err != nil {
+		return err
+	}
+	if r != '[' && r != 'O' {
+		return nil
+	}
+	if r, _, err = e.in.ReadRune(); err != nil {
+		return err
+	}
+	if r >= '0' && r <= '9' {
+		n := r
+		for r != '~' {
+			if r, _, err = e.in.ReadRune(); err != nil {
+				return err
+			}
+		}
+		switch n {
+		case '3': // Delete
+			s.deleteAt(s.pos)
+		case '1', '7': // Home
+			s.pos = 0
+		case '4', '8': // End
+			s.pos = len(s.buf)
+		}
+		return nil
+	}
+	switch r {
+	case 'A':
+		e.showHistory(s, -1)
+	case 'B':
+		e.showHistory(s, 1)
+	case 'C':
+		s.move(1)
+	case 'D':
+		s.move(-1)
+	case 'H':
+		s.pos = 0
+	case 'F':
+		s.pos = len(s.buf)
+	}
+	return nil
+}
+
+func (s *lineState) insert(r rune) {
+	s.buf = append(s.buf, 0)
+	copy(s.buf[s.pos+1:], s.buf[s.pos:])
+	s.buf[s.pos] = r
+	s.pos++
+}
+
+func (s *lineState) deleteAt(pos int) {
+	if pos < len(s.buf) {
+		s.buf = append(s.buf[:pos], s.buf[pos+1:]...)
+	}
+}
+
+// deleteWord 删除光标之前的一个单词及其后的空格
+func (s *lineState) deleteWord() {
+	start := s.pos
+	for start > 0 && s.buf[start-1] == ' ' {
+		start--
+	}
+	for start > 0 && s.buf[start-1] != ' ' {
+		start--
+	}
+	s.buf = append(s.buf[:start], s.buf[s.pos:]...)
+	s.pos = start
+}
+
+func (s *lineState) move(delta int) {
+	if pos := s.pos + delta; pos >= 0 && pos <= len(s.buf) {
+		s.pos = pos
+	}
+}
+
+func (s *lineState) set(line []rune) {
+	s.buf = append(s.buf[:0], line...)
+	s.pos = len(s.buf)
+}
+
+// showHistory 显示上一条 (delta 为 -1) 或下一条历史记录
+func (e *editor) showHistory(s *lineState, delta int) {
+	index := s.historyIndex + delta
+	if index < 0 || index > len(e.history) {
+		return
+	}
+	if s.historyIndex == len(e.history) {
+		s.pending = append(s.pending[:0], s.buf...)
+	}
+	s.historyIndex = index
+	if index == len(e.history) {
+		s.set(s.pending)
+	} else {
+		s.set([]rune(e.history[index]))
+	}
+}
+
+// completeLine 只有一个候选时直接补全; 有多个候选时补全它们的公共前缀, 没有公共前缀可以补全时列出全部候选
+func (e *editor) completeLine(s *lineState) {
+	if e.complete == nil {
+		return
+	}
+	candidates := e.complete(string(s.buf))
+	switch len(candidates) {
+	case 0:
+		_, _ = io.WriteString(e.out, "\a")
+	case 1:
+		s.set([]rune(candidates[0] + " "))
+	default:
+		prefix := candidates[0]
+		for _, candidate := range candidates[1:] {
+			for !strings.HasPrefix(candidate, prefix) {
+				prefix = prefix[:len(prefix)-1]
+			}
+		}
+		if utf8.RuneCountInString(prefix) > len(s.buf) {
+			s.set([]rune(prefix))
+			return
+		}
+		_, _ = io.WriteString(e.out, "\r\n"+strings.Join(candidates, "  ")+"\n")
+	}
+}
+
+func (e *editor) refresh(s *lineState) {
+	e.draw(s, true)
+}
+
+// draw 重新绘制提示符, 输入与灰色的提示, 然后把光标移到 pos
+func (e *editor) draw(s *lineState, withHint bool) {
+	var builder strings.Builder
+	builder.WriteString("\r")
+	builder.WriteString(s.prompt)
+	builder.WriteString(string(s.buf))
+	if withHint && e.hint != nil {
+		if h := e.hint(string(s.buf)); h != "" {
+			builder.WriteString("\x1b[90m" + h + "\x1b[0m")
+		}
+	}
+	builder.WriteString("\x1b[K\r")
+	if column := utf8.RuneCountInString(s.prompt) + s.pos; column > 0 {
+		builder.WriteString("\x1b[" + strconv.Itoa(column) + "C")
+	}
+	_, _ = io.WriteString(e.out, builder.String())
+}
+
+// addHistory 记录一行输入, 忽略空行与和上一条相同的输入
+func (e *editor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+	e.saveHistory()
+}
+
+func (e *editor) loadHistory() {
+	if e.historyFile == "" {
+		return
+	}
+	data, err := os.ReadFile(e.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// saveHistory 重写历史记录文件, 写入失败时忽略
+func (e *editor) saveHistory() {
+	if e.historyFile == "" {
+		return
+	}
+	_ = os.WriteFile(e.historyFile, []byte(strings.Join(e.history, "\n")+"\n"), 0600)
+}
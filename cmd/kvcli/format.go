@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// outputMode 回复的输出格式
+type outputMode int
+
+const (
+	// modePretty 与 redis-cli 在终端中的输出相同, 如 (integer) 1, 1) "a"
+	modePretty outputMode = iota
+	// modeRaw 原样输出内容, 数组的每个元素一行, 输出不是终端时的默认格式
+	modeRaw
+	// modeCSV 一个回复一行, 数组的元素以逗号分隔, 字符串带引号
+	modeCSV
+	// modeJSON 一个回复一行 JSON
+	modeJSON
+)
+
+// formatReply 按输出格式将回复转换为文本, 不包括末尾的换行
+func formatReply(r reply.Reply, mode outputMode) string {
+	switch mode {
+	case modeRaw:
+		return formatRaw(r)
+	case modeCSV:
+		return formatCSV(r)
+	case modeJSON:
+		data, err := json.Marshal(jsonValue(r))
+		if err != nil {
+			return strconv.Quote(err.Error())
+		}
+		return string(data)
+	}
+	return formatPretty(r, "")
+}
+
+// formatPretty indent 是嵌套在数组中时后续行的缩进
+func formatPretty(r reply.Reply, indent string) string {
+	switch r := r.(type) {
+	case reply.ErrorReply:
+		return "(error) " + r.Error()
+	case *reply.StatusReply:
+		return r.Status
+	case *reply.IntReply:
+		return "(integer) " + strconv.FormatInt(r.Code, 10)
+	case *reply.BulkReply:
+		if r.Bytes() == nil {
+			return "(nil)"
+		}
+		return quote(r.Bytes())
+	case *reply.NullBulkReply, *reply.NullMultiBulkReply:
+		return "(nil)"
+	case *reply.EmptyMultiBulkReply:
+		return "(empty array)"
+	case *reply.MultiBulkReply:
+		elements := make([]reply.Reply, len(r.Args))
+		for i, arg := range r.Args {
+			elements[i] = reply.NewBulkReply(arg)
+		}
+		return formatPrettyList(elements, ")", indent)
+	case *reply.MultiRawReply:
+		// 嵌套的数组, 如 COMMAND INFO
+		return formatPrettyList(r.Replies, ")", indent)
+	case *reply.SetReply:
+		return formatPrettyList(r.Members, "~", indent)
+	case *reply.PushReply:
+		return formatPrettyList(r.Data, ">", indent)
+	case *reply.MapReply:
+		if len(r.Entries) == 0 {
+			return "(empty hash)"
+		}
+		lines := make([]string, len(r.Entries))
+		width := len(strconv.Itoa(len(r.Entries)))
+		for i, entry := range r.Entries {
+			// 值的后续行与值的第一行对齐
+			head := padLeft(strconv.Itoa(i+1), width) + "# " + formatPretty(entry.Key, "") + " => "
+			lines[i] = head + formatPretty(entry.Value, indent+strings.Repeat(" ", utf8.RuneCountInString(head)))
+		}
+		return strings.Join(lines, "\n"+indent)
+	case *reply.DoubleReply:
+		return "(double) " + formatDouble(r.Value)
+	case *reply.BooleanReply:
+		if r.Value {
+			return "(true)"
+		}
+		return "(false)"
+	case *reply.BigNumberReply:
+		return "(big number) " + r.Value.String()
+	case *reply.VerbatimReply:
+		return string(r.Text)
+	}
+	return strings.TrimSpace(string(r.ToBytes()))
+}
+
+// formatPrettyList 数组的每个元素一行, 带有右对齐的序号
+func formatPrettyList(elements []reply.Reply, mark string, indent string) string {
+	if len(elements) == 0 {
+		return "(empty array)"
+	}
+	width := len(strconv.Itoa(len(elements)))
+	lines := make([]string, len(elements))
+	for i, element := range elements {
+		prefix := padLeft(strconv.Itoa(i+1), width) + mark + " "
+		lines[i] = prefix + formatPretty(element, indent+strings.Repeat(" ", len(prefix)))
+	}
+	return strings.Join(lines, "\n"+indent)
+}
+
+func formatRaw(r reply.Reply) string {
+	switch r := r.(type) {
+	case reply.ErrorReply:
+		return r.Error()
+	case *reply.StatusReply:
+		return r.Status
+	case *reply.IntReply:
+		return strconv.FormatInt(r.Code, 10)
+	case *reply.BulkReply:
+		return string(r.Bytes())
+	case *reply.NullBulkReply, *reply.NullMultiBulkReply, *reply.EmptyMultiBulkReply:
+		return ""
+	case *reply.MultiBulkReply:
+		lines := make([]string, len(r.Args))
+		for i, arg := range r.Args {
+			lines[i] = string(arg)
+		}
+		return strings.Join(lines, "\n")
+	case *reply.VerbatimReply:
+		return string(r.Text)
+	}
+	if elements, ok := aggregateElements(r); ok {
+		lines := make([]string, len(elements))
+		for i, element := range elements {
+			lines[i] = formatRaw(element)
+		}
+		return strings.Join(lines, "\n")
+	}
+	return formatPretty(r, "")
+}
+
+func formatCSV(r reply.Reply) string {
+	switch r := r.(type) {
+	case reply.ErrorReply:
+		return "ERROR," + quote([]byte(r.Error()))
+	case *reply.StatusReply:
+		return quote([]byte(r.Status))
+	case *reply.IntReply:
+		return strconv.FormatInt(r.Code, 10)
+	case *reply.BulkReply:
+		if r.Bytes() == nil {
+			return "NULL"
+		}
+		return quote(r.Bytes())
+	case *reply.NullBulkReply, *reply.NullMultiBulkReply:
+		return "NULL"
+	case *reply.EmptyMultiBulkReply:
+		return ""
+	case *reply.MultiBulkReply:
+		fields := make([]string, len(r.Args))
+		for i, arg := range r.Args {
+			fields[i] = formatCSV(reply.NewBulkReply(arg))
+		}
+		return strings.Join(fields, ",")
+	case *reply.VerbatimReply:
+		return quote(r.Text)
+	}
+	if elements, ok := aggregateElements(r); ok {
+		fields := make([]string, len(elements))
+		for i, element := range elements {
+			fields[i] = formatCSV(element)
+		}
+		return strings.Join(fields, ",")
+	}
+	return quote([]byte(formatPretty(r, "")))
+}
+
+// aggregateElements 返回嵌套的数组与 RESP3 聚合类型的元素, Map 按 键, 值, 键, 值 ... 的顺序展开
+func aggregateElements(r reply.Reply) ([]reply.Reply, bool) {
+	switch r := r.(type) {
+	case *reply.MultiRawReply:
+		return r.Replies, true
+	case *reply.SetReply:
+		return r.Members, true
+	case *reply.PushReply:
+		return r.Data, true
+	case *reply.MapReply:
+		elements := make([]reply.Reply, 0, 2*len(r.Entries))
+		for _, entry := range r.Entries {
+			elements = append(elements, entry.Key, entry.Value)
+		}
+		return elements, true
+	}
+	return nil, false
+}
+
+// jsonValue 将回复转换为可以序列化为 JSON 的值, 错误为 {"error": "..."}
+func jsonValue(r reply.Reply) any {
+	switch r := r.(type) {
+	case reply.ErrorReply:
+		return map[string]string{"error": r.Error()}
+	case *reply.StatusReply:
+		return r.Status
+	case *reply.IntReply:
+		return r.Code
+	case *reply.BulkReply:
+		if r.Bytes() == nil {
+			return nil
+		}
+		return string(r.Bytes())
+	case *reply.NullBulkReply, *reply.NullMultiBulkReply:
+		return nil
+	case *reply.EmptyMultiBulkReply:
+		return []any{}
+	case *reply.MultiBulkReply:
+		values := make([]any, len(r.Args))
+		for i, arg := range r.Args {
+			if arg != nil {
+				values[i] = string(arg)
+			}
+		}
+		return values
+	case *reply.MultiRawReply:
+		return jsonValues(r.Replies)
+	case *reply.SetReply:
+		return jsonValues(r.Members)
+	case *reply.PushReply:
+		return jsonValues(r.Data)
+	case *reply.MapReply:
+		object := make(map[string]any, len(r.Entries))
+		for _, entry := range r.Entries {
+			object[formatRaw(entry.Key)] = jsonValue(entry.Value)
+		}
+		return object
+	case *reply.DoubleReply:
+		if math.IsInf(r.Value, 0) || math.IsNaN(r.Value) {
+			return formatDouble(r.Value)
+		}
+		return r.Value
+	case *reply.BooleanReply:
+		return r.Value
+	case *reply.BigNumberReply:
+		return r.Value.String()
+	case *reply.VerbatimReply:
+		return string(r.Text)
+	}
+	return formatPretty(r, "")
+}
+
+func jsonValues(elements []reply.Reply) []any {
+	values := make([]any, len(elements))
+	for i, element := range elements {
+		values[i] = jsonValue(element)
+	}
+	return values
+}
+
+// quote 与 redis-cli 相同, 带引号输出字符串, 不可见的字节转义为 \xHH
+func quote(s []byte) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+	for _, b := range s {
+		switch b {
+		case '\\', '"':
+			builder.WriteByte('\\')
+			builder.WriteByte(b)
+		case '\n':
+			builder.WriteString("\\n")
+		case '\r':
+			builder.WriteString("\\r")
+		case '\t':
+			builder.WriteString("\\t")
+		case '\a':
+			builder.WriteString("\\a")
+		case '\b':
+			builder.WriteString("\\b")
+		default:
+			if b < 0x20 || b >= 0x7f {
+				builder.WriteString("\\x")
+				builder.WriteString(strconv.FormatUint(uint64(b)>>4, 16))
+				builder.WriteString(strconv.FormatUint(uint64(b)&0xf, 16))
+			} else {
+				builder.WriteByte(b)
+			}
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
+
+func formatDouble(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "inf"
+	case math.IsInf(value, -1):
+		return "-inf"
+	case math.IsNaN(value):
+		return "nan"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func padLeft(s string, width int) string {
+	if len(s) >= width {
+		return s
+	}
+	return strings.Repeat(" ", width-len(s)) + s
+}
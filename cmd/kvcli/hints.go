@@ -0,0 +1,132 @@
+package main
+
+import (
+	// 由 core 与 cluster 处理的命令 (如 SELECT, CLUSTER) 也在 executor 中注册, 提示与 help 都来自注册的信息
+	_ "simple_kvstorage/cluster"
+	_ "simple_kvstorage/core"
+	"simple_kvstorage/executor"
+	_ "simple_kvstorage/executor/command"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// syntaxOf 返回命令的参数语法, 第二个返回值表示命令是否已知
+func syntaxOf(name string) (string, bool) {
+	info, ok := executor.Lookup(strings.ToLower(name))
+	if !ok {
+		return "", false
+	}
+	return syntaxFromInfo(info), true
+}
+
+// syntaxFromInfo 由注册命令时的参数数量与 key 的位置推导参数语法, 如 DEL 的 "key [key ...]".
+// 参数数量的含义与 executor.RegisterCommand 相同, 为负数时最后的可变参数按 key 的步长重复.
+func syntaxFromInfo(info executor.CommandInfo) string {
+	keys, step := info.Keys, info.Keys.Step
+	if step <= 0 {
+		step = 1
+	}
+	isKey := func(i int) bool {
+		return keys.First > 0 && i >= keys.First && (keys.Last < 0 || i <= keys.Last) && (i-keys.First)%step == 0
+	}
+	argName := func(i int) string {
+		if isKey(i) {
+			return "key"
+		}
+		return "arg"
+	}
+
+	n := info.Arity
+	if n < 0 {
+		n = -n
+	}
+	var args []string
+	for i := 1; i < n; i++ {
+		args = append(args, argName(i))
+	}
+	if info.Arity < 0 {
+		// key 一直到最后一个参数时, 可变参数是一组 key 与其后的参数, 否则是任意的参数
+		group := []string{"arg"}
+		if keys.First > 0 && keys.Last < 0 {
+			group = group[:0]
+			for i := keys.First; i < keys.First+step; i++ {
+				group = append(group, argName(i))
+			}
+		}
+		args = append(args, "["+strings.Join(group, " ")+" ...]")
+	}
+	return strings.Join(args, " ")
+}
+
+// hint 返回显示在输入之后的提示: 在命令名或一个完整的参数之后输入空格时, 提示还没有输入的参数
+func hint(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !strings.HasSuffix(line, " ") {
+		return ""
+	}
+	syntax, ok := syntaxOf(fields[0])
+	if !ok || syntax == "" {
+		return ""
+	}
+	// 跳过已经输入的必需参数, 可选参数的提示一直保留
+	tokens := strings.Fields(syntax)
+	for typed := len(fields) - 1; typed > 0 && len(tokens) > 0 && !strings.HasPrefix(tokens[0], "["); typed-- {
+		tokens = tokens[1:]
+	}
+	return strings.Join(tokens, " ")
+}
+
+// commandNames 全部已注册的命令名, 按字母顺序排列
+func commandNames() []string {
+	infos := executor.Commands()
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// complete 补全输入的命令名, 只在还没有输入参数时补全. 输入是大写时返回大写的命令名.
+func complete(line string) []string {
+	if line == "" || strings.ContainsAny(line, " \t") {
+		return nil
+	}
+	upper := strings.ToUpper(line) == line && strings.ToLower(line) != line
+	prefix := strings.ToLower(line)
+	var candidates []string
+	for _, name := range commandNames() {
+		if strings.HasPrefix(name, prefix) {
+			if upper {
+				name = strings.ToUpper(name)
+			}
+			candidates = append(candidates, name)
+		}
+	}
+	return candidates
+}
+
+// helpText 交互模式下 help 命令的输出: 不带参数时列出全部命令, 否则输出命令的语法
+func helpText(args []string) string {
+	if len(args) == 0 {
+		lines := []string{
+			"Type a command and press enter, or one of:",
+			"      help <command>   show the syntax of a command",
+			"      clear            clear the screen",
+			"      quit, exit       leave kvcli",
+			"",
+			"Commands:",
+		}
+		for _, name := range commandNames() {
+			syntax, _ := syntaxOf(name)
+			lines = append(lines, "      "+strings.ToUpper(name)+" "+syntax)
+		}
+		return strings.Join(lines, "\n")
+	}
+	syntax, ok := syntaxOf(args[0])
+	if !ok {
+		return "unknown command " + strconv.Quote(args[0])
+	}
+	return strings.ToUpper(args[0]) + " " + syntax
+}
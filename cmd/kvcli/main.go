@@ -0,0 +1,269 @@
+// kvcli 此服务器的命令行客户端.
+//
+// 用法:
+//
+//	kvcli [-h host] [-p port] [-a password] [-n db] [-raw | -no-raw | -csv | -json] [command [arg ...]]
+//	kvcli -pipe < commands.resp
+//	kvcli -pattern 'user:*'
+//	kvcli -stat [-i interval]
+//
+// 带有命令时执行这条命令后退出. 不带命令且标准输入是终端时进入交互模式: 支持行编辑与历史记录, Tab 补全命令名,
+// 在输入之后以灰色提示命令的参数, 输入 help 查看命令的语法. 历史记录保存在 ~/.kvcli_history,
+// 可以由环境变量 KVCLI_HISTFILE 指定其他文件, 为空时不保存. 标准输入不是终端时, 每行作为一条命令执行.
+//
+// 输出是终端时回复的格式与 redis-cli 相同, 否则原样输出; -csv 与 -json 每个回复输出一行.
+// -pipe 将标准输入中的 RESP 命令原样发送给服务器, 用于批量导入; -pattern 列出匹配的 key;
+// -stat 每隔一段时间输出 key 的数量, 内存, 连接数与请求数.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"simple_kvstorage/resp"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialTimeout 建立连接的超时时间
+const dialTimeout = 5 * time.Second
+
+// session 交互模式与逐行执行命令时使用的连接. 连接发生错误之后, 在下一条命令之前重新连接, 以之前的密码认证并选择之前的数据库.
+type session struct {
+	addr     string
+	password string
+	db       int
+	conn     *conn
+	mode     outputMode
+	out      io.Writer
+}
+
+// connect 连接还没有建立时建立连接
+func (s *session) connect() error {
+	if s.conn != nil {
+		return nil
+	}
+	c, err := dial(s.addr, s.password, s.db, dialTimeout)
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %w", s.addr, err)
+	}
+	s.conn = c
+	return nil
+}
+
+// exec 执行一条命令, 返回 error 时连接已经被关闭
+func (s *session) exec(args [][]byte) (reply.Reply, error) {
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	r, err := s.conn.do(args)
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	if _, isErr := r.(reply.ErrorReply); !isErr && len(args) == 2 {
+		switch strings.ToLower(string(args[0])) {
+		case "select":
+			s.db, _ = strconv.Atoi(string(args[1]))
+		case "auth":
+			s.password = string(args[1])
+		}
+	}
+	return r, nil
+}
+
+func (s *session) close() {
+	if s.conn != nil {
+		s.conn.close()
+		s.conn = nil
+	}
+}
+
+// prompt 交互模式的提示符, 如 127.0.0.1:6379[1]>
+func (s *session) prompt() string {
+	if s.conn == nil {
+		return "not connected> "
+	}
+	prompt := s.addr
+	if s.db != 0 {
+		prompt += "[" + strconv.Itoa(s.db) + "]"
+	}
+	return prompt + "> "
+}
+
+// runLine 执行一行输入并输出回复, 返回 false 表示输入了 quit 或 exit
+func (s *session) runLine(line string) bool {
+	args, err := resp.SplitInlineArgs(line)
+	if err != nil {
+		_, _ = fmt.Fprintln(s.out, "Invalid argument(s)")
+		return true
+	}
+	if len(args) == 0 {
+		return true
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "quit", "exit":
+		return false
+	case "clear":
+		_, _ = io.WriteString(s.out, "\x1b[H\x1b[2J")
+		return true
+	case "help":
+		helpArgs := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			helpArgs = append(helpArgs, string(arg))
+		}
+		_, _ = fmt.Fprintln(s.out, helpText(helpArgs))
+		return true
+	}
+
+	r, err := s.exec(args)
+	if err != nil {
+		_, _ = fmt.Fprintln(s.out, "Error:", err)
+		return true
+	}
+	_, _ = fmt.Fprintln(s.out, formatReply(r, s.mode))
+	return true
+}
+
+// interactive 交互模式, 直到输入 quit, exit, 或在空行上按下 Ctrl-D. Ctrl-C 丢弃正在输入的一行.
+func (s *session) interactive(e *editor) {
+	if err := s.connect(); err != nil {
+		_, _ = fmt.Fprintln(s.out, err)
+	}
+	for {
+		line, err := e.readLine(s.prompt())
+		if err == errInterrupted {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		e.addHistory(line)
+		if !s.runLine(line) {
+			return
+		}
+	}
+}
+
+// runPattern 使用 KEYS 列出匹配的 key, 每行一个
+func runPattern(c *conn, pattern string, out io.Writer) error {
+	r, err := c.do([][]byte{[]byte("keys"), []byte(pattern)})
+	if err != nil {
+		return err
+	}
+	switch r := r.(type) {
+	case reply.ErrorReply:
+		return r
+	case *reply.MultiBulkReply:
+		for _, key := range r.Args {
+			_, _ = fmt.Fprintln(out, string(key))
+		}
+	}
+	return nil
+}
+
+// historyFile 历史记录文件的路径, 由 KVCLI_HISTFILE 指定或默认在用户目录下
+func historyFile() string {
+	if file, ok := os.LookupEnv("KVCLI_HISTFILE"); ok {
+		return file
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kvcli_history")
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	host := flag.String("h", "127.0.0.1", "server hostname")
+	port := flag.Int("p", 6379, "server port")
+	password := flag.String("a", "", "password to use when connecting to the server")
+	db := flag.Int("n", 0, "database number")
+	raw := flag.Bool("raw", false, "use raw formatting for replies (default when stdout is not a tty)")
+	noRaw := flag.Bool("no-raw", false, "force formatted output even when stdout is not a tty")
+	csv := flag.Bool("csv", false, "output replies in CSV format")
+	jsonOutput := flag.Bool("json", false, "output replies in JSON format")
+	pipe := flag.Bool("pipe", false, "transfer raw RESP commands from stdin to the server")
+	pattern := flag.String("pattern", "", "list keys matching the pattern")
+	stat := flag.Bool("stat", false, "print rolling stats about the server")
+	interval := flag.Duration("i", time.Second, "interval between -stat samples")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintln(flag.CommandLine.Output(), "Usage: kvcli [options] [command [arg ...]]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	addr := net.JoinHostPort(*host, strconv.Itoa(*port))
+	mode := modeRaw
+	if isTerminal(int(os.Stdout.Fd())) {
+		mode = modePretty
+	}
+	switch {
+	case *jsonOutput:
+		mode = modeJSON
+	case *csv:
+		mode = modeCSV
+	case *raw:
+		mode = modeRaw
+	case *noRaw:
+		mode = modePretty
+	}
+
+	if *pipe || *pattern != "" || *stat {
+		c, err := dial(addr, *password, *db, dialTimeout)
+		exitOnError(err)
+		defer c.close()
+		switch {
+		case *pipe:
+			result, err := runPipe(c, os.Stdin, os.Stdout, os.Stderr)
+			exitOnError(err)
+			if result.Errors > 0 {
+				os.Exit(1)
+			}
+		case *pattern != "":
+			exitOnError(runPattern(c, *pattern, os.Stdout))
+		default:
+			exitOnError(runStat(c, *interval, 0, os.Stdout))
+		}
+		return
+	}
+
+	s := &session{addr: addr, password: *password, db: *db, mode: mode, out: os.Stdout}
+	defer s.close()
+	if flag.NArg() > 0 {
+		args := make([][]byte, flag.NArg())
+		for i, arg := range flag.Args() {
+			args[i] = []byte(arg)
+		}
+		r, err := s.exec(args)
+		exitOnError(err)
+		fmt.Println(formatReply(r, mode))
+		return
+	}
+
+	if isTerminal(int(os.Stdin.Fd())) {
+		e := newEditor(os.Stdin, os.Stdout, historyFile())
+		e.hint = hint
+		e.complete = complete
+		s.interactive(e)
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 512*1024*1024)
+	for scanner.Scan() && s.runLine(scanner.Text()) {
+	}
+	exitOnError(scanner.Err())
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"math/big"
+	"net"
+	"path/filepath"
+	"simple_kvstorage/core"
+	"simple_kvstorage/database"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer 在回环地址上启动的服务器
+type testServer struct {
+	listener net.Listener
+	dbs      []database.DB
+
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+func newTestServer(t *testing.T) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbs := []database.DB{database.NewConcurrentDB(0), database.NewConcurrentDB(1)}
+	handler := core.NewHandler(dbs, nil)
+	s := &testServer{listener: listener, dbs: dbs}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mutex.Lock()
+			s.conns = append(s.conns, conn)
+			s.mutex.Unlock()
+			go handler.Handle(conn, context.Background())
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		s.dropConnections()
+		_ = handler.Close()
+	})
+	return s
+}
+
+// dropConnections 断开全部已经建立的连接
+func (s *testServer) dropConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testServer) dial(t *testing.T) *conn {
+	c, err := dial(s.listener.Addr().String(), "", 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.close)
+	return c
+}
+
+func TestFormatReply(t *testing.T) {
+	array := reply.NewMultiBulkReply([][]byte{[]byte("a"), nil, []byte("x\n\"y\"\x01")})
+	long := make([][]byte, 10)
+	for i := range long {
+		long[i] = []byte(strconv.Itoa(i))
+	}
+	info := reply.NewMultiRawReply([]reply.Reply{reply.NewBulkReply([]byte("get")), reply.NewIntReply(2),
+		reply.NewSetReply([]reply.Reply{reply.NewStatusReply("readonly")})})
+	nested := reply.NewMapReply([]reply.MapEntry{
+		{Key: reply.NewBulkReply([]byte("k")), Value: reply.NewSetReply([]reply.Reply{reply.NewIntReply(1), reply.NewIntReply(2)})},
+	})
+
+	tests := []struct {
+		reply reply.Reply
+		mode  outputMode
+		want  string
+	}{
+		{reply.NewStatusReply("OK"), modePretty, "OK"},
+		{reply.NewStandardErrorReply("ERR bad"), modePretty, "(error) ERR bad"},
+		{reply.NewIntReply(-3), modePretty, "(integer) -3"},
+		{reply.NewBulkReply([]byte("v")), modePretty, `"v"`},
+		{&reply.NullBulkReply{}, modePretty, "(nil)"},
+		{&reply.EmptyMultiBulkReply{}, modePretty, "(empty array)"},
+		{array, modePretty, "1) \"a\"\n2) (nil)\n3) \"x\\n\\\"y\\\"\\x01\""},
+		{reply.NewMultiBulkReply(long), modePretty, " 1) \"0\"\n 2) \"1\"\n 3) \"2\"\n 4) \"3\"\n 5) \"4\"\n" +
+			" 6) \"5\"\n 7) \"6\"\n 8) \"7\"\n 9) \"8\"\n10) \"9\""},
+		{nested, modePretty, "1# \"k\" => 1~ (integer) 1\n          2~ (integer) 2"},
+		{reply.NewDoubleReply(1.5), modePretty, "(double) 1.5"},
+		{reply.NewBooleanReply(true), modePretty, "(true)"},
+		{reply.NewBigNumberReply(big.NewInt(7)), modePretty, "(big number) 7"},
+		{reply.NewVerbatimReply("txt", []byte("# Stats")), modePretty, "# Stats"},
+		{info, modePretty, "1) \"get\"\n2) (integer) 2\n3) 1~ readonly"},
+
+		{reply.NewStandardErrorReply("ERR bad"), modeRaw, "ERR bad"},
+		{reply.NewIntReply(5), modeRaw, "5"},
+		{&reply.NullBulkReply{}, modeRaw, ""},
+		{array, modeRaw, "a\n\nx\n\"y\"\x01"},
+		{info, modeRaw, "get\n2\nreadonly"},
+		{nested, modeRaw, "k\n1\n2"},
+
+		{array, modeCSV, `"a",NULL,"x\n\"y\"\x01"`},
+		{reply.NewIntReply(5), modeCSV, "5"},
+		{reply.NewStandardErrorReply("ERR bad"), modeCSV, `ERROR,"ERR bad"`},
+		{info, modeCSV, `"get",2,"readonly"`},
+		{nested, modeCSV, `"k",1,2`},
+
+		{array, modeJSON, `["a",null,"x\n\"y\"\u0001"]`},
+		{reply.NewIntReply(5), modeJSON, "5"},
+		{&reply.NullBulkReply{}, modeJSON, "null"},
+		{reply.NewStandardErrorReply("ERR bad"), modeJSON, `{"error":"ERR bad"}`},
+		{nested, modeJSON, `{"k":[1,2]}`},
+		{info, modeJSON, `["get",2,["readonly"]]`},
+	}
+	for i, test := range tests {
+		if got := formatReply(test.reply, test.mode); got != test.want {
+			t.Errorf("%d: 输出错误.\n%s\n应该是:\n%s", i, got, test.want)
+		}
+	}
+}
+
+func TestHint(t *testing.T) {
+	tests := map[string]string{
+		"":                 "",
+		"set":              "",
+		"set ":             "key arg [arg ...]",
+		"SET k ":           "arg [arg ...]",
+		"set k v ":         "[arg ...]",
+		"del ":             "key [key ...]",
+		"del a b ":         "[key ...]",
+		"rename ":          "key key",
+		"restore k 0 v ":   "[arg ...]",
+		"unknowncommand ":  "",
+		"lastsave ":        "",
+		"client ":          "arg [arg ...]",
+		"ping hello extra": "",
+	}
+	for line, want := range tests {
+		if got := hint(line); got != want {
+			t.Errorf("%q 的提示是 %q, 应该是 %q.", line, got, want)
+		}
+	}
+
+	// 由参数数量与 key 的位置推导语法
+	infos := map[string]executor.CommandInfo{
+		"key arg [key arg ...]": {Arity: -3, Keys: executor.KeySpec{First: 1, Last: -1, Step: 2}},
+		"arg key [arg ...]":     {Arity: -3, Keys: executor.KeySpec{First: 2, Last: 2, Step: 1}},
+		"arg arg":               {Arity: 3},
+		"[arg ...]":             {Arity: -1},
+	}
+	for want, info := range infos {
+		if got := syntaxFromInfo(info); got != want {
+			t.Errorf("%+v 的语法是 %q, 应该是 %q.", info, got, want)
+		}
+	}
+	// core 与 cluster 处理的命令也有提示
+	for _, name := range []string{"select", "hello", "cluster", "raft"} {
+		if _, ok := syntaxOf(name); !ok {
+			t.Error("命令应该已知.", name)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	if got := complete("ren"); strings.Join(got, ",") != "rename,renamenx" {
+		t.Error("补全错误.", got)
+	}
+	if got := complete("STRL"); strings.Join(got, ",") != "STRLEN" {
+		t.Error("大写的输入应该补全为大写.", got)
+	}
+	if got := complete("get k"); got != nil {
+		t.Error("输入参数之后不应该补全.", got)
+	}
+}
+
+// newTestEditor 从 input 中读取按键的编辑器
+func newTestEditor(input string, history ...string) *editor {
+	return &editor{
+		in:       bufio.NewReader(strings.NewReader(input)),
+		out:      io.Discard,
+		history:  history,
+		hint:     hint,
+		complete: complete,
+	}
+}
+
+func TestEditor_Edit(t *testing.T) {
+	tests := []struct {
+		input   string
+		history []string
+		want    string
+	}{
+		{"get a\r", nil, "get a"},
+		// 左移之后插入, Backspace
+		{"gt a\x1b[D\x1b[D\x1b[Dex\x7f\r", nil, "get a"},
+		// Ctrl-A 与 Ctrl-E, Delete
+		{"et a\x01g\x05b\x1b[3~\x1b[D\x1b[3~\r", nil, "get a"},
+		// Ctrl-W 删除上一个单词, Ctrl-U 删除光标之前的内容, Ctrl-K 删除光标之后的内容
+		{"set a b\x17\x17get a\r", nil, "set get a"},
+		{"xx get ab\x1b[D\x0b\x1b[D\x1b[D\x1b[D\x1b[D\x1b[D\x15\r", nil, "get a"},
+		// 方向键与 Ctrl-P, Ctrl-N 浏览历史记录, 回到最新时恢复正在输入的内容
+		{"\x1b[A\r", []string{"get a", "get b"}, "get b"},
+		{"\x1b[A\x1b[A\x1b[A\r", []string{"get a", "get b"}, "get a"},
+		{"new\x10\x0e\r", []string{"get a"}, "new"},
+		// Tab 补全命令名
+		{"strl\t a\r", nil, "strlen  a"},
+		{"getse\tk v\r", nil, "getset k v"},
+		{"rena\t\r", nil, "rename"},
+		// 多字节字符
+		{"set k 值\x1b[D\x7f\r", nil, "set k值"},
+	}
+	for i, test := range tests {
+		line, err := newTestEditor(test.input, test.history...).edit("> ")
+		if err != nil || line != test.want {
+			t.Errorf("%d: 输入 %q 得到 %q, 应该是 %q.", i, test.input, line, test.want)
+		}
+	}
+
+	if _, err := newTestEditor("\x04").edit("> "); err != io.EOF {
+		t.Error("在空行上按 Ctrl-D 应该返回 io.EOF.", err)
+	}
+	if _, err := newTestEditor("get\x03").edit("> "); err != errInterrupted {
+		t.Error("按 Ctrl-C 应该返回 errInterrupted.", err)
+	}
+}
+
+func TestEditor_History(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	e := &editor{historyFile: file}
+	for i := 0; i < maxHistory+10; i++ {
+		e.addHistory("get " + strconv.Itoa(i))
+	}
+	e.addHistory("get " + strconv.Itoa(maxHistory+9))
+	e.addHistory("  ")
+
+	loaded := &editor{historyFile: file}
+	loaded.loadHistory()
+	if len(loaded.history) != maxHistory || loaded.history[0] != "get 10" || loaded.history[maxHistory-1] != "get 1009" {
+		t.Error("历史记录文件错误.", len(loaded.history))
+	}
+}
+
+func TestSession(t *testing.T) {
+	s := newTestServer(t)
+	var out bytes.Buffer
+	sess := &session{addr: s.listener.Addr().String(), mode: modePretty, out: &out}
+	defer sess.close()
+
+	for _, line := range []string{`set k "a b"`, "get k", "select 1", "get k", `set "unbalanced`, "help strlen", ""} {
+		if !sess.runLine(line) {
+			t.Fatal("只有 quit 与 exit 应该退出.")
+		}
+	}
+	want := "OK\n\"a b\"\nOK\n(nil)\nInvalid argument(s)\nSTRLEN key\n"
+	if out.String() != want {
+		t.Errorf("输出错误.\n%s\n应该是:\n%s", out.String(), want)
+	}
+	if sess.prompt() != s.listener.Addr().String()+"[1]> " {
+		t.Error("提示符应该包括数据库.", sess.prompt())
+	}
+
+	// 服务端断开连接之后, 第一条命令报告错误, 之后重新连接并选择之前的数据库
+	s.dropConnections()
+	out.Reset()
+	sess.runLine("set x 1")
+	if !strings.HasPrefix(out.String(), "Error:") || sess.prompt() != "not connected> " {
+		t.Error("连接断开时应该报告错误.", out.String())
+	}
+	out.Reset()
+	sess.runLine("set x 1")
+	if _, ok := s.dbs[1].Get("x"); !ok || out.String() != "OK\n" {
+		t.Error("应该重新连接并选择之前的数据库.", out.String())
+	}
+	if sess.runLine("QUIT") {
+		t.Error("quit 应该退出.")
+	}
+}
+
+func TestSession_Resp3(t *testing.T) {
+	s := newTestServer(t)
+	var out bytes.Buffer
+	sess := &session{addr: s.listener.Addr().String(), mode: modePretty, out: &out}
+	defer sess.close()
+
+	// COMMAND INFO 的回复是嵌套的数组
+	sess.runLine("command info get")
+	if want := "1) 1) \"get\"\n   2) (integer) 2\n   3) 1) readonly\n      2) fast\n"; !strings.HasPrefix(out.String(), want) {
+		t.Errorf("COMMAND INFO 的输出错误.\n%s\n应该以此开头:\n%s", out.String(), want)
+	}
+
+	// HELLO 3 之后的回复按 RESP3 解析
+	out.Reset()
+	sess.runLine("hello 3")
+	if want := "1# \"server\" => \"simple_kvstorage\"\n"; !strings.HasPrefix(out.String(), want) ||
+		!strings.Contains(out.String(), "\"proto\" => (integer) 3\n") || !strings.Contains(out.String(), "\"modules\" => (empty array)\n") {
+		t.Errorf("HELLO 3 的输出错误.\n%s", out.String())
+	}
+	out.Reset()
+	for _, line := range []string{"get missing", "command info get"} {
+		sess.runLine(line)
+	}
+	if want := "(nil)\n1) 1) \"get\"\n   2) (integer) 2\n   3) 1~ readonly\n      2~ fast\n"; !strings.HasPrefix(out.String(), want) {
+		t.Errorf("RESP3 的输出错误.\n%s\n应该以此开头:\n%s", out.String(), want)
+	}
+}
+
+func TestRunPipe(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+
+	var input bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		key := []byte("key:" + strconv.Itoa(i))
+		input.Write(reply.NewMultiBulkReply([][]byte{[]byte("set"), key, key}).ToBytes())
+	}
+	input.Write(reply.NewMultiBulkReply([][]byte{[]byte("rename"), []byte("missing"), []byte("b")}).ToBytes())
+
+	var out, errOut bytes.Buffer
+	result, err := runPipe(c, &input, &out, &errOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Replies != 1001 || result.Errors != 1 || errOut.Len() == 0 {
+		t.Error("回复的统计错误.", result, errOut.String())
+	}
+	if !strings.HasSuffix(out.String(), "errors: 1, replies: 1001\n") {
+		t.Error("输出错误.", out.String())
+	}
+	if s.dbs[0].Size() != 1000 {
+		t.Error("应该写入全部命令.", s.dbs[0].Size())
+	}
+}
+
+func TestRunPattern(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+	for _, key := range []string{"user:1", "user:2", "order:1"} {
+		if _, err := c.do([][]byte{[]byte("set"), []byte(key), []byte("v")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := runPattern(c, "user:*", &out); err != nil {
+		t.Fatal(err)
+	}
+	keys := strings.Fields(out.String())
+	if len(keys) != 2 || !strings.HasPrefix(keys[0], "user:") || !strings.HasPrefix(keys[1], "user:") {
+		t.Error("列出的 key 错误.", keys)
+	}
+}
+
+func TestStat(t *testing.T) {
+	info := "# Clients\r\nconnected_clients:3\r\n\r\n# Memory\r\nused_memory:1572864\r\n\r\n" +
+		"# Stats\r\ntotal_connections_received:10\r\ntotal_commands_processed:120\r\n\r\n" +
+		"# Keyspace\r\ndb0:keys=5\r\ndb2:keys=7\r\n"
+	sample := parseStat(info)
+	if sample != (statSample{Keys: 12, UsedMemory: 1572864, Clients: 3, Commands: 120, Connections: 10}) {
+		t.Error("解析 INFO 错误.", sample)
+	}
+	prev := sample
+	prev.Commands = 100
+	if line := formatStatLine(&prev, sample); strings.Join(strings.Fields(line), " ") != "12 1.50M 3 120 (+20) 10" {
+		t.Error("统计的一行错误.", line)
+	}
+	if humanBytes(512) != "512B" || humanBytes(2048) != "2.00K" {
+		t.Error("字节数的格式错误.", humanBytes(512), humanBytes(2048))
+	}
+
+	s := newTestServer(t)
+	c := s.dial(t)
+	var out bytes.Buffer
+	if err := runStat(c, time.Millisecond, 2, &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[3], "(+1)") {
+		t.Error("统计的输出错误.", out.String())
+	}
+}
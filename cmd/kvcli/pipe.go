@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"simple_kvstorage/resp/reply"
+)
+
+// pipeResult --pipe 模式的统计
+type pipeResult struct {
+	// Replies 收到的回复的数量, 不包括最后的 PING, Errors 其中错误的数量
+	Replies int
+	Errors  int
+}
+
+// runPipe 将 input 中的内容原样发送给服务器, 同时读取并统计回复. 输入结束之后发送 PING <随机标记>,
+// 收到原样返回的标记时全部回复都已经读取. 服务端回复的错误输出到 errOut.
+func runPipe(c *conn, input io.Reader, out, errOut io.Writer) (pipeResult, error) {
+	var result pipeResult
+	markerBytes := make([]byte, 20)
+	if _, err := rand.Read(markerBytes); err != nil {
+		return result, err
+	}
+	marker := hex.EncodeToString(markerBytes)
+
+	writeErr := make(chan error, 1)
+	go func() {
+		if _, err := io.Copy(c.netConn, input); err != nil {
+			writeErr <- err
+			return
+		}
+		_, _ = fmt.Fprintln(out, "All data transferred. Waiting for the last reply...")
+		ping := reply.NewMultiBulkReply([][]byte{[]byte("ping"), []byte(marker)}).ToBytes()
+		_, err := c.netConn.Write(ping)
+		writeErr <- err
+	}()
+
+	for {
+		r, err := c.decoder.Decode()
+		if err != nil {
+			// 写入失败时连接被关闭, 读取的错误不如写入的错误有意义
+			select {
+			case werr := <-writeErr:
+				if werr != nil {
+					return result, werr
+				}
+			default:
+			}
+			return result, err
+		}
+		if isMarker(r, marker) {
+			break
+		}
+		result.Replies++
+		if errReply, ok := r.(reply.ErrorReply); ok {
+			result.Errors++
+			_, _ = fmt.Fprintln(errOut, errReply.Error())
+		}
+	}
+	if err := <-writeErr; err != nil {
+		return result, err
+	}
+	_, _ = fmt.Fprintln(out, "Last reply received from server.")
+	_, _ = fmt.Fprintf(out, "errors: %d, replies: %d\n", result.Errors, result.Replies)
+	return result, nil
+}
+
+// isMarker PING message 原样返回 message, 回复可能是状态或字符串
+func isMarker(r reply.Reply, marker string) bool {
+	switch r := r.(type) {
+	case *reply.StatusReply:
+		return r.Status == marker
+	case *reply.BulkReply:
+		return string(r.Bytes()) == marker
+	}
+	return false
+}
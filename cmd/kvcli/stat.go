@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statHeaderInterval 每输出多少行统计重复一次表头
+const statHeaderInterval = 20
+
+const statHeader = "------- data ------ ------------------ load ------------------\n" +
+	"keys       mem      clients requests             connections"
+
+// statSample --stat 模式从一次 INFO 中读取的字段
+type statSample struct {
+	Keys        int64
+	UsedMemory  int64
+	Clients     int64
+	Commands    int64
+	Connections int64
+}
+
+// parseStat 解析 INFO 的输出, keys 是全部数据库的 key 的数量之和
+func parseStat(info string) statSample {
+	var s statSample
+	for _, line := range strings.Split(info, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		switch {
+		case key == "used_memory":
+			s.UsedMemory, _ = strconv.ParseInt(value, 10, 64)
+		case key == "connected_clients":
+			s.Clients, _ = strconv.ParseInt(value, 10, 64)
+		case key == "total_commands_processed":
+			s.Commands, _ = strconv.ParseInt(value, 10, 64)
+		case key == "total_connections_received":
+			s.Connections, _ = strconv.ParseInt(value, 10, 64)
+		case strings.HasPrefix(key, "db"):
+			// db0:keys=10
+			for _, field := range strings.Split(value, ",") {
+				if strings.HasPrefix(field, "keys=") {
+					keys, _ := strconv.ParseInt(strings.TrimPrefix(field, "keys="), 10, 64)
+					s.Keys += keys
+				}
+			}
+		}
+	}
+	return s
+}
+
+// formatStatLine 输出一行统计, prev 不为 nil 时在请求数之后给出与上一次相比的增量
+func formatStatLine(prev *statSample, s statSample) string {
+	requests := strconv.FormatInt(s.Commands, 10)
+	if prev != nil {
+		requests += " (+" + strconv.FormatInt(s.Commands-prev.Commands, 10) + ")"
+	}
+	return fmt.Sprintf("%-10d %-8s %-7d %-20s %d", s.Keys, humanBytes(s.UsedMemory), s.Clients, requests, s.Connections)
+}
+
+// humanBytes 以 B, K, M, G 为单位输出字节数
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	value := float64(n)
+	for _, suffix := range []string{"K", "M", "G"} {
+		value /= unit
+		if value < unit || suffix == "G" {
+			return strconv.FormatFloat(value, 'f', 2, 64) + suffix
+		}
+	}
+	return ""
+}
+
+// runStat 每隔 interval 执行一次 INFO 并输出一行统计. count 为 0 时一直运行, 直到连接发生错误.
+func runStat(c *conn, interval time.Duration, count int, out io.Writer) error {
+	var prev *statSample
+	for i := 0; count == 0 || i < count; i++ {
+		r, err := c.do([][]byte{[]byte("info")})
+		if err != nil {
+			return err
+		}
+		if errReply, ok := r.(reply.ErrorReply); ok {
+			return errReply
+		}
+		sample := parseStat(formatRaw(r))
+		if i%statHeaderInterval == 0 {
+			_, _ = fmt.Fprintln(out, statHeader)
+		}
+		_, _ = fmt.Fprintln(out, formatStatLine(prev, sample))
+		prev = &sample
+		if count == 0 || i+1 < count {
+			time.Sleep(interval)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// terminalState 不支持原始模式的平台上不使用
+type terminalState struct{}
+
+// isTerminal 不支持的平台上总是返回 false, 交互模式逐行读取输入, 没有行编辑与提示
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (*terminalState, error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+func restoreTerminal(fd int, state *terminalState) error {
+	return nil
+}
@@ -0,0 +1,59 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// terminalState 进入原始模式之前的终端设置, 用于恢复
+type terminalState struct {
+	termios syscall.Termios
+}
+
+func getTermios(fd int) (*syscall.Termios, error) {
+	termios := &syscall.Termios{}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), ioctlGetTermios, uintptr(unsafe.Pointer(termios))); errno != 0 {
+		return nil, errno
+	}
+	return termios, nil
+}
+
+func setTermios(fd int, termios *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), ioctlSetTermios, uintptr(unsafe.Pointer(termios))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// isTerminal fd 是否是终端
+func isTerminal(fd int) bool {
+	_, err := getTermios(fd)
+	return err == nil
+}
+
+// makeRaw 使终端进入原始模式: 逐个字节读取输入, 不回显, Ctrl-C 等按键作为普通字节读取. 输出的处理保持不变.
+func makeRaw(fd int) (*terminalState, error) {
+	termios, err := getTermios(fd)
+	if err != nil {
+		return nil, err
+	}
+	state := &terminalState{termios: *termios}
+
+	termios.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	termios.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	termios.Cflag &^= syscall.CSIZE | syscall.PARENB
+	termios.Cflag |= syscall.CS8
+	termios.Cc[syscall.VMIN] = 1
+	termios.Cc[syscall.VTIME] = 0
+	if err := setTermios(fd, termios); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// restoreTerminal 恢复 makeRaw 之前的终端设置
+func restoreTerminal(fd int, state *terminalState) error {
+	return setTermios(fd, &state.termios)
+}
@@ -20,8 +20,64 @@ type ServerProperties struct {
 	RequirePass    string `cfg:"requirepass"`
 	Databases      int    `cfg:"databases"`
 
-	Peers []string `cfg:"peers"`
-	Self  string   `cfg:"self"`
+	// AppendDirname 存放 AOF 基础文件, 增量文件和清单的目录, AppendFilename 是其中文件名的前缀
+	AppendDirname string `cfg:"appenddirname"`
+	AppendFsync   string `cfg:"appendfsync"`
+	// AutoAofRewritePercentage AOF 文件相比上次重写之后增长了多少百分比时自动重写, 0 表示不自动重写
+	AutoAofRewritePercentage int   `cfg:"auto-aof-rewrite-percentage"`
+	AutoAofRewriteMinSize    int64 `cfg:"auto-aof-rewrite-min-size"`
+	// AofLoadTruncated 启动时 AOF 文件末尾的命令不完整, 是否截断后继续启动
+	AofLoadTruncated bool `cfg:"aof-load-truncated"`
+	// AofLoadIgnoreCorruption 启动时 AOF 文件中间损坏, 是否丢弃损坏位置之后的内容后继续启动
+	AofLoadIgnoreCorruption bool `cfg:"aof-load-ignore-corruption"`
+
+	// DbFilename 快照文件, Save 自动生成快照的规则, 形如 "900 1 300 10"
+	DbFilename string `cfg:"dbfilename"`
+	Save       string `cfg:"save"`
+
+	// ReplicaOf 启动时成为哪个主节点的从节点, 形如 "host port". ReplBacklogSize 复制积压缓冲区的大小.
+	// MasterAuth 主节点的密码, 为空时使用 RequirePass. 集群模式与 Raft 模式下节点之间的连接使用 RequirePass 认证.
+	ReplicaOf       string `cfg:"replicaof"`
+	ReplBacklogSize int64  `cfg:"repl-backlog-size"`
+	MasterAuth      string `cfg:"masterauth"`
+
+	// ErasureRules 数据主体擦除规则文件, ErasureSigningKey 擦除报告的签名密钥
+	ErasureRules      string `cfg:"erasure-rules"`
+	ErasureSigningKey string `cfg:"erasure-signing-key"`
+
+	// ProtoMaxBulkLen 客户端命令中单个参数的最大长度, ProtoMaxMultiBulkLen 命令中参数的最大数量,
+	// ClientQueryBufferLimit 一条命令中全部参数的总长度上限. 超过限制的客户端会被断开.
+	ProtoMaxBulkLen        int64 `cfg:"proto-max-bulk-len"`
+	ProtoMaxMultiBulkLen   int   `cfg:"proto-max-multibulk-len"`
+	ClientQueryBufferLimit int64 `cfg:"client-query-buffer-limit"`
+	// ClientOutputBufferLimit 客户端输出缓冲区的限制, 形如 "<hard> <soft> <soft-seconds>", 如 "256mb 64mb 60", 0 表示不限制
+	ClientOutputBufferLimit string `cfg:"client-output-buffer-limit"`
+
+	// SlowLogLogSlowerThan 慢查询日志记录耗时不少于多少微秒的命令, 0 表示记录全部命令, 负数表示不记录.
+	// SlowLogMaxLen 慢查询日志最多保留的记录数
+	SlowLogLogSlowerThan int `cfg:"slowlog-log-slower-than"`
+	SlowLogMaxLen        int `cfg:"slowlog-max-len"`
+
+	// MaxMemory 内存上限, 支持 kb, mb, gb 等单位, 0 表示不限制
+	MaxMemory        int64  `cfg:"maxmemory"`
+	MaxMemoryPolicy  string `cfg:"maxmemory-policy"`
+	MaxMemorySamples int    `cfg:"maxmemory-samples"`
+
+	// Self 与 Peers 都配置时开启集群模式, Self 是本节点的地址, Peers 是其他节点的地址, 以逗号分隔.
+	// ClusterForward 是否将不属于本节点的命令转发给负责的节点, 否则回复 MOVED / ASK
+	Peers          []string `cfg:"peers"`
+	Self           string   `cfg:"self"`
+	ClusterForward bool     `cfg:"cluster-forward"`
+
+	// RaftEnabled 开启 Raft 模式, 由 Self 与 Peers 组成 Raft 集群, 不再使用集群模式. RaftDir 存放 Raft 状态与快照的目录.
+	// RaftJoin 以非成员的身份启动, 等待领导者通过 RAFT ADDNODE 将本节点加入集群.
+	// RaftSnapshotThreshold 快照之后应用了多少条命令时生成新的快照并压缩日志.
+	// RaftSecret 节点之间共享的密钥, 只有以 RAFT AUTH 提供了它的连接才能发送 RAFT RPC
+	RaftEnabled           bool   `cfg:"raft-enabled"`
+	RaftDir               string `cfg:"raft-dir"`
+	RaftJoin              bool   `cfg:"raft-join"`
+	RaftSnapshotThreshold int    `cfg:"raft-snapshot-threshold"`
+	RaftSecret            string `cfg:"raft-secret"`
 }
 
 // Properties holds global config properties
@@ -29,16 +85,44 @@ var Properties *ServerProperties
 
 func init() {
 	// default config
-	Properties = &ServerProperties{
-		Bind:       "127.0.0.1",
-		Port:       6379,
-		AppendOnly: false,
-		Databases:  16,
+	Properties = defaultProperties()
+}
+
+// Default 返回一份新的默认配置, 供不读取配置文件的嵌入模式使用
+func Default() *ServerProperties {
+	return defaultProperties()
+}
+
+// defaultProperties 返回默认配置, 配置文件中没有给出的配置项将使用默认值
+func defaultProperties() *ServerProperties {
+	return &ServerProperties{
+		Bind:                     "127.0.0.1",
+		Port:                     6379,
+		AppendOnly:               false,
+		AppendFilename:           "appendonly.aof",
+		AppendDirname:            "appendonlydir",
+		AppendFsync:              "everysec",
+		AutoAofRewritePercentage: 100,
+		AutoAofRewriteMinSize:    64 << 20,
+		AofLoadTruncated:         true,
+		DbFilename:               "dump.rdb",
+		Databases:                16,
+		ReplBacklogSize:          1 << 20,
+		MaxMemoryPolicy:          "noeviction",
+		MaxMemorySamples:         5,
+		ProtoMaxBulkLen:          512 << 20,
+		ProtoMaxMultiBulkLen:     1024 * 1024,
+		ClientQueryBufferLimit:   1 << 30,
+		ClientOutputBufferLimit:  "0 0 0",
+		SlowLogLogSlowerThan:     10000,
+		SlowLogMaxLen:            128,
+		RaftDir:                  "raft",
+		RaftSnapshotThreshold:    10000,
 	}
 }
 
 func parse(src io.Reader) *ServerProperties {
-	config := &ServerProperties{}
+	config := defaultProperties()
 
 	// read config file
 	rawMap := make(map[string]string)
@@ -81,6 +165,11 @@ func parse(src io.Reader) *ServerProperties {
 				if err == nil {
 					fieldVal.SetInt(intValue)
 				}
+			case reflect.Int64:
+				memoryValue, err := ParseMemory(value)
+				if err == nil {
+					fieldVal.SetInt(memoryValue)
+				}
 			case reflect.Bool:
 				boolValue := "yes" == value
 				fieldVal.SetBool(boolValue)
@@ -95,6 +184,39 @@ func parse(src io.Reader) *ServerProperties {
 	return config
 }
 
+// memoryUnits 内存大小的单位, 与 Redis 相同: k 和 kb 分别是 1000 和 1024
+var memoryUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"kb", 1 << 10},
+	{"mb", 1 << 20},
+	{"gb", 1 << 30},
+	{"k", 1000},
+	{"m", 1000 * 1000},
+	{"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// ParseMemory 解析内存大小, 例如 "100mb", "1gb", "1024"
+func ParseMemory(value string) (int64, error) {
+	value = strings.ToLower(value)
+	multiplier := int64(1)
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSuffix(value, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
+
 // SetupConfig read config file and store properties into Properties
 func SetupConfig(configFilename string) {
 	file, err := os.Open(configFilename)
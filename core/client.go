@@ -2,41 +2,187 @@ package core
 
 import (
 	"io"
+	"simple_kvstorage/resp/reply"
 	"simple_kvstorage/util/sync/wait"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// nextClientID 上一个客户端连接的 ID
+var nextClientID int64
+
 // Client 描述了对客户端连接的操作
 type Client struct {
+	// id 客户端连接的唯一 ID, 从 1 开始递增
+	id int64
 	// TCP 的连接
 	connection io.ReadWriteCloser
+	// protocol 通过 HELLO 协商的协议版本, name 通过 HELLO SETNAME 设置的名字, authenticated 是否已经通过 AUTH 或 HELLO AUTH 认证
+	protocol      int
+	name          string
+	authenticated bool
 	// 当前客户端连接的数据库序号
 	selectedDB int
+	// isReplica 是否是已经开始同步的从节点, replListeningPort 从节点通过 REPLCONF 告知的服务端口
+	isReplica         bool
+	replListeningPort int
+	// asking 集群模式下, 下一条命令是否可以访问正在导入的槽. forwarded 是否是其他节点用于转发命令的连接.
+	asking    bool
+	forwarded bool
+	// raftPeer 是否是以 RAFT AUTH 提供了共享密钥的其他 Raft 节点, 只有这样的连接才能发送 RAFT RPC
+	raftPeer bool
+
+	// output 尚未交给写协程的回复, 读取下一批命令之前交给写协程一次写入连接. outputLimit 输出缓冲区的限制, nil 表示不限制.
+	// outputPending 尚未写入连接的字节数, 包括写协程正在写的部分; queryBuffered 读缓冲区中尚未解析的字节数, 供 CLIENT LIST 读取.
+	output        []byte
+	outputLimit   *OutputBufferLimit
+	outputPending int64
+	queryBuffered int64
+	// 以下字段由 locker 保护. writing 写协程正在写的字节数, queued 与 written 累计交给写协程与已经写入连接的字节数,
+	// overSoftSince 尚未写入的回复开始持续超过软限制的时间, 零值表示没有超过; writeErr 写入连接时发生的错误, 之后的写操作都返回它.
+	// closing 连接即将关闭, 写协程写完剩余的回复后退出. outputCond 在有新的回复, 写入了一部分或需要退出时通知.
+	writing       int
+	queued        int64
+	written       int64
+	overSoftSince time.Time
+	writeErr      error
+	closing       bool
+	outputCond    *sync.Cond
+
+	// createdAt 建立连接的时间, lastInteraction 最后一次执行命令的时间, lastCmd 最后执行的命令名
+	createdAt       time.Time
+	lastInteraction time.Time
+	lastCmd         []byte
 
 	waitingReply wait.Wait
 	locker       sync.Mutex
+	// infoLocker 保护 CLIENT LIST 会从其他协程读取的字段: protocol, name, selectedDB, lastInteraction, lastCmd.
+	// 这些字段只由处理此连接的协程修改, 因此此协程读取时不需要加锁.
+	infoLocker sync.Mutex
 }
 
+const (
+	// outputFlushThreshold 输出缓冲区超过此大小时立即交给写协程, 不等待这一批命令执行完
+	outputFlushThreshold = 64 * 1024
+	// outputRetainLimit 写入连接之后, 容量不超过此大小的输出缓冲区被保留下来复用
+	outputRetainLimit = 64 * 1024
+)
+
+// newClient 创建客户端, connection 不为 nil 时开启写协程
 func newClient(connection io.ReadWriteCloser) *Client {
-	return &Client{connection: connection}
+	now := time.Now()
+	c := &Client{
+		id:              atomic.AddInt64(&nextClientID, 1),
+		connection:      connection,
+		protocol:        reply.Resp2,
+		createdAt:       now,
+		lastInteraction: now,
+	}
+	c.outputCond = sync.NewCond(&c.locker)
+	if connection != nil {
+		c.waitingReply.Add(1)
+		go c.writeLoop()
+	}
+	return c
 }
 
-// Write 向客户端写数据
+// Write 向客户端写数据, 直到写入连接才返回. 输出缓冲区中的回复先于 bytes 发送, bytes 不受输出缓冲区的限制.
 func (c *Client) Write(bytes []byte) error {
 	if len(bytes) == 0 {
 		return nil
 	}
 
 	c.locker.Lock()
-	c.waitingReply.Add(1)
-	defer func() {
-		c.waitingReply.Done()
-		c.locker.Unlock()
-	}()
+	defer c.locker.Unlock()
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+	c.enqueueLocked(bytes)
+	target := c.queued
+	c.outputCond.Broadcast()
+	for c.written < target && c.writeErr == nil {
+		c.outputCond.Wait()
+	}
+	return c.writeErr
+}
+
+// bufferReply 将回复追加到输出缓冲区, 缓冲区过大时立即交给写协程. 超过输出缓冲区的限制时返回 errOutputBufferLimit.
+func (c *Client) bufferReply(bytes []byte) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+	if err := c.checkOutputLimitLocked(len(bytes)); err != nil {
+		return err
+	}
+	c.enqueueLocked(bytes)
+	if len(c.output) >= outputFlushThreshold {
+		c.outputCond.Broadcast()
+	}
+	return c.waitOutputLocked()
+}
+
+// Flush 将输出缓冲区中的回复交给写协程, 不等待写入连接
+func (c *Client) Flush() error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	if len(c.output) > 0 {
+		c.outputCond.Broadcast()
+	}
+	return c.writeErr
+}
+
+// enqueueLocked 将 bytes 追加到输出缓冲区. 调用者需要持有 locker.
+func (c *Client) enqueueLocked(bytes []byte) {
+	c.output = append(c.output, bytes...)
+	c.queued += int64(len(bytes))
+	atomic.StoreInt64(&c.outputPending, int64(len(c.output)+c.writing))
+}
+
+// flushingReader 从客户端的连接读取数据, 每次读取之前先将输出缓冲区中的回复交给写协程.
+// Decoder 只在读缓冲区中的命令都解析完之后才读取连接, 因此一批命令的回复只需一次 Write, 且不会在等待下一批命令时滞留.
+type flushingReader struct {
+	client *Client
+}
+
+func (r flushingReader) Read(p []byte) (int, error) {
+	if err := r.client.Flush(); err != nil {
+		return 0, err
+	}
+	return r.client.connection.Read(p)
+}
 
-	_, err := c.connection.Write(bytes)
-	return err
+// ID 返回客户端连接的唯一 ID
+func (c *Client) ID() int64 {
+	return c.id
+}
+
+// Protocol 返回客户端使用的协议版本, reply.Resp2 或 reply.Resp3
+func (c *Client) Protocol() int {
+	return c.protocol
+}
+
+// SetProtocol 设置客户端使用的协议版本, 之后的回复按此版本编码
+func (c *Client) SetProtocol(protocol int) {
+	c.infoLocker.Lock()
+	defer c.infoLocker.Unlock()
+	c.protocol = protocol
+}
+
+// Name 返回客户端的名字
+func (c *Client) Name() string {
+	return c.name
+}
+
+// SetName 设置客户端的名字
+func (c *Client) SetName(name string) {
+	c.infoLocker.Lock()
+	defer c.infoLocker.Unlock()
+	c.name = name
 }
 
 // GetDBIndex 获取此客户端连接的数据库序号
@@ -47,10 +193,50 @@ func (c *Client) GetDBIndex() int {
 // SelectDB 切换此客户端的数据库
 // index 数据库序号
 func (c *Client) SelectDB(index int) {
+	c.infoLocker.Lock()
+	defer c.infoLocker.Unlock()
 	c.selectedDB = index
 }
 
+// recordCommand 记录最后一次执行的命令, 以及读缓冲区中尚未解析的字节数
+func (c *Client) recordCommand(cmdName []byte, queryBuffered int) {
+	atomic.StoreInt64(&c.queryBuffered, int64(queryBuffered))
+	c.infoLocker.Lock()
+	defer c.infoLocker.Unlock()
+	c.lastCmd = cmdName
+	c.lastInteraction = time.Now()
+}
+
+// Asking 集群模式下, 下一条命令是否可以访问正在导入的槽
+func (c *Client) Asking() bool {
+	return c.asking
+}
+
+// SetAsking 设置下一条命令是否可以访问正在导入的槽, 由 ASKING 命令设置, 执行下一条命令之前清除
+func (c *Client) SetAsking(asking bool) {
+	c.asking = asking
+}
+
+// Forwarded 是否是集群中其他节点用于转发命令的连接, 这样的连接上的命令不会被再次转发
+func (c *Client) Forwarded() bool {
+	return c.forwarded
+}
+
+// SetForwarded 标记此连接是其他节点用于转发命令的连接
+func (c *Client) SetForwarded(forwarded bool) {
+	c.forwarded = forwarded
+}
+
+// Close 等待写协程写完剩余的回复, 然后关闭连接. 输出缓冲区超过了限制或写入失败时直接关闭连接.
 func (c *Client) Close() error {
+	c.locker.Lock()
+	c.closing = true
+	if c.writeErr != nil {
+		_ = c.connection.Close()
+	}
+	c.outputCond.Broadcast()
+	c.locker.Unlock()
+
 	c.waitingReply.WaitWithTimeout(10 * time.Second)
 	_ = c.connection.Close()
 	return nil
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"net"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// execClient CLIENT LIST | CLIENT ID | CLIENT GETNAME | CLIENT SETNAME name
+// 参考: https://redis.io/commands/client-list
+func (h *Handler) execClient(client *Client, cmdLine executor.CmdLine) reply.Reply {
+	if len(cmdLine) < 2 {
+		return reply.NewArgNumberErrorReply("client")
+	}
+
+	subCommand := strings.ToLower(string(cmdLine[1]))
+	switch subCommand {
+	case "list":
+		if len(cmdLine) != 2 {
+			return reply.NewArgNumberErrorReply("client|list")
+		}
+		return reply.NewVerbatimReply("txt", []byte(h.clientList()))
+	case "id":
+		if len(cmdLine) != 2 {
+			return reply.NewArgNumberErrorReply("client|id")
+		}
+		return reply.NewIntReply(client.ID())
+	case "getname":
+		if len(cmdLine) != 2 {
+			return reply.NewArgNumberErrorReply("client|getname")
+		}
+		if client.Name() == "" {
+			return reply.GetNullBulkReply()
+		}
+		return reply.NewBulkReply([]byte(client.Name()))
+	case "setname":
+		if len(cmdLine) != 3 {
+			return reply.NewArgNumberErrorReply("client|setname")
+		}
+		name := string(cmdLine[2])
+		if !isValidClientName(name) {
+			return reply.NewStandardErrorReply("ERROR Client names cannot contain spaces, newlines or special characters.")
+		}
+		client.SetName(name)
+		return reply.GetOkReply()
+	}
+	return reply.NewStandardErrorReply("ERROR unknown client subcommand '" + subCommand + "'")
+}
+
+// isValidClientName 与 Redis 相同, 名字中只能有可见的 ASCII 字符
+func isValidClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// clientList 按 ID 的顺序列出全部客户端连接, 每行一个
+func (h *Handler) clientList() string {
+	var clients []*Client
+	h.activeClient.Range(func(key, value any) bool {
+		clients = append(clients, key.(*Client))
+		return true
+	})
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ID() < clients[j].ID()
+	})
+
+	now := time.Now()
+	var builder strings.Builder
+	for _, client := range clients {
+		builder.WriteString(client.listEntry(now))
+		builder.WriteByte('\n')
+	}
+	return builder.String()
+}
+
+// addr 返回客户端的地址, 连接没有地址时返回空字符串
+func (c *Client) addr() string {
+	if conn, ok := c.connection.(interface{ RemoteAddr() net.Addr }); ok {
+		return conn.RemoteAddr().String()
+	}
+	return ""
+}
+
+// listEntry CLIENT LIST 中的一行, qbuf 是读缓冲区中尚未解析的字节数, omem 是尚未发送给客户端的回复的字节数
+func (c *Client) listEntry(now time.Time) string {
+	addr := c.addr()
+
+	c.infoLocker.Lock()
+	name, db, protocol := c.name, c.selectedDB, c.protocol
+	idle := now.Sub(c.lastInteraction)
+	cmd := strings.ToLower(string(c.lastCmd))
+	c.infoLocker.Unlock()
+	if cmd == "" {
+		cmd = "NULL"
+	}
+
+	fields := []string{
+		"id=" + strconv.FormatInt(c.id, 10),
+		"addr=" + addr,
+		"name=" + name,
+		"age=" + strconv.FormatInt(int64(now.Sub(c.createdAt)/time.Second), 10),
+		"idle=" + strconv.FormatInt(int64(idle/time.Second), 10),
+		"db=" + strconv.Itoa(db),
+		"qbuf=" + strconv.FormatInt(atomic.LoadInt64(&c.queryBuffered), 10),
+		"omem=" + strconv.FormatInt(atomic.LoadInt64(&c.outputPending), 10),
+		"resp=" + strconv.Itoa(protocol),
+		"cmd=" + cmd,
+	}
+	return strings.Join(fields, " ")
+}
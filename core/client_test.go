@@ -0,0 +1,154 @@
+package core
+
+import (
+	"context"
+	"io"
+	"net"
+	"simple_kvstorage/database"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOutputBufferLimit(t *testing.T) {
+	limit, err := ParseOutputBufferLimit("256mb 64mb 60")
+	if err != nil || limit != (OutputBufferLimit{Hard: 256 << 20, Soft: 64 << 20, SoftSeconds: 60}) {
+		t.Error("解析错误.", limit, err)
+	}
+	for _, value := range []string{"", "0 0", "1mb x 60", "-1 0 0", "0 0 -1"} {
+		if _, err := ParseOutputBufferLimit(value); err == nil {
+			t.Errorf("%q 应该是非法的限制.", value)
+		}
+	}
+}
+
+func TestClient_OutputBufferHardLimit(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.SetOutputBufferLimit(OutputBufferLimit{Hard: 64})
+	s.handler.dbs[0].Put("big", &database.DataEntity{Data: []byte(strings.Repeat("x", 100))})
+
+	conn, err := net.Dial("tcp", s.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// 超过硬限制的回复不会被发送, 客户端被断开
+	if _, err := conn.Write([]byte("*2\r\n$3\r\nget\r\n$3\r\nbig\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	received, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal("连接没有被关闭.", err)
+	}
+	if len(received) != 0 {
+		t.Errorf("不应该收到回复. %q", received)
+	}
+}
+
+func TestClient_OutputBufferSoftLimit(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.SetOutputBufferLimit(OutputBufferLimit{Soft: 16, SoftSeconds: 1})
+	s.handler.dbs[0].Put("big", &database.DataEntity{Data: []byte(strings.Repeat("x", 100))})
+	request := []byte("*2\r\n$3\r\nget\r\n$3\r\nbig\r\n")
+
+	// net.Pipe 没有缓冲区, 客户端不读取时回复一直无法发送
+	dial := func() net.Conn {
+		clientSide, serverSide := net.Pipe()
+		go s.handler.Handle(serverSide, context.Background())
+		t.Cleanup(func() { _ = clientSide.Close() })
+		if _, err := clientSide.Write(request); err != nil {
+			t.Fatal(err)
+		}
+		return clientSide
+	}
+
+	// 及时读取的客户端可以收到完整的回复
+	conn := dial()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	received := make([]byte, len("$100\r\n")+100+2)
+	if _, err := io.ReadFull(conn, received); err != nil || !strings.HasPrefix(string(received), "$100\r\n") {
+		t.Errorf("回复错误. %q %v", received, err)
+	}
+
+	// 超过 soft-seconds 秒没有读取的客户端被断开
+	conn = dial()
+	time.Sleep(1500 * time.Millisecond)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	received, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal("连接没有被关闭.", err)
+	}
+	if len(received) != 0 {
+		t.Errorf("不应该收到回复. %q", received)
+	}
+}
+
+func TestClient_OutputBufferNeverRead(t *testing.T) {
+	for _, limit := range []OutputBufferLimit{{Hard: 1 << 16}, {Soft: 1 << 16, SoftSeconds: 1}} {
+		s := newTestServer(t)
+		s.handler.SetOutputBufferLimit(limit)
+		conn, err := net.Dial("tcp", s.addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 缩小接收缓冲区, 使回复很快堆积在服务器上
+		_ = conn.(*net.TCPConn).SetReadBuffer(4096)
+
+		// 客户端不断发送命令, 从不读取回复. 每条回复都很小, 尚未发送的回复在多次写入连接之间累积
+		request := []byte(strings.Repeat("*1\r\n$4\r\nping\r\n", 1024))
+		closed := make(chan error, 1)
+		go func() {
+			for {
+				if _, err := conn.Write(request); err != nil {
+					closed <- err
+					return
+				}
+			}
+		}()
+		select {
+		case <-closed:
+		case <-time.After(30 * time.Second):
+			t.Error("从不读取回复的客户端没有被断开.", limit)
+		}
+		_ = conn.Close()
+		waitFor(t, "服务器没有释放连接.", func() bool {
+			count := 0
+			s.handler.activeClient.Range(func(key, value any) bool {
+				count++
+				return true
+			})
+			return count == 0
+		})
+	}
+}
+
+func TestClient_List(t *testing.T) {
+	s := newTestServer(t)
+	worker := dialTestServer(t, s)
+	if r := worker.do(t, "client", "setname", "worker-1"); r != "+OK" {
+		t.Fatal("CLIENT SETNAME 失败.", r)
+	}
+	if r := worker.do(t, "client", "setname", "bad name"); !strings.HasPrefix(r, "-") {
+		t.Error("名字中不能有空格.", r)
+	}
+	if r := worker.do(t, "client", "getname"); !strings.HasSuffix(r, "worker-1") {
+		t.Error("CLIENT GETNAME 错误.", r)
+	}
+
+	r := dialTestServer(t, s).do(t, "client", "list")
+	lines := strings.Split(strings.TrimSpace(r[strings.Index(r, "\n")+1:]), "\n")
+	if len(lines) != 2 {
+		t.Fatal("应该有两个连接.", r)
+	}
+	for _, field := range []string{"name=worker-1", "db=0", "qbuf=0", "omem=0", "cmd=client"} {
+		if !strings.Contains(lines[0], field) {
+			t.Errorf("第一个连接缺少 %s. %s", field, lines[0])
+		}
+	}
+	if !strings.Contains(lines[1], "name= ") || !strings.Contains(lines[1], "addr=127.0.0.1:") {
+		t.Error("第二个连接的信息错误.", lines[1])
+	}
+}
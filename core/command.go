@@ -0,0 +1,201 @@
+package core
+
+import (
+	"simple_kvstorage/executor"
+	"simple_kvstorage/resp/reply"
+	"simple_kvstorage/util/wildcard"
+	"strings"
+)
+
+func init() {
+	// 以下命令由 Handler 直接执行, 只在 executor 中注册元数据, 供 COMMAND 与集群路由使用
+	executor.RegisterCommand("select", nil, 2, executor.FlagFast, executor.NoKeys, "connection")
+	executor.RegisterCommand("info", nil, -1, 0, executor.NoKeys, "dangerous")
+	executor.RegisterCommand("bgrewriteaof", nil, 1, executor.FlagAdmin|executor.FlagNoscript, executor.NoKeys)
+	executor.RegisterCommand("save", nil, 1, executor.FlagAdmin|executor.FlagNoscript, executor.NoKeys)
+	executor.RegisterCommand("bgsave", nil, 1, executor.FlagAdmin|executor.FlagNoscript, executor.NoKeys)
+	executor.RegisterCommand("lastsave", nil, 1, executor.FlagFast, executor.NoKeys, "admin", "dangerous")
+	executor.RegisterCommand("replicaof", nil, 3, executor.FlagAdmin|executor.FlagNoscript, executor.NoKeys)
+	executor.RegisterCommand("slaveof", nil, 3, executor.FlagAdmin|executor.FlagNoscript, executor.NoKeys)
+	executor.RegisterCommand("psync", nil, 3, executor.FlagAdmin|executor.FlagNoscript, executor.NoKeys)
+	executor.RegisterCommand("replconf", nil, -3, executor.FlagAdmin|executor.FlagNoscript, executor.NoKeys)
+	// MIGRATE 的 key 可以在 KEYS 选项之后, 位置不固定, 集群模式下总是在本节点执行
+	executor.RegisterCommand("migrate", nil, -6, executor.FlagWrite, executor.NoKeys, "keyspace", "dangerous")
+	executor.RegisterCommand("erasure.plan", nil, 2, executor.FlagReadonly|executor.FlagAdmin, executor.NoKeys, "keyspace")
+	executor.RegisterCommand("erasure.execute", nil, 2, executor.FlagWrite|executor.FlagAdmin|executor.FlagNoscript, executor.NoKeys, "keyspace")
+	executor.RegisterCommand("raft", nil, -2, executor.FlagAdmin|executor.FlagNoscript, executor.NoKeys)
+	executor.RegisterCommand("auth", nil, -2, executor.FlagNoscript|executor.FlagFast, executor.NoKeys, "connection")
+	executor.RegisterCommand("hello", nil, -1, executor.FlagNoscript|executor.FlagFast, executor.NoKeys, "connection")
+	executor.RegisterCommand("client", nil, -2, executor.FlagNoscript, executor.NoKeys, "connection")
+	executor.RegisterCommand("command", nil, -1, 0, executor.NoKeys, "connection")
+	executor.RegisterCommand("slowlog", nil, -2, executor.FlagAdmin, executor.NoKeys)
+}
+
+// implicitCategories 由命令的标志决定的分类, 见 executor.RegisterCommand
+var implicitCategories = map[string]struct{}{
+	"write":     {},
+	"read":      {},
+	"admin":     {},
+	"dangerous": {},
+	"pubsub":    {},
+	"fast":      {},
+	"slow":      {},
+}
+
+// execCommand COMMAND [COUNT | INFO [name ...] | DOCS [name ...] | GETKEYS command [arg ...] | LIST [FILTERBY ...]]
+// 参考: https://redis.io/commands/command
+func execCommand(cmdLine executor.CmdLine) reply.Reply {
+	if len(cmdLine) == 1 {
+		return commandInfoReply(executor.Commands())
+	}
+
+	subCommand := strings.ToLower(string(cmdLine[1]))
+	switch subCommand {
+	case "count":
+		if len(cmdLine) != 2 {
+			return reply.NewArgNumberErrorReply("command|count")
+		}
+		return reply.NewIntReply(int64(len(executor.Commands())))
+	case "info":
+		if len(cmdLine) == 2 {
+			return commandInfoReply(executor.Commands())
+		}
+		replies := make([]reply.Reply, 0, len(cmdLine)-2)
+		for _, name := range cmdLine[2:] {
+			info, ok := executor.Lookup(string(name))
+			if !ok {
+				replies = append(replies, reply.GetNullMultiBulkReply())
+				continue
+			}
+			replies = append(replies, commandEntry(info))
+		}
+		return reply.NewMultiRawReply(replies)
+	case "docs":
+		infos := executor.Commands()
+		if len(cmdLine) > 2 {
+			infos = infos[:0:0]
+			for _, name := range cmdLine[2:] {
+				// 未知的命令不出现在回复中
+				if info, ok := executor.Lookup(string(name)); ok {
+					infos = append(infos, info)
+				}
+			}
+		}
+		entries := make([]reply.MapEntry, 0, len(infos))
+		for _, info := range infos {
+			entries = append(entries, reply.MapEntry{
+				Key:   reply.NewBulkReply([]byte(info.Name)),
+				Value: commandDocs(info),
+			})
+		}
+		return reply.NewMapReply(entries)
+	case "getkeys":
+		if len(cmdLine) < 3 {
+			return reply.NewArgNumberErrorReply("command|getkeys")
+		}
+		info, ok := executor.Lookup(string(cmdLine[2]))
+		if !ok {
+			return reply.NewStandardErrorReply("ERROR Invalid command specified")
+		}
+		if !info.ValidateArity(cmdLine[2:]) {
+			return reply.NewStandardErrorReply("ERROR Invalid number of arguments specified for command")
+		}
+		keys := info.Keys.Keys(cmdLine[2:])
+		if len(keys) == 0 {
+			return reply.NewStandardErrorReply("ERROR The command has no key arguments")
+		}
+		return reply.NewMultiBulkReply(keys)
+	case "list":
+		return execCommandList(cmdLine)
+	}
+	return reply.NewStandardErrorReply("ERROR unknown command subcommand '" + subCommand + "'")
+}
+
+// execCommandList COMMAND LIST [FILTERBY MODULE module-name | ACLCAT category | PATTERN pattern]
+func execCommandList(cmdLine executor.CmdLine) reply.Reply {
+	filter := func(executor.CommandInfo) bool { return true }
+	switch len(cmdLine) {
+	case 2:
+	case 5:
+		if strings.ToLower(string(cmdLine[2])) != "filterby" {
+			return reply.GetSyntaxErrReply()
+		}
+		value := string(cmdLine[4])
+		switch strings.ToLower(string(cmdLine[3])) {
+		case "module":
+			// 不支持模块, 没有属于任何模块的命令
+			filter = func(executor.CommandInfo) bool { return false }
+		case "aclcat":
+			category := strings.ToLower(strings.TrimPrefix(value, "@"))
+			filter = func(info executor.CommandInfo) bool {
+				for _, c := range info.Categories {
+					if c == category {
+						return true
+					}
+				}
+				return false
+			}
+		case "pattern":
+			pattern := wildcard.CompilePattern(strings.ToLower(value))
+			filter = func(info executor.CommandInfo) bool {
+				return pattern.IsMatch(info.Name)
+			}
+		default:
+			return reply.GetSyntaxErrReply()
+		}
+	default:
+		return reply.GetSyntaxErrReply()
+	}
+
+	names := make([][]byte, 0)
+	for _, info := range executor.Commands() {
+		if filter(info) {
+			names = append(names, []byte(info.Name))
+		}
+	}
+	return reply.NewMultiBulkReply(names)
+}
+
+func commandInfoReply(infos []executor.CommandInfo) reply.Reply {
+	replies := make([]reply.Reply, 0, len(infos))
+	for _, info := range infos {
+		replies = append(replies, commandEntry(info))
+	}
+	return reply.NewMultiRawReply(replies)
+}
+
+// commandEntry 一个命令的信息: 名字, 参数数量, 标志, 第一个 key, 最后一个 key, key 的步长, ACL 分类
+func commandEntry(info executor.CommandInfo) reply.Reply {
+	flags := make([]reply.Reply, 0)
+	for _, name := range info.Flags.Names() {
+		flags = append(flags, reply.NewStatusReply(name))
+	}
+	categories := make([]reply.Reply, 0, len(info.Categories))
+	for _, category := range info.Categories {
+		categories = append(categories, reply.NewStatusReply("@"+category))
+	}
+	return reply.NewMultiRawReply([]reply.Reply{
+		reply.NewBulkReply([]byte(info.Name)),
+		reply.NewIntReply(int64(info.Arity)),
+		reply.NewSetReply(flags),
+		reply.NewIntReply(int64(info.Keys.First)),
+		reply.NewIntReply(int64(info.Keys.Last)),
+		reply.NewIntReply(int64(info.Keys.Step)),
+		reply.NewSetReply(categories),
+	})
+}
+
+// commandDocs 命令的文档. 命令没有单独的说明, 只返回所属的分组, 即第一个不由标志决定的分类.
+func commandDocs(info executor.CommandInfo) reply.Reply {
+	group := "server"
+	for _, category := range info.Categories {
+		if _, implicit := implicitCategories[category]; !implicit {
+			group = category
+			break
+		}
+	}
+	return reply.NewMapReply([]reply.MapEntry{{
+		Key:   reply.NewBulkReply([]byte("group")),
+		Value: reply.NewBulkReply([]byte(group)),
+	}})
+}
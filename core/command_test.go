@@ -0,0 +1,73 @@
+package core
+
+import (
+	"simple_kvstorage/database"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	handler := NewHandler([]database.DB{database.NewConcurrentDB(0)}, nil)
+	client := newClient(nil)
+
+	count := len(executor.Commands())
+	if r := execString(handler, client, "command", "count"); r != ":"+strconv.Itoa(count) {
+		t.Error("COMMAND COUNT 错误.", r)
+	}
+	// 由 Handler 直接执行的命令也有元数据
+	for _, name := range []string{"get", "select", "command", "hello"} {
+		if _, ok := executor.Lookup(name); !ok {
+			t.Errorf("%s 应该已注册.", name)
+		}
+	}
+	if r := execString(handler, client, "command"); !strings.HasPrefix(r, "*"+strconv.Itoa(count)+"\r\n") {
+		t.Error("COMMAND 应该返回全部命令.", r)
+	}
+
+	want := "*2\r\n" +
+		"*7\r\n$3\r\nset\r\n:-3\r\n*2\r\n+write\r\n+denyoom\r\n:1\r\n:1\r\n:1\r\n*3\r\n+@write\r\n+@slow\r\n+@string\r\n" +
+		"*-1"
+	if r := execString(handler, client, "command", "info", "SET", "nosuchcommand"); r != want {
+		t.Error("COMMAND INFO 错误.", r)
+	}
+	if r := execString(handler, client, "command", "info", "del"); !strings.Contains(r, ":1\r\n:-1\r\n:1\r\n") {
+		t.Error("DEL 的 key 的位置错误.", r)
+	}
+	encoded := string(reply.Encode(execCommand([][]byte{[]byte("command"), []byte("docs"), []byte("get"), []byte("info")}), reply.Resp3))
+	if encoded != "%2\r\n$3\r\nget\r\n%1\r\n$5\r\ngroup\r\n$6\r\nstring\r\n$4\r\ninfo\r\n%1\r\n$5\r\ngroup\r\n$6\r\nserver\r\n" {
+		t.Error("COMMAND DOCS 错误.", encoded)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"command", "getkeys", "rename", "a", "b"}, "*2\r\n$1\r\na\r\n$1\r\nb"},
+		{[]string{"command", "getkeys", "del", "a", "b", "c"}, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc"},
+		{[]string{"command", "getkeys", "memory", "usage", "k"}, "*1\r\n$1\r\nk"},
+		{[]string{"command", "getkeys", "ping"}, "-ERROR The command has no key arguments"},
+		{[]string{"command", "getkeys", "get"}, "-ERROR Invalid number of arguments specified for command"},
+		{[]string{"command", "getkeys", "nosuchcommand", "a"}, "-ERROR Invalid command specified"},
+		{[]string{"command", "list", "filterby", "pattern", "rename*"}, "*2\r\n$6\r\nrename\r\n$8\r\nrenamenx"},
+		{[]string{"command", "list", "filterby", "aclcat", "@string"}, "*5\r\n$3\r\nget\r\n$6\r\ngetset\r\n$3\r\nset\r\n$5\r\nsetnx\r\n$6\r\nstrlen"},
+		{[]string{"command", "list", "filterby", "module", "json"}, "*0"},
+		{[]string{"command", "list", "filterby", "name", "x"}, "-Error syntax error"},
+		{[]string{"command", "nosuchsubcommand"}, "-ERROR unknown command subcommand 'nosuchsubcommand'"},
+	}
+	for _, test := range tests {
+		if r := execString(handler, client, test.args...); r != test.want {
+			t.Errorf("%v: 期望 %q, 实际 %q.", test.args, test.want, r)
+		}
+	}
+	if r := execString(handler, client, "command", "list"); !strings.HasPrefix(r, "*"+strconv.Itoa(count)+"\r\n") {
+		t.Error("COMMAND LIST 应该返回全部命令.", r)
+	}
+
+	// 只有元数据的命令不能经 executor 执行
+	if r := executor.Exec(handler.dbs[0], [][]byte{[]byte("select"), []byte("0")}); !reply.IsErrorReply(r) {
+		t.Error("只有元数据的命令应该是未知的命令.", string(r.ToBytes()))
+	}
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"simple_kvstorage/executor"
+	"simple_kvstorage/resp/reply"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// commandStat 一个命令的统计.
+// calls 执行的次数, usec 执行的总耗时 (微秒), rejectedCalls 因参数数量错误而没有执行的次数, failedCalls 执行之后回复错误的次数
+type commandStat struct {
+	calls         int64
+	usec          int64
+	rejectedCalls int64
+	failedCalls   int64
+}
+
+// commandStats 统计每个命令的执行次数与耗时, 见 INFO commandstats.
+type commandStats struct {
+	// name -> *commandStat
+	stats sync.Map
+}
+
+func newCommandStats() *commandStats {
+	return &commandStats{}
+}
+
+// intercept 实现 executor.Interceptor, 未知的命令不统计
+func (s *commandStats) intercept(call *executor.Call, next func() reply.Reply) reply.Reply {
+	theReply := next()
+	if _, ok := executor.Lookup(call.Name); !ok {
+		return theReply
+	}
+
+	value, ok := s.stats.Load(call.Name)
+	if !ok {
+		value, _ = s.stats.LoadOrStore(call.Name, &commandStat{})
+	}
+	stat := value.(*commandStat)
+	if _, rejected := theReply.(*reply.ArgNumberErrorReply); rejected {
+		atomic.AddInt64(&stat.rejectedCalls, 1)
+		return theReply
+	}
+	atomic.AddInt64(&stat.calls, 1)
+	atomic.AddInt64(&stat.usec, time.Since(call.Start).Microseconds())
+	// 不用 reply.IsErrorReply, 避免编码整个回复. PSYNC 等命令的回复为空.
+	if _, failed := theReply.(reply.ErrorReply); failed {
+		atomic.AddInt64(&stat.failedCalls, 1)
+	}
+	return theReply
+}
+
+// info 返回 INFO commandstats 的字段, 按命令名排序
+func (s *commandStats) info() [][2]string {
+	fields := make([][2]string, 0)
+	s.stats.Range(func(key, value any) bool {
+		stat := value.(*commandStat)
+		calls := atomic.LoadInt64(&stat.calls)
+		usec := atomic.LoadInt64(&stat.usec)
+		perCall := 0.0
+		if calls > 0 {
+			perCall = float64(usec) / float64(calls)
+		}
+		fields = append(fields, [2]string{
+			"cmdstat_" + key.(string),
+			"calls=" + strconv.FormatInt(calls, 10) +
+				",usec=" + strconv.FormatInt(usec, 10) +
+				",usec_per_call=" + strconv.FormatFloat(perCall, 'f', 2, 64) +
+				",rejected_calls=" + strconv.FormatInt(atomic.LoadInt64(&stat.rejectedCalls), 10) +
+				",failed_calls=" + strconv.FormatInt(atomic.LoadInt64(&stat.failedCalls), 10),
+		})
+		return true
+	})
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i][0] < fields[j][0]
+	})
+	return fields
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"errors"
+	"simple_kvstorage/erasure"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/persistent"
+	"simple_kvstorage/replication"
+	"simple_kvstorage/resp/reply"
+	"simple_kvstorage/util/logger"
+	"strconv"
+	"time"
+)
+
+const (
+	// erasureWaitTimeout 擦除之后等待正在进行的 AOF 重写或快照完成的最长时间
+	erasureWaitTimeout = time.Minute
+	// erasureRetryInterval 等待时重试的间隔
+	erasureRetryInterval = 50 * time.Millisecond
+)
+
+// SetErasure 设置数据主体的擦除规则和报告的签名密钥
+func (h *Handler) SetErasure(rules *erasure.Rules, signingKey []byte) {
+	h.erasureRules = rules
+	h.erasureSigningKey = signingKey
+}
+
+// execErasurePlan ERASURE.PLAN subject
+// 返回与数据主体相关的全部 key, 形如 [db1, key1, db2, key2, ...], 不做任何修改
+func (h *Handler) execErasurePlan(cmdLine executor.CmdLine) reply.Reply {
+	if len(cmdLine) != 2 {
+		return reply.NewArgNumberErrorReply("erasure.plan")
+	}
+	if h.erasureRules == nil {
+		return reply.NewStandardErrorReply("ERROR erasure rules are not configured")
+	}
+
+	h.worldLock.RLock()
+	plan, err := h.erasureRules.Plan(h.dbs, string(cmdLine[1]))
+	h.worldLock.RUnlock()
+	if err != nil {
+		return reply.NewStandardErrorReply(err.Error())
+	}
+
+	result := make([][]byte, 0, 2*plan.Count())
+	for _, dbIndex := range plan.DBs() {
+		for _, key := range plan.Keys[dbIndex] {
+			result = append(result, []byte(strconv.Itoa(dbIndex)), []byte(key))
+		}
+	}
+	return reply.NewMultiBulkReply(result)
+}
+
+// execErasureExecute ERASURE.EXECUTE subject
+// 原子地删除与数据主体相关的全部 key, 然后重写 AOF 并重新生成快照, 使被删除的数据不再出现在任何持久化文件中.
+// 返回签名的擦除报告 (JSON).
+func (h *Handler) execErasureExecute(cmdLine executor.CmdLine) reply.Reply {
+	if len(cmdLine) != 2 {
+		return reply.NewArgNumberErrorReply("erasure.execute")
+	}
+	if h.erasureRules == nil {
+		return reply.NewStandardErrorReply("ERROR erasure rules are not configured")
+	}
+	if len(h.erasureSigningKey) == 0 {
+		return reply.NewStandardErrorReply("ERROR erasure-signing-key is not configured")
+	}
+	// Raft 模式下的修改必须经过 Raft 日志
+	if h.raftNode != nil {
+		return reply.NewStandardErrorReply("ERROR erasure.execute is not supported in raft mode")
+	}
+	if h.replication != nil && h.replication.IsReplica() {
+		return reply.NewStandardErrorReply(replication.ErrReadOnly.Error())
+	}
+
+	// 1. 暂停其他命令, 找出并删除全部 key. 删除以 DEL 命令写入 AOF 并传播给从节点.
+	h.worldLock.Lock()
+	// 从节点只会收到 DEL, 它的 AOF 与快照仍然包含被删除的数据, 因此有从节点连接时拒绝执行.
+	// Psync 也需要 worldLock, 检查之后直到删除完成都不会有新的从节点加入.
+	if h.replication != nil && len(h.replication.Stats().Replicas) > 0 {
+		h.worldLock.Unlock()
+		return reply.NewStandardErrorReply("ERROR erasure.execute is not supported while replicas are connected")
+	}
+	plan, err := h.erasureRules.Plan(h.dbs, string(cmdLine[1]))
+	if err != nil {
+		h.worldLock.Unlock()
+		return reply.NewStandardErrorReply(err.Error())
+	}
+	for _, dbIndex := range plan.DBs() {
+		keys := plan.Keys[dbIndex]
+		h.dbs[dbIndex].Removes(keys...)
+
+		delLine := make([][]byte, 0, len(keys)+1)
+		delLine = append(delLine, []byte("del"))
+		for _, key := range keys {
+			delLine = append(delLine, []byte(key))
+		}
+		// 随后的重写会生成不包含这些 key 的基础文件, 写入失败只需要记录下来
+		if h.aof != nil {
+			if err := h.aof.Persistence(dbIndex, delLine); err != nil {
+				logger.Warn("擦除的 DEL 命令写入 AOF 失败.", err)
+			}
+		}
+		if h.replication != nil {
+			h.replication.Propagate(dbIndex, delLine)
+		}
+	}
+	if h.snapshotter != nil {
+		h.snapshotter.AddDirty(int64(plan.Count()))
+	}
+	h.worldLock.Unlock()
+
+	report := erasure.NewReport(plan, time.Now())
+
+	// 2. 重写 AOF, 旧的基础文件和增量文件中的数据随之被删除
+	if aof, ok := h.aof.(*persistent.AofPersistent); ok {
+		if err := waitInProgress(aof.Rewrite, persistent.ErrRewriteInProgress); err != nil {
+			return reply.NewStandardErrorReply("ERROR keys erased but AOF rewrite failed: " + err.Error())
+		}
+		report.AofRewritten = true
+	}
+
+	// 3. 重新生成快照
+	if h.snapshotter != nil {
+		if err := waitInProgress(h.snapshotter.Save, persistent.ErrSaveInProgress); err != nil {
+			return reply.NewStandardErrorReply("ERROR keys erased but snapshot save failed: " + err.Error())
+		}
+		report.SnapshotSaved = true
+	}
+
+	signed, err := report.Sign(h.erasureSigningKey)
+	if err != nil {
+		return reply.NewStandardErrorReply(err.Error())
+	}
+	return reply.NewBulkReply(signed)
+}
+
+// waitInProgress 调用 fn. 已经有一次在进行中时 (返回 busy), 它开始于擦除之前, 可能包含被删除的数据,
+// 因此等待它完成之后重新调用.
+func waitInProgress(fn func() error, busy error) error {
+	deadline := time.Now().Add(erasureWaitTimeout)
+	for {
+		err := fn()
+		if !errors.Is(err, busy) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(erasureRetryInterval)
+	}
+}
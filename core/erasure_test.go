@@ -0,0 +1,103 @@
+package core
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"simple_kvstorage/database"
+	"simple_kvstorage/erasure"
+	"simple_kvstorage/persistent"
+	"simple_kvstorage/resp/reply"
+	"strings"
+	"testing"
+)
+
+func TestErasure(t *testing.T) {
+	dbs := []database.DB{database.NewConcurrentDB(0), database.NewConcurrentDB(1)}
+	dir := t.TempDir()
+	aof, err := persistent.NewAofPersistent(&persistent.AofConfig{
+		Dirname:  filepath.Join(dir, "appendonlydir"),
+		Filename: filepath.Join(dir, "test.aof"),
+		Fsync:    persistent.FsyncAlways,
+	}, dbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(dbs, aof)
+	defer handler.CloseDatabase()
+	rules, _ := erasure.ParseRules(strings.NewReader("user:{subject}:*\n"))
+	handler.SetErasure(rules, []byte("secret"))
+
+	client := newClient(nil)
+	exec := func(args ...string) reply.Reply {
+		cmdLine := make([][]byte, len(args))
+		for i, arg := range args {
+			cmdLine[i] = []byte(arg)
+		}
+		return handler.Exec(client, cmdLine)
+	}
+	exec("set", "user:42:name", "alice")
+	exec("set", "user:7:name", "bob")
+	exec("select", "1")
+	exec("set", "user:42:email", "a@example.com")
+
+	plan := exec("erasure.plan", "42").(*reply.MultiBulkReply)
+	if len(plan.Args) != 4 || string(plan.Args[0]) != "0" || string(plan.Args[3]) != "user:42:email" {
+		t.Fatal("擦除计划错误.", string(plan.ToBytes()))
+	}
+
+	result, ok := exec("erasure.execute", "42").(*reply.BulkReply)
+	if !ok {
+		t.Fatal("擦除失败.")
+	}
+	// 去掉 $长度\r\n 与末尾的 \r\n
+	data := result.ToBytes()
+	data = data[strings.Index(string(data), "\r\n")+2 : len(data)-2]
+	report, err := erasure.Verify(data, []byte("secret"))
+	if err != nil || len(report.Keys) != 2 || !report.AofRewritten {
+		t.Fatal("擦除报告错误.", report, err)
+	}
+	if dbs[0].Size() != 1 || dbs[1].Size() != 0 {
+		t.Error("没有删除匹配的 key.")
+	}
+
+	// 重写之后, AOF 目录中不再包含被删除的数据
+	files, _ := filepath.Glob(filepath.Join(dir, "appendonlydir", "*"))
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		if strings.Contains(string(data), "alice") || strings.Contains(string(data), "a@example.com") {
+			t.Error("AOF 文件中仍然包含被删除的数据.", file)
+		}
+	}
+}
+
+func TestErasure_RefusedWithReplicas(t *testing.T) {
+	master, replica := newTestServer(t), newTestServer(t)
+	rules, _ := erasure.ParseRules(strings.NewReader("user:{subject}:*\n"))
+	master.handler.SetErasure(rules, []byte("secret"))
+	masterConn := dialTestServer(t, master)
+	masterConn.do(t, "set", "user:42:name", "alice")
+
+	host, port, _ := net.SplitHostPort(master.addr())
+	dialTestServer(t, replica).do(t, "replicaof", host, port)
+	waitFor(t, "从节点没有连接.", func() bool { return len(master.replication.Stats().Replicas) == 1 })
+
+	// 从节点的持久化文件不会被重写, 有从节点时拒绝擦除
+	if r := masterConn.do(t, "erasure.execute", "42"); !strings.Contains(r, "replicas are connected") {
+		t.Fatal("有从节点时应该拒绝擦除.", r)
+	}
+	if getValue(master, 0, "user:42:name") != "alice" {
+		t.Error("拒绝擦除时不应该删除 key.")
+	}
+
+	// 从节点断开之后可以擦除
+	replicaConn := dialTestServer(t, replica)
+	replicaConn.do(t, "replicaof", "no", "one")
+	waitFor(t, "从节点没有断开.", func() bool { return len(master.replication.Stats().Replicas) == 0 })
+	if r := masterConn.do(t, "erasure.execute", "42"); !strings.HasPrefix(r, "$") {
+		t.Fatal("擦除失败.", r)
+	}
+	if getValue(master, 0, "user:42:name") != "" {
+		t.Error("没有删除匹配的 key.")
+	}
+}
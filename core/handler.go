@@ -4,9 +4,13 @@ import (
 	"context"
 	"io"
 	"runtime/debug"
+	"simple_kvstorage/client"
 	"simple_kvstorage/database"
+	"simple_kvstorage/erasure"
 	"simple_kvstorage/executor"
 	"simple_kvstorage/persistent"
+	"simple_kvstorage/raft"
+	"simple_kvstorage/replication"
 	"simple_kvstorage/resp"
 	"simple_kvstorage/resp/reply"
 	"simple_kvstorage/util/logger"
@@ -14,6 +18,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	syncatomic "sync/atomic"
 )
 
 // Handler the Core handler
@@ -27,10 +32,101 @@ type Handler struct {
 	dbs []database.DB
 	// 持久化
 	aof persistent.Persistent
+	// 内存淘汰
+	evictor *database.Evictor
+	// 快照持久化
+	snapshotter *persistent.Snapshotter
+	// 主从复制
+	replication *replication.Manager
+	// 数据主体的擦除规则, 擦除报告的签名密钥
+	erasureRules      *erasure.Rules
+	erasureSigningKey []byte
+
+	// 集群模式下的路由
+	router Router
+	// Raft 模式下的节点, 写命令经它提交之后才应用到数据库
+	raftNode *raft.Node
+	// raftSecret Raft 节点之间共享的密钥, 见 RAFT AUTH
+	raftSecret string
+
+	// MIGRATE 与每个目标节点之间的客户端
+	migrateMutex   sync.Mutex
+	migrateClients map[string]*client.Client
+
+	// requirePass 客户端通过 AUTH 或 HELLO AUTH 认证时使用的密码
+	requirePass string
+	// protoLimits 解析客户端命令时的限制, outputLimit 客户端输出缓冲区的限制
+	protoLimits resp.Limits
+	outputLimit OutputBufferLimit
+
+	// connectionsReceived 接受过的连接数, commandsProcessed 执行过的命令数, 见 INFO stats
+	connectionsReceived int64
+	commandsProcessed   int64
+
+	// interceptors 拦截客户端发起的命令, 默认包括 commandStats 与 slowLog
+	interceptors executor.Chain
+	// commandStats 每个命令的执行次数与耗时, 见 INFO commandstats; slowLog 慢查询日志, 见 SLOWLOG
+	commandStats *commandStats
+	slowLog      *slowLog
+
+	// worldLock 普通命令执行时持有读锁, 需要原子地修改多个数据库的命令 (如 ERASURE.EXECUTE) 持有写锁
+	worldLock sync.RWMutex
+}
+
+// Router 集群模式下, 在本节点执行命令之前决定命令由哪个节点执行
+type Router interface {
+	// Route 命令由本节点执行时返回 nil, 否则返回要发给客户端的回复, 如 MOVED 错误或转发给其他节点之后的回复
+	Route(client *Client, cmdLine executor.CmdLine) reply.Reply
+	// Close 关闭与其他节点的连接
+	Close()
 }
 
 func NewHandler(dbs []database.DB, aof persistent.Persistent) *Handler {
-	return &Handler{dbs: dbs, aof: aof}
+	h := &Handler{
+		dbs:          dbs,
+		aof:          aof,
+		protoLimits:  resp.DefaultLimits,
+		commandStats: newCommandStats(),
+		slowLog:      newSlowLog(defaultSlowLogSlowerThan, defaultSlowLogMaxLen),
+	}
+	h.Use(h.commandStats.intercept, h.slowLog.intercept)
+	// 重写 AOF 时持有写锁复制数据库, 使快照与新的增量文件恰好衔接
+	if aof, ok := aof.(*persistent.AofPersistent); ok {
+		aof.SetWorldLock(&h.worldLock)
+	}
+	return h
+}
+
+// Use 加入拦截客户端命令的拦截器, 它们在内置的 commandstats 与慢查询日志之内执行, Call.Client 是 *Client.
+// 拦截器也会看到由 Handler 直接执行的命令 (如 SELECT, INFO) 与集群模式下转发给其他节点的命令.
+func (h *Handler) Use(interceptors ...executor.Interceptor) {
+	h.interceptors.Use(interceptors...)
+}
+
+// SetProtocolLimits 设置解析客户端命令时的限制, 超过限制的客户端会收到协议错误并被断开
+func (h *Handler) SetProtocolLimits(limits resp.Limits) {
+	h.protoLimits = limits
+}
+
+// SetOutputBufferLimit 设置客户端输出缓冲区的限制, 超过限制的客户端会被断开
+func (h *Handler) SetOutputBufferLimit(limit OutputBufferLimit) {
+	h.outputLimit = limit
+}
+
+// SetEvictor 设置内存淘汰器, 被淘汰的 key 会以 DEL 命令写入持久化文件
+func (h *Handler) SetEvictor(evictor *database.Evictor) {
+	h.evictor = evictor
+	evictor.OnEvict(func(dbIndex int, key string) {
+		cmdLine := [][]byte{[]byte("del"), []byte(key)}
+		if h.aof != nil {
+			if err := h.aof.Persistence(dbIndex, cmdLine); err != nil {
+				logger.Warn("被淘汰的 key 写入 AOF 失败.", err)
+			}
+		}
+		if h.replication != nil {
+			h.replication.Propagate(dbIndex, cmdLine)
+		}
+	})
 }
 
 func (h *Handler) Handle(connection io.ReadWriteCloser, ctx context.Context) {
@@ -41,43 +137,41 @@ func (h *Handler) Handle(connection io.ReadWriteCloser, ctx context.Context) {
 
 	// 2. 将连接封装进客户端中, 并记录客户端到活跃客户端的容器里
 	client := newClient(connection)
+	client.outputLimit = &h.outputLimit
 	h.activeClient.Store(client, struct{}{})
+	syncatomic.AddInt64(&h.connectionsReceived, 1)
 
-	// 3. 与客户端进行交互通信
-	parseChan := resp.CreateParser(client.connection)
-	for payload := range parseChan {
+	// 3. 与客户端进行交互通信. 一批命令在本协程上逐个解析执行, 回复先写入输出缓冲区, 读取下一批命令之前一次发送.
+	decoder := resp.NewRequestDecoder(flushingReader{client: client}, &h.protoLimits)
+	for {
 		// 给客户端的回应
 		var theReply reply.Reply
 
-		if payload.Error != nil {
-			// 发生 IO 错误, 表示 TCP 连接已经发生错误或已经关闭了
-			if payload.Error == io.EOF || payload.Error == io.ErrUnexpectedEOF ||
-				strings.Contains(payload.Error.Error(), "use of closed network connection") {
-				logger.Info("payload.Error 为 EOF.")
-				h.closeClient(client)
-				break
-			}
-
+		request, err := decoder.Decode()
+		if err != nil {
 			// 发生参数错误, 协议错误, 语法错误, 或其他错误. 则返回给客户端错误原因即可.
-			errorReply, ok := payload.Error.(reply.ErrorReply)
+			errorReply, ok := err.(reply.ErrorReply)
 			if !ok {
-				errorReply = reply.NewStandardErrorReply(payload.Error.Error())
+				// 发生 IO 错误, 表示 TCP 连接已经发生错误或已经关闭了, 或者输出缓冲区超过了限制
+				if err == io.EOF || err == io.ErrUnexpectedEOF ||
+					strings.Contains(err.Error(), "use of closed network connection") {
+					logger.Info("payload.Error 为 EOF.")
+				}
+				h.closeClient(client)
+				return
 			}
 
 			theReply = errorReply
 		} else {
-			if payload.Data == nil {
-				logger.Error("客户端的请求报文是空的.")
-				continue
-			}
-
-			parsedReply, ok := payload.Data.(*reply.MultiBulkReply)
+			parsedReply, ok := request.(*reply.MultiBulkReply)
 			if !ok {
-				logger.Error("客户端的请求报文不是 Multi Bulk Reply.", string(payload.Data.ToBytes()))
+				logger.Error("客户端的请求报文不是 Multi Bulk Reply.", string(request.ToBytes()))
 				continue
 			}
 
 			// 接收到正常的命令报文, 执行命令
+			client.recordCommand(parsedReply.Args[0], decoder.Buffered())
+			syncatomic.AddInt64(&h.commandsProcessed, 1)
 			dbReply := h.Exec(client, parsedReply.Args)
 			if dbReply == nil {
 				dbReply = reply.GetUnknownErrorReply()
@@ -86,13 +180,20 @@ func (h *Handler) Handle(connection io.ReadWriteCloser, ctx context.Context) {
 			theReply = dbReply
 		}
 
-		// 将 theReply 通过 TCP 写给客户端
-		err := client.Write(theReply.ToBytes())
-		if err != nil {
+		// 将 theReply 写入输出缓冲区
+		if err := client.bufferReply(reply.Encode(theReply, client.Protocol())); err != nil {
 			h.closeClient(client)
 			return
 		}
+
+		// 4. 解析器停止了, 例如命令超过了协议的限制, 或发生了其他 IO 错误
+		if decoder.Err() != nil {
+			break
+		}
 	}
+
+	_ = client.Flush()
+	h.closeClient(client)
 }
 
 func (h *Handler) Close() error {
@@ -114,7 +215,7 @@ func (h *Handler) closeClient(client *Client) {
 	h.activeClient.Delete(client)
 }
 
-// Exec 执行命令
+// Exec 经过拦截器之后执行命令
 func (h *Handler) Exec(client *Client, cmdLine executor.CmdLine) reply.Reply {
 	defer func() {
 		if err := recover(); err != nil {
@@ -123,8 +224,90 @@ func (h *Handler) Exec(client *Client, cmdLine executor.CmdLine) reply.Reply {
 	}()
 
 	cmdName := strings.ToLower(string(cmdLine[0]))
-	if cmdName == "select" {
+	// 设置了密码时, 认证之前只能执行 AUTH 与 HELLO. 其他 Raft 节点以共享密钥认证, 不需要 requirepass.
+	if cmdName != "auth" && cmdName != "hello" && !isRaftPeerCommand(client, cmdLine) && h.needAuth(client) {
+		return reply.NewStandardErrorReply("NOAUTH Authentication required.")
+	}
+
+	call := &executor.Call{
+		Client:  client,
+		DBIndex: client.GetDBIndex(),
+		Name:    cmdName,
+		CmdLine: cmdLine,
+	}
+	return h.interceptors.Invoke(call, func() reply.Reply {
+		return h.exec(client, call.Name, cmdLine)
+	})
+}
+
+// exec 执行命令
+func (h *Handler) exec(client *Client, cmdName string, cmdLine executor.CmdLine) reply.Reply {
+	if h.router != nil {
+		if theReply := h.router.Route(client, cmdLine); theReply != nil {
+			return theReply
+		}
+	}
+
+	switch cmdName {
+	case "select":
 		return h.execSelect(client, cmdLine)
+	case "info":
+		return h.execInfo(cmdLine)
+	case "bgrewriteaof":
+		return h.execBgRewriteAof(cmdLine)
+	case "save":
+		return h.execSave(cmdLine)
+	case "bgsave":
+		return h.execBgSave(cmdLine)
+	case "lastsave":
+		return h.execLastSave(cmdLine)
+	case "replicaof", "slaveof":
+		return h.execReplicaOf(cmdLine)
+	case "psync":
+		return h.execPsync(client, cmdLine)
+	case "replconf":
+		return h.execReplConf(client, cmdLine)
+	case "migrate":
+		return h.execMigrate(client, cmdLine)
+	case "erasure.plan":
+		return h.execErasurePlan(cmdLine)
+	case "erasure.execute":
+		return h.execErasureExecute(cmdLine)
+	case "raft":
+		return h.execRaft(client, cmdLine)
+	case "auth":
+		return h.execAuth(client, cmdLine)
+	case "hello":
+		return h.execHello(client, cmdLine)
+	case "client":
+		return h.execClient(client, cmdLine)
+	case "command":
+		return execCommand(cmdLine)
+	case "slowlog":
+		return h.execSlowLog(cmdLine)
+	}
+
+	isWrite := executor.HasFlag(cmdName, executor.FlagWrite)
+	// Raft 模式下, 写命令提交到 Raft 日志之后才执行, 读命令只在领导者上执行
+	if h.raftNode != nil {
+		if isWrite {
+			return h.execRaftWrite(client, cmdLine)
+		}
+		return h.execRaftRead(client, cmdLine)
+	}
+	// 从节点只执行主节点传播的写命令
+	if isWrite && h.replication != nil && h.replication.IsReplica() {
+		return reply.NewStandardErrorReply(replication.ErrReadOnly.Error())
+	}
+
+	h.worldLock.RLock()
+	defer h.worldLock.RUnlock()
+
+	// 内存不足时, 写命令需要先淘汰数据. 无法淘汰时只拒绝可能增加内存占用的命令, DEL 与 FLUSHDB 仍然可以释放内存.
+	if isWrite && h.evictor != nil {
+		if err := h.evictor.FreeMemoryIfNeeded(); err != nil && executor.HasFlag(cmdName, executor.FlagDenyOOM) {
+			return reply.NewStandardErrorReply(err.Error())
+		}
 	}
 
 	// normal commands
@@ -132,13 +315,30 @@ func (h *Handler) Exec(client *Client, cmdLine executor.CmdLine) reply.Reply {
 	theReply := executor.Exec(selectedDB, cmdLine)
 
 	// 持久化
+	var aofErr error
 	if h.aof != nil && !reply.IsErrorReply(theReply) {
-		h.aof.Persistence(client.GetDBIndex(), cmdLine)
+		aofErr = h.aof.Persistence(client.GetDBIndex(), cmdLine)
+	}
+	if isWrite && !reply.IsErrorReply(theReply) {
+		if h.snapshotter != nil {
+			h.snapshotter.AddDirty(1)
+		}
+		if h.replication != nil {
+			h.replication.Propagate(client.GetDBIndex(), cmdLine)
+		}
+	}
+	if aofErr != nil {
+		return aofErrorReply(aofErr)
 	}
 
 	return theReply
 }
 
+// aofErrorReply 写命令没有写入 AOF 时的回复. 命令已经修改了内存中的数据, 但不能向客户端确认它已经持久化.
+func aofErrorReply(err error) reply.ErrorReply {
+	return reply.NewStandardErrorReply("ERROR writing to the append only file: " + err.Error())
+}
+
 // execSelect SELECT index
 // 参考: https://redis.io/commands/select
 func (h *Handler) execSelect(client *Client, cmdLine executor.CmdLine) reply.Reply {
@@ -158,8 +358,63 @@ func (h *Handler) execSelect(client *Client, cmdLine executor.CmdLine) reply.Rep
 	return reply.GetOkReply()
 }
 
+// execBgRewriteAof BGREWRITEAOF
+// 参考: https://redis.io/commands/bgrewriteaof
+func (h *Handler) execBgRewriteAof(cmdLine executor.CmdLine) reply.Reply {
+	if len(cmdLine) != 1 {
+		return reply.NewArgNumberErrorReply("bgrewriteaof")
+	}
+
+	aof, ok := h.aof.(*persistent.AofPersistent)
+	if !ok {
+		return reply.NewStandardErrorReply("ERROR append only file is disabled")
+	}
+	if err := aof.BackgroundRewrite(); err != nil {
+		return reply.NewStandardErrorReply(err.Error())
+	}
+	return reply.NewStatusReply("Background append only file rewriting started")
+}
+
+// SetRouter 开启集群模式, 命令先经过 router 决定由哪个节点执行
+func (h *Handler) SetRouter(router Router) {
+	h.router = router
+}
+
+// SetSnapshotter 设置快照持久化引擎, 生成快照时持有 worldLock 复制数据库
+func (h *Handler) SetSnapshotter(snapshotter *persistent.Snapshotter) {
+	h.snapshotter = snapshotter
+	snapshotter.SetWorldLock(&h.worldLock)
+}
+
 // AfterClientClose 一个客户端断开连接之后的清理工作
-func (h *Handler) AfterClientClose(client *Client) {}
+func (h *Handler) AfterClientClose(client *Client) {
+	if client.isReplica && h.replication != nil {
+		h.replication.RemoveReplica(client)
+	}
+}
 
 // CloseDatabase 关闭数据库
-func (h *Handler) CloseDatabase() {}
+func (h *Handler) CloseDatabase() {
+	if h.router != nil {
+		h.router.Close()
+	}
+	if h.raftNode != nil {
+		h.raftNode.Stop()
+	}
+	h.closeMigrateClients()
+	if h.replication != nil {
+		h.replication.Close()
+	}
+	if h.aof != nil {
+		_ = h.aof.Close()
+	}
+	if h.snapshotter != nil {
+		h.snapshotter.Close()
+		// 配置了自动生成快照的规则时, 关闭前保存一次快照
+		if h.snapshotter.HasSaveRules() {
+			if err := h.snapshotter.Save(); err != nil {
+				logger.Error("关闭前保存快照失败.", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"path/filepath"
+	"simple_kvstorage/database"
+	"simple_kvstorage/persistent"
+	"simple_kvstorage/resp"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler_ProtocolLimits(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.SetProtocolLimits(resp.Limits{MaxBulkLen: 16, MaxMultiBulkLen: 4, QueryBufferLimit: 32})
+
+	conn, err := net.Dial("tcp", s.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// 超过限制之后, 服务器回复协议错误并关闭连接, 后面的命令不会被执行
+	request := "*3\r\n$3\r\nset\r\n$1\r\na\r\n$0\r\n\r\n" +
+		"*3\r\n$3\r\nset\r\n$1\r\nb\r\n$9999999999\r\n" +
+		"*3\r\n$3\r\nset\r\n$1\r\nc\r\n$1\r\n1\r\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	received, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal("连接没有被关闭.", err)
+	}
+	if string(received) != "+OK\r\n-ERROR Protocol error: 'invalid bulk length'\r\n" {
+		t.Errorf("回复错误. %q", received)
+	}
+	if value, ok := s.handler.dbs[0].Get("a"); !ok || len(value.Data.([]byte)) != 0 {
+		t.Error("空字符串应该被正常存入.")
+	}
+	if _, ok := s.handler.dbs[0].Get("c"); ok {
+		t.Error("超过限制之后的命令不应该被执行.")
+	}
+
+	// 其他连接不受影响
+	if r := dialTestServer(t, s).do(t, "get", "a"); !strings.HasPrefix(r, "$0") {
+		t.Error("其他连接应该可以正常执行命令.", r)
+	}
+}
+
+func TestHandler_Pipeline(t *testing.T) {
+	s := newTestServer(t)
+	conn, err := net.Dial("tcp", s.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// 一批命令的回复按顺序返回. 最后一条命令不完整, 它之前的命令的回复不应该等待它.
+	request := "*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n" +
+		"get a\r\n" +
+		"*2\r\n$3\r\nget\r\n$1\r\nb\r\n" +
+		"*2\r\n$3\r\nget\r\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	expected := "+OK\r\n$1\r\n1\r\n$-1\r\n"
+	received := make([]byte, len(expected))
+	if _, err := io.ReadFull(conn, received); err != nil {
+		t.Fatal("读取回复失败.", err)
+	}
+	if string(received) != expected {
+		t.Errorf("回复错误. %q", received)
+	}
+
+	// 补全最后一条命令
+	if _, err := conn.Write([]byte("$1\r\na\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	received = make([]byte, len("$1\r\n1\r\n"))
+	if _, err := io.ReadFull(conn, received); err != nil || string(received) != "$1\r\n1\r\n" {
+		t.Errorf("回复错误. %q %v", received, err)
+	}
+}
+
+func TestHandler_AofError(t *testing.T) {
+	dbs := []database.DB{database.NewConcurrentDB(0)}
+	dir := t.TempDir()
+	aof, err := persistent.NewAofPersistent(&persistent.AofConfig{
+		Dirname:  filepath.Join(dir, "appendonlydir"),
+		Filename: filepath.Join(dir, "test.aof"),
+		Fsync:    persistent.FsyncAlways,
+	}, dbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(dbs, aof)
+	defer handler.CloseDatabase()
+
+	client := newClient(nil)
+	if r := execString(handler, client, "set", "a", "1"); r != "+OK" {
+		t.Fatal(r)
+	}
+	// 没有写入 AOF 的写命令不能回复成功, 读命令不受影响
+	_ = aof.Close()
+	if r := execString(handler, client, "set", "b", "2"); !strings.HasPrefix(r, "-ERROR writing to the append only file") {
+		t.Error("AOF 写入失败时应该回复错误.", r)
+	}
+	if r := execString(handler, client, "get", "a"); r != "$1\r\n1" {
+		t.Error(r)
+	}
+}
+
+func TestHandler_NoEviction(t *testing.T) {
+	dbs := []database.DB{database.NewConcurrentDB(0)}
+	handler := NewHandler(dbs, nil)
+	defer handler.CloseDatabase()
+	client := newClient(nil)
+	for i := 0; i < 10; i++ {
+		execString(handler, client, "set", "key"+strconv.Itoa(i), "value")
+	}
+	handler.SetEvictor(database.NewEvictor(dbs, database.UsedMemory(dbs)-1, database.NoEviction, 0))
+
+	// 内存不足时只拒绝可能增加内存占用的命令, 释放内存的命令可以执行
+	for _, cmdLine := range [][]string{{"set", "a", "1"}, {"setnx", "a", "1"}, {"getset", "key0", "v"}, {"rename", "key0", "a"}} {
+		if r := execString(handler, client, cmdLine...); !strings.HasPrefix(r, "-OOM") {
+			t.Error("内存不足时应该拒绝.", cmdLine, r)
+		}
+	}
+	if r := execString(handler, client, "del", "key0"); r != ":1" {
+		t.Error("内存不足时 DEL 应该可以执行.", r)
+	}
+	if r := execString(handler, client, "flushdb"); r != "+OK" || dbs[0].Size() != 0 {
+		t.Error("内存不足时 FLUSHDB 应该可以执行.", r)
+	}
+	if r := execString(handler, client, "set", "a", "1"); r != "+OK" {
+		t.Error("释放内存之后应该可以写入.", r)
+	}
+}
+
+// BenchmarkHandler_Pipeline 与 redis-benchmark -P 相同, 每批发送 pipeline 条命令之后再读取全部回复, 报告每秒执行的命令数
+func BenchmarkHandler_Pipeline(b *testing.B) {
+	for _, command := range []string{"set", "get"} {
+		for _, pipeline := range []int{1, 16, 128} {
+			b.Run(strings.ToUpper(command)+"/P"+strconv.Itoa(pipeline), func(b *testing.B) {
+				benchmarkPipeline(b, command, pipeline)
+			})
+		}
+	}
+}
+
+func benchmarkPipeline(b *testing.B, command string, pipeline int) {
+	s := newTestServer(b)
+	conn, err := net.Dial("tcp", s.addr())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	s.handler.dbs[0].Put("key:__rand_int__", &database.DataEntity{Data: []byte("xxx")})
+
+	args := [][]byte{[]byte(command), []byte("key:__rand_int__")}
+	if command == "set" {
+		args = append(args, []byte("xxx"))
+	}
+	var batch bytes.Buffer
+	for i := 0; i < pipeline; i++ {
+		batch.Write(reply.NewMultiBulkReply(args).ToBytes())
+	}
+	decoder := resp.NewDecoder(conn)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for sent := 0; sent < b.N; sent += pipeline {
+		if _, err := conn.Write(batch.Bytes()); err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < pipeline; i++ {
+			if _, err := decoder.Decode(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "requests/s")
+}
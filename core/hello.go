@@ -0,0 +1,131 @@
+package core
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+)
+
+const (
+	helloServerName = "simple_kvstorage"
+	// helloServerVersion 兼容的 Redis 版本, 客户端可能按版本判断支持的特性
+	helloServerVersion = "7.0.0"
+)
+
+// SetRequirePass 设置 AUTH 与 HELLO AUTH 使用的密码, 空字符串表示没有设置密码.
+// 设置了密码时, 客户端认证之前只能执行 AUTH 与 HELLO.
+func (h *Handler) SetRequirePass(password string) {
+	h.requirePass = password
+}
+
+// needAuth 客户端是否需要先认证才能执行命令
+func (h *Handler) needAuth(client *Client) bool {
+	return h.requirePass != "" && !client.authenticated
+}
+
+// checkPassword 检查用户名与密码, 只有默认用户 default. 比较摘要而不是密码本身, 耗时与密码的内容和长度无关.
+func (h *Handler) checkPassword(username, password string) reply.Reply {
+	if h.requirePass == "" {
+		return reply.NewStandardErrorReply("ERROR AUTH called without any password configured for the default user")
+	}
+	given, expected := sha256.Sum256([]byte(password)), sha256.Sum256([]byte(h.requirePass))
+	if username != "default" || subtle.ConstantTimeCompare(given[:], expected[:]) != 1 {
+		return reply.NewStandardErrorReply("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	return nil
+}
+
+// execAuth AUTH [username] password
+// 参考: https://redis.io/commands/auth
+func (h *Handler) execAuth(client *Client, cmdLine executor.CmdLine) reply.Reply {
+	username, password := "default", string(cmdLine[1])
+	switch len(cmdLine) {
+	case 2:
+	case 3:
+		username, password = string(cmdLine[1]), string(cmdLine[2])
+	default:
+		return reply.GetSyntaxErrReply()
+	}
+	if errReply := h.checkPassword(username, password); errReply != nil {
+		return errReply
+	}
+	client.authenticated = true
+	return reply.GetOkReply()
+}
+
+// execHello HELLO [protover [AUTH username password] [SETNAME clientname]]
+// 切换协议版本, 返回服务器与连接的信息. 参数全部合法时才会修改连接的状态.
+// 参考: https://redis.io/commands/hello
+func (h *Handler) execHello(client *Client, cmdLine executor.CmdLine) reply.Reply {
+	protocol := client.Protocol()
+	if len(cmdLine) > 1 {
+		version, err := strconv.Atoi(string(cmdLine[1]))
+		if err != nil {
+			return reply.NewStandardErrorReply("ERROR Protocol version is not an integer or out of range")
+		}
+		if version != reply.Resp2 && version != reply.Resp3 {
+			return reply.NewStandardErrorReply("NOPROTO unsupported protocol version")
+		}
+		protocol = version
+	}
+
+	var name string
+	var setName, auth bool
+	for i := 2; i < len(cmdLine); i++ {
+		option := strings.ToLower(string(cmdLine[i]))
+		switch {
+		case option == "auth" && i+2 < len(cmdLine):
+			if errReply := h.checkPassword(string(cmdLine[i+1]), string(cmdLine[i+2])); errReply != nil {
+				return errReply
+			}
+			auth = true
+			i += 2
+		case option == "setname" && i+1 < len(cmdLine):
+			name = string(cmdLine[i+1])
+			if !isValidClientName(name) {
+				return reply.NewStandardErrorReply("ERROR Client names cannot contain spaces, newlines or special characters.")
+			}
+			setName = true
+			i++
+		default:
+			return reply.NewStandardErrorReply("ERROR Syntax error in HELLO option '" + option + "'")
+		}
+	}
+
+	if !auth && h.needAuth(client) {
+		return reply.NewStandardErrorReply("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+
+	client.SetProtocol(protocol)
+	if setName {
+		client.SetName(name)
+	}
+	if auth {
+		client.authenticated = true
+	}
+
+	mode := "standalone"
+	if h.router != nil {
+		mode = "cluster"
+	}
+	role := "master"
+	if h.replication != nil && h.replication.IsReplica() {
+		role = "replica"
+	}
+	field := func(key string, value reply.Reply) reply.MapEntry {
+		return reply.MapEntry{Key: reply.NewBulkReply([]byte(key)), Value: value}
+	}
+	return reply.NewMapReply([]reply.MapEntry{
+		field("server", reply.NewBulkReply([]byte(helloServerName))),
+		field("version", reply.NewBulkReply([]byte(helloServerVersion))),
+		field("proto", reply.NewIntReply(int64(protocol))),
+		field("id", reply.NewIntReply(client.ID())),
+		field("mode", reply.NewBulkReply([]byte(mode))),
+		field("role", reply.NewBulkReply([]byte(role))),
+		field("modules", reply.GetEmptyMultiBulkReply()),
+	})
+}
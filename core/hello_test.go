@@ -0,0 +1,107 @@
+package core
+
+import (
+	"net"
+	"simple_kvstorage/resp/reply"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHello(t *testing.T) {
+	s := newTestServer(t)
+	s.handler.SetRequirePass("secret")
+	client := newClient(nil)
+
+	exec := func(args ...string) reply.Reply {
+		cmdLine := make([][]byte, len(args))
+		for i, arg := range args {
+			cmdLine[i] = []byte(arg)
+		}
+		return s.handler.Exec(client, cmdLine)
+	}
+
+	// 参数不合法时不修改连接的状态
+	if r := exec("hello", "4"); !strings.HasPrefix(string(r.ToBytes()), "-NOPROTO") {
+		t.Error("不支持的协议版本应该回复 NOPROTO.", string(r.ToBytes()))
+	}
+	if r := exec("hello", "3", "auth", "default", "wrong"); !strings.HasPrefix(string(r.ToBytes()), "-WRONGPASS") {
+		t.Error("密码错误时应该回复 WRONGPASS.", string(r.ToBytes()))
+	}
+	if r := exec("hello", "3", "setname"); !reply.IsErrorReply(r) {
+		t.Error("缺少参数时应该回复错误.", string(r.ToBytes()))
+	}
+	if client.Protocol() != reply.Resp2 || client.authenticated {
+		t.Error("命令失败时不应该修改连接的状态.")
+	}
+
+	r := exec("hello", "3", "auth", "default", "secret", "setname", "conn-1")
+	if client.Protocol() != reply.Resp3 || client.Name() != "conn-1" || !client.authenticated {
+		t.Error("HELLO 没有修改连接的状态.", client.Protocol(), client.Name())
+	}
+	if encoded := string(reply.Encode(r, reply.Resp3)); !strings.HasPrefix(encoded, "%7\r\n$6\r\nserver\r\n") ||
+		!strings.Contains(encoded, "$5\r\nproto\r\n:3\r\n") {
+		t.Error("HELLO 的回复错误.", encoded)
+	}
+	if encoded := string(r.ToBytes()); !strings.HasPrefix(encoded, "*14\r\n") {
+		t.Error("RESP2 下 HELLO 的回复应该是数组.", encoded)
+	}
+
+	// 通过网络连接: 切换到 RESP3 之后, 空值按 RESP3 编码
+	conn, err := net.Dial("tcp", s.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	request := "*5\r\n$5\r\nHELLO\r\n$1\r\n3\r\n$4\r\nAUTH\r\n$7\r\ndefault\r\n$6\r\nsecret\r\n" +
+		"*2\r\n$3\r\nget\r\n$7\r\nmissing\r\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var received []byte
+	buffer := make([]byte, 1024)
+	for !strings.HasSuffix(string(received), "\r\n_\r\n") {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			t.Fatal("读取回复失败.", err, string(received))
+		}
+		received = append(received, buffer[:n]...)
+	}
+	if !strings.HasPrefix(string(received), "%7\r\n") {
+		t.Error("RESP3 连接上的回复错误.", string(received))
+	}
+}
+
+func TestAuth(t *testing.T) {
+	s := newTestServer(t)
+	client := newClient(nil)
+	if r := execString(s.handler, client, "auth", "secret"); !strings.HasPrefix(r, "-ERROR AUTH called without") {
+		t.Error("没有设置密码时 AUTH 应该失败.", r)
+	}
+
+	s.handler.SetRequirePass("secret")
+	if r := execString(s.handler, client, "get", "a"); !strings.HasPrefix(r, "-NOAUTH") {
+		t.Error("认证之前应该回复 NOAUTH.", r)
+	}
+	if r := execString(s.handler, client, "hello", "3"); !strings.HasPrefix(r, "-NOAUTH") || client.Protocol() != reply.Resp2 {
+		t.Error("认证之前不带 AUTH 的 HELLO 应该回复 NOAUTH.", r)
+	}
+	for _, args := range [][]string{{"auth", "wrong"}, {"auth", "default", "wrong"}, {"auth", "admin", "secret"}, {"auth", "secre"}} {
+		if r := execString(s.handler, client, args...); !strings.HasPrefix(r, "-WRONGPASS") {
+			t.Error("密码错误时应该回复 WRONGPASS.", args, r)
+		}
+	}
+	if r := execString(s.handler, client, "get", "a"); !strings.HasPrefix(r, "-NOAUTH") {
+		t.Error("认证失败之后仍然应该回复 NOAUTH.", r)
+	}
+	if r := execString(s.handler, client, "auth", "secret"); r != "+OK" {
+		t.Fatal("AUTH 失败.", r)
+	}
+	if r := execString(s.handler, client, "get", "a"); strings.HasPrefix(r, "-") {
+		t.Error("认证之后应该可以执行命令.", r)
+	}
+	if r := execString(s.handler, newClient(nil), "auth", "default", "secret"); r != "+OK" {
+		t.Error("AUTH default 失败.", r)
+	}
+}
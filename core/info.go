@@ -0,0 +1,141 @@
+package core
+
+import (
+	"simple_kvstorage/database"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/persistent"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// infoSections INFO 默认输出的部分, 按输出的顺序排列. commandstats 只在指定 all, everything 或它本身时输出.
+var infoSections = []string{"clients", "memory", "persistence", "stats", "replication", "keyspace"}
+
+// execInfo INFO [section]
+// 参考: https://redis.io/commands/info
+func (h *Handler) execInfo(cmdLine executor.CmdLine) reply.Reply {
+	sections := infoSections
+	switch len(cmdLine) {
+	case 1:
+	case 2:
+		switch section := strings.ToLower(string(cmdLine[1])); section {
+		case "default":
+		case "all", "everything":
+			sections = append(sections[:len(sections):len(sections)], "commandstats")
+		default:
+			sections = []string{section}
+		}
+	default:
+		return reply.NewArgNumberErrorReply("info")
+	}
+
+	var builder strings.Builder
+	for _, section := range sections {
+		fields := h.infoSection(section)
+		if fields == nil {
+			continue
+		}
+		if builder.Len() > 0 {
+			builder.WriteString(reply.CRLF)
+		}
+		builder.WriteString("# " + strings.ToUpper(section[:1]) + section[1:] + reply.CRLF)
+		for _, field := range fields {
+			builder.WriteString(field[0] + ":" + field[1] + reply.CRLF)
+		}
+	}
+	return reply.NewVerbatimReply("txt", []byte(builder.String()))
+}
+
+// infoSection 返回 INFO 中一个部分的全部字段, 未知的部分返回 nil
+func (h *Handler) infoSection(section string) [][2]string {
+	switch section {
+	case "clients":
+		connected := 0
+		h.activeClient.Range(func(_, _ any) bool {
+			connected++
+			return true
+		})
+		return [][2]string{
+			{"connected_clients", strconv.Itoa(connected)},
+		}
+	case "memory":
+		fields := [][2]string{
+			{"used_memory", strconv.FormatInt(database.UsedMemory(h.dbs), 10)},
+		}
+		if h.evictor != nil {
+			fields = append(fields,
+				[2]string{"maxmemory", strconv.FormatInt(h.evictor.MaxMemory(), 10)},
+				[2]string{"maxmemory_policy", h.evictor.Policy().String()},
+				[2]string{"evicted_keys", strconv.FormatInt(h.evictor.EvictedKeys(), 10)},
+			)
+		}
+		return fields
+	case "persistence":
+		fields := make([][2]string, 0)
+		if h.snapshotter != nil {
+			stats := h.snapshotter.Stats()
+			fields = append(fields,
+				[2]string{"rdb_changes_since_last_save", strconv.FormatInt(stats.ChangesSinceLastSave, 10)},
+				[2]string{"rdb_bgsave_in_progress", boolToInfo(stats.SaveInProgress)},
+				[2]string{"rdb_last_save_time", strconv.FormatInt(stats.LastSave.Unix(), 10)},
+				[2]string{"rdb_last_bgsave_status", errorToInfo(stats.LastSaveError)},
+			)
+		}
+
+		aof, enabled := h.aof.(*persistent.AofPersistent)
+		if !enabled {
+			return append(fields, [2]string{"aof_enabled", "0"})
+		}
+		stats := aof.Stats()
+		return append(fields, [][2]string{
+			{"aof_enabled", "1"},
+			{"aof_rewrite_in_progress", boolToInfo(stats.RewriteInProgress)},
+			{"aof_rewrites", strconv.FormatInt(stats.Rewrites, 10)},
+			{"aof_last_bgrewrite_status", errorToInfo(stats.LastRewriteError)},
+			{"aof_current_size", strconv.FormatInt(stats.CurrentSize, 10)},
+			{"aof_base_size", strconv.FormatInt(stats.BaseSize, 10)},
+			{"aof_base_file", stats.BaseFile},
+			{"aof_incr_files", strconv.Itoa(stats.IncrFiles)},
+			{"aof_fsync_policy", stats.FsyncPolicy.String()},
+			{"aof_fsync_count", strconv.FormatInt(stats.FsyncCount, 10)},
+			{"aof_last_fsync_latency_us", strconv.FormatInt(stats.LastFsyncLatency.Microseconds(), 10)},
+			{"aof_max_fsync_latency_us", strconv.FormatInt(stats.MaxFsyncLatency.Microseconds(), 10)},
+			{"aof_avg_fsync_latency_us", strconv.FormatInt(stats.AvgFsyncLatency.Microseconds(), 10)},
+			{"aof_delayed_fsync", strconv.FormatInt(stats.DelayedFsync, 10)},
+		}...)
+	case "stats":
+		return [][2]string{
+			{"total_connections_received", strconv.FormatInt(atomic.LoadInt64(&h.connectionsReceived), 10)},
+			{"total_commands_processed", strconv.FormatInt(atomic.LoadInt64(&h.commandsProcessed), 10)},
+		}
+	case "replication":
+		return h.replicationInfo()
+	case "commandstats":
+		return h.commandStats.info()
+	case "keyspace":
+		fields := make([][2]string, 0)
+		for i, db := range h.dbs {
+			if size := db.Size(); size > 0 {
+				fields = append(fields, [2]string{"db" + strconv.Itoa(i), "keys=" + strconv.Itoa(size)})
+			}
+		}
+		return fields
+	}
+	return nil
+}
+
+func boolToInfo(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func errorToInfo(err error) string {
+	if err != nil {
+		return "err"
+	}
+	return "ok"
+}
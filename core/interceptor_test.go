@@ -0,0 +1,201 @@
+package core
+
+import (
+	"simple_kvstorage/database"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandler_Use(t *testing.T) {
+	handler := NewHandler([]database.DB{database.NewConcurrentDB(0), database.NewConcurrentDB(1)}, nil)
+	client := newClient(nil)
+
+	var order []string
+	var calls []executor.Call
+	handler.Use(func(call *executor.Call, next func() reply.Reply) reply.Reply {
+		order = append(order, "outer")
+		calls = append(calls, *call)
+		r := next()
+		if time.Since(call.Start) < 0 {
+			t.Error("开始时间错误.")
+		}
+		return r
+	}, func(call *executor.Call, next func() reply.Reply) reply.Reply {
+		order = append(order, "inner")
+		// 拒绝执行写入 protected 的命令
+		if len(call.CmdLine) > 1 && string(call.CmdLine[1]) == "protected" {
+			return reply.NewStandardErrorReply("ERROR key is protected")
+		}
+		return next()
+	})
+
+	execString(handler, client, "select", "1")
+	if r := execString(handler, client, "set", "protected", "v"); r != "-ERROR key is protected" {
+		t.Error("拦截器应该拒绝执行命令.", r)
+	}
+	if _, ok := handler.dbs[1].Get("protected"); ok {
+		t.Error("被拒绝的命令不应该执行.")
+	}
+	if r := execString(handler, client, "SET", "a", "v"); r != "+OK" {
+		t.Error("SET 错误.", r)
+	}
+	if strings.Join(order, ",") != "outer,inner,outer,inner,outer,inner" {
+		t.Error("拦截器的顺序错误.", order)
+	}
+	if last := calls[len(calls)-1]; last.Client != client || last.DBIndex != 1 || last.Name != "set" || string(last.CmdLine[1]) != "a" {
+		t.Error("拦截器看到的命令错误.", last)
+	}
+}
+
+func TestChain(t *testing.T) {
+	var chain executor.Chain
+	call := &executor.Call{Name: "get"}
+	ok := func() reply.Reply { return reply.GetOkReply() }
+	if r := chain.Invoke(call, ok); r != reply.GetOkReply() || !call.Start.IsZero() {
+		t.Error("没有拦截器时直接执行命令.")
+	}
+
+	// 执行命令的同时可以加入拦截器
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	count := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			chain.Use(func(call *executor.Call, next func() reply.Reply) reply.Reply {
+				mutex.Lock()
+				count++
+				mutex.Unlock()
+				return next()
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			chain.Invoke(&executor.Call{}, ok)
+		}()
+	}
+	wg.Wait()
+	count = 0
+	if r := chain.Invoke(call, ok); r != reply.GetOkReply() || count != 10 || call.Start.IsZero() {
+		t.Error("全部拦截器都应该执行.", count)
+	}
+
+	// executor.Exec 中的拦截器没有客户端
+	seen := -1
+	executor.Use(func(call *executor.Call, next func() reply.Reply) reply.Reply {
+		if call.Name == "strlen" && call.Client == nil {
+			seen = call.DBIndex
+		}
+		return next()
+	})
+	if r := executor.Exec(database.NewConcurrentDB(3), [][]byte{[]byte("STRLEN"), []byte("a")}); reply.IsErrorReply(r) || seen != 3 {
+		t.Error("executor.Exec 应该经过拦截器.", seen)
+	}
+}
+
+func TestCommandStats(t *testing.T) {
+	handler := NewHandler([]database.DB{database.NewConcurrentDB(0)}, nil)
+	client := newClient(nil)
+
+	execString(handler, client, "set", "a", "1")
+	execString(handler, client, "set", "a")
+	execString(handler, client, "get", "a")
+	execString(handler, client, "restore", "b", "0", "invalid")
+	execString(handler, client, "nosuchcommand")
+
+	info := execString(handler, client, "info", "commandstats")
+	if !strings.Contains(info, "# Commandstats\r\n") {
+		t.Error("INFO commandstats 错误.", info)
+	}
+	stats := make(map[string]string)
+	for _, line := range strings.Split(info, "\r\n") {
+		if name, value, found := strings.Cut(line, ":"); found {
+			stats[name] = value
+		}
+	}
+	for name, want := range map[string][2]string{
+		"cmdstat_get":     {"calls=1,", "rejected_calls=0,failed_calls=0"},
+		"cmdstat_set":     {"calls=1,", "rejected_calls=1,failed_calls=0"},
+		"cmdstat_restore": {"calls=1,", "rejected_calls=0,failed_calls=1"},
+	} {
+		if !strings.HasPrefix(stats[name], want[0]) || !strings.HasSuffix(stats[name], want[1]) {
+			t.Errorf("%s 的统计错误: %s.", name, stats[name])
+		}
+	}
+	if strings.Contains(info, "nosuchcommand") {
+		t.Error("未知的命令不应该统计.", info)
+	}
+	if strings.Index(info, "cmdstat_get") > strings.Index(info, "cmdstat_set") {
+		t.Error("应该按命令名排序.", info)
+	}
+	if r := execString(handler, client, "info"); strings.Contains(r, "Commandstats") {
+		t.Error("默认不输出 commandstats.", r)
+	}
+	if r := execString(handler, client, "info", "everything"); !strings.Contains(r, "cmdstat_info:calls=") {
+		t.Error("everything 应该包括 commandstats.", r)
+	}
+}
+
+func TestSlowLog(t *testing.T) {
+	handler := NewHandler([]database.DB{database.NewConcurrentDB(0)}, nil)
+	client := newClient(nil)
+	client.SetName("conn-1")
+
+	execString(handler, client, "set", "fast", "v")
+	if r := execString(handler, client, "slowlog", "len"); r != ":0" {
+		t.Error("默认的阈值下不应该记录.", r)
+	}
+
+	handler.SetSlowLog(0, 3)
+	execString(handler, client, "set", "a", "1")
+	execString(handler, client, "get", "a")
+	execString(handler, client, "strlen", "a")
+	execString(handler, client, "type", "a")
+	if r := execString(handler, client, "slowlog", "len"); r != ":3" {
+		t.Error("最多保留 3 条记录.", r)
+	}
+
+	// 最新的记录在前. SLOWLOG LEN 本身也被记录.
+	r := execString(handler, client, "slowlog", "get", "2")
+	if !strings.HasPrefix(r, "*2\r\n*6\r\n:4\r\n") || !strings.Contains(r, "$7\r\nslowlog\r\n$3\r\nlen") ||
+		!strings.Contains(r, "*6\r\n:3\r\n") || !strings.Contains(r, "*2\r\n$4\r\ntype\r\n$1\r\na\r\n$0\r\n\r\n$6\r\nconn-1") {
+		t.Error("SLOWLOG GET 错误.", r)
+	}
+	if r := execString(handler, client, "slowlog", "get", "-1"); !strings.HasPrefix(r, "*3\r\n") {
+		t.Error("SLOWLOG GET -1 应该返回全部记录.", r)
+	}
+
+	handler.SetSlowLog(0, 10)
+	execString(handler, client, "slowlog", "reset")
+	args := []string{"del"}
+	for i := 0; i < 40; i++ {
+		args = append(args, "key"+strconv.Itoa(i))
+	}
+	execString(handler, client, args...)
+	execString(handler, client, "set", "long", strings.Repeat("x", 200))
+	r = execString(handler, client, "slowlog", "get")
+	if !strings.Contains(r, "*32\r\n$3\r\ndel\r\n") || !strings.Contains(r, "$23\r\n... (10 more arguments)\r\n") {
+		t.Error("过多的参数应该被截断.", r)
+	}
+	if !strings.Contains(r, strings.Repeat("x", 128)+"... (72 more bytes)\r\n") {
+		t.Error("过长的参数应该被截断.", r)
+	}
+
+	handler.SetSlowLog(-1, 10)
+	execString(handler, client, "slowlog", "reset")
+	execString(handler, client, "get", "long")
+	if r := execString(handler, client, "slowlog", "len"); r != ":0" {
+		t.Error("阈值为负数时不记录.", r)
+	}
+	for _, args := range [][]string{{"slowlog", "get", "-2"}, {"slowlog", "get", "x"}, {"slowlog", "nosuchsubcommand"}, {"slowlog"}} {
+		if r := execString(handler, client, args...); !strings.HasPrefix(r, "-") {
+			t.Error("应该返回错误.", args, r)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"simple_kvstorage/client"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/persistent"
+	"simple_kvstorage/replication"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// migrateDialTimeout MIGRATE 连接目标节点的超时时间
+	migrateDialTimeout = 5 * time.Second
+	// migrateDefaultTimeout MIGRATE 的 timeout 参数不是正数时使用的超时时间
+	migrateDefaultTimeout = time.Second
+	// migratePoolMaxIdle 与每个目标节点之间最多保留的空闲连接数量
+	migratePoolMaxIdle = 4
+)
+
+// migrateArgs MIGRATE 命令的参数
+type migrateArgs struct {
+	addr     string
+	keys     []string
+	destDB   int
+	timeout  time.Duration
+	copy     bool
+	replace  bool
+	username string
+	password string
+}
+
+// parseMigrateArgs 解析 MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key [key ...]]
+func parseMigrateArgs(cmdLine executor.CmdLine) (*migrateArgs, reply.Reply) {
+	if len(cmdLine) < 6 {
+		return nil, reply.NewArgNumberErrorReply("migrate")
+	}
+
+	port, err := strconv.ParseUint(string(cmdLine[2]), 10, 16)
+	if err != nil {
+		return nil, reply.NewStandardErrorReply("ERROR invalid port")
+	}
+	destDB, err := strconv.Atoi(string(cmdLine[4]))
+	if err != nil || destDB < 0 {
+		return nil, reply.NewStandardErrorReply("ERROR invalid DB index")
+	}
+	timeout, err := strconv.ParseInt(string(cmdLine[5]), 10, 64)
+	if err != nil {
+		return nil, reply.NewStandardErrorReply("ERROR value is not an integer or out of range")
+	}
+	args := &migrateArgs{
+		addr:    net.JoinHostPort(string(cmdLine[1]), strconv.FormatUint(port, 10)),
+		destDB:  destDB,
+		timeout: time.Duration(timeout) * time.Millisecond,
+	}
+	if args.timeout <= 0 {
+		args.timeout = migrateDefaultTimeout
+	}
+
+	for i := 6; i < len(cmdLine); i++ {
+		switch strings.ToLower(string(cmdLine[i])) {
+		case "copy":
+			args.copy = true
+		case "replace":
+			args.replace = true
+		case "auth":
+			if i+1 >= len(cmdLine) {
+				return nil, reply.GetSyntaxErrReply()
+			}
+			args.password = string(cmdLine[i+1])
+			i++
+		case "auth2":
+			if i+2 >= len(cmdLine) {
+				return nil, reply.GetSyntaxErrReply()
+			}
+			args.username, args.password = string(cmdLine[i+1]), string(cmdLine[i+2])
+			i += 2
+		case "keys":
+			if len(cmdLine[3]) != 0 {
+				return nil, reply.NewStandardErrorReply("ERROR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			for _, key := range cmdLine[i+1:] {
+				args.keys = append(args.keys, string(key))
+			}
+			i = len(cmdLine)
+		default:
+			return nil, reply.GetSyntaxErrReply()
+		}
+	}
+	if len(cmdLine[3]) != 0 {
+		args.keys = []string{string(cmdLine[3])}
+	}
+	return args, nil
+}
+
+// execMigrate MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key [key ...]]
+// 将 key 序列化后以 RESTORE 命令发送给目标节点, 目标节点确认之后才从本节点删除 (COPY 时不删除).
+// 迁移期间暂停其他命令, 其他客户端不会看到 key 同时存在于或同时不存在于两个节点上.
+// 参考: https://redis.io/commands/migrate
+func (h *Handler) execMigrate(client *Client, cmdLine executor.CmdLine) reply.Reply {
+	args, errReply := parseMigrateArgs(cmdLine)
+	if errReply != nil {
+		return errReply
+	}
+	// Raft 模式下的修改必须经过 Raft 日志
+	if h.raftNode != nil {
+		return reply.NewStandardErrorReply("ERROR migrate is not supported in raft mode")
+	}
+	if h.replication != nil && h.replication.IsReplica() {
+		return reply.NewStandardErrorReply(replication.ErrReadOnly.Error())
+	}
+
+	h.worldLock.Lock()
+	defer h.worldLock.Unlock()
+
+	// 1. 序列化存在的 key
+	dbIndex := client.GetDBIndex()
+	var keys []string
+	var payloads [][]byte
+	for _, key := range args.keys {
+		entity, exists := h.dbs[dbIndex].Get(key)
+		if !exists {
+			continue
+		}
+		payload, err := persistent.DumpValue(entity)
+		if err != nil {
+			return reply.NewStandardErrorReply("ERROR " + err.Error())
+		}
+		keys = append(keys, key)
+		payloads = append(payloads, payload)
+	}
+	if len(keys) == 0 {
+		return reply.NewStatusReply("NOKEY")
+	}
+
+	// 2. 发送给目标节点, 记录目标节点确认的 key
+	acked, err := h.sendRestores(args, keys, payloads)
+
+	// 3. 从本节点删除目标节点已经确认的 key
+	if !args.copy && len(acked) > 0 {
+		h.dbs[dbIndex].Removes(acked...)
+		delLine := make([][]byte, 0, len(acked)+1)
+		delLine = append(delLine, []byte("del"))
+		for _, key := range acked {
+			delLine = append(delLine, []byte(key))
+		}
+		if h.aof != nil {
+			if aofErr := h.aof.Persistence(dbIndex, delLine); aofErr != nil && err == nil {
+				err = aofErrorReply(aofErr)
+			}
+		}
+		if h.replication != nil {
+			h.replication.Propagate(dbIndex, delLine)
+		}
+		if h.snapshotter != nil {
+			h.snapshotter.AddDirty(int64(len(acked)))
+		}
+	}
+
+	if err != nil {
+		return reply.NewStandardErrorReply(err.Error())
+	}
+	return reply.GetOkReply()
+}
+
+// errMigrateIO 与目标节点之间的连接出错或超时
+var errMigrateIO = errors.New("IOERR error or timeout")
+
+// sendRestores 向目标节点发送每个 key 的 RESTORE, 返回目标节点确认的 key.
+// 全部命令在一次往返中发送, timeout 是这次往返的超时时间.
+// 目标节点对某个 key 回复错误时其余的 key 不受影响, 返回第一个错误.
+// 认证或选择数据库失败时连接建立失败, 每个 key 都得到这个错误.
+func (h *Handler) sendRestores(args *migrateArgs, keys []string, payloads [][]byte) ([]string, error) {
+	pipeline := h.migrateClient(args).Pipeline()
+	restores := make([]*client.Cmd, len(keys))
+	for i, key := range keys {
+		restore := []any{"restore", key, 0, payloads[i]}
+		if args.replace {
+			restore = append(restore, "replace")
+		}
+		restores[i] = pipeline.Do(restore...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), args.timeout)
+	defer cancel()
+	if _, err := pipeline.Exec(ctx); err != nil {
+		if _, isServerError := err.(client.Error); !isServerError {
+			return nil, errors.New(errMigrateIO.Error() + " reading from target instance: " + err.Error())
+		}
+	}
+
+	var acked []string
+	var firstErr error
+	for i, restore := range restores {
+		if err := restore.Err(); err != nil {
+			if firstErr == nil {
+				firstErr = targetError(err)
+			}
+			continue
+		}
+		acked = append(acked, keys[i])
+	}
+	return acked, firstErr
+}
+
+// targetError 目标节点回复的错误
+func targetError(err error) error {
+	return errors.New("ERROR Target instance replied with error: " + err.Error())
+}
+
+// migrateClient 返回与目标节点的数据库之间的客户端, 使用不同的数据库, 用户名与密码时是不同的客户端.
+// 新的连接先认证再选择数据库, 失败时不会发送任何命令. 服务器没有 AUTH 命令, 通过 HELLO 2 AUTH 认证, 只有密码时使用默认用户.
+// 迁移的命令不重试, 以免重复执行 RESTORE.
+func (h *Handler) migrateClient(args *migrateArgs) *client.Client {
+	h.migrateMutex.Lock()
+	defer h.migrateMutex.Unlock()
+
+	id := args.addr + "\x00" + strconv.Itoa(args.destDB) + "\x00" + args.username + "\x00" + args.password
+	if h.migrateClients == nil {
+		h.migrateClients = make(map[string]*client.Client)
+	}
+	if c, ok := h.migrateClients[id]; ok {
+		return c
+	}
+	opts := client.Options{
+		Addr:        args.addr,
+		DialTimeout: migrateDialTimeout,
+		MaxIdle:     migratePoolMaxIdle,
+		MaxRetries:  -1,
+	}
+	if args.password != "" {
+		username := args.username
+		if username == "" {
+			username = "default"
+		}
+		opts.InitCommands = append(opts.InitCommands, []any{"hello", 2, "auth", username, args.password})
+	}
+	opts.InitCommands = append(opts.InitCommands, []any{"select", args.destDB})
+	c := client.New(opts)
+	h.migrateClients[id] = c
+	return c
+}
+
+// closeMigrateClients 关闭与全部目标节点之间的连接
+func (h *Handler) closeMigrateClients() {
+	h.migrateMutex.Lock()
+	defer h.migrateMutex.Unlock()
+	for id, c := range h.migrateClients {
+		_ = c.Close()
+		delete(h.migrateClients, id)
+	}
+}
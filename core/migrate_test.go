@@ -0,0 +1,104 @@
+package core
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	source, target := newTestServer(t), newTestServer(t)
+	sourceConn := dialTestServer(t, source)
+	host, port, _ := net.SplitHostPort(target.addr())
+
+	sourceConn.do(t, "set", "a", "1")
+	sourceConn.do(t, "set", "b", "2")
+	sourceConn.do(t, "set", "c", "3")
+	sourceConn.do(t, "set", "d", "4")
+
+	if r := sourceConn.do(t, "migrate", host, port, "a", "1", "1000"); r != "+OK" {
+		t.Fatal("迁移失败.", r)
+	}
+	if getValue(source, 0, "a") != "" || getValue(target, 1, "a") != "1" {
+		t.Error("迁移之后 key 应该只存在于目标节点.")
+	}
+	if r := sourceConn.do(t, "migrate", host, port, "missing", "1", "1000"); r != "+NOKEY" {
+		t.Error("key 不存在时应该回复 NOKEY.", r)
+	}
+
+	// COPY 不删除本节点的 key, 目标节点已经存在时需要 REPLACE
+	if r := sourceConn.do(t, "migrate", host, port, "b", "1", "1000", "copy"); r != "+OK" {
+		t.Fatal("复制失败.", r)
+	}
+	if getValue(source, 0, "b") != "2" || getValue(target, 1, "b") != "2" {
+		t.Error("COPY 之后 key 应该同时存在于两个节点.")
+	}
+	sourceConn.do(t, "set", "b", "22")
+	if r := sourceConn.do(t, "migrate", host, port, "b", "1", "1000"); !strings.Contains(r, "BUSYKEY") {
+		t.Fatal("目标节点已经存在 key 时应该失败.", r)
+	}
+	if getValue(source, 0, "b") != "22" {
+		t.Error("目标节点没有确认时不能删除本节点的 key.")
+	}
+	if r := sourceConn.do(t, "migrate", host, port, "b", "1", "1000", "replace"); r != "+OK" || getValue(target, 1, "b") != "22" {
+		t.Error("REPLACE 迁移失败.", r)
+	}
+
+	// KEYS 选项迁移多个 key, key 参数必须是空字符串
+	cmdLine := [][]byte{[]byte("migrate"), []byte(host), []byte(port), {}, []byte("0"), []byte("1000"),
+		[]byte("keys"), []byte("c"), []byte("d"), []byte("missing")}
+	if r := source.handler.Exec(newClient(nil), cmdLine); string(r.ToBytes()) != "+OK\r\n" {
+		t.Fatal("迁移多个 key 失败.", string(r.ToBytes()))
+	}
+	if source.handler.dbs[0].Size() != 0 || getValue(target, 0, "c") != "3" || getValue(target, 0, "d") != "4" {
+		t.Error("迁移多个 key 的结果错误.")
+	}
+
+	// 目标节点没有这个数据库时不发送 RESTORE
+	sourceConn.do(t, "set", "e", "5")
+	if r := sourceConn.do(t, "migrate", host, port, "e", "99", "1000"); !strings.Contains(r, "Target instance replied with error") {
+		t.Error("选择数据库失败时应该回复目标节点的错误.", r)
+	}
+	if getValue(source, 0, "e") != "5" || getValue(target, 0, "e") != "" {
+		t.Error("选择数据库失败时不能迁移 key.")
+	}
+
+	// 目标节点不可达
+	_ = target.listener.Close()
+	target.dropConnections()
+	if r := sourceConn.do(t, "migrate", host, port, "e", "0", "100"); !strings.HasPrefix(r, "-IOERR") {
+		t.Error("目标节点不可达时应该回复 IOERR.", r)
+	}
+	if getValue(source, 0, "e") != "5" {
+		t.Error("迁移失败时不能删除本节点的 key.")
+	}
+}
+
+func TestMigrate_Auth(t *testing.T) {
+	source, target := newTestServer(t), newTestServer(t)
+	target.handler.SetRequirePass("secret")
+	sourceConn := dialTestServer(t, source)
+	host, port, _ := net.SplitHostPort(target.addr())
+
+	sourceConn.do(t, "set", "a", "1")
+	sourceConn.do(t, "set", "b", "2")
+
+	if r := sourceConn.do(t, "migrate", host, port, "a", "0", "1000"); !strings.Contains(r, "NOAUTH") {
+		t.Fatal("没有认证时应该失败.", r)
+	}
+	if r := sourceConn.do(t, "migrate", host, port, "a", "0", "1000", "auth", "wrong"); !strings.Contains(r, "WRONGPASS") {
+		t.Fatal("密码错误时应该失败.", r)
+	}
+	if getValue(source, 0, "a") != "1" || getValue(target, 0, "a") != "" {
+		t.Error("认证失败时不能迁移 key.")
+	}
+	if r := sourceConn.do(t, "migrate", host, port, "a", "0", "1000", "auth", "secret"); r != "+OK" {
+		t.Fatal("AUTH 迁移失败.", r)
+	}
+	if r := sourceConn.do(t, "migrate", host, port, "b", "0", "1000", "auth2", "default", "secret"); r != "+OK" {
+		t.Fatal("AUTH2 迁移失败.", r)
+	}
+	if getValue(source, 0, "a") != "" || getValue(target, 0, "a") != "1" || getValue(target, 0, "b") != "2" {
+		t.Error("认证之后 key 应该迁移到目标节点.")
+	}
+}
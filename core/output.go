@@ -0,0 +1,156 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"simple_kvstorage/config"
+	"simple_kvstorage/util/logger"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// OutputBufferLimit 客户端输出缓冲区的限制, 对应配置 client-output-buffer-limit, 值为 0 的字段表示不限制.
+// 尚未发送给客户端的回复超过 Hard, 或持续 SoftSeconds 秒超过 Soft 时, 服务器断开这个客户端,
+// 防止读取很慢的客户端 (如 KEYS 或大的 LRANGE 的结果) 一直占用内存和协程.
+type OutputBufferLimit struct {
+	Hard        int64
+	Soft        int64
+	SoftSeconds int
+}
+
+// errOutputBufferLimit 输出缓冲区超过了限制, 客户端需要被断开
+var errOutputBufferLimit = errors.New("output buffer limit exceeded")
+
+// errClientClosed 客户端已经关闭, 不能再发送数据
+var errClientClosed = errors.New("client is closed")
+
+// ParseOutputBufferLimit 解析形如 "256mb 64mb 60" 的限制, 依次是硬限制, 软限制与软限制的秒数
+func ParseOutputBufferLimit(value string) (OutputBufferLimit, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return OutputBufferLimit{}, errors.New("invalid client-output-buffer-limit '" + value + "'")
+	}
+	hard, err1 := config.ParseMemory(fields[0])
+	soft, err2 := config.ParseMemory(fields[1])
+	seconds, err3 := strconv.Atoi(fields[2])
+	if err1 != nil || err2 != nil || err3 != nil || hard < 0 || soft < 0 || seconds < 0 {
+		return OutputBufferLimit{}, errors.New("invalid client-output-buffer-limit '" + value + "'")
+	}
+	return OutputBufferLimit{Hard: hard, Soft: soft, SoftSeconds: seconds}, nil
+}
+
+// checkOutputLimitLocked 检查追加 n 个字节的回复之后是否超过了输出缓冲区的限制. 调用者需要持有 locker.
+// 超过硬限制, 或持续超过软限制已经 SoftSeconds 秒时返回 errOutputBufferLimit, 剩余的回复不再发送.
+// 刚开始超过软限制时记录时间, 之后写协程的每次写入都以 SoftSeconds 秒之后为截止时间.
+func (c *Client) checkOutputLimitLocked(n int) error {
+	// 从节点的连接只用于发送同步数据和命令流, 不受普通客户端的限制
+	if c.outputLimit == nil || c.isReplica {
+		return nil
+	}
+	pending := int64(len(c.output)+c.writing) + int64(n)
+	if c.outputLimit.Hard > 0 && pending > c.outputLimit.Hard {
+		c.writeErr = c.outputLimitExceeded()
+		return c.writeErr
+	}
+	if c.outputLimit.Soft > 0 && pending > c.outputLimit.Soft {
+		if c.overSoftSince.IsZero() {
+			c.overSoftSince = time.Now()
+			// 写协程可能正阻塞在没有截止时间的写操作上
+			c.setWriteDeadline(c.softDeadlineLocked())
+		} else if time.Now().After(c.softDeadlineLocked()) {
+			c.writeErr = c.outputLimitExceeded()
+			return c.writeErr
+		}
+	}
+	return nil
+}
+
+// waitOutputLocked 没有配置硬限制时, 尚未写入的回复过多则等待写协程, 避免读取很慢的客户端占用过多内存.
+// 等待的上限不小于软限制, 使软限制仍然可以被触发. 调用者需要持有 locker.
+func (c *Client) waitOutputLocked() error {
+	if c.outputLimit != nil && c.outputLimit.Hard > 0 && !c.isReplica {
+		return nil
+	}
+	limit := outputFlushThreshold
+	if c.outputLimit != nil && c.outputLimit.Soft > int64(limit) {
+		limit = int(c.outputLimit.Soft)
+	}
+	for len(c.output)+c.writing > limit && c.writeErr == nil {
+		c.outputCond.Wait()
+	}
+	return c.writeErr
+}
+
+// softDeadlineLocked 持续超过软限制时写操作的截止时间, 没有超过时返回零值. 调用者需要持有 locker.
+func (c *Client) softDeadlineLocked() time.Time {
+	if c.overSoftSince.IsZero() {
+		return time.Time{}
+	}
+	return c.overSoftSince.Add(time.Duration(c.outputLimit.SoftSeconds) * time.Second)
+}
+
+// setWriteDeadline 设置连接的写截止时间, 零值表示没有截止时间. 不支持截止时间的连接什么也不做.
+func (c *Client) setWriteDeadline(deadline time.Time) {
+	if deadliner, ok := c.connection.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		_ = deadliner.SetWriteDeadline(deadline)
+	}
+}
+
+// writeLoop 写协程, 不断将输出缓冲区中的回复写入连接, 直到连接关闭或写入失败.
+// 写连接时不持有 locker, 因此读取很慢的客户端不会阻塞命令的执行; 持续超过软限制时每次写入都有截止时间,
+// 超过截止时间仍未写完则关闭连接, 使阻塞在读取命令上的协程也随之退出.
+func (c *Client) writeLoop() {
+	defer c.waitingReply.Done()
+	var data []byte
+
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	for {
+		for len(c.output) == 0 && !c.closing && c.writeErr == nil {
+			c.outputCond.Wait()
+		}
+		if c.writeErr != nil {
+			return
+		}
+		if len(c.output) == 0 {
+			// 连接即将关闭, 之后的写操作都失败
+			c.writeErr = errClientClosed
+			c.outputCond.Broadcast()
+			return
+		}
+
+		// 交换缓冲区, 写连接时不持有锁
+		data, c.output = c.output, data[:0]
+		c.writing = len(data)
+		c.setWriteDeadline(c.softDeadlineLocked())
+		c.locker.Unlock()
+		n, err := c.connection.Write(data)
+		c.locker.Lock()
+
+		c.writing = 0
+		c.written += int64(n)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				err = c.outputLimitExceeded()
+			}
+			c.writeErr = err
+			_ = c.connection.Close()
+		}
+		if !c.overSoftSince.IsZero() && int64(len(c.output)) <= c.outputLimit.Soft {
+			c.overSoftSince = time.Time{}
+		}
+		if cap(data) > outputRetainLimit {
+			data = nil
+		}
+		atomic.StoreInt64(&c.outputPending, int64(len(c.output)))
+		c.outputCond.Broadcast()
+	}
+}
+
+// outputLimitExceeded 记录超过限制的客户端, 返回 errOutputBufferLimit
+func (c *Client) outputLimitExceeded() error {
+	logger.Warn("客户端 id=" + strconv.FormatInt(c.id, 10) + " 的输出缓冲区超过了限制, 断开连接.")
+	return errOutputBufferLimit
+}
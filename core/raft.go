@@ -0,0 +1,208 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/gob"
+	"errors"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/persistent"
+	"simple_kvstorage/raft"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// raftTimeout 等待写命令提交或读屏障完成的最长时间
+const raftTimeout = 5 * time.Second
+
+// raftCommand 写入 Raft 日志的命令
+type raftCommand struct {
+	DB      int
+	CmdLine [][]byte
+}
+
+// raftStateMachine 将已经提交的命令应用到数据库, 用快照文件的格式生成和恢复 Raft 快照
+type raftStateMachine struct {
+	handler *Handler
+}
+
+// RaftStateMachine 返回由此 Handler 的数据库构成的状态机, 用于创建 raft.Node
+func (h *Handler) RaftStateMachine() raft.StateMachine {
+	return &raftStateMachine{handler: h}
+}
+
+// SetRaft 开启 Raft 模式: 写命令经 node 提交之后才应用到数据库, 读命令只在领导者上执行.
+// Raft 模式下数据由 Raft 日志和快照持久化, 不使用 AOF, 主从复制和集群模式.
+// secret 是节点之间共享的密钥, 连接以 RAFT AUTH <secret> 认证之后才能发送 RAFT RPC, 空字符串表示拒绝全部 RPC.
+func (h *Handler) SetRaft(node *raft.Node, secret string) {
+	h.raftNode = node
+	h.raftSecret = secret
+}
+
+// isRaftPeerCommand 是否是 RAFT AUTH, 或者其他 Raft 节点发送的 RAFT RPC
+func isRaftPeerCommand(client *Client, cmdLine executor.CmdLine) bool {
+	if len(cmdLine) < 2 || !strings.EqualFold(string(cmdLine[0]), "raft") {
+		return false
+	}
+	subCommand := strings.ToLower(string(cmdLine[1]))
+	return subCommand == "auth" || subCommand == "rpc" && client.raftPeer
+}
+
+func (m *raftStateMachine) Apply(command []byte) any {
+	cmd := &raftCommand{}
+	if err := gob.NewDecoder(bytes.NewReader(command)).Decode(cmd); err != nil {
+		return reply.NewStandardErrorReply("ERROR invalid raft command")
+	}
+	if cmd.DB < 0 || cmd.DB >= len(m.handler.dbs) || len(cmd.CmdLine) == 0 {
+		return reply.NewStandardErrorReply("ERROR invalid raft command")
+	}
+
+	m.handler.worldLock.RLock()
+	defer m.handler.worldLock.RUnlock()
+	theReply := executor.Exec(m.handler.dbs[cmd.DB], cmd.CmdLine)
+	if !reply.IsErrorReply(theReply) && m.handler.snapshotter != nil {
+		m.handler.snapshotter.AddDirty(1)
+	}
+	return theReply
+}
+
+func (m *raftStateMachine) Snapshot() ([]byte, error) {
+	m.handler.worldLock.RLock()
+	defer m.handler.worldLock.RUnlock()
+
+	var buffer bytes.Buffer
+	if err := persistent.WriteRdb(&buffer, m.handler.dbs); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (m *raftStateMachine) Restore(snapshot []byte) error {
+	m.handler.worldLock.Lock()
+	defer m.handler.worldLock.Unlock()
+
+	for _, db := range m.handler.dbs {
+		db.Flush()
+	}
+	_, err := persistent.ReadRdb(bytes.NewReader(snapshot), m.handler.dbs)
+	return err
+}
+
+// execRaftWrite 将写命令提交到 Raft 日志, 提交并应用之后返回执行的结果
+func (h *Handler) execRaftWrite(client *Client, cmdLine executor.CmdLine) reply.Reply {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(&raftCommand{DB: client.GetDBIndex(), CmdLine: cmdLine}); err != nil {
+		return reply.NewStandardErrorReply("ERROR " + err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), raftTimeout)
+	defer cancel()
+	result, err := h.raftNode.Propose(ctx, buffer.Bytes())
+	if err != nil {
+		return raftErrorReply(err)
+	}
+	theReply, _ := result.(reply.Reply)
+	return theReply
+}
+
+// execRaftRead 确认本节点是领导者且已经应用了全部已提交的命令之后, 在本地执行读命令
+func (h *Handler) execRaftRead(client *Client, cmdLine executor.CmdLine) reply.Reply {
+	ctx, cancel := context.WithTimeout(context.Background(), raftTimeout)
+	defer cancel()
+	if err := h.raftNode.ReadBarrier(ctx); err != nil {
+		return raftErrorReply(err)
+	}
+
+	h.worldLock.RLock()
+	defer h.worldLock.RUnlock()
+	return executor.Exec(h.dbs[client.GetDBIndex()], cmdLine)
+}
+
+// raftErrorReply 非领导者回复 NOTLEADER <leader>, 客户端应该重新连接到领导者
+func raftErrorReply(err error) reply.Reply {
+	var notLeader *raft.NotLeaderError
+	if errors.As(err, &notLeader) {
+		if notLeader.Leader == "" {
+			return reply.NewStandardErrorReply("CLUSTERDOWN No leader elected")
+		}
+		return reply.NewStandardErrorReply("NOTLEADER " + notLeader.Leader)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return reply.NewStandardErrorReply("TIMEOUT raft command was not committed in time")
+	}
+	return reply.NewStandardErrorReply("ERROR " + err.Error())
+}
+
+// execRaft RAFT STATUS | RAFT ADDNODE id | RAFT REMOVENODE id | RAFT AUTH secret | RAFT RPC kind payload
+func (h *Handler) execRaft(client *Client, cmdLine executor.CmdLine) reply.Reply {
+	if len(cmdLine) < 2 {
+		return reply.NewArgNumberErrorReply("raft")
+	}
+	if h.raftNode == nil {
+		return reply.NewStandardErrorReply("ERROR raft mode is disabled")
+	}
+
+	subCommand := strings.ToLower(string(cmdLine[1]))
+	switch subCommand {
+	case "status":
+		if len(cmdLine) != 2 {
+			return reply.NewArgNumberErrorReply("raft|status")
+		}
+		status := h.raftNode.Status()
+		var builder strings.Builder
+		builder.WriteString("id:" + status.ID + "\r\n")
+		builder.WriteString("role:" + status.Role.String() + "\r\n")
+		builder.WriteString("term:" + strconv.FormatUint(status.Term, 10) + "\r\n")
+		builder.WriteString("leader:" + status.Leader + "\r\n")
+		builder.WriteString("members:" + strings.Join(status.Members, ",") + "\r\n")
+		builder.WriteString("last_index:" + strconv.FormatUint(status.LastIndex, 10) + "\r\n")
+		builder.WriteString("commit_index:" + strconv.FormatUint(status.CommitIndex, 10) + "\r\n")
+		builder.WriteString("last_applied:" + strconv.FormatUint(status.LastApplied, 10) + "\r\n")
+		builder.WriteString("snapshot_index:" + strconv.FormatUint(status.SnapshotIndex, 10) + "\r\n")
+		return reply.NewVerbatimReply("txt", []byte(builder.String()))
+	case "addnode", "removenode":
+		if len(cmdLine) != 3 {
+			return reply.NewArgNumberErrorReply("raft|" + subCommand)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), raftTimeout)
+		defer cancel()
+		var err error
+		if subCommand == "addnode" {
+			err = h.raftNode.AddMember(ctx, string(cmdLine[2]))
+		} else {
+			err = h.raftNode.RemoveMember(ctx, string(cmdLine[2]))
+		}
+		if err != nil {
+			return raftErrorReply(err)
+		}
+		return reply.GetOkReply()
+	case "auth":
+		if len(cmdLine) != 3 {
+			return reply.NewArgNumberErrorReply("raft|auth")
+		}
+		// 与 AUTH 一样比较摘要, 耗时与密钥的内容和长度无关
+		given, expected := sha256.Sum256(cmdLine[2]), sha256.Sum256([]byte(h.raftSecret))
+		if h.raftSecret == "" || subtle.ConstantTimeCompare(given[:], expected[:]) != 1 {
+			return reply.NewStandardErrorReply("WRONGPASS invalid raft secret")
+		}
+		client.raftPeer = true
+		return reply.GetOkReply()
+	case "rpc":
+		if len(cmdLine) != 4 {
+			return reply.NewArgNumberErrorReply("raft|rpc")
+		}
+		if !client.raftPeer {
+			return reply.NewStandardErrorReply("NOPERM raft rpc is only accepted from peers authenticated with RAFT AUTH")
+		}
+		result, err := h.raftNode.HandleRPC(string(cmdLine[2]), cmdLine[3])
+		if err != nil {
+			return reply.NewStandardErrorReply("ERROR " + err.Error())
+		}
+		return reply.NewBulkReply(result)
+	}
+	return reply.NewStandardErrorReply("ERROR unknown raft subcommand '" + subCommand + "'")
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"simple_kvstorage/database"
+	"simple_kvstorage/raft"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRaftHandlers 创建通过进程内网络组成 Raft 集群的多个 Handler
+func newTestRaftHandlers(t *testing.T, ids ...string) map[string]*Handler {
+	network := raft.NewMemoryNetwork()
+	handlers := make(map[string]*Handler)
+	for _, id := range ids {
+		dbs := make([]database.DB, 2)
+		for i := range dbs {
+			dbs[i] = database.NewConcurrentDB(i)
+		}
+		handler := NewHandler(dbs, nil)
+
+		var peers []string
+		for _, peer := range ids {
+			if peer != id {
+				peers = append(peers, peer)
+			}
+		}
+		node, err := raft.NewNode(&raft.Config{
+			ID:                id,
+			Peers:             peers,
+			ElectionTimeout:   100 * time.Millisecond,
+			HeartbeatInterval: 10 * time.Millisecond,
+			SnapshotThreshold: 8,
+			Transport:         network.Transport(id),
+			Storage:           raft.NewMemoryStorage(),
+			StateMachine:      handler.RaftStateMachine(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		network.Register(node)
+		handler.SetRaft(node, "secret")
+		handlers[id] = handler
+		t.Cleanup(func() { _ = handler.Close() })
+	}
+	return handlers
+}
+
+func execString(handler *Handler, client *Client, args ...string) string {
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	return strings.TrimSpace(string(handler.Exec(client, cmdLine).ToBytes()))
+}
+
+func TestRaftMode(t *testing.T) {
+	handlers := newTestRaftHandlers(t, "n1", "n2", "n3")
+
+	// 1. 等待选出领导者
+	var leader string
+	waitFor(t, "没有选出领导者.", func() bool {
+		for id, handler := range handlers {
+			if status := handler.raftNode.Status(); status.Role == raft.Leader && status.Leader == id {
+				leader = id
+				return true
+			}
+		}
+		return false
+	})
+
+	// 2. 写命令提交之后应用到全部节点, 包括 SELECT 之后的数据库
+	client := newClient(nil)
+	if r := execString(handlers[leader], client, "set", "a", "1"); r != "+OK" {
+		t.Fatal("写命令执行失败.", r)
+	}
+	client.SelectDB(1)
+	for i := 0; i < 20; i++ {
+		execString(handlers[leader], client, "set", "b", "2")
+	}
+	for _, handler := range handlers {
+		handler := handler
+		waitFor(t, "写命令没有应用到全部节点.", func() bool {
+			a, _ := handler.dbs[0].Get("a")
+			b, _ := handler.dbs[1].Get("b")
+			return a != nil && string(a.Data.([]byte)) == "1" && b != nil && string(b.Data.([]byte)) == "2"
+		})
+	}
+	if r := execString(handlers[leader], client, "get", "b"); r != "$1\r\n2" {
+		t.Error("领导者上的读命令结果错误.", r)
+	}
+
+	// 3. 跟随者拒绝读写命令, 并告知领导者的地址
+	for id, handler := range handlers {
+		if id == leader {
+			continue
+		}
+		if r := execString(handler, newClient(nil), "set", "c", "3"); r != "-NOTLEADER "+leader {
+			t.Error("跟随者应该拒绝写命令.", r)
+		}
+		if r := execString(handler, newClient(nil), "get", "a"); r != "-NOTLEADER "+leader {
+			t.Error("跟随者应该拒绝读命令.", r)
+		}
+		if _, ok := handler.dbs[0].Get("c"); ok {
+			t.Error("被拒绝的写命令不应该被执行.")
+		}
+	}
+
+	// 4. 日志已经被快照压缩, 与主从复制相关的命令被拒绝
+	if r := execString(handlers[leader], client, "raft", "status"); !strings.Contains(r, "role:leader") ||
+		strings.Contains(r, "snapshot_index:0\r\n") {
+		t.Error("RAFT STATUS 的结果错误.", r)
+	}
+	if r := execString(handlers[leader], client, "migrate", "127.0.0.1", "1", "a", "0", "1000"); !strings.HasPrefix(r, "-ERROR") {
+		t.Error("Raft 模式下应该拒绝 MIGRATE.", r)
+	}
+}
+
+func TestRaft_RPCAuth(t *testing.T) {
+	handler := newTestRaftHandlers(t, "a")["a"]
+	handler.SetRequirePass("password")
+	client := newClient(nil)
+
+	// 普通客户端即使通过了 AUTH 也不能发送 RAFT RPC
+	if r := execString(handler, client, "raft", "rpc", "requestvote", ""); !strings.HasPrefix(r, "-NOAUTH") {
+		t.Error("认证之前应该回复 NOAUTH.", r)
+	}
+	if r := execString(handler, client, "auth", "password"); r != "+OK" {
+		t.Fatal("AUTH 失败.", r)
+	}
+	if r := execString(handler, client, "raft", "rpc", "requestvote", ""); !strings.HasPrefix(r, "-NOPERM") {
+		t.Error("没有以 RAFT AUTH 认证的连接应该回复 NOPERM.", r)
+	}
+	if r := execString(handler, client, "raft", "auth", "wrong"); !strings.HasPrefix(r, "-WRONGPASS") {
+		t.Error("密钥错误时应该回复 WRONGPASS.", r)
+	}
+
+	// 其他节点不需要 requirepass, 以共享密钥认证之后只能发送 RAFT RPC
+	peer := newClient(nil)
+	if r := execString(handler, peer, "raft", "auth", "secret"); r != "+OK" {
+		t.Fatal("RAFT AUTH 失败.", r)
+	}
+	if r := execString(handler, peer, "raft", "rpc", "nosuchrpc", ""); !strings.HasPrefix(r, "-ERROR unknown raft rpc") {
+		t.Error("RAFT AUTH 之后应该处理 RPC.", r)
+	}
+	if r := execString(handler, peer, "get", "a"); !strings.HasPrefix(r, "-NOAUTH") {
+		t.Error("RAFT AUTH 不能代替 AUTH.", r)
+	}
+
+	// 通过网络传输: 共享密钥错误时 RPC 失败
+	s := newTestServer(t)
+	s.handler.SetRaft(handler.raftNode, "secret")
+	for secret, ok := range map[string]bool{"secret": true, "wrong": false} {
+		transport := raft.NewNetworkTransport(time.Second, secret)
+		_, err := transport.RequestVote(s.addr(), &raft.RequestVoteArgs{Term: 1, Candidate: "b"})
+		if (err == nil) != ok {
+			t.Error("RAFT RPC 的结果错误.", secret, err)
+		}
+		transport.Close()
+	}
+}
@@ -0,0 +1,180 @@
+package core
+
+import (
+	"net"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/persistent"
+	"simple_kvstorage/replication"
+	"simple_kvstorage/resp/reply"
+	"simple_kvstorage/util/logger"
+	"strconv"
+	"strings"
+)
+
+// SetReplication 设置主从复制管理器. 从节点执行主节点传播的命令之后, 同样会写入持久化文件.
+func (h *Handler) SetReplication(replication *replication.Manager) {
+	h.replication = replication
+	replication.SetWorldLock(&h.worldLock)
+	replication.SetApplyLock(h.worldLock.RLocker())
+	replication.OnApply(func(dbIndex int, cmdLine executor.CmdLine) {
+		if h.aof != nil {
+			if err := h.aof.Persistence(dbIndex, cmdLine); err != nil {
+				logger.Warn("主节点传播的命令写入 AOF 失败.", err)
+			}
+		}
+		if h.snapshotter != nil {
+			h.snapshotter.AddDirty(1)
+		}
+	})
+	replication.OnFullSync(func() {
+		// 数据已经被主节点的快照替换, 重写 AOF 使其与新的数据一致
+		if aof, ok := h.aof.(*persistent.AofPersistent); ok {
+			if err := aof.BackgroundRewrite(); err != nil && err != persistent.ErrRewriteInProgress {
+				logger.Warn("全量同步之后重写 AOF 失败.", err)
+			}
+		}
+	})
+}
+
+// execReplicaOf REPLICAOF host port | REPLICAOF NO ONE
+// 参考: https://redis.io/commands/replicaof
+func (h *Handler) execReplicaOf(cmdLine executor.CmdLine) reply.Reply {
+	if len(cmdLine) != 3 {
+		return reply.NewArgNumberErrorReply(string(cmdLine[0]))
+	}
+	if h.replication == nil {
+		return reply.NewStandardErrorReply("ERROR replication is disabled")
+	}
+
+	host, port := string(cmdLine[1]), string(cmdLine[2])
+	if strings.ToLower(host) == "no" && strings.ToLower(port) == "one" {
+		h.replication.ReplicaOfNoOne()
+		return reply.GetOkReply()
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return reply.NewStandardErrorReply("ERROR invalid master port")
+	}
+	h.replication.ReplicaOf(net.JoinHostPort(host, port))
+	return reply.GetOkReply()
+}
+
+// execPsync PSYNC replicationid offset, 由从节点发送. 之后这个连接上只会发送同步数据和命令流.
+// 参考: https://redis.io/commands/psync
+func (h *Handler) execPsync(client *Client, cmdLine executor.CmdLine) reply.Reply {
+	if len(cmdLine) != 3 {
+		return reply.NewArgNumberErrorReply("psync")
+	}
+	if h.replication == nil {
+		return reply.NewStandardErrorReply("ERROR replication is disabled")
+	}
+	offset, err := strconv.ParseInt(string(cmdLine[2]), 10, 64)
+	if err != nil {
+		return reply.NewStandardErrorReply("ERROR value is not an integer or out of range")
+	}
+
+	if err := h.replication.Psync(client, client.replicaAddr(), string(cmdLine[1]), offset); err != nil {
+		return reply.NewStandardErrorReply(err.Error())
+	}
+	client.isReplica = true
+	return reply.GetNoReply()
+}
+
+// execReplConf REPLCONF option value [option value ...], 由从节点发送
+// 参考: https://redis.io/commands/replconf
+func (h *Handler) execReplConf(client *Client, cmdLine executor.CmdLine) reply.Reply {
+	if len(cmdLine) < 3 || len(cmdLine)%2 != 1 {
+		return reply.NewArgNumberErrorReply("replconf")
+	}
+
+	for i := 1; i < len(cmdLine); i += 2 {
+		value := string(cmdLine[i+1])
+		switch strings.ToLower(string(cmdLine[i])) {
+		case "listening-port":
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return reply.NewStandardErrorReply("ERROR invalid listening-port")
+			}
+			client.replListeningPort = port
+		case "ack":
+			// 从节点确认复制偏移量, 不需要回复
+			offset, err := strconv.ParseInt(value, 10, 64)
+			if err == nil && h.replication != nil {
+				h.replication.Ack(client, offset)
+			}
+			return reply.GetNoReply()
+		case "capa":
+		default:
+			return reply.NewStandardErrorReply("ERROR unrecognized REPLCONF option '" + string(cmdLine[i]) + "'")
+		}
+	}
+	return reply.GetOkReply()
+}
+
+// replicaAddr 返回从节点的地址, 端口为其通过 REPLCONF listening-port 告知的服务端口
+func (c *Client) replicaAddr() string {
+	conn, ok := c.connection.(net.Conn)
+	if !ok {
+		return "unknown"
+	}
+	host, port, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	if c.replListeningPort > 0 {
+		port = strconv.Itoa(c.replListeningPort)
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// replicationInfo 返回 INFO replication 的全部字段
+func (h *Handler) replicationInfo() [][2]string {
+	if h.replication == nil {
+		return [][2]string{{"role", "master"}, {"connected_slaves", "0"}}
+	}
+
+	stats := h.replication.Stats()
+	fields := [][2]string{{"role", stats.Role.String()}}
+	if stats.Role == replication.RoleReplica {
+		host, port, _ := net.SplitHostPort(stats.MasterAddr)
+		linkStatus := "down"
+		if stats.MasterLinkUp {
+			linkStatus = "up"
+		}
+		lastIO := int64(-1)
+		if stats.MasterLastIO >= 0 {
+			lastIO = int64(stats.MasterLastIO.Seconds())
+		}
+		fields = append(fields,
+			[2]string{"master_host", host},
+			[2]string{"master_port", port},
+			[2]string{"master_link_status", linkStatus},
+			[2]string{"master_last_io_seconds_ago", strconv.FormatInt(lastIO, 10)},
+			[2]string{"master_sync_in_progress", boolToInfo(stats.MasterSyncInProgress)},
+			[2]string{"slave_repl_offset", strconv.FormatInt(stats.Offset, 10)},
+			[2]string{"slave_read_only", "1"},
+		)
+	}
+
+	fields = append(fields, [2]string{"connected_slaves", strconv.Itoa(len(stats.Replicas))})
+	for i, r := range stats.Replicas {
+		host, port, _ := net.SplitHostPort(r.Addr)
+		fields = append(fields, [2]string{
+			"slave" + strconv.Itoa(i),
+			"ip=" + host + ",port=" + port + ",state=" + r.State +
+				",offset=" + strconv.FormatInt(r.AckOffset, 10) + ",lag=" + strconv.FormatInt(int64(r.Lag.Seconds()), 10),
+		})
+	}
+	return append(fields,
+		[2]string{"master_replid", stats.ReplID},
+		[2]string{"master_replid2", stats.ReplID2},
+		[2]string{"master_repl_offset", strconv.FormatInt(stats.Offset, 10)},
+		[2]string{"second_repl_offset", strconv.FormatInt(stats.SecondOffset, 10)},
+		[2]string{"repl_backlog_active", boolToInfo(stats.BacklogActive)},
+		[2]string{"repl_backlog_size", strconv.Itoa(stats.BacklogSize)},
+		[2]string{"repl_backlog_first_byte_offset", strconv.FormatInt(stats.BacklogFirstOffset, 10)},
+		[2]string{"repl_backlog_histlen", strconv.FormatInt(stats.BacklogHistLen, 10)},
+		[2]string{"sync_full", strconv.FormatInt(stats.FullSyncs, 10)},
+		[2]string{"sync_partial_ok", strconv.FormatInt(stats.PartialSyncs, 10)},
+		[2]string{"sync_partial_err", strconv.FormatInt(stats.PartialSyncErrors, 10)},
+	)
+}
@@ -0,0 +1,256 @@
+package core
+
+import (
+	"context"
+	"net"
+	"simple_kvstorage/database"
+	_ "simple_kvstorage/executor/command"
+	"simple_kvstorage/replication"
+	"simple_kvstorage/resp"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer 在回环地址上运行的 Handler, 记录了全部连接以便模拟断线
+type testServer struct {
+	handler     *Handler
+	replication *replication.Manager
+	listener    net.Listener
+
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+func newTestServer(t testing.TB) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbs := make([]database.DB, 2)
+	for i := range dbs {
+		dbs[i] = database.NewConcurrentDB(i)
+	}
+
+	s := &testServer{handler: NewHandler(dbs, nil), listener: listener}
+	s.replication = replication.NewManager(&replication.Config{
+		BacklogSize:   1 << 10,
+		ListeningPort: listener.Addr().(*net.TCPAddr).Port,
+	}, dbs)
+	s.handler.SetReplication(s.replication)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mutex.Lock()
+			s.conns = append(s.conns, conn)
+			s.mutex.Unlock()
+			go s.handler.Handle(conn, context.Background())
+		}
+	}()
+	t.Cleanup(s.close)
+	return s
+}
+
+func (s *testServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+// dropConnections 断开全部已经建立的连接
+func (s *testServer) dropConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testServer) close() {
+	_ = s.listener.Close()
+	s.dropConnections()
+	_ = s.handler.Close()
+}
+
+// testConn 向测试服务器发送命令的客户端
+type testConn struct {
+	conn    net.Conn
+	replies <-chan *resp.Payload
+}
+
+func dialTestServer(t *testing.T, s *testServer) *testConn {
+	conn, err := net.Dial("tcp", s.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return &testConn{conn: conn, replies: resp.CreateParser(conn)}
+}
+
+func (c *testConn) do(t *testing.T, args ...string) string {
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	if _, err := c.conn.Write(reply.NewMultiBulkReply(cmdLine).ToBytes()); err != nil {
+		t.Fatal(err)
+	}
+	payload := <-c.replies
+	if payload == nil || payload.Error != nil {
+		t.Fatal("读取回复失败.", payload)
+	}
+	return strings.TrimSpace(string(payload.Data.ToBytes()))
+}
+
+// waitFor 等待 condition 成立, 超时则测试失败
+func waitFor(t *testing.T, message string, condition func() bool) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(message)
+}
+
+func getValue(s *testServer, dbIndex int, key string) string {
+	entity, ok := s.handler.dbs[dbIndex].Get(key)
+	if !ok {
+		return ""
+	}
+	return string(entity.Data.([]byte))
+}
+
+func TestReplication(t *testing.T) {
+	master, replica := newTestServer(t), newTestServer(t)
+	masterConn, replicaConn := dialTestServer(t, master), dialTestServer(t, replica)
+
+	masterConn.do(t, "set", "before", "1")
+	masterConn.do(t, "select", "1")
+	masterConn.do(t, "set", "db1", "1")
+
+	// 1. 全量同步, 然后接收命令流
+	host, port, _ := net.SplitHostPort(master.addr())
+	if r := replicaConn.do(t, "replicaof", host, port); r != "+OK" {
+		t.Fatal("REPLICAOF 失败.", r)
+	}
+	waitFor(t, "全量同步没有完成.", func() bool {
+		return getValue(replica, 0, "before") == "1" && getValue(replica, 1, "db1") == "1"
+	})
+	masterConn.do(t, "set", "after", "2")
+	waitFor(t, "没有收到命令流.", func() bool { return getValue(replica, 1, "after") == "2" })
+
+	// 2. 从节点只读
+	if r := replicaConn.do(t, "set", "key", "value"); !strings.HasPrefix(r, "-READONLY") {
+		t.Error("从节点应该拒绝写命令.", r)
+	}
+	if r := replicaConn.do(t, "get", "before"); r != "$1\r\n1" {
+		t.Error("从节点应该可以读取.", r)
+	}
+
+	// 3. 短暂断线之后部分重同步
+	waitFor(t, "从节点没有上线.", func() bool {
+		stats := master.replication.Stats()
+		return len(stats.Replicas) == 1 && stats.Replicas[0].State == "online"
+	})
+	master.dropConnections()
+	masterConn = dialTestServer(t, master)
+	for i := 0; i < 10; i++ {
+		masterConn.do(t, "set", "offline"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+	waitFor(t, "重连之后没有收到断线期间的命令.", func() bool { return getValue(replica, 0, "offline9") == "9" })
+	if stats := master.replication.Stats(); stats.FullSyncs != 1 || stats.PartialSyncs != 1 {
+		t.Error("应该只有一次全量同步和一次部分重同步.", stats.FullSyncs, stats.PartialSyncs)
+	}
+	waitFor(t, "复制偏移量不一致.", func() bool {
+		return replica.replication.Stats().Offset == master.replication.Stats().Offset
+	})
+
+	// 4. 成为主节点之后可以写入
+	if r := replicaConn.do(t, "replicaof", "no", "one"); r != "+OK" {
+		t.Fatal("REPLICAOF NO ONE 失败.", r)
+	}
+	if r := replicaConn.do(t, "set", "key", "value"); r != "+OK" {
+		t.Error("成为主节点之后应该可以写入.", r)
+	}
+	if stats := replica.replication.Stats(); stats.Role != replication.RoleMaster || stats.ReplID2 != master.replication.Stats().ReplID {
+		t.Error("成为主节点之后的复制 ID 错误.", stats)
+	}
+	if r := masterConn.do(t, "info", "replication"); !strings.Contains(r, "role:master") || !strings.Contains(r, "sync_full:1") {
+		t.Error("INFO replication 错误.", r)
+	}
+}
+
+func TestReplication_FullSyncDuringWrites(t *testing.T) {
+	master, replica := newTestServer(t), newTestServer(t)
+	const n = 50000
+	for i := 0; i < n; i++ {
+		master.handler.dbs[0].Put("a"+strconv.Itoa(i), &database.DataEntity{Data: []byte("v")})
+	}
+
+	// 全量同步期间执行 RENAME a b 之后 SET a x: 若快照中已经有 a 的新值, 从节点重放 RENAME 时会把新值移到 b, 与主节点永久不一致
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client := newClient(nil)
+		for i := 0; i < n; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			execString(master.handler, client, "rename", "a"+strconv.Itoa(i), "b"+strconv.Itoa(i))
+			execString(master.handler, client, "set", "a"+strconv.Itoa(i), "x")
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(master.addr())
+	if r := dialTestServer(t, replica).do(t, "replicaof", host, port); r != "+OK" {
+		t.Fatal("REPLICAOF 失败.", r)
+	}
+	synced := func() bool {
+		stats := replica.replication.Stats()
+		return stats.MasterLinkUp && !stats.MasterSyncInProgress
+	}
+	waitFor(t, "全量同步没有完成.", synced)
+	close(stop)
+	<-done
+
+	waitFor(t, "从节点没有追上主节点.", func() bool {
+		return synced() && replica.replication.Stats().Offset == master.replication.Stats().Offset
+	})
+	if master.replication.Stats().FullSyncs != 1 {
+		t.Error("应该只有一次全量同步.", master.replication.Stats().FullSyncs)
+	}
+	master.handler.dbs[0].ForEach(func(key string, val *database.DataEntity) bool {
+		if value := getValue(replica, 0, key); value != string(val.Data.([]byte)) {
+			t.Error("从节点的数据与主节点不一致.", key, value)
+			return false
+		}
+		return true
+	})
+}
+
+func TestReplication_MasterAuth(t *testing.T) {
+	master, replica := newTestServer(t), newTestServer(t)
+	master.handler.SetRequirePass("secret")
+	replica.replication = replication.NewManager(&replication.Config{
+		BacklogSize: 1 << 10,
+		MasterAuth:  "secret",
+	}, replica.handler.dbs)
+	replica.handler.SetReplication(replica.replication)
+	master.handler.dbs[0].Put("a", &database.DataEntity{Data: []byte("1")})
+
+	host, port, _ := net.SplitHostPort(master.addr())
+	if r := execString(replica.handler, newClient(nil), "replicaof", host, port); r != "+OK" {
+		t.Fatal("REPLICAOF 失败.", r)
+	}
+	waitFor(t, "从节点应该以 masterauth 认证之后同步.", func() bool { return getValue(replica, 0, "a") == "1" })
+}
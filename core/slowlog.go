@@ -0,0 +1,197 @@
+package core
+
+import (
+	"simple_kvstorage/executor"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultSlowLogSlowerThan 默认记录耗时不少于 10 毫秒的命令, defaultSlowLogMaxLen 默认最多保留 128 条记录
+	defaultSlowLogSlowerThan = 10 * time.Millisecond
+	defaultSlowLogMaxLen     = 128
+
+	// 与 Redis 相同, 每条记录最多保存 32 个参数, 每个参数最多保存 128 个字节
+	slowLogMaxArgs   = 32
+	slowLogMaxArgLen = 128
+)
+
+// slowLogEntry 慢查询日志中的一条记录
+type slowLogEntry struct {
+	id         int64
+	time       time.Time
+	duration   time.Duration
+	args       [][]byte
+	clientAddr string
+	clientName string
+}
+
+// slowLog 记录执行时间超过阈值的命令, 新的记录在前, 超过 maxLen 时丢弃最旧的记录
+type slowLog struct {
+	// slowerThan 记录的阈值, 为 0 时记录全部命令, 为负数时不记录
+	slowerThan int64
+
+	mutex   sync.Mutex
+	maxLen  int
+	entries []*slowLogEntry
+	nextID  int64
+}
+
+func newSlowLog(slowerThan time.Duration, maxLen int) *slowLog {
+	l := &slowLog{}
+	l.configure(slowerThan, maxLen)
+	return l
+}
+
+// configure 修改阈值与最多保留的记录数
+func (l *slowLog) configure(slowerThan time.Duration, maxLen int) {
+	atomic.StoreInt64(&l.slowerThan, int64(slowerThan))
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if maxLen < 0 {
+		maxLen = 0
+	}
+	l.maxLen = maxLen
+	if len(l.entries) > maxLen {
+		l.entries = l.entries[:maxLen]
+	}
+}
+
+// intercept 实现 executor.Interceptor
+func (l *slowLog) intercept(call *executor.Call, next func() reply.Reply) reply.Reply {
+	theReply := next()
+	slowerThan := time.Duration(atomic.LoadInt64(&l.slowerThan))
+	if duration := time.Since(call.Start); slowerThan >= 0 && duration >= slowerThan {
+		entry := &slowLogEntry{
+			time:     call.Start,
+			duration: duration,
+			args:     slowLogArgs(call.CmdLine),
+		}
+		if client, ok := call.Client.(*Client); ok {
+			entry.clientAddr = client.addr()
+			entry.clientName = client.Name()
+		}
+		l.add(entry)
+	}
+	return theReply
+}
+
+func (l *slowLog) add(entry *slowLogEntry) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	entry.id = l.nextID
+	l.nextID++
+	if l.maxLen == 0 {
+		return
+	}
+	if len(l.entries) < l.maxLen {
+		l.entries = append(l.entries, nil)
+	}
+	copy(l.entries[1:], l.entries)
+	l.entries[0] = entry
+}
+
+// get 返回最新的 count 条记录, count 为负数时返回全部记录
+func (l *slowLog) get(count int) []*slowLogEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if count < 0 || count > len(l.entries) {
+		count = len(l.entries)
+	}
+	return append([]*slowLogEntry(nil), l.entries[:count]...)
+}
+
+func (l *slowLog) len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.entries)
+}
+
+func (l *slowLog) reset() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.entries = nil
+}
+
+// slowLogArgs 复制命令的参数, 超过 slowLogMaxArgs 个参数或 slowLogMaxArgLen 字节的参数只保存开头的部分
+func slowLogArgs(cmdLine executor.CmdLine) [][]byte {
+	n := len(cmdLine)
+	if n > slowLogMaxArgs {
+		n = slowLogMaxArgs
+	}
+	args := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		if i == slowLogMaxArgs-1 && len(cmdLine) > slowLogMaxArgs {
+			more := len(cmdLine) - slowLogMaxArgs + 1
+			args[i] = []byte("... (" + strconv.Itoa(more) + " more arguments)")
+			break
+		}
+		arg := cmdLine[i]
+		if len(arg) > slowLogMaxArgLen {
+			more := len(arg) - slowLogMaxArgLen
+			args[i] = append(append([]byte(nil), arg[:slowLogMaxArgLen]...), "... ("+strconv.Itoa(more)+" more bytes)"...)
+			continue
+		}
+		args[i] = append([]byte(nil), arg...)
+	}
+	return args
+}
+
+// SetSlowLog 设置慢查询日志的阈值与最多保留的记录数. slowerThan 为 0 时记录全部命令, 为负数时不记录.
+func (h *Handler) SetSlowLog(slowerThan time.Duration, maxLen int) {
+	h.slowLog.configure(slowerThan, maxLen)
+}
+
+// execSlowLog SLOWLOG GET [count] | SLOWLOG LEN | SLOWLOG RESET
+// 参考: https://redis.io/commands/slowlog
+func (h *Handler) execSlowLog(cmdLine executor.CmdLine) reply.Reply {
+	if len(cmdLine) < 2 {
+		return reply.NewArgNumberErrorReply("slowlog")
+	}
+
+	subCommand := strings.ToLower(string(cmdLine[1]))
+	switch subCommand {
+	case "get":
+		count := 10
+		switch len(cmdLine) {
+		case 2:
+		case 3:
+			var err error
+			count, err = strconv.Atoi(string(cmdLine[2]))
+			if err != nil || count < -1 {
+				return reply.NewStandardErrorReply("ERROR count should be greater than or equal to -1")
+			}
+		default:
+			return reply.NewArgNumberErrorReply("slowlog|get")
+		}
+		entries := h.slowLog.get(count)
+		replies := make([]reply.Reply, 0, len(entries))
+		for _, entry := range entries {
+			replies = append(replies, reply.NewMultiRawReply([]reply.Reply{
+				reply.NewIntReply(entry.id),
+				reply.NewIntReply(entry.time.Unix()),
+				reply.NewIntReply(entry.duration.Microseconds()),
+				reply.NewMultiBulkReply(entry.args),
+				reply.NewBulkReply([]byte(entry.clientAddr)),
+				reply.NewBulkReply([]byte(entry.clientName)),
+			}))
+		}
+		return reply.NewMultiRawReply(replies)
+	case "len":
+		if len(cmdLine) != 2 {
+			return reply.NewArgNumberErrorReply("slowlog|len")
+		}
+		return reply.NewIntReply(int64(h.slowLog.len()))
+	case "reset":
+		if len(cmdLine) != 2 {
+			return reply.NewArgNumberErrorReply("slowlog|reset")
+		}
+		h.slowLog.reset()
+		return reply.GetOkReply()
+	}
+	return reply.NewStandardErrorReply("ERROR unknown slowlog subcommand '" + subCommand + "'")
+}
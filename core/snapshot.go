@@ -0,0 +1,48 @@
+package core
+
+import (
+	"simple_kvstorage/executor"
+	"simple_kvstorage/resp/reply"
+)
+
+// execSave SAVE
+// 参考: https://redis.io/commands/save
+func (h *Handler) execSave(cmdLine executor.CmdLine) reply.Reply {
+	if len(cmdLine) != 1 {
+		return reply.NewArgNumberErrorReply("save")
+	}
+	if h.snapshotter == nil {
+		return reply.NewStandardErrorReply("ERROR snapshot is disabled")
+	}
+	if err := h.snapshotter.Save(); err != nil {
+		return reply.NewStandardErrorReply(err.Error())
+	}
+	return reply.GetOkReply()
+}
+
+// execBgSave BGSAVE
+// 参考: https://redis.io/commands/bgsave
+func (h *Handler) execBgSave(cmdLine executor.CmdLine) reply.Reply {
+	if len(cmdLine) != 1 {
+		return reply.NewArgNumberErrorReply("bgsave")
+	}
+	if h.snapshotter == nil {
+		return reply.NewStandardErrorReply("ERROR snapshot is disabled")
+	}
+	if err := h.snapshotter.BackgroundSave(); err != nil {
+		return reply.NewStandardErrorReply(err.Error())
+	}
+	return reply.NewStatusReply("Background saving started")
+}
+
+// execLastSave LASTSAVE
+// 参考: https://redis.io/commands/lastsave
+func (h *Handler) execLastSave(cmdLine executor.CmdLine) reply.Reply {
+	if len(cmdLine) != 1 {
+		return reply.NewArgNumberErrorReply("lastsave")
+	}
+	if h.snapshotter == nil {
+		return reply.NewStandardErrorReply("ERROR snapshot is disabled")
+	}
+	return reply.NewIntReply(h.snapshotter.LastSave().Unix())
+}
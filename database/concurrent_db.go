@@ -0,0 +1,420 @@
+package database
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultShardCount 默认的分片数量, 必须是 2 的幂
+const defaultShardCount = 64
+
+// ConcurrentDB 分片的并发安全字典.
+// key 通过 FNV 哈希被固定地分配到某个分片上, 每个分片各自持有一把读写锁,
+// 不同分片上的操作互不阻塞. 键值对的总数由一个原子计数器维护, 因此 Size 是 O(1) 的.
+type ConcurrentDB struct {
+	// count 键值对的总数
+	count int64
+	// used 估算的内存占用, 单位是字节
+	used  int64
+	index int
+	// lfuTracking 非 0 时记录 LFU 访问计数, 仅当淘汰策略为 LFU 时才开启, 见 setLFUTracking
+	lfuTracking uint32
+
+	shards []*shard
+}
+
+// shard 一个分片.
+// 键值对保存在 entries 切片中, m 记录了 key 在 entries 中的下标,
+// 这样可以在 O(1) 时间内均匀地随机选取一个 key.
+type shard struct {
+	// size 分片中键值对的数量, 可以在不加锁的情况下读取
+	size    int64
+	m       map[string]int
+	entries []*dictEntry
+	mutex   sync.RWMutex
+}
+
+type dictEntry struct {
+	key string
+	val *DataEntity
+	// size 估算的此键值对的内存占用
+	size int64
+	// lru 最近一次访问的时钟, 见 lruClock
+	lru uint32
+	// lfu 高 16 位是计数器最近一次衰减的时间 (分钟), 低 8 位是对数访问计数器, 见 lfuIncrease
+	lfu uint32
+}
+
+func newDictEntry(key string, val *DataEntity) *dictEntry {
+	return &dictEntry{
+		key:  key,
+		val:  val,
+		size: EstimateSize(key, val),
+		lru:  lruClock(),
+		lfu:  lfuInit(),
+	}
+}
+
+// touch 记录一次访问, lfu 为 true 时同时增加 LFU 访问计数
+func (e *dictEntry) touch(lfu bool) {
+	atomic.StoreUint32(&e.lru, lruClock())
+	if lfu {
+		lfuIncrease(&e.lfu)
+	}
+}
+
+func NewConcurrentDB(index int) *ConcurrentDB {
+	db := &ConcurrentDB{
+		index:  index,
+		shards: make([]*shard, defaultShardCount),
+	}
+	for i := range db.shards {
+		db.shards[i] = newShard()
+	}
+	return db
+}
+
+func newShard() *shard {
+	return &shard{m: make(map[string]int)}
+}
+
+// fnv32 计算 key 的 FNV-1a 哈希值
+func fnv32(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// getShard 获取 key 所在的分片
+func (db *ConcurrentDB) getShard(key string) *shard {
+	return db.shards[fnv32(key)&uint32(len(db.shards)-1)]
+}
+
+/* --- shard, 调用者需要持有分片的锁 --- */
+
+func (s *shard) get(key string) (*dictEntry, bool) {
+	i, exists := s.m[key]
+	if !exists {
+		return nil, false
+	}
+	return s.entries[i], true
+}
+
+// insert 插入一个新的键值对, 调用者需确保 key 不存在. 返回新增的内存占用.
+func (s *shard) insert(key string, val *DataEntity) int64 {
+	entry := newDictEntry(key, val)
+	s.m[key] = len(s.entries)
+	s.entries = append(s.entries, entry)
+	atomic.AddInt64(&s.size, 1)
+	return entry.size
+}
+
+// update 替换已有键值对的值并记录一次访问. 返回内存占用的变化量.
+func (e *dictEntry) update(val *DataEntity, lfu bool) int64 {
+	size := EstimateSize(e.key, val)
+	delta := size - e.size
+	e.val = val
+	e.size = size
+	e.touch(lfu)
+	return delta
+}
+
+// delete 删除一个键值对, 用最后一个元素填补被删除元素的位置
+func (s *shard) delete(key string) (*dictEntry, bool) {
+	i, exists := s.m[key]
+	if !exists {
+		return nil, false
+	}
+
+	removed := s.entries[i]
+	last := len(s.entries) - 1
+	if i != last {
+		s.entries[i] = s.entries[last]
+		s.m[s.entries[i].key] = i
+	}
+	s.entries[last] = nil
+	s.entries = s.entries[:last]
+	delete(s.m, key)
+	atomic.AddInt64(&s.size, -1)
+	return removed, true
+}
+
+func (s *shard) reset() {
+	s.m = make(map[string]int)
+	s.entries = nil
+	atomic.StoreInt64(&s.size, 0)
+}
+
+/* --- DB --- */
+
+func (db *ConcurrentDB) Get(key string) (*DataEntity, bool) {
+	s := db.getShard(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entry, exists := s.get(key)
+	if !exists {
+		return nil, false
+	}
+	entry.touch(db.lfuEnabled())
+	return entry.val, true
+}
+
+func (db *ConcurrentDB) Put(key string, val *DataEntity) int {
+	s := db.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if entry, exists := s.get(key); exists {
+		atomic.AddInt64(&db.used, entry.update(val, db.lfuEnabled()))
+		return 1
+	}
+	atomic.AddInt64(&db.used, s.insert(key, val))
+	atomic.AddInt64(&db.count, 1)
+	return 1
+}
+
+func (db *ConcurrentDB) PutIfExists(key string, val *DataEntity) int {
+	s := db.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, exists := s.get(key)
+	if !exists {
+		return 0
+	}
+	atomic.AddInt64(&db.used, entry.update(val, db.lfuEnabled()))
+	return 1
+}
+
+func (db *ConcurrentDB) PutIfAbsent(key string, val *DataEntity) int {
+	s := db.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.get(key); exists {
+		return 0
+	}
+	atomic.AddInt64(&db.used, s.insert(key, val))
+	atomic.AddInt64(&db.count, 1)
+	return 1
+}
+
+func (db *ConcurrentDB) remove0(key string) int {
+	s := db.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed, exists := s.delete(key)
+	if !exists {
+		return 0
+	}
+	atomic.AddInt64(&db.used, -removed.size)
+	atomic.AddInt64(&db.count, -1)
+	return 1
+}
+
+func (db *ConcurrentDB) Remove(key string) {
+	db.remove0(key)
+}
+
+func (db *ConcurrentDB) Removes(keys ...string) int {
+	deleted := 0
+	for _, key := range keys {
+		deleted += db.remove0(key)
+	}
+	return deleted
+}
+
+func (db *ConcurrentDB) Size() int {
+	return int(atomic.LoadInt64(&db.count))
+}
+
+// ForEach 逐个分片地遍历.
+// 遍历一个分片前会先复制该分片的 key 与值, 因此 traverser 中可以安全地读写 db.
+// 只复制 key 与值: 访问信息会被并发的 Get 原子地修改, 不能整体复制.
+func (db *ConcurrentDB) ForEach(traverser func(key string, val *DataEntity) bool) {
+	type pair struct {
+		key string
+		val *DataEntity
+	}
+	for _, s := range db.shards {
+		s.mutex.RLock()
+		entries := make([]pair, len(s.entries))
+		for i, entry := range s.entries {
+			entries[i] = pair{key: entry.key, val: entry.val}
+		}
+		s.mutex.RUnlock()
+
+		for i := range entries {
+			if !traverser(entries[i].key, entries[i].val) {
+				return
+			}
+		}
+	}
+}
+
+func (db *ConcurrentDB) Keys() []string {
+	keys := make([]string, 0, db.Size())
+	for _, s := range db.shards {
+		s.mutex.RLock()
+		for _, entry := range s.entries {
+			keys = append(keys, entry.key)
+		}
+		s.mutex.RUnlock()
+	}
+	return keys
+}
+
+// randomKey 均匀地随机选取一个 key.
+// 先按各分片的大小加权选出一个分片, 再在分片内均匀地选出一个 key.
+// 当数据库为空时返回 false.
+func (db *ConcurrentDB) randomKey() (string, bool) {
+	for {
+		total := int64(0)
+		for _, s := range db.shards {
+			total += atomic.LoadInt64(&s.size)
+		}
+		if total <= 0 {
+			return "", false
+		}
+
+		r := rand.Int63n(total)
+		for _, s := range db.shards {
+			size := atomic.LoadInt64(&s.size)
+			if r >= size {
+				r -= size
+				continue
+			}
+
+			s.mutex.RLock()
+			if int(r) < len(s.entries) {
+				key := s.entries[r].key
+				s.mutex.RUnlock()
+				return key, true
+			}
+			s.mutex.RUnlock()
+			break
+		}
+		// 选取的过程中分片被并发地修改了, 重试
+	}
+}
+
+func (db *ConcurrentDB) RandomKeys(limit int) []string {
+	keys := make([]string, 0, limit)
+	for i := 0; i < limit; i++ {
+		key, ok := db.randomKey()
+		if !ok {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (db *ConcurrentDB) RandomDistinctKeys(limit int) []string {
+	if limit <= 0 {
+		return []string{}
+	}
+
+	// 需要的 key 占了一半以上时, 直接打乱全部的 key 更快
+	if size := db.Size(); limit*2 > size {
+		keys := db.Keys()
+		if limit > len(keys) {
+			limit = len(keys)
+		}
+		for i := 0; i < limit; i++ {
+			j := i + rand.Intn(len(keys)-i)
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+		return keys[:limit]
+	}
+
+	selected := make(map[string]struct{}, limit)
+	keys := make([]string, 0, limit)
+	// 限制尝试次数, 防止并发删除导致 key 的数量不足 limit 时陷入死循环
+	for attempts := 0; len(keys) < limit && attempts < limit*16; attempts++ {
+		key, ok := db.randomKey()
+		if !ok {
+			break
+		}
+		if _, exists := selected[key]; exists {
+			continue
+		}
+		selected[key] = struct{}{}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (db *ConcurrentDB) Flush() {
+	for _, s := range db.shards {
+		s.mutex.Lock()
+	}
+	for _, s := range db.shards {
+		s.reset()
+	}
+	atomic.StoreInt64(&db.count, 0)
+	atomic.StoreInt64(&db.used, 0)
+	for _, s := range db.shards {
+		s.mutex.Unlock()
+	}
+}
+
+func (db *ConcurrentDB) Index() int {
+	return db.index
+}
+
+// UsedMemory 返回估算的内存占用, 单位是字节
+func (db *ConcurrentDB) UsedMemory() int64 {
+	return atomic.LoadInt64(&db.used)
+}
+
+// MemoryUsage 返回估算的一个键值对的内存占用, 单位是字节
+func (db *ConcurrentDB) MemoryUsage(key string) (int64, bool) {
+	s := db.getShard(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entry, exists := s.get(key)
+	if !exists {
+		return 0, false
+	}
+	return entry.size, true
+}
+
+// setLFUTracking 开启或关闭 LFU 访问计数
+func (db *ConcurrentDB) setLFUTracking(enabled bool) {
+	var v uint32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreUint32(&db.lfuTracking, v)
+}
+
+func (db *ConcurrentDB) lfuEnabled() bool {
+	return atomic.LoadUint32(&db.lfuTracking) != 0
+}
+
+// sample 从一个随机的分片开始, 取出最多 n 个随机的键值对的访问信息, 用于淘汰
+func (db *ConcurrentDB) sample(n int) []evictionCandidate {
+	candidates := make([]evictionCandidate, 0, n)
+	start := rand.Intn(len(db.shards))
+	for i := 0; i < len(db.shards) && len(candidates) < n; i++ {
+		s := db.shards[(start+i)&(len(db.shards)-1)]
+		s.mutex.RLock()
+		for j := 0; j < len(s.entries) && len(candidates) < n; j++ {
+			entry := s.entries[rand.Intn(len(s.entries))]
+			candidates = append(candidates, evictionCandidate{
+				dbIndex: db.index,
+				key:     entry.key,
+				lru:     atomic.LoadUint32(&entry.lru),
+				lfu:     atomic.LoadUint32(&entry.lfu),
+			})
+		}
+		s.mutex.RUnlock()
+	}
+	return candidates
+}
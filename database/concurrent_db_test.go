@@ -0,0 +1,187 @@
+package database
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentDB(t *testing.T) {
+	const firstPutNum = 3
+
+	db := NewConcurrentDB(0)
+
+	for i := 0; i < firstPutNum; i++ {
+		db.Put(testCases[i].key, testCases[i].val)
+	}
+
+	if db.Size() != firstPutNum {
+		t.Error("Put or Size 方法测试失败.")
+		return
+	}
+
+	for i := 0; i < firstPutNum; i++ {
+		val, exists := db.Get(testCases[i].key)
+		if !exists || testCases[i].val != val {
+			t.Error("Get 方法测试失败.")
+			return
+		}
+	}
+
+	for i := firstPutNum; i < len(testCases); i++ {
+		entity := &DataEntity{Data: "PutIfExists"}
+		if db.PutIfExists(testCases[i].key, entity) != 0 {
+			t.Error("PutIfExists 方法测试失败 (when not exists).")
+			return
+		}
+	}
+
+	for i := firstPutNum; i < len(testCases); i++ {
+		entity := &DataEntity{Data: "PutIfAbsent"}
+		if db.PutIfAbsent(testCases[i].key, entity) != 1 {
+			t.Error("PutIfAbsent 方法测试失败 (when not exists).")
+			return
+		}
+	}
+	if db.PutIfAbsent(testCases[0].key, &DataEntity{Data: "PutIfAbsent"}) != 0 {
+		t.Error("PutIfAbsent 方法测试失败 (when exists).")
+		return
+	}
+
+	keys := db.Keys()
+	if db.Size() != len(keys) || len(keys) != len(testCases) {
+		t.Error("Keys 方法测试失败.")
+		return
+	}
+
+	db.Remove(keys[0])
+	if db.Size() != len(keys)-1 {
+		t.Error("Remove 方法测试失败.")
+		return
+	}
+
+	removedNum := db.Removes(keys...)
+	if db.Size() != 0 || removedNum != len(keys)-1 {
+		t.Error("Removes 方法测试失败.")
+		return
+	}
+}
+
+func TestConcurrentDB_RandomKeys(t *testing.T) {
+	db := NewConcurrentDB(0)
+	if len(db.RandomKeys(3)) != 0 || len(db.RandomDistinctKeys(3)) != 0 {
+		t.Error("空数据库的 RandomKeys 测试失败.")
+		return
+	}
+
+	const size = 100
+	for i := 0; i < size; i++ {
+		db.Put(strconv.Itoa(i), &DataEntity{Data: i})
+	}
+
+	// 每个 key 被选中的概率应该是均匀的, 而不是总返回同一个 key
+	const samples = size * 1000
+	counter := make(map[string]int)
+	for _, key := range db.RandomKeys(samples) {
+		counter[key]++
+	}
+	if len(counter) != size {
+		t.Error("RandomKeys 方法测试失败, 不是全部的 key 都被选中过.", len(counter))
+		return
+	}
+	for key, n := range counter {
+		if n < samples/size/2 || n > samples/size*2 {
+			t.Error("RandomKeys 方法测试失败, 分布不均匀.", key, n)
+			return
+		}
+	}
+
+	for _, limit := range []int{1, 10, 60, size, size * 2} {
+		keys := db.RandomDistinctKeys(limit)
+		expected := limit
+		if expected > size {
+			expected = size
+		}
+		distinct := make(map[string]struct{})
+		for _, key := range keys {
+			distinct[key] = struct{}{}
+		}
+		if len(keys) != expected || len(distinct) != expected {
+			t.Error("RandomDistinctKeys 方法测试失败.", limit, len(keys), len(distinct))
+			return
+		}
+	}
+}
+
+func TestConcurrentDB_Concurrent(t *testing.T) {
+	db := NewConcurrentDB(0)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(g*1000 + i)
+				db.Put(key, &DataEntity{Data: i})
+				db.Get(key)
+				db.Keys()
+				// 遍历与 Get 同时进行, Get 会修改访问信息
+				if i%100 == 0 {
+					db.ForEach(func(key string, val *DataEntity) bool { return true })
+				}
+				db.RandomKeys(2)
+				if i%2 == 0 {
+					db.Remove(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if db.Size() != 8*500 || len(db.Keys()) != db.Size() {
+		t.Error("并发读写测试失败.", db.Size(), len(db.Keys()))
+		return
+	}
+
+	db.Flush()
+	if db.Size() != 0 || len(db.Keys()) != 0 || db.index != 0 {
+		t.Error("Flush 方法测试失败.")
+		return
+	}
+}
+
+// benchmarkMixed 模拟混合的读写负载: 80% Get, 15% Put, 4% Remove, 1% Size
+func benchmarkMixed(b *testing.B, db DB) {
+	const keySpace = 1 << 14
+	for i := 0; i < keySpace; i++ {
+		db.Put(strconv.Itoa(i), &DataEntity{Data: i})
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			key := strconv.Itoa(r.Intn(keySpace))
+			switch op := r.Intn(100); {
+			case op < 80:
+				db.Get(key)
+			case op < 95:
+				db.Put(key, &DataEntity{Data: op})
+			case op < 99:
+				db.Remove(key)
+			default:
+				db.Size()
+			}
+		}
+	})
+}
+
+func BenchmarkMixed_MapDB(b *testing.B) {
+	benchmarkMixed(b, NewMapDB(0))
+}
+
+func BenchmarkMixed_ConcurrentDB(b *testing.B) {
+	benchmarkMixed(b, NewConcurrentDB(0))
+}
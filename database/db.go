@@ -39,6 +39,9 @@ type DB interface {
 
 	// Flush 清空数据库
 	Flush()
+
+	// Index 返回数据库的编号
+	Index() int
 }
 
 // DataEntity 存储层的数据结构, 包括 string, list, hash, set 等
@@ -0,0 +1,257 @@
+package database
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// EvictionPolicy 内存达到上限时的淘汰策略
+type EvictionPolicy int
+
+const (
+	// NoEviction 不淘汰任何数据, 可能增加内存占用的写命令直接返回 OOM 错误
+	NoEviction EvictionPolicy = iota
+	// AllKeysLRU 淘汰最近最少使用的 key
+	AllKeysLRU
+	// AllKeysLFU 淘汰访问频率最低的 key
+	AllKeysLFU
+	// AllKeysRandom 随机淘汰 key
+	AllKeysRandom
+)
+
+var evictionPolicyNames = map[EvictionPolicy]string{
+	NoEviction:    "noeviction",
+	AllKeysLRU:    "allkeys-lru",
+	AllKeysLFU:    "allkeys-lfu",
+	AllKeysRandom: "allkeys-random",
+}
+
+func (p EvictionPolicy) String() string {
+	return evictionPolicyNames[p]
+}
+
+// ParseEvictionPolicy 按名称解析淘汰策略, 例如 "allkeys-lru"
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	name = strings.ToLower(name)
+	for policy, policyName := range evictionPolicyNames {
+		if policyName == name {
+			return policy, nil
+		}
+	}
+	return NoEviction, errors.New("unknown maxmemory-policy '" + name + "'")
+}
+
+// ErrOutOfMemory 内存已达上限, 且无法通过淘汰释放出足够的内存
+var ErrOutOfMemory = errors.New("OOM command not allowed when used memory > 'maxmemory'")
+
+const (
+	// defaultEvictionSamples 每次从每个数据库中采样的 key 的数量
+	defaultEvictionSamples = 5
+	// evictionPoolSize 淘汰池的大小
+	evictionPoolSize = 16
+)
+
+// memoryTracker 能够统计内存占用的 DB
+type memoryTracker interface {
+	UsedMemory() int64
+}
+
+// evictable 支持采样淘汰的 DB
+type evictable interface {
+	memoryTracker
+	sample(n int) []evictionCandidate
+	// setLFUTracking 开启或关闭 LFU 访问计数
+	setLFUTracking(enabled bool)
+}
+
+// evictionCandidate 淘汰池中的候选 key
+type evictionCandidate struct {
+	dbIndex int
+	key     string
+	lru     uint32
+	lfu     uint32
+	// idle 越大越应该被淘汰
+	idle uint32
+}
+
+// Evictor 在内存占用超过 maxMemory 时按照淘汰策略删除 key.
+// LRU 和 LFU 都是近似的: 每次从每个数据库采样若干个 key, 放入按 idle 排序的淘汰池中,
+// 然后淘汰池中 idle 最大的 key. 淘汰池在多次淘汰之间保留, 使得结果越来越接近真正的 LRU/LFU.
+type Evictor struct {
+	dbs       []DB
+	maxMemory int64
+	policy    EvictionPolicy
+	samples   int
+
+	// pool 按 idle 从小到大排序的淘汰池
+	pool  []evictionCandidate
+	mutex sync.Mutex
+
+	// onEvict 淘汰了一个 key 之后的回调
+	onEvict func(dbIndex int, key string)
+	// evictedKeys 累计淘汰的 key 的数量
+	evictedKeys int64
+}
+
+// NewEvictor 创建一个淘汰器
+// maxMemory 内存上限, 单位是字节, 小于等于 0 表示不限制
+// samples 每次采样的 key 的数量, 小于等于 0 时使用默认值
+func NewEvictor(dbs []DB, maxMemory int64, policy EvictionPolicy, samples int) *Evictor {
+	if samples <= 0 {
+		samples = defaultEvictionSamples
+	}
+	for _, db := range dbs {
+		if db, ok := db.(evictable); ok {
+			db.setLFUTracking(policy == AllKeysLFU)
+		}
+	}
+	return &Evictor{
+		dbs:       dbs,
+		maxMemory: maxMemory,
+		policy:    policy,
+		samples:   samples,
+		pool:      make([]evictionCandidate, 0, evictionPoolSize),
+	}
+}
+
+// OnEvict 设置淘汰了一个 key 之后的回调, 例如用于将删除操作写入持久化文件
+func (e *Evictor) OnEvict(callback func(dbIndex int, key string)) {
+	e.onEvict = callback
+}
+
+// Policy 返回淘汰策略
+func (e *Evictor) Policy() EvictionPolicy {
+	return e.policy
+}
+
+// MaxMemory 返回内存上限
+func (e *Evictor) MaxMemory() int64 {
+	return e.maxMemory
+}
+
+// EvictedKeys 返回累计淘汰的 key 的数量
+func (e *Evictor) EvictedKeys() int64 {
+	return atomic.LoadInt64(&e.evictedKeys)
+}
+
+// UsedMemory 返回全部数据库估算的内存占用
+func UsedMemory(dbs []DB) int64 {
+	used := int64(0)
+	for _, db := range dbs {
+		if tracker, ok := db.(memoryTracker); ok {
+			used += tracker.UsedMemory()
+		}
+	}
+	return used
+}
+
+// FreeMemoryIfNeeded 在内存占用超过上限时淘汰 key, 直到内存占用回到上限以下.
+// 若淘汰策略是 NoEviction 或者已经没有可以淘汰的 key, 则返回 ErrOutOfMemory.
+func (e *Evictor) FreeMemoryIfNeeded() error {
+	if e.maxMemory <= 0 || UsedMemory(e.dbs) <= e.maxMemory {
+		return nil
+	}
+	if e.policy == NoEviction {
+		return ErrOutOfMemory
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for UsedMemory(e.dbs) > e.maxMemory {
+		var dbIndex int
+		var key string
+		var found bool
+		if e.policy == AllKeysRandom {
+			dbIndex, key, found = e.randomVictim()
+		} else {
+			dbIndex, key, found = e.bestVictim()
+		}
+		if !found {
+			return ErrOutOfMemory
+		}
+
+		if e.dbs[dbIndex].Removes(key) == 0 {
+			continue
+		}
+		atomic.AddInt64(&e.evictedKeys, 1)
+		if e.onEvict != nil {
+			e.onEvict(dbIndex, key)
+		}
+	}
+	return nil
+}
+
+// randomVictim 从一个随机的非空数据库中随机选取一个 key
+func (e *Evictor) randomVictim() (int, string, bool) {
+	start := rand.Intn(len(e.dbs))
+	for i := 0; i < len(e.dbs); i++ {
+		dbIndex := (start + i) % len(e.dbs)
+		if keys := e.dbs[dbIndex].RandomKeys(1); len(keys) > 0 {
+			return dbIndex, keys[0], true
+		}
+	}
+	return 0, "", false
+}
+
+// bestVictim 采样填充淘汰池, 并从淘汰池中取出 idle 最大的 key
+func (e *Evictor) bestVictim() (int, string, bool) {
+	for attempts := 0; attempts < evictionPoolSize; attempts++ {
+		sampled := false
+		for _, db := range e.dbs {
+			if db.Size() == 0 {
+				continue
+			}
+			if db, ok := db.(evictable); ok {
+				for _, candidate := range db.sample(e.samples) {
+					e.populate(candidate)
+				}
+				sampled = true
+			}
+		}
+		if !sampled {
+			return 0, "", false
+		}
+
+		// 取出 idle 最大的 key, 它可能已经被删除了, 由调用者处理
+		if len(e.pool) > 0 {
+			best := e.pool[len(e.pool)-1]
+			e.pool = e.pool[:len(e.pool)-1]
+			return best.dbIndex, best.key, true
+		}
+	}
+	return 0, "", false
+}
+
+// populate 将一个候选 key 放入淘汰池. 淘汰池已满时, 只有比池中最小的 idle 更大的候选才会放入.
+func (e *Evictor) populate(candidate evictionCandidate) {
+	if e.policy == AllKeysLFU {
+		candidate.idle = 255 - lfuDecrAndReturn(candidate.lfu)
+	} else {
+		candidate.idle = lruIdleTime(candidate.lru)
+	}
+
+	for i := range e.pool {
+		if e.pool[i].dbIndex == candidate.dbIndex && e.pool[i].key == candidate.key {
+			// 已经在池中了, 更新其 idle
+			e.pool = append(e.pool[:i], e.pool[i+1:]...)
+			break
+		}
+	}
+	if len(e.pool) == evictionPoolSize {
+		if candidate.idle <= e.pool[0].idle {
+			return
+		}
+		copy(e.pool, e.pool[1:])
+		e.pool = e.pool[:len(e.pool)-1]
+	}
+
+	i := sort.Search(len(e.pool), func(i int) bool { return e.pool[i].idle >= candidate.idle })
+	e.pool = append(e.pool, evictionCandidate{})
+	copy(e.pool[i+1:], e.pool[i:])
+	e.pool[i] = candidate
+}
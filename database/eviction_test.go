@@ -0,0 +1,147 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newEvictionTestDBs(n int) []DB {
+	dbs := make([]DB, n)
+	for i := range dbs {
+		dbs[i] = NewConcurrentDB(i)
+	}
+	return dbs
+}
+
+func TestEstimateSize(t *testing.T) {
+	db := NewConcurrentDB(0)
+	db.Put("a", &DataEntity{Data: []byte("hello")})
+	db.Put("b", &DataEntity{Data: []byte("world")})
+	if db.UsedMemory() != EstimateSize("a", &DataEntity{Data: []byte("hello")})*2 {
+		t.Error("UsedMemory 测试失败.", db.UsedMemory())
+		return
+	}
+
+	db.Put("a", &DataEntity{Data: make([]byte, 1024)})
+	size, _ := db.MemoryUsage("a")
+	if size < 1024 || db.UsedMemory() < 1024 {
+		t.Error("覆盖写入后 UsedMemory 测试失败.", size, db.UsedMemory())
+		return
+	}
+
+	db.Removes("a", "b")
+	if db.UsedMemory() != 0 {
+		t.Error("删除后 UsedMemory 测试失败.", db.UsedMemory())
+		return
+	}
+}
+
+func TestEvictor_NoEviction(t *testing.T) {
+	dbs := newEvictionTestDBs(1)
+	evictor := NewEvictor(dbs, 1024, NoEviction, 0)
+
+	for i := 0; i < 100; i++ {
+		dbs[0].Put(strconv.Itoa(i), &DataEntity{Data: []byte("value")})
+	}
+	if evictor.FreeMemoryIfNeeded() != ErrOutOfMemory || dbs[0].Size() != 100 {
+		t.Error("noeviction 测试失败.")
+		return
+	}
+}
+
+func TestEvictor_LRU(t *testing.T) {
+	dbs := newEvictionTestDBs(2)
+	const n = 100
+	for i := 0; i < n; i++ {
+		dbs[i%2].Put("old"+strconv.Itoa(i), &DataEntity{Data: []byte("value")})
+	}
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < n; i++ {
+		dbs[i%2].Put("new"+strconv.Itoa(i), &DataEntity{Data: []byte("value")})
+	}
+
+	evicted := 0
+	evictor := NewEvictor(dbs, UsedMemory(dbs)/2, AllKeysLRU, 10)
+	evictor.OnEvict(func(dbIndex int, key string) {
+		evicted++
+	})
+	if err := evictor.FreeMemoryIfNeeded(); err != nil {
+		t.Error(err)
+		return
+	}
+	if UsedMemory(dbs) > evictor.MaxMemory() || evicted == 0 || int64(evicted) != evictor.EvictedKeys() {
+		t.Error("allkeys-lru 测试失败, 没有释放足够的内存.")
+		return
+	}
+
+	// 绝大多数被淘汰的应该是较早访问的 key
+	survived := 0
+	for i := 0; i < n; i++ {
+		if _, exists := dbs[i%2].Get("new" + strconv.Itoa(i)); exists {
+			survived++
+		}
+	}
+	if survived < n*8/10 {
+		t.Error("allkeys-lru 测试失败, 较新的 key 被淘汰得太多.", survived)
+		return
+	}
+}
+
+func TestEvictor_LFU(t *testing.T) {
+	dbs := newEvictionTestDBs(1)
+	evictor := NewEvictor(dbs, 0, AllKeysLFU, 10)
+	// 同一进程中另一组数据库的淘汰策略不影响这一组
+	NewEvictor(newEvictionTestDBs(1), 0, AllKeysLRU, 0)
+
+	const n = 100
+	for i := 0; i < n; i++ {
+		dbs[0].Put(strconv.Itoa(i), &DataEntity{Data: []byte("value")})
+	}
+	// 偶数的 key 被频繁访问
+	for round := 0; round < 200; round++ {
+		for i := 0; i < n; i += 2 {
+			dbs[0].Get(strconv.Itoa(i))
+		}
+	}
+
+	evictor.maxMemory = UsedMemory(dbs) / 2
+	if err := evictor.FreeMemoryIfNeeded(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	survived := 0
+	for i := 0; i < n; i += 2 {
+		if _, exists := dbs[0].Get(strconv.Itoa(i)); exists {
+			survived++
+		}
+	}
+	if survived < n/2*8/10 {
+		t.Error("allkeys-lfu 测试失败, 频繁访问的 key 被淘汰得太多.", survived)
+		return
+	}
+}
+
+func TestEvictor_Random(t *testing.T) {
+	dbs := newEvictionTestDBs(4)
+	for i := 0; i < 100; i++ {
+		dbs[i%4].Put(strconv.Itoa(i), &DataEntity{Data: []byte("value")})
+	}
+
+	evictor := NewEvictor(dbs, UsedMemory(dbs)/4, AllKeysRandom, 0)
+	if err := evictor.FreeMemoryIfNeeded(); err != nil || UsedMemory(dbs) > evictor.MaxMemory() {
+		t.Error("allkeys-random 测试失败.", err)
+		return
+	}
+
+	// 全部数据都被淘汰后仍然超过上限, 则返回 OOM
+	evictor.maxMemory = 1
+	for _, db := range dbs {
+		db.Flush()
+	}
+	if err := evictor.FreeMemoryIfNeeded(); err != nil {
+		t.Error("空数据库不应该返回 OOM.", err)
+		return
+	}
+}
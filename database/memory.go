@@ -0,0 +1,106 @@
+package database
+
+import (
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// entryOverhead 每个键值对除了 key 和 value 之外的固定开销, 包括字典项, 索引和 DataEntity 本身
+const entryOverhead = 96
+
+// EstimateSize 估算一个键值对的内存占用, 单位是字节
+func EstimateSize(key string, val *DataEntity) int64 {
+	size := int64(entryOverhead + len(key))
+	if val == nil {
+		return size
+	}
+
+	switch data := val.Data.(type) {
+	case []byte:
+		size += int64(24 + cap(data))
+	case string:
+		size += int64(16 + len(data))
+	case int, int64, float64:
+		size += 8
+	default:
+		size += 16
+	}
+	return size
+}
+
+/* --- LRU --- */
+
+// lruClockResolution LRU 时钟的精度
+const lruClockResolution = time.Millisecond
+
+// lruClock 返回当前的 LRU 时钟. 时钟溢出后会从 0 开始,
+// 计算空闲时间时使用无符号整数的减法即可正确处理溢出.
+func lruClock() uint32 {
+	return uint32(time.Now().UnixNano() / int64(lruClockResolution))
+}
+
+// lruIdleTime 返回一个键值对自最近一次访问以来的空闲时间
+func lruIdleTime(lru uint32) uint32 {
+	return lruClock() - lru
+}
+
+/* --- LFU --- */
+
+const (
+	// lfuInitValue 新的键值对的访问计数器的初始值, 防止其刚被创建就被淘汰
+	lfuInitValue = 5
+	// lfuLogFactor 对数计数器的增长因子, 越大则计数器增长越慢
+	lfuLogFactor = 10
+	// lfuDecayTime 计数器每经过多少分钟衰减 1
+	lfuDecayTime = 1
+)
+
+// lfuMinutes 返回当前时间的分钟数的低 16 位
+func lfuMinutes() uint32 {
+	return uint32(time.Now().Unix()/60) & math.MaxUint16
+}
+
+func lfuInit() uint32 {
+	return lfuMinutes()<<8 | lfuInitValue
+}
+
+// lfuDecrAndReturn 返回按照经过的时间衰减之后的计数器
+func lfuDecrAndReturn(lfu uint32) uint32 {
+	lastDecr := lfu >> 8
+	counter := lfu & 0xff
+
+	now := lfuMinutes()
+	elapsed := now - lastDecr
+	if now < lastDecr {
+		elapsed = math.MaxUint16 - lastDecr + now
+	}
+
+	periods := elapsed / lfuDecayTime
+	if periods >= counter {
+		return 0
+	}
+	return counter - periods
+}
+
+// lfuIncrease 衰减并以对数的方式增加访问计数器: 计数器越大, 增加的概率越小
+func lfuIncrease(lfu *uint32) {
+	for {
+		old := atomic.LoadUint32(lfu)
+		counter := lfuDecrAndReturn(old)
+		if counter < 255 {
+			baseVal := float64(0)
+			if counter > lfuInitValue {
+				baseVal = float64(counter - lfuInitValue)
+			}
+			if rand.Float64() < 1.0/(baseVal*lfuLogFactor+1) {
+				counter++
+			}
+		}
+
+		if atomic.CompareAndSwapUint32(lfu, old, lfuMinutes()<<8|counter) {
+			return
+		}
+	}
+}
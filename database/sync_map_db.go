@@ -76,6 +76,10 @@ func (db *MapDB) Flush() {
 	db.data = sync.Map{}
 }
 
+func (db *MapDB) Index() int {
+	return db.index
+}
+
 func (db *MapDB) Size() int {
 	l := 0
 	db.data.Range(func(_, _ any) bool {
@@ -0,0 +1,83 @@
+package erasure
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// SignatureAlgorithm 报告的签名算法
+const SignatureAlgorithm = "hmac-sha256"
+
+// ErrBadSignature 报告的签名不匹配
+var ErrBadSignature = errors.New("erasure report signature mismatch")
+
+// Report 一次擦除的报告, 只记录被删除的 key, 不包含值
+type Report struct {
+	Subject  string      `json:"subject"`
+	ErasedAt time.Time   `json:"erased_at"`
+	Keys     []ErasedKey `json:"keys"`
+	// AofRewritten 是否已经重写 AOF, 使被删除的数据不再出现在 AOF 文件中
+	AofRewritten bool `json:"aof_rewritten"`
+	// SnapshotSaved 是否已经重新生成快照
+	SnapshotSaved bool `json:"snapshot_saved"`
+}
+
+// ErasedKey 一个被删除的 key
+type ErasedKey struct {
+	DB  int    `json:"db"`
+	Key string `json:"key"`
+}
+
+// NewReport 根据擦除计划创建报告
+func NewReport(plan *Plan, erasedAt time.Time) *Report {
+	report := &Report{Subject: plan.Subject, ErasedAt: erasedAt.UTC(), Keys: make([]ErasedKey, 0, plan.Count())}
+	for _, dbIndex := range plan.DBs() {
+		for _, key := range plan.Keys[dbIndex] {
+			report.Keys = append(report.Keys, ErasedKey{DB: dbIndex, Key: key})
+		}
+	}
+	return report
+}
+
+// SignedReport 签名的报告. 签名覆盖 Report 字段的原始字节.
+type SignedReport struct {
+	Report    json.RawMessage `json:"report"`
+	Algorithm string          `json:"algorithm"`
+	Signature string          `json:"signature"`
+}
+
+// Sign 使用 key 对报告签名, 返回签名的报告的 JSON
+func (r *Report) Sign(key []byte) ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&SignedReport{Report: data, Algorithm: SignatureAlgorithm, Signature: sign(data, key)})
+}
+
+// Verify 校验签名的报告, 签名正确时返回其中的报告
+func Verify(signed []byte, key []byte) (*Report, error) {
+	var s SignedReport
+	if err := json.Unmarshal(signed, &s); err != nil {
+		return nil, err
+	}
+	if s.Algorithm != SignatureAlgorithm || !hmac.Equal([]byte(s.Signature), []byte(sign(s.Report, key))) {
+		return nil, ErrBadSignature
+	}
+
+	report := &Report{}
+	if err := json.Unmarshal(s.Report, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func sign(data []byte, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
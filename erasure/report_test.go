@@ -0,0 +1,31 @@
+package erasure
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport_Sign(t *testing.T) {
+	plan := &Plan{Subject: "42", Keys: map[int][]string{1: {"order:1"}, 0: {"user:42:name"}}}
+	report := NewReport(plan, time.Unix(1700000000, 0))
+	report.AofRewritten = true
+	signed, err := report.Sign([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := Verify(signed, []byte("secret"))
+	if err != nil || verified.Subject != "42" || !verified.AofRewritten || len(verified.Keys) != 2 ||
+		verified.Keys[0] != (ErasedKey{DB: 0, Key: "user:42:name"}) {
+		t.Fatal("校验报告失败.", verified, err)
+	}
+
+	if _, err := Verify(signed, []byte("other")); err != ErrBadSignature {
+		t.Error("密钥不同时应该校验失败.", err)
+	}
+	tampered := strings.Replace(string(signed), "user:42:name", "user:43:name", 1)
+	if _, err := Verify([]byte(tampered), []byte("secret")); err != ErrBadSignature {
+		t.Error("报告被修改时应该校验失败.", err)
+	}
+}
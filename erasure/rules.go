@@ -0,0 +1,184 @@
+package erasure
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"simple_kvstorage/database"
+	"simple_kvstorage/util/wildcard"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SubjectPlaceholder 规则中的占位符, 匹配时被替换为数据主体的 ID
+const SubjectPlaceholder = "{subject}"
+
+// 规则文件的格式: 每行一条规则, 由 key 的通配符模式和可选的值的通配符模式组成, 以空白分隔.
+// 两者都可以包含占位符 {subject}. 以 # 开头的行是注释. 例如:
+//
+//	user:{subject}:*
+//	order:* *"customer":"{subject}"*
+//
+// 第一条规则匹配 key 中包含主体 ID 的全部键值对, 第二条规则匹配值中包含主体 ID 的订单.
+
+// Rule 一条规则
+type Rule struct {
+	// KeyPattern key 的通配符模式
+	KeyPattern string
+	// ValuePattern 值的通配符模式, 为空表示不检查值
+	ValuePattern string
+}
+
+// Rules 数据主体到键值对的映射规则
+type Rules struct {
+	rules []Rule
+}
+
+// ParseRules 解析规则
+func ParseRules(reader io.Reader) (*Rules, error) {
+	rules := &Rules{}
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("erasure rule line %d: too many fields", lineNumber)
+		}
+		rule := Rule{KeyPattern: fields[0]}
+		if len(fields) == 2 {
+			rule.ValuePattern = fields[1]
+		}
+		if !strings.Contains(line, SubjectPlaceholder) {
+			return nil, fmt.Errorf("erasure rule line %d: missing %s", lineNumber, SubjectPlaceholder)
+		}
+		rules.rules = append(rules.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// LoadRules 从文件中读取规则
+func LoadRules(filename string) (*Rules, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	return ParseRules(file)
+}
+
+// Len 规则的数量
+func (r *Rules) Len() int {
+	return len(r.rules)
+}
+
+// compiledRule 替换了占位符之后的规则
+type compiledRule struct {
+	key   *wildcard.Pattern
+	value *wildcard.Pattern
+}
+
+// compile 用主体 ID 替换占位符. 主体 ID 中的通配符会被转义, 只能按字面匹配.
+func (r *Rules) compile(subject string) []compiledRule {
+	escaped := escapePattern(subject)
+	compiled := make([]compiledRule, len(r.rules))
+	for i, rule := range r.rules {
+		compiled[i].key = wildcard.CompilePattern(strings.ReplaceAll(rule.KeyPattern, SubjectPlaceholder, escaped))
+		if rule.ValuePattern != "" {
+			compiled[i].value = wildcard.CompilePattern(strings.ReplaceAll(rule.ValuePattern, SubjectPlaceholder, escaped))
+		}
+	}
+	return compiled
+}
+
+func escapePattern(s string) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(`*?[]^-\`, s[i]) >= 0 {
+			builder.WriteByte('\\')
+		}
+		builder.WriteByte(s[i])
+	}
+	return builder.String()
+}
+
+// ErrEmptySubject 主体 ID 为空
+var ErrEmptySubject = errors.New("ERROR erasure subject must not be empty")
+
+// Plan 一次擦除涉及的全部键值对
+type Plan struct {
+	Subject string
+	// Keys 每个数据库中匹配的 key, 按字典序排列
+	Keys map[int][]string
+}
+
+// Count 匹配的 key 的总数
+func (p *Plan) Count() int {
+	count := 0
+	for _, keys := range p.Keys {
+		count += len(keys)
+	}
+	return count
+}
+
+// DBs 按序号排列的, 包含匹配的 key 的数据库
+func (p *Plan) DBs() []int {
+	dbs := make([]int, 0, len(p.Keys))
+	for dbIndex := range p.Keys {
+		dbs = append(dbs, dbIndex)
+	}
+	sort.Ints(dbs)
+	return dbs
+}
+
+// Plan 遍历全部数据库, 找出与主体相关的键值对
+func (r *Rules) Plan(dbs []database.DB, subject string) (*Plan, error) {
+	if subject == "" {
+		return nil, ErrEmptySubject
+	}
+
+	compiled := r.compile(subject)
+	plan := &Plan{Subject: subject, Keys: make(map[int][]string)}
+	for dbIndex, db := range dbs {
+		var keys []string
+		db.ForEach(func(key string, entity *database.DataEntity) bool {
+			for _, rule := range compiled {
+				if rule.matches(key, entity) {
+					keys = append(keys, key)
+					break
+				}
+			}
+			return true
+		})
+		if len(keys) > 0 {
+			sort.Strings(keys)
+			plan.Keys[dbIndex] = keys
+		}
+	}
+	return plan, nil
+}
+
+func (r *compiledRule) matches(key string, entity *database.DataEntity) bool {
+	if !r.key.IsMatch(key) {
+		return false
+	}
+	if r.value == nil {
+		return true
+	}
+	switch data := entity.Data.(type) {
+	case []byte:
+		return r.value.IsMatch(string(data))
+	case int:
+		return r.value.IsMatch(strconv.Itoa(data))
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package erasure
+
+import (
+	"reflect"
+	"simple_kvstorage/database"
+	"strings"
+	"testing"
+)
+
+const testRules = `# 用户的资料
+user:{subject}:*
+order:* *"customer":"{subject}"*
+`
+
+func newTestDBs() []database.DB {
+	dbs := []database.DB{database.NewConcurrentDB(0), database.NewConcurrentDB(1)}
+	put := func(db database.DB, key string, value any) {
+		db.Put(key, &database.DataEntity{Data: value})
+	}
+	put(dbs[0], "user:42:name", []byte("alice"))
+	put(dbs[0], "user:42:email", []byte("a@example.com"))
+	put(dbs[0], "user:420:name", []byte("bob"))
+	put(dbs[1], "order:1", []byte(`{"customer":"42","total":3}`))
+	put(dbs[1], "order:2", []byte(`{"customer":"420","total":5}`))
+	put(dbs[1], "counter", 42)
+	return dbs
+}
+
+func TestRules_Plan(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(testRules))
+	if err != nil || rules.Len() != 2 {
+		t.Fatal("解析规则失败.", err)
+	}
+
+	plan, err := rules.Plan(newTestDBs(), "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int][]string{0: {"user:42:email", "user:42:name"}, 1: {"order:1"}}
+	if !reflect.DeepEqual(plan.Keys, expected) || plan.Count() != 3 {
+		t.Error("匹配的 key 错误.", plan.Keys)
+	}
+
+	// 主体 ID 中的通配符按字面匹配
+	if plan, _ := rules.Plan(newTestDBs(), "*"); plan.Count() != 0 {
+		t.Error("主体 ID 中的通配符不应该生效.", plan.Keys)
+	}
+	if _, err := rules.Plan(newTestDBs(), ""); err != ErrEmptySubject {
+		t.Error("应该拒绝空的主体 ID.", err)
+	}
+}
+
+func TestParseRules_Invalid(t *testing.T) {
+	for _, invalid := range []string{"user:*", "user:{subject} * *"} {
+		if _, err := ParseRules(strings.NewReader(invalid)); err == nil {
+			t.Error("应该拒绝非法的规则.", invalid)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package command
 
+import "simple_kvstorage/executor"
+
 const (
 	ping = "ping"
 
@@ -16,4 +18,16 @@ const (
 	setNx  = "setNx"
 	getSet = "getSet"
 	strLen = "strLen"
+
+	memory = "memory"
+
+	dump    = "dump"
+	restore = "restore"
+)
+
+var (
+	// firstKey 只有第一个参数是 key
+	firstKey = executor.KeySpec{First: 1, Last: 1, Step: 1}
+	// allKeys 全部参数都是 key
+	allKeys = executor.KeySpec{First: 1, Last: -1, Step: 1}
 )
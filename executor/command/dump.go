@@ -0,0 +1,66 @@
+package command
+
+import (
+	"simple_kvstorage/database"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/persistent"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	executor.RegisterCommand(dump, execDump, 2, executor.FlagReadonly, firstKey, "keyspace")
+	executor.RegisterCommand(restore, execRestore, -4, executor.FlagWrite|executor.FlagDenyOOM, firstKey, "keyspace", "dangerous")
+}
+
+// execDump DUMP key
+// 参考: https://redis.io/commands/dump
+func execDump(db database.DB, args [][]byte) reply.Reply {
+	entity, exists := db.Get(string(args[0]))
+	if !exists {
+		return reply.GetNullBulkReply()
+	}
+
+	payload, err := persistent.DumpValue(entity)
+	if err != nil {
+		return reply.NewStandardErrorReply("ERROR " + err.Error())
+	}
+	return reply.NewBulkReply(payload)
+}
+
+// execRestore RESTORE key ttl serialized-value [REPLACE]
+// 键值对不支持过期时间, ttl 只能是 0.
+// 参考: https://redis.io/commands/restore
+func execRestore(db database.DB, args [][]byte) reply.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.NewStandardErrorReply("ERROR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return reply.NewStandardErrorReply("ERROR Invalid TTL value, must be >= 0")
+	}
+	if ttl > 0 {
+		return reply.NewStandardErrorReply("ERROR TTL is not supported")
+	}
+
+	replace := false
+	for _, arg := range args[3:] {
+		if strings.ToLower(string(arg)) != "replace" {
+			return reply.GetSyntaxErrReply()
+		}
+		replace = true
+	}
+
+	entity, err := persistent.RestoreValue(args[2])
+	if err != nil {
+		return reply.NewStandardErrorReply(persistent.ErrDumpPayload.Error())
+	}
+	if replace {
+		db.Put(key, entity)
+	} else if db.PutIfAbsent(key, entity) == 0 {
+		return reply.NewStandardErrorReply("BUSYKEY Target key name already exists.")
+	}
+	return reply.GetOkReply()
+}
@@ -8,13 +8,13 @@ import (
 )
 
 func init() {
-	executor.RegisterCommand(del, execDel, -2)
-	executor.RegisterCommand(exists, execExists, -2)
-	executor.RegisterCommand(keys, execKeys, 2)
-	executor.RegisterCommand(flushDB, execFlushDB, -1)
-	executor.RegisterCommand(_type, execType, 2)
-	executor.RegisterCommand(rename, execRename, 3)
-	executor.RegisterCommand(renameNx, execRenameNx, 3)
+	executor.RegisterCommand(del, execDel, -2, executor.FlagWrite, allKeys, "keyspace")
+	executor.RegisterCommand(exists, execExists, -2, executor.FlagReadonly|executor.FlagFast, allKeys, "keyspace")
+	executor.RegisterCommand(keys, execKeys, 2, executor.FlagReadonly, executor.NoKeys, "keyspace", "dangerous")
+	executor.RegisterCommand(flushDB, execFlushDB, -1, executor.FlagWrite, executor.NoKeys, "keyspace", "dangerous")
+	executor.RegisterCommand(_type, execType, 2, executor.FlagReadonly|executor.FlagFast, firstKey, "keyspace")
+	executor.RegisterCommand(rename, execRename, 3, executor.FlagWrite|executor.FlagDenyOOM, executor.KeySpec{First: 1, Last: 2, Step: 1}, "keyspace")
+	executor.RegisterCommand(renameNx, execRenameNx, 3, executor.FlagWrite|executor.FlagFast, executor.KeySpec{First: 1, Last: 2, Step: 1}, "keyspace")
 }
 
 // execDel DEL key [key ...]
@@ -0,0 +1,40 @@
+package command
+
+import (
+	"simple_kvstorage/database"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/resp/reply"
+	"strings"
+)
+
+func init() {
+	// MEMORY USAGE key 的 key 是第二个参数
+	executor.RegisterCommand(memory, execMemory, -2, executor.FlagReadonly, executor.KeySpec{First: 2, Last: 2, Step: 1})
+}
+
+// memoryUsageDB 能够估算单个 key 的内存占用的 DB
+type memoryUsageDB interface {
+	MemoryUsage(key string) (int64, bool)
+}
+
+// execMemory MEMORY USAGE key
+// 参考: https://redis.io/commands/memory-usage
+func execMemory(db database.DB, args [][]byte) reply.Reply {
+	subCommand := strings.ToLower(string(args[0]))
+	if subCommand != "usage" {
+		return reply.NewStandardErrorReply("ERROR unknown subcommand '" + subCommand + "'")
+	}
+	if len(args) != 2 {
+		return reply.NewArgNumberErrorReply("memory|usage")
+	}
+
+	usageDB, ok := db.(memoryUsageDB)
+	if !ok {
+		return reply.GetNullBulkReply()
+	}
+	size, exists := usageDB.MemoryUsage(string(args[1]))
+	if !exists {
+		return reply.GetNullBulkReply()
+	}
+	return reply.NewIntReply(size)
+}
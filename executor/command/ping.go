@@ -7,7 +7,7 @@ import (
 )
 
 func init() {
-	executor.RegisterCommand(ping, execPing, -1)
+	executor.RegisterCommand(ping, execPing, -1, executor.FlagFast, executor.NoKeys, "connection")
 }
 
 // execPing PING [message]
@@ -7,11 +7,11 @@ import (
 )
 
 func init() {
-	executor.RegisterCommand(get, execGet, 2)
-	executor.RegisterCommand(set, execSet, -3)
-	executor.RegisterCommand(setNx, execSetNX, 3)
-	executor.RegisterCommand(getSet, execGetSet, 3)
-	executor.RegisterCommand(strLen, execStrLen, 2)
+	executor.RegisterCommand(get, execGet, 2, executor.FlagReadonly|executor.FlagFast, firstKey, "string")
+	executor.RegisterCommand(set, execSet, -3, executor.FlagWrite|executor.FlagDenyOOM, firstKey, "string")
+	executor.RegisterCommand(setNx, execSetNX, 3, executor.FlagWrite|executor.FlagDenyOOM|executor.FlagFast, firstKey, "string")
+	executor.RegisterCommand(getSet, execGetSet, 3, executor.FlagWrite|executor.FlagDenyOOM|executor.FlagFast, firstKey, "string")
+	executor.RegisterCommand(strLen, execStrLen, 2, executor.FlagReadonly|executor.FlagFast, firstKey, "string")
 }
 
 // execGet GET key
@@ -3,6 +3,7 @@ package executor
 import (
 	"simple_kvstorage/database"
 	"simple_kvstorage/resp/reply"
+	"sort"
 	"strings"
 )
 
@@ -11,11 +12,27 @@ import (
 type CmdLine = [][]byte
 
 // Exec executes command within one database
+// 命令先经过 Use 加入的拦截器, 再由注册的 CommandExecutor 执行
 func Exec(db database.DB, cmdLine CmdLine) reply.Reply {
 	cmdName := strings.ToLower(string(cmdLine[0]))
+	// 没有拦截器时不创建 Call, 避免每条命令多余的内存分配
+	if chain.empty() {
+		return execute(db, cmdName, cmdLine)
+	}
+	call := &Call{
+		DBIndex: db.Index(),
+		Name:    cmdName,
+		CmdLine: cmdLine,
+	}
+	return chain.Invoke(call, func() reply.Reply {
+		return execute(db, call.Name, cmdLine)
+	})
+}
 
+func execute(db database.DB, cmdName string, cmdLine CmdLine) reply.Reply {
 	cmd, exist := cmdTable[cmdName]
-	if !exist {
+	// 只有元数据的命令由 core 或 cluster 执行, 在这里与未知的命令相同
+	if !exist || cmd.executor == nil {
 		return reply.NewStandardErrorReply("ERROR unknown command '" + cmdName + "'")
 	}
 	if !cmd.validateArity(cmdLine) {
@@ -30,13 +47,179 @@ func Exec(db database.DB, cmdLine CmdLine) reply.Reply {
 // cmdTable 记录了支持的全部 Redis 命令
 var cmdTable = make(map[string]*command)
 
-// RegisterCommand 注册一个命令
-func RegisterCommand(cmdName string, executor CommandExecutor, arity int) {
+// Flag 命令的标志, 可以按位组合
+type Flag uint32
+
+const (
+	// FlagWrite 命令会修改数据, 需要持久化并传播给从节点
+	FlagWrite Flag = 1 << iota
+	// FlagReadonly 命令只读取数据
+	FlagReadonly
+	// FlagAdmin 管理命令, 如 SAVE, REPLICAOF
+	FlagAdmin
+	// FlagPubsub 发布订阅相关的命令
+	FlagPubsub
+	// FlagNoscript 不能在脚本中执行的命令
+	FlagNoscript
+	// FlagFast 时间复杂度为 O(1) 或 O(log N) 的命令
+	FlagFast
+	// FlagDenyOOM 可能增加内存占用的命令, 内存不足且无法淘汰时被拒绝执行. DEL 等只会释放内存的写命令不设置它.
+	FlagDenyOOM
+)
+
+// flagNames 各个标志在 COMMAND 的回复中的名字, 顺序与标志的位相同
+var flagNames = []string{"write", "readonly", "admin", "pubsub", "noscript", "fast", "denyoom"}
+
+// Names 返回全部已设置的标志的名字
+func (f Flag) Names() []string {
+	names := make([]string, 0)
+	for i, name := range flagNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// KeySpec 描述了命令中 key 的位置, 含义与 COMMAND INFO 中的 first key, last key, step 相同.
+// First 是第一个 key 的下标 (命令名的下标为 0), 为 0 时表示命令没有 key;
+// Last 是最后一个 key 的下标, 负数表示从末尾数起, -1 表示最后一个参数; Step 是相邻两个 key 的下标之差.
+type KeySpec struct {
+	First int
+	Last  int
+	Step  int
+}
+
+// NoKeys 没有 key 的命令的 KeySpec
+var NoKeys = KeySpec{}
+
+// Keys 返回命令中的全部 key. cmdLine 的参数数量不足时只返回存在的 key.
+func (s KeySpec) Keys(cmdLine CmdLine) [][]byte {
+	if s.First <= 0 || s.First >= len(cmdLine) {
+		return nil
+	}
+	last := s.Last
+	if last < 0 {
+		last += len(cmdLine)
+	}
+	if last >= len(cmdLine) {
+		last = len(cmdLine) - 1
+	}
+	step := s.Step
+	if step <= 0 {
+		step = 1
+	}
+
+	var keys [][]byte
+	for i := s.First; i <= last; i += step {
+		keys = append(keys, cmdLine[i])
+	}
+	return keys
+}
+
+// RegisterCommand 注册一个命令.
+// flags 是命令的标志, keys 是命令中 key 的位置, categories 是命令所属的分类 (如 "string", "keyspace"),
+// 由标志决定的分类 (如 write, read, fast, slow) 会自动加入, 不需要重复指定.
+// 由 core 或 cluster 直接处理的命令 (如 SELECT, INFO) 只注册元数据, executor 为 nil, Exec 不会执行它们.
+func RegisterCommand(cmdName string, executor CommandExecutor, arity int, flags Flag, keys KeySpec, categories ...string) {
 	cmdName = strings.ToLower(cmdName)
 	cmdTable[cmdName] = &command{
-		executor: executor,
-		arity:    arity,
+		executor:   executor,
+		arity:      arity,
+		flags:      flags,
+		keys:       keys,
+		categories: withFlagCategories(flags, categories),
+	}
+}
+
+// withFlagCategories 在 categories 之后加入由标志决定的分类, 去掉重复的分类
+func withFlagCategories(flags Flag, categories []string) []string {
+	all := make([]string, 0, len(categories)+3)
+	add := func(category string) {
+		category = strings.ToLower(category)
+		for _, c := range all {
+			if c == category {
+				return
+			}
+		}
+		all = append(all, category)
+	}
+
+	if flags&FlagWrite != 0 {
+		add("write")
+	}
+	if flags&FlagReadonly != 0 {
+		add("read")
+	}
+	if flags&FlagAdmin != 0 {
+		add("admin")
+		add("dangerous")
+	}
+	if flags&FlagPubsub != 0 {
+		add("pubsub")
+	}
+	if flags&FlagFast != 0 {
+		add("fast")
+	} else {
+		add("slow")
+	}
+	for _, category := range categories {
+		add(category)
+	}
+	return all
+}
+
+// CommandInfo 一个已注册的命令的元数据, 含义与 RegisterCommand 的参数相同.
+// Categories 包括由标志决定的分类.
+type CommandInfo struct {
+	Name       string
+	Arity      int
+	Flags      Flag
+	Keys       KeySpec
+	Categories []string
+}
+
+func (c *command) info(name string) CommandInfo {
+	return CommandInfo{
+		Name:       name,
+		Arity:      c.arity,
+		Flags:      c.flags,
+		Keys:       c.keys,
+		Categories: c.categories,
+	}
+}
+
+// Commands 按名字的顺序返回全部已注册的命令
+func Commands() []CommandInfo {
+	infos := make([]CommandInfo, 0, len(cmdTable))
+	for name, cmd := range cmdTable {
+		infos = append(infos, cmd.info(name))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// Lookup 返回命令的元数据, 命令名不区分大小写
+func Lookup(cmdName string) (CommandInfo, bool) {
+	cmdName = strings.ToLower(cmdName)
+	cmd, exist := cmdTable[cmdName]
+	if !exist {
+		return CommandInfo{}, false
 	}
+	return cmd.info(cmdName), true
+}
+
+// HasFlag 返回命令是否设置了 flag. cmdName 必须是小写的, 未注册的命令没有任何标志.
+func HasFlag(cmdName string, flag Flag) bool {
+	cmd, exist := cmdTable[cmdName]
+	return exist && cmd.flags&flag != 0
+}
+
+// ValidateArity 校验命令参数的数量是否正确
+func (info CommandInfo) ValidateArity(cmdLine CmdLine) bool {
+	return (&command{arity: info.Arity}).validateArity(cmdLine)
 }
 
 // CommandExecutor 命令所对应的要执行的函数
@@ -53,6 +236,10 @@ type command struct {
 	// 详见 validateArity 函数.
 	// for example: the arity of `get` is 2, `mget` is -2
 	arity int
+
+	flags      Flag
+	keys       KeySpec
+	categories []string
 }
 
 // validateArity 校验命令参数的数量是否正确
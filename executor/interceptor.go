@@ -0,0 +1,77 @@
+package executor
+
+import (
+	"simple_kvstorage/resp/reply"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Call 一次命令的执行, 拦截器通过它得到命令的信息
+type Call struct {
+	// Client 发起命令的客户端, 在 core.Handler 中是 *core.Client.
+	// 不是由客户端发起的执行 (如加载 AOF, 应用主节点传播的命令) 为 nil.
+	Client any
+	// DBIndex 执行命令的数据库的编号
+	DBIndex int
+	// Name 小写的命令名
+	Name    string
+	CmdLine CmdLine
+	// Start 开始执行的时间, 由 Chain.Invoke 设置. 拦截器在 next 返回之后用 time.Since(call.Start) 计算耗时.
+	Start time.Time
+}
+
+// Interceptor 拦截命令的执行. 调用 next 执行之后的拦截器与命令本身并得到回复, 拦截器可以修改回复之后再返回;
+// 不调用 next 而直接返回错误回复则拒绝执行命令.
+type Interceptor func(call *Call, next func() reply.Reply) reply.Reply
+
+// Chain 按加入的顺序嵌套执行的一组拦截器, 先加入的在外层. 零值可以直接使用, 执行命令的同时也可以加入拦截器.
+type Chain struct {
+	mutex        sync.Mutex
+	interceptors atomic.Pointer[[]Interceptor]
+}
+
+// Use 在最内层加入拦截器
+func (c *Chain) Use(interceptors ...Interceptor) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var all []Interceptor
+	if old := c.interceptors.Load(); old != nil {
+		all = append(all, *old...)
+	}
+	all = append(all, interceptors...)
+	c.interceptors.Store(&all)
+}
+
+// empty 返回是否没有任何拦截器
+func (c *Chain) empty() bool {
+	return c.interceptors.Load() == nil
+}
+
+// Invoke 依次经过全部拦截器之后调用 exec 执行命令. 没有拦截器时直接调用 exec.
+func (c *Chain) Invoke(call *Call, exec func() reply.Reply) reply.Reply {
+	interceptors := c.interceptors.Load()
+	if interceptors == nil {
+		return exec()
+	}
+	call.Start = time.Now()
+	return invoke(*interceptors, call, exec)
+}
+
+func invoke(interceptors []Interceptor, call *Call, exec func() reply.Reply) reply.Reply {
+	if len(interceptors) == 0 {
+		return exec()
+	}
+	return interceptors[0](call, func() reply.Reply {
+		return invoke(interceptors[1:], call, exec)
+	})
+}
+
+// chain 在 Exec 中执行的拦截器
+var chain Chain
+
+// Use 加入在 Exec 中执行的拦截器. 它们对所有经 Exec 执行的命令生效, 包括加载 AOF 与应用主节点传播的命令,
+// 此时 Call.Client 为 nil. 只需要拦截客户端发起的命令时使用 core.Handler 的 Use.
+func Use(interceptors ...Interceptor) {
+	chain.Use(interceptors...)
+}
@@ -1,15 +1,10 @@
 package main
 
 import (
-	"context"
 	"fmt"
-	"io"
 	"os"
 	"simple_kvstorage/config"
-	"simple_kvstorage/core"
-	"simple_kvstorage/database"
-	_ "simple_kvstorage/executor/command" // For execute the `init` function
-	"simple_kvstorage/persistent"
+	"simple_kvstorage/server"
 	"simple_kvstorage/tcp"
 	"simple_kvstorage/util/logger"
 )
@@ -28,40 +23,22 @@ func main() {
 	if fileInfo, err := os.Stat(configFile); err == nil && !fileInfo.IsDir() {
 		config.SetupConfig(configFile)
 	} else {
-		config.Properties = &config.ServerProperties{
-			Bind:       "0.0.0.0",
-			Port:       6379,
-			Databases:  16,
-			AppendOnly: false,
-		}
+		config.Properties.Bind = "0.0.0.0"
 	}
 
 	tcpConfig := &tcp.Config{
 		Address: fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port),
 	}
 
-	// 2.1. 创建数据存储引擎
-	dbs := make([]database.DB, config.Properties.Databases)
-	for i := range dbs {
-		dbs[i] = database.NewMapDB(i)
-	}
-
-	// 2.2. 创建持久化引擎
-	var aofPersistent persistent.Persistent
-	if config.Properties.AppendOnly {
-		aofPersistent = persistent.NewAofPersistent(config.Properties.AppendFilename, true)
+	// 2. 创建数据存储引擎, 加载持久化的数据, 创建命令处理器并开启配置的各种模式
+	s, err := server.Open(server.Options{Config: config.Properties})
+	if err != nil {
+		logger.Error(err)
+		return
 	}
 
-	// 2.3. 加载持久化的数据
-	aofHandler := core.NewHandler(dbs, nil)
-	persistent.LoadAof(config.Properties.AppendFilename, func(connection io.ReadWriteCloser) {
-		aofHandler.Handle(connection, context.Background())
-	})
-	aofHandler = nil // help GC
-
 	// 3. 启动 TCP 服务
-	coreHandler := core.NewHandler(dbs, aofPersistent)
-	err := tcp.ListenAndServe(tcpConfig, coreHandler)
+	err = tcp.ListenAndServe(tcpConfig, s.Handler())
 	if err != nil {
 		logger.Error(err)
 		return
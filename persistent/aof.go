@@ -1,112 +1,467 @@
 package persistent
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
+	"simple_kvstorage/database"
 	"simple_kvstorage/executor"
 	"simple_kvstorage/resp/reply"
 	"simple_kvstorage/util/logger"
+	"simple_kvstorage/util/sync/atomic"
 	"strconv"
 	"strings"
+	"sync"
+	goatomic "sync/atomic"
+	"time"
 )
 
 type Persistent interface {
-	Persistence(dbIndex int, cmdLine executor.CmdLine)
+	// Persistence 持久化刚刚执行成功的命令. fsync 策略为 always 时阻塞到命令被写入磁盘为止, 写入或 fsync 失败时返回错误.
+	Persistence(dbIndex int, cmdLine executor.CmdLine) error
+	Close() error
 }
 
-// cmdPersistent 记录了需要持久化的命令
-var cmdPersistent map[string]interface{}
+// ErrAofClosed AOF 持久化已经关闭, 之后的命令不再被写入文件
+var ErrAofClosed = errors.New("append only file is closed")
 
-func init() {
-	var cmd = []string{
-		"del",
-		"flushDB",
-		"rename",
-		"renameNx",
-		"set",
-		"setNx",
-		"getSet",
-	}
+// FsyncPolicy 将 AOF 文件刷入磁盘的策略
+type FsyncPolicy int
+
+const (
+	// FsyncEverySec 每秒 fsync 一次, 机器崩溃时最多丢失一秒的数据
+	FsyncEverySec FsyncPolicy = iota
+	// FsyncAlways 每次写入后都 fsync, 命令的回复会等到数据落盘之后
+	FsyncAlways
+	// FsyncNo 从不主动 fsync, 由操作系统决定何时落盘
+	FsyncNo
+)
+
+var fsyncPolicyNames = map[FsyncPolicy]string{
+	FsyncEverySec: "everysec",
+	FsyncAlways:   "always",
+	FsyncNo:       "no",
+}
+
+func (p FsyncPolicy) String() string {
+	return fsyncPolicyNames[p]
+}
 
-	cmdPersistent = make(map[string]interface{})
-	for i := range cmd {
-		cmdName := strings.ToLower(cmd[i])
-		cmdPersistent[cmdName] = struct{}{}
+// ParseFsyncPolicy 按名称解析 fsync 策略, 例如 "everysec"
+func ParseFsyncPolicy(name string) (FsyncPolicy, error) {
+	name = strings.ToLower(name)
+	for policy, policyName := range fsyncPolicyNames {
+		if policyName == name {
+			return policy, nil
+		}
 	}
+	return FsyncEverySec, errors.New("unknown appendfsync policy '" + name + "'")
+}
+
+// maxBatchSize 持久化协程一次最多合并写入的命令数量
+const maxBatchSize = 1 << 10
+
+// AofConfig AOF 持久化的配置
+type AofConfig struct {
+	// Dirname 存放 AOF 文件和清单的目录
+	Dirname string
+	// Filename AOF 文件名的前缀. 目录中没有清单时, 若存在这个旧版本的单个 AOF 文件, 会将其移入目录作为基础文件.
+	Filename string
+	// Fsync fsync 策略
+	Fsync FsyncPolicy
+	// AutoRewritePercentage 文件大小相比上次重写之后增长了多少百分比时自动重写, 0 表示不自动重写
+	AutoRewritePercentage int
+	// AutoRewriteMinSize 文件至少达到多少字节才会自动重写
+	AutoRewriteMinSize int64
 }
 
 type AofPersistent struct {
 	// 是否开启持久化
 	enable bool
-	// 持久化文件
+	config AofConfig
+	// dbs 重写时需要遍历的存储引擎
+	dbs []database.DB
+	// worldLock 持有时数据库不会被修改, 也不会有新的命令加入 aofChan, 见 SetWorldLock
+	worldLock sync.Locker
+
+	// mutex 保护持久化文件及重写相关的状态
+	mutex sync.Mutex
+	// manifest 当前有效的 AOF 文件清单
+	manifest *aofManifest
+	// aofFile 当前正在追加写入的增量文件
 	aofFile *os.File
 	// 当前数据库序号
 	currentDB int
+	// fsync 策略
+	fsync FsyncPolicy
+	// currentSize 全部文件的当前大小, baseSize 上次重写之后全部文件的大小, incrSize 当前增量文件的大小
+	currentSize int64
+	baseSize    int64
+	incrSize    int64
+	// rewriting 是否正在重写
+	rewriting    bool
+	rewriteStats rewriteStats
 
 	aofChan chan *aofCmd
+	// closing 防止在关闭 aofChan 之后继续向其发送命令
+	closing sync.RWMutex
+	closed  bool
+	// finished 持久化协程退出时关闭
+	finished chan struct{}
+	// background 正在运行的后台 fsync 与重写, 关闭文件之前需要等待它们结束
+	background sync.WaitGroup
+
+	// dirty 自上次 fsync 以来是否写入过数据
+	dirty atomic.Boolean
+	// syncing 是否正在 fsync
+	syncing atomic.Boolean
+	stats   fsyncStats
+}
+
+// fsyncStats fsync 的统计数据, 时间的单位是微秒
+type fsyncStats struct {
+	count        int64
+	lastLatency  int64
+	maxLatency   int64
+	totalLatency int64
+	delayed      int64
 }
 
-func NewAofPersistent(aofFilename string, enable bool) *AofPersistent {
-	p := &AofPersistent{enable: enable}
+// AofStats AOF 持久化的统计数据
+type AofStats struct {
+	FsyncPolicy FsyncPolicy
+	// CurrentSize, BaseSize 全部文件的当前大小和上次重写之后的大小
+	CurrentSize int64
+	BaseSize    int64
+	// RewriteInProgress 是否正在重写
+	RewriteInProgress bool
+	// BaseFile 当前的基础文件, IncrFiles 当前增量文件的数量
+	BaseFile  string
+	IncrFiles int
+	// Rewrites 重写成功的次数, LastRewriteError 上次重写失败的原因
+	Rewrites         int64
+	LastRewriteError error
+	// FsyncCount fsync 的次数
+	FsyncCount int64
+	// LastFsyncLatency, MaxFsyncLatency, AvgFsyncLatency fsync 的耗时
+	LastFsyncLatency time.Duration
+	MaxFsyncLatency  time.Duration
+	AvgFsyncLatency  time.Duration
+	// DelayedFsync 因为上一次 fsync 还没有完成而被推迟的 fsync 的次数
+	DelayedFsync int64
+}
+
+// NewAofPersistent 创建 AOF 持久化引擎, dbs 用于重写时生成快照
+func NewAofPersistent(config *AofConfig, dbs []database.DB) (*AofPersistent, error) {
+	// currentDB 为 -1 时, 先写一条 select 命令
+	p := &AofPersistent{enable: true, config: *config, fsync: config.Fsync, dbs: dbs, currentDB: -1, worldLock: new(sync.Mutex)}
 
 	var err error
-	p.aofFile, err = os.OpenFile(aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	p.manifest, err = openManifest(config.Dirname, config.Filename)
 	if err != nil {
-		return nil
+		return nil, err
+	}
+	if p.manifest.lastIncr() == nil {
+		manifest := p.manifest.clone()
+		manifest.addIncr()
+		if err := saveManifest(config.Dirname, manifest); err != nil {
+			return nil, err
+		}
+		p.manifest = manifest
 	}
 
+	p.aofFile, err = openAofFile(filepath.Join(config.Dirname, p.manifest.lastIncr().name))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range p.manifest.files() {
+		if info, err := os.Stat(filepath.Join(config.Dirname, f.name)); err == nil {
+			p.currentSize += info.Size()
+		}
+	}
+	if info, err := p.aofFile.Stat(); err == nil {
+		p.incrSize = info.Size()
+	}
+	p.baseSize = p.currentSize
+
 	// 开启一个持久化协程
 	p.aofChan = make(chan *aofCmd, 1<<8)
+	p.finished = make(chan struct{})
 	go p.persistenceFromChan()
-	return p
+	if p.fsync == FsyncEverySec {
+		p.background.Add(1)
+		go p.fsyncEverySecond()
+	}
+	return p, nil
+}
+
+// SetWorldLock 设置修改数据库时使用的锁. 修改数据库的一方在持有锁期间执行命令并调用 Persistence,
+// 重写持有锁时复制数据库并切换增量文件, 使快照与新的增量文件恰好衔接.
+func (p *AofPersistent) SetWorldLock(lock sync.Locker) {
+	p.worldLock = lock
 }
 
 type aofCmd struct {
 	dbIndex int
 	cmdLine executor.CmdLine
+	// done 不为 nil 时, 持久化协程在命令落盘之后关闭它, 写入或 fsync 失败的原因记录在 err 中
+	done chan struct{}
+	err  error
+	// switched 不为 nil 时这不是一条命令, 而是重写加入的标记. 持久化协程写完它之前的命令后切换增量文件, 并将结果发送给 switched.
+	switched chan error
 }
 
-// Persistence 持久化刚刚执行成功的命令
-func (p *AofPersistent) Persistence(dbIndex int, cmdLine executor.CmdLine) {
+// Persistence 持久化刚刚执行成功的命令.
+// fsync 策略为 always 时, 会阻塞到命令被写入磁盘为止, 并返回写入或 fsync 失败的原因.
+func (p *AofPersistent) Persistence(dbIndex int, cmdLine executor.CmdLine) error {
 	if p.enable && p.aofChan != nil {
 
-		// 判断命令是否需要持久化
+		// 只持久化写命令
 		cmdName := strings.ToLower(string(cmdLine[0]))
-		_, exist := cmdPersistent[cmdName]
-		if !exist {
-			return
+		if !executor.HasFlag(cmdName, executor.FlagWrite) {
+			return nil
 		}
 
-		p.aofChan <- &aofCmd{
+		cmd := &aofCmd{
 			dbIndex: dbIndex,
 			cmdLine: cmdLine,
 		}
+		if p.fsync == FsyncAlways {
+			cmd.done = make(chan struct{})
+		}
+
+		p.closing.RLock()
+		if p.closed {
+			p.closing.RUnlock()
+			return ErrAofClosed
+		}
+		p.aofChan <- cmd
+		p.closing.RUnlock()
+
+		if cmd.done != nil {
+			<-cmd.done
+			return cmd.err
+		}
 	}
+	return nil
 }
 
+// persistenceFromChan 不断从 aofChan 中取出命令写入文件.
+// 每次会取出管道中已有的全部命令一起写入, 在 always 策略下只需要 fsync 一次 (group commit).
 func (p *AofPersistent) persistenceFromChan() {
-	// 先写一条 select 0 命令
-	p.currentDB = -1
+	defer close(p.finished)
 
+	batch := make([]*aofCmd, 0, maxBatchSize)
 	for cmd := range p.aofChan {
-		// 数据库切换了则写入一条 select db 命令
-		if p.currentDB != cmd.dbIndex {
-			data := reply.NewMultiBulkReply(toCmdLine("select", strconv.Itoa(cmd.dbIndex))).ToBytes()
-			_, err := p.aofFile.Write(data)
-			if err != nil {
-				logger.Warn(err)
-				continue
+		batch = append(batch[:0], cmd)
+	drain:
+		for len(batch) < maxBatchSize && cmd.switched == nil {
+			select {
+			case next, ok := <-p.aofChan:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+				cmd = next
+			default:
+				break drain
+			}
+		}
+
+		// 切换增量文件的标记只会出现在一批命令的末尾, cmd 是这一批的最后一条
+		if cmd.switched != nil {
+			batch = batch[:len(batch)-1]
+		}
+		if len(batch) > 0 {
+			p.flushBatch(batch)
+		}
+		if cmd.switched != nil {
+			p.mutex.Lock()
+			cmd.switched <- p.switchIncr()
+			p.mutex.Unlock()
+		}
+	}
+}
+
+// flushBatch 写入一批命令, 按照 fsync 策略落盘, 然后通知等待的命令
+func (p *AofPersistent) flushBatch(batch []*aofCmd) {
+	err := p.writeBatch(batch)
+	if err == nil && p.fsync == FsyncAlways {
+		err = p.sync()
+	}
+	for _, cmd := range batch {
+		if cmd.done != nil {
+			cmd.err = err
+			close(cmd.done)
+		}
+	}
+}
+
+func openAofFile(filename string) (*os.File, error) {
+	return os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+}
+
+// EncodeCmd 编码一条命令追加到 data 中. 若 dbIndex 与 currentDB 不同, 则先追加一条 select 命令.
+// AOF 文件与主从复制的命令流使用相同的格式.
+func EncodeCmd(data []byte, currentDB *int, dbIndex int, cmdLine executor.CmdLine) []byte {
+	// 数据库切换了则写入一条 select db 命令
+	if *currentDB != dbIndex {
+		data = append(data, reply.NewMultiBulkReply(toCmdLine("select", strconv.Itoa(dbIndex))).ToBytes()...)
+		*currentDB = dbIndex
+	}
+	return append(data, reply.NewMultiBulkReply(cmdLine).ToBytes()...)
+}
+
+// writeBatch 将一批命令编码后一次性写入文件
+func (p *AofPersistent) writeBatch(batch []*aofCmd) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var data []byte
+	for _, cmd := range batch {
+		data = EncodeCmd(data, &p.currentDB, cmd.dbIndex, cmd.cmdLine)
+	}
+
+	n, err := p.aofFile.Write(data)
+	p.currentSize += int64(n)
+	p.incrSize += int64(n)
+	if err != nil {
+		logger.Warn(err)
+		// 无法确定哪些命令写入成功了, 下一批命令先重新 select
+		p.currentDB = -1
+		return err
+	}
+	p.dirty.Set(true)
+
+	if p.needAutoRewrite() {
+		p.background.Add(1)
+		go func() {
+			defer p.background.Done()
+			if err := p.doRewrite(); err != nil {
+				logger.Warn("AOF 自动重写失败.", err)
 			}
-			p.currentDB = cmd.dbIndex
+		}()
+	}
+	return nil
+}
+
+// sync 将文件刷入磁盘, 并记录耗时
+func (p *AofPersistent) sync() error {
+	if !p.dirty.Get() {
+		return nil
+	}
+	p.dirty.Set(false)
+
+	p.mutex.Lock()
+	file := p.aofFile
+	p.mutex.Unlock()
+
+	p.syncing.Set(true)
+	start := time.Now()
+	err := file.Sync()
+	latency := time.Since(start).Microseconds()
+	p.syncing.Set(false)
+	if errors.Is(err, os.ErrClosed) {
+		// 重写时切换到了新的增量文件, 旧文件在切换前已经落盘
+		return nil
+	}
+	if err != nil {
+		logger.Warn("AOF fsync 失败.", err)
+		p.dirty.Set(true)
+		return err
+	}
+
+	goatomic.AddInt64(&p.stats.count, 1)
+	goatomic.StoreInt64(&p.stats.lastLatency, latency)
+	goatomic.AddInt64(&p.stats.totalLatency, latency)
+	for {
+		max := goatomic.LoadInt64(&p.stats.maxLatency)
+		if latency <= max || goatomic.CompareAndSwapInt64(&p.stats.maxLatency, max, latency) {
+			break
 		}
+	}
+	return nil
+}
+
+// fsyncEverySecond everysec 策略下, 每秒在后台 fsync 一次.
+// 若上一次 fsync 还没有完成, 则推迟本次 fsync 并记录下来.
+func (p *AofPersistent) fsyncEverySecond() {
+	defer p.background.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
-		data := reply.NewMultiBulkReply(cmd.cmdLine).ToBytes()
-		_, err := p.aofFile.Write(data)
-		if err != nil {
-			logger.Warn(err)
+	for {
+		select {
+		case <-ticker.C:
+			if p.syncing.Get() {
+				goatomic.AddInt64(&p.stats.delayed, 1)
+				logger.Warn("上一次 AOF fsync 还没有完成, 推迟本次 fsync. 磁盘可能过于繁忙.")
+				continue
+			}
+			p.background.Add(1)
+			go func() {
+				defer p.background.Done()
+				_ = p.sync()
+			}()
+		case <-p.finished:
+			return
 		}
 	}
 }
 
+// Stats 返回 AOF 持久化的统计数据
+func (p *AofPersistent) Stats() AofStats {
+	stats := AofStats{
+		FsyncPolicy:      p.fsync,
+		FsyncCount:       goatomic.LoadInt64(&p.stats.count),
+		LastFsyncLatency: time.Duration(goatomic.LoadInt64(&p.stats.lastLatency)) * time.Microsecond,
+		MaxFsyncLatency:  time.Duration(goatomic.LoadInt64(&p.stats.maxLatency)) * time.Microsecond,
+		DelayedFsync:     goatomic.LoadInt64(&p.stats.delayed),
+	}
+	if stats.FsyncCount > 0 {
+		stats.AvgFsyncLatency = time.Duration(goatomic.LoadInt64(&p.stats.totalLatency)/stats.FsyncCount) * time.Microsecond
+	}
+
+	p.mutex.Lock()
+	stats.CurrentSize = p.currentSize
+	stats.BaseSize = p.baseSize
+	stats.RewriteInProgress = p.rewriting
+	if p.manifest.base != nil {
+		stats.BaseFile = p.manifest.base.name
+	}
+	stats.IncrFiles = len(p.manifest.incrs)
+	stats.Rewrites = p.rewriteStats.count
+	stats.LastRewriteError = p.rewriteStats.lastError
+	p.mutex.Unlock()
+	return stats
+}
+
+// Close 写完管道中剩余的命令, 等待后台的 fsync 与重写结束, 刷入磁盘后关闭文件
+func (p *AofPersistent) Close() error {
+	p.closing.Lock()
+	if p.closed {
+		p.closing.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.aofChan)
+	p.closing.Unlock()
+
+	<-p.finished
+	p.background.Wait()
+	var err error
+	if p.fsync != FsyncNo {
+		err = p.sync()
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if closeErr := p.aofFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
 func toCmdLine(cmd ...string) [][]byte {
 	args := make([][]byte, len(cmd))
 	for i, s := range cmd {
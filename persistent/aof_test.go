@@ -0,0 +1,143 @@
+package persistent
+
+import (
+	"os"
+	"path/filepath"
+	"simple_kvstorage/database"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestAofConfig 返回临时目录中的 AOF 配置, 以及第一个增量文件的路径
+func newTestAofConfig(t *testing.T, fsync FsyncPolicy) (*AofConfig, string) {
+	dir := t.TempDir()
+	config := &AofConfig{
+		Dirname:  filepath.Join(dir, "appendonlydir"),
+		Filename: filepath.Join(dir, "test.aof"),
+		Fsync:    fsync,
+	}
+	return config, filepath.Join(config.Dirname, "test.aof.1.incr.aof")
+}
+
+func TestAofPersistent_FsyncAlways(t *testing.T) {
+	config, filename := newTestAofConfig(t, FsyncAlways)
+	aof, err := NewAofPersistent(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 并发写入, Persistence 返回时命令必须已经落盘
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := "key" + strconv.Itoa(i)
+			if err := aof.Persistence(0, toCmdLine("set", key, "value")); err != nil {
+				t.Error(err)
+			}
+
+			data, _ := os.ReadFile(filename)
+			if !strings.Contains(string(data), key+"\r\n") {
+				t.Error("Persistence 返回时命令还没有写入文件.", key)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats := aof.Stats()
+	if stats.FsyncCount == 0 || stats.FsyncCount > 32 || stats.FsyncPolicy != FsyncAlways {
+		t.Error("fsync 统计数据错误.", stats)
+	}
+	if err := aof.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 关闭之后的命令被丢弃
+	if err := aof.Persistence(0, toCmdLine("set", "closed", "value")); err != ErrAofClosed {
+		t.Error("关闭之后应该返回 ErrAofClosed.", err)
+	}
+}
+
+func TestAofPersistent_WriteError(t *testing.T) {
+	config, _ := newTestAofConfig(t, FsyncAlways)
+	aof, err := NewAofPersistent(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = aof.Close() }()
+
+	// 文件被关闭之后写入失败, 等待落盘的命令需要收到错误
+	aof.mutex.Lock()
+	_ = aof.aofFile.Close()
+	aof.mutex.Unlock()
+	if err := aof.Persistence(0, toCmdLine("set", "a", "1")); err == nil {
+		t.Error("写入失败时应该返回错误.")
+	}
+}
+
+func TestAofPersistent_CloseWaitsRewrite(t *testing.T) {
+	config, _ := newTestAofConfig(t, FsyncEverySec)
+	dbs := newRdbTestDBs(1)
+	aof, err := NewAofPersistent(config, dbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		dbs[0].Put("key"+strconv.Itoa(i), &database.DataEntity{Data: []byte("value")})
+	}
+
+	if err := aof.BackgroundRewrite(); err != nil {
+		t.Fatal(err)
+	}
+	if err := aof.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Close 返回时重写已经结束, 之后不能再开始重写
+	if stats := aof.Stats(); stats.RewriteInProgress || stats.Rewrites != 1 {
+		t.Error("Close 没有等待重写结束.", stats)
+	}
+	if err := aof.Rewrite(); err != ErrAofClosed {
+		t.Error("关闭之后不能重写.", err)
+	}
+	if dbs := loadTestAof(t, config, 1); dbs[0].Size() != 1000 {
+		t.Error("重写之后的数据错误.", dbs[0].Size())
+	}
+}
+
+func TestAofPersistent_FsyncNo(t *testing.T) {
+	config, filename := newTestAofConfig(t, FsyncNo)
+	aof, err := NewAofPersistent(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aof.Persistence(1, toCmdLine("set", "a", "1"))
+	aof.Persistence(1, toCmdLine("get", "a"))
+	aof.Persistence(2, toCmdLine("del", "a"))
+	_ = aof.Close()
+
+	data, _ := os.ReadFile(filename)
+	expected := "*2\r\n$6\r\nselect\r\n$1\r\n1\r\n*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n" +
+		"*2\r\n$6\r\nselect\r\n$1\r\n2\r\n*2\r\n$3\r\ndel\r\n$1\r\na\r\n"
+	if string(data) != expected {
+		t.Error("AOF 文件内容错误.", strconv.Quote(string(data)))
+	}
+	if aof.Stats().FsyncCount != 0 {
+		t.Error("no 策略下不应该 fsync.")
+	}
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	for _, name := range []string{"always", "everysec", "no", "EverySec"} {
+		policy, err := ParseFsyncPolicy(name)
+		if err != nil || policy.String() != strings.ToLower(name) {
+			t.Error("ParseFsyncPolicy 测试失败.", name)
+		}
+	}
+	if _, err := ParseFsyncPolicy("sometimes"); err == nil {
+		t.Error("ParseFsyncPolicy 测试失败.")
+	}
+}
@@ -1,37 +1,261 @@
 package persistent
 
 import (
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"simple_kvstorage/database"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/resp"
+	"simple_kvstorage/resp/reply"
+	"simple_kvstorage/util/logger"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// LoadAof 加载持久化数据
-// aofFilename 持久化数据文件
-// handle 函数用于处理数据加载逻辑, 参数 connection 是一个流, 可以从中不断获取命令
-func LoadAof(aofFilename string, handle func(connection io.ReadWriteCloser)) {
-	handle(newLoadAofConn(aofFilename))
+// AofLoadConfig 加载 AOF 文件的配置
+type AofLoadConfig struct {
+	// LoadTruncated 文件末尾的命令不完整时 (例如写入时机器崩溃), 是否截断不完整的命令后继续加载
+	LoadTruncated bool
+	// IgnoreCorruption 文件中间损坏时, 是否丢弃损坏位置之后的全部内容后继续加载.
+	// 丢弃之前会将原文件备份为 "文件名.corrupt-时间戳".
+	IgnoreCorruption bool
 }
 
-type loadAofConn struct {
-	aofFile *os.File
+// AofLoadStats 加载 AOF 文件的统计数据
+type AofLoadStats struct {
+	// Commands 重放的命令总数, 不包括 select
+	Commands int
+	// CommandsPerDB 每个数据库重放的命令数
+	CommandsPerDB map[int]int
+	// Keys 从快照格式的基础文件中加载的键值对数量
+	Keys int
+	// TruncatedBytes 被截断的字节数
+	TruncatedBytes int64
 }
 
-func newLoadAofConn(aofFilename string) *loadAofConn {
-	file, err := os.Open(aofFilename)
+// AofCorruptionError AOF 文件中出现了无法解析的内容
+type AofCorruptionError struct {
+	// Filename 损坏的文件
+	Filename string
+	// Offset 第一条无法解析的命令在文件中的起始字节偏移
+	Offset int64
+	// CommandNumber 第一条无法解析的命令的序号, 从 1 开始
+	CommandNumber int
+	// Truncated 是否是文件末尾的命令不完整
+	Truncated bool
+	Err       error
+}
+
+func (e *AofCorruptionError) Error() string {
+	kind := "corrupted"
+	if e.Truncated {
+		kind = "truncated"
+	}
+	return fmt.Sprintf("AOF file %s is %s at offset %d (command #%d): %v", e.Filename, kind, e.Offset, e.CommandNumber, e.Err)
+}
+
+func (e *AofCorruptionError) Unwrap() error {
+	return e.Err
+}
+
+// LoadAof 加载持久化数据, 按清单依次加载 dirname 中的基础文件和增量文件.
+// 清单不存在时, 加载旧版本的单个 AOF 文件 filename; 两者都不存在时什么也不做.
+// 遇到无法解析的内容时返回 *AofCorruptionError, 除非 config 允许修复. 只有最后一个文件可以被修复.
+func LoadAof(dirname, filename string, dbs []database.DB, config *AofLoadConfig) (*AofLoadStats, error) {
+	stats := &AofLoadStats{CommandsPerDB: make(map[int]int)}
+
+	manifest, err := loadManifest(dirname, filepath.Base(filename))
+	if err != nil {
+		return stats, err
+	}
+	var paths []string
+	if manifest != nil {
+		for _, f := range manifest.files() {
+			paths = append(paths, filepath.Join(dirname, f.name))
+		}
+	} else if _, err := os.Stat(filename); err == nil {
+		paths = append(paths, filename)
+	}
+
+	start := time.Now()
+	for i, path := range paths {
+		last := i == len(paths)-1
+		if strings.HasSuffix(path, baseRdbSuffix) {
+			if err := loadRdbFile(path, dbs, stats); err != nil {
+				return stats, err
+			}
+			continue
+		}
+		if err := loadAofFile(path, last, dbs, config, stats); err != nil {
+			return stats, err
+		}
+	}
+
+	for dbIndex, n := range stats.CommandsPerDB {
+		logger.Info("AOF 加载: db"+strconv.Itoa(dbIndex), "重放了", n, "条命令.")
+	}
+	logger.Info("AOF 加载完成, 共加载了", len(paths), "个文件,", stats.Commands, "条命令, 耗时", time.Since(start))
+	return stats, nil
+}
+
+// loadRdbFile 加载快照格式的基础文件
+func loadRdbFile(path string, dbs []database.DB, stats *AofLoadStats) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	loaded, err := ReadRdb(file, dbs)
+	if err != nil {
+		return fmt.Errorf("load AOF base file %s: %v", path, err)
+	}
+	stats.Keys += loaded
+	return nil
+}
+
+// loadAofFile 重放一个命令格式的文件. last 表示是否是最后一个文件, 只有最后一个文件可以被修复.
+func loadAofFile(path string, last bool, dbs []database.DB, config *AofLoadConfig, stats *AofLoadStats) error {
+	file, err := os.Open(path)
 	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	corruption := replayAof(file, dbs, stats)
+	_ = file.Close()
+	if corruption == nil {
 		return nil
 	}
-	return &loadAofConn{aofFile: file}
+	corruption.Filename = path
+	if !last {
+		logger.Error(corruption.Error())
+		logger.Error("只有最后一个 AOF 文件可以被修复, 请使用 aof-check 工具检查.")
+		return corruption
+	}
+	return repairAof(path, info.Size(), corruption, config, stats)
 }
 
-func (l *loadAofConn) Read(b []byte) (n int, err error) {
-	return l.aofFile.Read(b)
+// replayAof 逐条解析并执行 reader 中的命令, 返回遇到的第一处损坏
+func replayAof(reader io.ReadCloser, dbs []database.DB, stats *AofLoadStats) *AofCorruptionError {
+	parseChan := resp.CreateParser(reader)
+	defer func() {
+		// 提前退出时解析协程可能还在等待发送, 关闭文件并清空管道使其退出
+		_ = reader.Close()
+		for range parseChan {
+		}
+	}()
+
+	dbIndex := 0
+	// lastOffset 最后一条完整的命令结束的位置
+	lastOffset := int64(0)
+	commandNumber := 0
+	for payload := range parseChan {
+		commandNumber++
+
+		if payload.Error != nil {
+			if payload.Error == io.EOF && payload.Offset == lastOffset {
+				// 文件正常结束
+				return nil
+			}
+			if payload.Error == io.EOF || payload.Error == io.ErrUnexpectedEOF {
+				return &AofCorruptionError{Offset: lastOffset, CommandNumber: commandNumber, Truncated: true, Err: payload.Error}
+			}
+			return &AofCorruptionError{Offset: lastOffset, CommandNumber: commandNumber, Err: payload.Error}
+		}
+
+		multiBulk, ok := payload.Data.(*reply.MultiBulkReply)
+		if !ok || len(multiBulk.Args) == 0 {
+			return &AofCorruptionError{
+				Offset:        lastOffset,
+				CommandNumber: commandNumber,
+				Err:           fmt.Errorf("unexpected reply '%s'", strings.TrimSpace(string(payload.Data.ToBytes()))),
+			}
+		}
+		lastOffset = payload.Offset
+
+		cmdLine := multiBulk.Args
+		if strings.ToLower(string(cmdLine[0])) == "select" {
+			index := -1
+			if len(cmdLine) == 2 {
+				index, _ = strconv.Atoi(string(cmdLine[1]))
+			}
+			if index < 0 || index >= len(dbs) {
+				return &AofCorruptionError{
+					Offset:        lastOffset,
+					CommandNumber: commandNumber,
+					Err:           fmt.Errorf("invalid DB index '%s'", cmdLine[1:]),
+				}
+			}
+			dbIndex = index
+			continue
+		}
+
+		result := executor.Exec(dbs[dbIndex], cmdLine)
+		if reply.IsErrorReply(result) {
+			logger.Warn("AOF 加载: 第", commandNumber, "条命令执行失败.", strings.TrimSpace(string(result.ToBytes())))
+		}
+		stats.Commands++
+		stats.CommandsPerDB[dbIndex]++
+	}
+	return nil
 }
 
-func (l *loadAofConn) Write(b []byte) (n int, err error) {
-	return len(b), nil
+// repairAof 按照配置处理 AOF 文件的损坏: 截断到最后一条完整的命令, 或者返回错误拒绝启动
+func repairAof(aofFilename string, size int64, corruption *AofCorruptionError, config *AofLoadConfig, stats *AofLoadStats) error {
+	logger.Warn(corruption.Error())
+
+	if corruption.Truncated {
+		if !config.LoadTruncated {
+			logger.Error("AOF 文件末尾的命令不完整. 设置 aof-load-truncated yes 以截断后启动, 或者使用 aof-check 工具修复.")
+			return corruption
+		}
+	} else {
+		if !config.IgnoreCorruption {
+			logger.Error("AOF 文件已损坏. 使用 aof-check 工具检查, 或者设置 aof-load-ignore-corruption yes 丢弃损坏位置之后的内容后启动.")
+			return corruption
+		}
+
+		backup := aofFilename + ".corrupt-" + strconv.FormatInt(time.Now().Unix(), 10)
+		if err := copyFile(aofFilename, backup); err != nil {
+			return err
+		}
+		logger.Warn("已将损坏的 AOF 文件备份为", backup)
+	}
+
+	if err := os.Truncate(aofFilename, corruption.Offset); err != nil {
+		return err
+	}
+	stats.TruncatedBytes = size - corruption.Offset
+	logger.Warn("AOF 文件已截断到", corruption.Offset, "字节, 丢弃了", stats.TruncatedBytes, "字节.")
+	return nil
 }
 
-func (l *loadAofConn) Close() error {
-	return l.aofFile.Close()
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
 }
@@ -0,0 +1,179 @@
+package persistent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"simple_kvstorage/database"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/resp/reply"
+	"strings"
+	"testing"
+)
+
+func init() {
+	// 测试中只需要 set 与 append 命令, 避免依赖 executor/command 包. del 与 get 只用于判断是否持久化, 只注册元数据.
+	executor.RegisterCommand("set", func(db database.DB, args [][]byte) reply.Reply {
+		db.Put(string(args[0]), &database.DataEntity{Data: args[1]})
+		return reply.GetOkReply()
+	}, 3, executor.FlagWrite, executor.KeySpec{First: 1, Last: 1, Step: 1})
+	// append 重放多次的结果与重放一次不同, 用于检查重写之后的命令不会被重复执行
+	executor.RegisterCommand("append", func(db database.DB, args [][]byte) reply.Reply {
+		db.Put(string(args[0]), &database.DataEntity{Data: []byte(getString(db, string(args[0])) + string(args[1]))})
+		return reply.GetOkReply()
+	}, 3, executor.FlagWrite, executor.KeySpec{First: 1, Last: 1, Step: 1})
+	executor.RegisterCommand("del", nil, -2, executor.FlagWrite, executor.KeySpec{First: 1, Last: -1, Step: 1})
+	executor.RegisterCommand("get", nil, 2, executor.FlagReadonly, executor.KeySpec{First: 1, Last: 1, Step: 1})
+}
+
+const validAof = "*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n" +
+	"*2\r\n$6\r\nselect\r\n$1\r\n1\r\n" +
+	"*3\r\n$3\r\nset\r\n$1\r\nb\r\n$1\r\n2\r\n" +
+	"*3\r\n$3\r\nset\r\n$1\r\nc\r\n$1\r\n3\r\n"
+
+func writeTestAof(t *testing.T, content string) string {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// testAofDir 返回与 filename 同目录的 appenddirname
+func testAofDir(filename string) string {
+	return filepath.Join(filepath.Dir(filename), "appendonlydir")
+}
+
+func TestLoadAof(t *testing.T) {
+	filename := writeTestAof(t, validAof)
+	dbs := newRdbTestDBs(2)
+	stats, err := LoadAof(testAofDir(filename), filename, dbs, &AofLoadConfig{})
+	if err != nil || stats.Commands != 3 || stats.CommandsPerDB[0] != 1 || stats.CommandsPerDB[1] != 2 {
+		t.Fatal("AOF 加载失败.", stats, err)
+	}
+	if dbs[0].Size() != 1 || dbs[1].Size() != 2 {
+		t.Error("AOF 加载的数据错误.")
+	}
+
+	// 文件不存在时什么也不做
+	if stats, err := LoadAof(testAofDir(filename), filename+".missing", dbs, &AofLoadConfig{}); err != nil || stats.Commands != 0 {
+		t.Error("AOF 文件不存在时不应该报错.", err)
+	}
+}
+
+func TestLoadAof_Truncated(t *testing.T) {
+	partial := "*3\r\n$3\r\nset\r\n$1\r\nd\r\n$5\r\nhel"
+	filename := writeTestAof(t, validAof+partial)
+
+	_, err := LoadAof(testAofDir(filename), filename, newRdbTestDBs(2), &AofLoadConfig{})
+	var corruption *AofCorruptionError
+	if !errors.As(err, &corruption) || !corruption.Truncated ||
+		corruption.Offset != int64(len(validAof)) || corruption.CommandNumber != 5 {
+		t.Fatal("不允许截断时应该返回错误.", err)
+	}
+
+	dbs := newRdbTestDBs(2)
+	stats, err := LoadAof(testAofDir(filename), filename, dbs, &AofLoadConfig{LoadTruncated: true})
+	if err != nil || stats.Commands != 3 || stats.TruncatedBytes != int64(len(partial)) {
+		t.Fatal("截断后加载失败.", stats, err)
+	}
+	data, _ := os.ReadFile(filename)
+	if string(data) != validAof {
+		t.Error("AOF 文件没有被截断到最后一条完整的命令.")
+	}
+}
+
+func TestLoadAof_Corrupted(t *testing.T) {
+	corrupted := "*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n" +
+		"*2\r\n$x\r\nfoo\r\n" +
+		"*3\r\n$3\r\nset\r\n$1\r\nb\r\n$1\r\n2\r\n"
+	filename := writeTestAof(t, corrupted)
+
+	_, err := LoadAof(testAofDir(filename), filename, newRdbTestDBs(1), &AofLoadConfig{LoadTruncated: true})
+	var corruption *AofCorruptionError
+	if !errors.As(err, &corruption) || corruption.Truncated || corruption.Offset != 27 || corruption.CommandNumber != 2 {
+		t.Fatal("文件中间损坏时应该拒绝启动.", err)
+	}
+	if !strings.Contains(err.Error(), "offset 27") {
+		t.Error("错误信息中应该包含偏移量.", err)
+	}
+
+	dbs := newRdbTestDBs(1)
+	stats, err := LoadAof(testAofDir(filename), filename, dbs, &AofLoadConfig{IgnoreCorruption: true})
+	if err != nil || stats.Commands != 1 || dbs[0].Size() != 1 {
+		t.Fatal("强制加载失败.", stats, err)
+	}
+
+	backups, _ := filepath.Glob(filename + ".corrupt-*")
+	if len(backups) != 1 {
+		t.Fatal("没有备份损坏的文件.")
+	}
+	backup, _ := os.ReadFile(backups[0])
+	data, _ := os.ReadFile(filename)
+	if string(backup) != corrupted || string(data) != corrupted[:27] {
+		t.Error("备份或截断的内容错误.")
+	}
+}
+
+func TestLoadAof_MultiPart(t *testing.T) {
+	// 旧版本的单个 AOF 文件在打开时被移入目录作为基础文件
+	legacy := writeTestAof(t, validAof)
+	config := &AofConfig{Dirname: testAofDir(legacy), Filename: legacy, Fsync: FsyncNo}
+	aof, err := NewAofPersistent(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aof.Persistence(1, toCmdLine("set", "d", "4"))
+	_ = aof.Close()
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Error("旧版本的 AOF 文件没有被移走.")
+	}
+	manifest, _ := os.ReadFile(manifestPath(config.Dirname, "test.aof"))
+	if string(manifest) != "file test.aof.1.base.aof seq 1 type b\nfile test.aof.1.incr.aof seq 1 type i\n" {
+		t.Error("清单内容错误.", string(manifest))
+	}
+
+	dbs := newRdbTestDBs(2)
+	stats, err := LoadAof(config.Dirname, legacy, dbs, &AofLoadConfig{})
+	if err != nil || stats.Commands != 4 || dbs[1].Size() != 3 {
+		t.Fatal("加载多个 AOF 文件失败.", stats, err)
+	}
+
+	// 不是最后一个文件的末尾不完整时, 不能修复
+	incr := filepath.Join(config.Dirname, "test.aof.1.incr.aof")
+	base := filepath.Join(config.Dirname, "test.aof.1.base.aof")
+	if err := os.WriteFile(base, []byte(validAof+"*3\r\n$3\r\nset"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadAof(config.Dirname, legacy, newRdbTestDBs(2), &AofLoadConfig{LoadTruncated: true})
+	var corruption *AofCorruptionError
+	if !errors.As(err, &corruption) || corruption.Filename != base {
+		t.Error("基础文件损坏时应该拒绝启动.", err)
+	}
+	if data, _ := os.ReadFile(incr); len(data) == 0 {
+		t.Error("增量文件不应该被修改.")
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	data := "# comment\nfile a.aof.2.base.rdb seq 2 type b\n\nfile a.aof.3.incr.aof seq 3 type i future field\nfile a.aof.5.incr.aof seq 5 type i\n"
+	m, err := parseManifest("a.aof", []byte(data))
+	if err != nil || m.base.name != "a.aof.2.base.rdb" || !m.base.isRdb() || len(m.incrs) != 2 {
+		t.Fatal("解析清单失败.", err)
+	}
+	if m.nextBase().name != "a.aof.3.base.rdb" || m.addIncr().name != "a.aof.6.incr.aof" {
+		t.Error("分配的序号错误.")
+	}
+
+	for _, invalid := range []string{
+		"file a.aof.1.incr.aof seq 1",
+		"file a.aof.1.incr.aof seq 1 type x",
+		"file ../a.aof.1.incr.aof seq 1 type i",
+		"file a.aof.2.incr.aof seq 2 type i\nfile a.aof.1.incr.aof seq 1 type i",
+		"file a.aof.1.base.rdb seq 1 type b\nfile a.aof.2.base.rdb seq 2 type b",
+	} {
+		if _, err := parseManifest("a.aof", []byte(invalid)); err == nil {
+			t.Error("应该拒绝非法的清单.", invalid)
+		}
+	}
+}
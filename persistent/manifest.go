@@ -0,0 +1,292 @@
+package persistent
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"simple_kvstorage/util/logger"
+	"strconv"
+	"strings"
+)
+
+// AOF 由 appenddirname 目录下的多个文件组成:
+//
+//	<appendfilename>.<seq>.base.rdb   基础文件, 重写时生成的快照
+//	<appendfilename>.<seq>.base.aof   基础文件, 由旧版本的单个 AOF 文件升级而来
+//	<appendfilename>.<seq>.incr.aof   增量文件, 按 seq 顺序追加写命令
+//	<appendfilename>.manifest         清单, 记录了当前有效的文件
+//
+// 清单的每一行描述一个文件, 例如 "file appendonly.aof.1.base.rdb seq 1 type b".
+// 清单总是先写入临时文件再原子地替换, 不在清单中的文件都可以安全地删除或归档.
+
+// aofFileType 文件的类型
+type aofFileType byte
+
+const (
+	aofBaseFile aofFileType = 'b'
+	aofIncrFile aofFileType = 'i'
+)
+
+const (
+	baseRdbSuffix  = ".base.rdb"
+	baseAofSuffix  = ".base.aof"
+	incrAofSuffix  = ".incr.aof"
+	manifestSuffix = ".manifest"
+)
+
+// aofFileInfo 清单中的一个文件
+type aofFileInfo struct {
+	name string
+	seq  int64
+	typ  aofFileType
+}
+
+// isRdb 基础文件是否是快照格式
+func (f *aofFileInfo) isRdb() bool {
+	return strings.HasSuffix(f.name, baseRdbSuffix)
+}
+
+// aofManifest AOF 文件清单
+type aofManifest struct {
+	// filename 文件名前缀, 即 appendfilename
+	filename string
+	// base 基础文件, 为 nil 表示还没有重写过
+	base *aofFileInfo
+	// incrs 增量文件, 按 seq 升序排列
+	incrs []*aofFileInfo
+	// baseSeq, incrSeq 最近一次分配的序号
+	baseSeq int64
+	incrSeq int64
+}
+
+func newAofManifest(filename string) *aofManifest {
+	return &aofManifest{filename: filename}
+}
+
+// files 按加载顺序返回全部文件
+func (m *aofManifest) files() []*aofFileInfo {
+	files := make([]*aofFileInfo, 0, len(m.incrs)+1)
+	if m.base != nil {
+		files = append(files, m.base)
+	}
+	return append(files, m.incrs...)
+}
+
+// lastIncr 返回当前正在追加写入的增量文件, 没有时返回 nil
+func (m *aofManifest) lastIncr() *aofFileInfo {
+	if len(m.incrs) == 0 {
+		return nil
+	}
+	return m.incrs[len(m.incrs)-1]
+}
+
+// addIncr 分配一个新的增量文件
+func (m *aofManifest) addIncr() *aofFileInfo {
+	m.incrSeq++
+	incr := &aofFileInfo{name: m.filename + "." + strconv.FormatInt(m.incrSeq, 10) + incrAofSuffix, seq: m.incrSeq, typ: aofIncrFile}
+	m.incrs = append(m.incrs, incr)
+	return incr
+}
+
+// nextBase 分配一个新的快照格式的基础文件, 但不放入清单
+func (m *aofManifest) nextBase() *aofFileInfo {
+	m.baseSeq++
+	return &aofFileInfo{name: m.filename + "." + strconv.FormatInt(m.baseSeq, 10) + baseRdbSuffix, seq: m.baseSeq, typ: aofBaseFile}
+}
+
+// clone 复制清单, 修改副本不影响原清单
+func (m *aofManifest) clone() *aofManifest {
+	c := *m
+	c.incrs = append([]*aofFileInfo(nil), m.incrs...)
+	return &c
+}
+
+func (m *aofManifest) encode() []byte {
+	var builder strings.Builder
+	for _, f := range m.files() {
+		builder.WriteString(fmt.Sprintf("file %s seq %d type %c\n", f.name, f.seq, f.typ))
+	}
+	return []byte(builder.String())
+}
+
+// parseManifest 解析清单的内容
+func parseManifest(filename string, data []byte) (*aofManifest, error) {
+	m := newAofManifest(filename)
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		f, err := parseManifestLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AOF manifest line %d: %v", lineNumber, err)
+		}
+		switch f.typ {
+		case aofBaseFile:
+			if m.base != nil {
+				return nil, fmt.Errorf("invalid AOF manifest line %d: duplicate base file", lineNumber)
+			}
+			m.base = f
+			m.baseSeq = f.seq
+		case aofIncrFile:
+			if f.seq <= m.incrSeq {
+				return nil, fmt.Errorf("invalid AOF manifest line %d: incr files out of order", lineNumber)
+			}
+			m.incrs = append(m.incrs, f)
+			m.incrSeq = f.seq
+		}
+	}
+	return m, nil
+}
+
+// parseManifestLine 解析形如 "file <name> seq <seq> type <b|i>" 的一行
+func parseManifestLine(line string) (*aofFileInfo, error) {
+	fields := strings.Fields(line)
+	if len(fields)%2 != 0 {
+		return nil, errors.New("odd number of fields")
+	}
+
+	f := &aofFileInfo{}
+	for i := 0; i < len(fields); i += 2 {
+		value := fields[i+1]
+		switch fields[i] {
+		case "file":
+			if value != filepath.Base(value) {
+				return nil, fmt.Errorf("file name '%s' must not contain a path", value)
+			}
+			f.name = value
+		case "seq":
+			seq, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seq <= 0 {
+				return nil, fmt.Errorf("invalid seq '%s'", value)
+			}
+			f.seq = seq
+		case "type":
+			if value != string(aofBaseFile) && value != string(aofIncrFile) {
+				return nil, fmt.Errorf("unknown file type '%s'", value)
+			}
+			f.typ = aofFileType(value[0])
+		}
+		// 忽略未知的字段, 以便兼容之后的版本
+	}
+	if f.name == "" || f.seq == 0 || f.typ == 0 {
+		return nil, errors.New("missing file, seq or type")
+	}
+	return f, nil
+}
+
+// manifestPath 返回清单文件的路径
+func manifestPath(dirname, filename string) string {
+	return filepath.Join(dirname, filename+manifestSuffix)
+}
+
+// loadManifest 读取 dirname 中的清单, 清单不存在时返回 nil
+func loadManifest(dirname, filename string) (*aofManifest, error) {
+	data, err := os.ReadFile(manifestPath(dirname, filename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseManifest(filename, data)
+}
+
+// AofFile 清单中的一个文件, 供 aof-check 等工具按加载顺序检查
+type AofFile struct {
+	// Path 文件的路径
+	Path string
+	// Base 是否是基础文件, Rdb 是否是快照格式
+	Base bool
+	Rdb  bool
+}
+
+// ReadAofManifest 读取 dirname 中前缀为 filename 的清单, 按加载顺序返回其中的文件
+func ReadAofManifest(dirname, filename string) ([]AofFile, error) {
+	m, err := loadManifest(dirname, filename)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, fmt.Errorf("AOF manifest %s: %w", manifestPath(dirname, filename), os.ErrNotExist)
+	}
+	var files []AofFile
+	for _, f := range m.files() {
+		files = append(files, AofFile{Path: filepath.Join(dirname, f.name), Base: f.typ == aofBaseFile, Rdb: f.isRdb()})
+	}
+	return files, nil
+}
+
+// saveManifest 原子地替换 dirname 中的清单
+func saveManifest(dirname string, m *aofManifest) error {
+	tmpFile, err := os.CreateTemp(dirname, m.filename+manifestSuffix+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(m.encode())
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), manifestPath(dirname, m.filename))
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	return syncDir(dirname)
+}
+
+// syncDir 将目录刷入磁盘, 使其中文件的创建和重命名持久化
+func syncDir(dirname string) error {
+	dir, err := os.Open(dirname)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dir.Close() }()
+	return dir.Sync()
+}
+
+// openManifest 读取 dirname 中的清单. 目录或清单不存在时创建它们;
+// 若存在旧版本的单个 AOF 文件 legacyPath, 则将其移入目录作为基础文件.
+func openManifest(dirname, legacyPath string) (*aofManifest, error) {
+	filename := filepath.Base(legacyPath)
+	m, err := loadManifest(dirname, filename)
+	if m != nil || err != nil {
+		return m, err
+	}
+
+	if err := os.MkdirAll(dirname, 0700); err != nil {
+		return nil, err
+	}
+	m = newAofManifest(filename)
+	if info, err := os.Stat(legacyPath); err == nil && !info.IsDir() {
+		m.baseSeq++
+		m.base = &aofFileInfo{name: filename + "." + strconv.FormatInt(m.baseSeq, 10) + baseAofSuffix, seq: m.baseSeq, typ: aofBaseFile}
+		// 先链接 (或复制) 到目录中, 清单保存之后再删除旧文件, 任何一步失败旧文件都还在
+		basePath := filepath.Join(dirname, m.base.name)
+		_ = os.Remove(basePath)
+		if err := os.Link(legacyPath, basePath); err != nil {
+			if err := copyFile(legacyPath, basePath); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := saveManifest(dirname, m); err != nil {
+		return nil, err
+	}
+	if m.base != nil {
+		if err := os.Remove(legacyPath); err != nil {
+			return nil, err
+		}
+		logger.Info("已将 AOF 文件", legacyPath, "升级为", filepath.Join(dirname, m.base.name))
+	}
+	return m, nil
+}
@@ -0,0 +1,466 @@
+package persistent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"math"
+	"simple_kvstorage/database"
+	"strconv"
+	"time"
+)
+
+// 快照文件的格式:
+//
+//	header:  "SKVRDB" + 4 位十进制的版本号, 例如 "SKVRDB0001"
+//	db:      opSelectDB dbIndex opResizeDB size entry*
+//	entry:   [opExpireTimeMs unixMilli] valueType key value
+//	footer:  opEOF crc64
+//
+// 整数与长度使用 varint 编码, 字符串为 长度+字节. crc64 (ECMA) 覆盖 footer 之前的全部字节, 以小端序写入.
+const (
+	rdbMagic   = "SKVRDB"
+	rdbVersion = 1
+
+	opEOF          byte = 0xFF
+	opSelectDB     byte = 0xFE
+	opResizeDB     byte = 0xFB
+	opExpireTimeMs byte = 0xFC
+
+	// rdbTypeString []byte 类型的值
+	rdbTypeString byte = 0
+	// rdbTypeInt int 类型的值
+	rdbTypeInt byte = 1
+)
+
+// maxRdbStringLength 快照中字符串的最大长度, 防止损坏的文件导致分配过多的内存
+const maxRdbStringLength = 512 << 20
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// ErrRdbChecksum 快照文件的校验和不匹配
+var ErrRdbChecksum = errors.New("rdb checksum mismatch")
+
+// rdbEncoder 将数据编码为快照格式, 同时计算校验和
+type rdbEncoder struct {
+	writer *bufio.Writer
+	crc    hash.Hash64
+	buf    [binary.MaxVarintLen64]byte
+}
+
+func newRdbEncoder(writer io.Writer) *rdbEncoder {
+	crc := crc64.New(crcTable)
+	return &rdbEncoder{
+		writer: bufio.NewWriter(io.MultiWriter(writer, crc)),
+		crc:    crc,
+	}
+}
+
+func (e *rdbEncoder) writeByte(b byte) error {
+	return e.writer.WriteByte(b)
+}
+
+func (e *rdbEncoder) writeUvarint(n uint64) error {
+	l := binary.PutUvarint(e.buf[:], n)
+	_, err := e.writer.Write(e.buf[:l])
+	return err
+}
+
+func (e *rdbEncoder) writeVarint(n int64) error {
+	l := binary.PutVarint(e.buf[:], n)
+	_, err := e.writer.Write(e.buf[:l])
+	return err
+}
+
+func (e *rdbEncoder) writeString(s []byte) error {
+	if err := e.writeUvarint(uint64(len(s))); err != nil {
+		return err
+	}
+	_, err := e.writer.Write(s)
+	return err
+}
+
+func (e *rdbEncoder) writeHeader() error {
+	_, err := e.writer.WriteString(fmt.Sprintf("%s%04d", rdbMagic, rdbVersion))
+	return err
+}
+
+// writeDB 写入一个数据库. 空数据库不写入.
+func (e *rdbEncoder) writeDB(dbIndex int, db database.DB) error {
+	size := db.Size()
+	if size == 0 {
+		return nil
+	}
+	if err := e.writeDBHeader(dbIndex, size); err != nil {
+		return err
+	}
+
+	var err error
+	db.ForEach(func(key string, entity *database.DataEntity) bool {
+		err = e.writeEntry(key, entity)
+		return err == nil
+	})
+	return err
+}
+
+// writeDBHeader 写入数据库的序号与键值对的数量
+func (e *rdbEncoder) writeDBHeader(dbIndex int, size int) error {
+	if err := e.writeByte(opSelectDB); err != nil {
+		return err
+	}
+	if err := e.writeUvarint(uint64(dbIndex)); err != nil {
+		return err
+	}
+	if err := e.writeByte(opResizeDB); err != nil {
+		return err
+	}
+	return e.writeUvarint(uint64(size))
+}
+
+// writeEntry 写入一个键值对, 不支持的数据类型会被跳过
+func (e *rdbEncoder) writeEntry(key string, entity *database.DataEntity) error {
+	valueType, ok := rdbValueType(entity)
+	if !ok {
+		return nil
+	}
+	if err := e.writeByte(valueType); err != nil {
+		return err
+	}
+	if err := e.writeString([]byte(key)); err != nil {
+		return err
+	}
+	return e.writeValue(entity)
+}
+
+// rdbValueType 返回值的类型, 不支持的数据类型返回 false
+func rdbValueType(entity *database.DataEntity) (byte, bool) {
+	switch entity.Data.(type) {
+	case []byte:
+		return rdbTypeString, true
+	case int:
+		return rdbTypeInt, true
+	}
+	return 0, false
+}
+
+// writeValue 写入一个值, 不包括其类型
+func (e *rdbEncoder) writeValue(entity *database.DataEntity) error {
+	switch data := entity.Data.(type) {
+	case []byte:
+		return e.writeString(data)
+	case int:
+		return e.writeVarint(int64(data))
+	}
+	return nil
+}
+
+// writeFooter 写入 EOF 和校验和, 并将缓冲区中的数据全部写出
+func (e *rdbEncoder) writeFooter() error {
+	if err := e.writeByte(opEOF); err != nil {
+		return err
+	}
+	if err := e.writer.Flush(); err != nil {
+		return err
+	}
+
+	var checksum [8]byte
+	binary.LittleEndian.PutUint64(checksum[:], e.crc.Sum64())
+	_, err := e.writer.Write(checksum[:])
+	if err != nil {
+		return err
+	}
+	return e.writer.Flush()
+}
+
+// WriteRdb 将全部数据库以快照格式写入 writer
+func WriteRdb(writer io.Writer, dbs []database.DB) error {
+	encoder := newRdbEncoder(writer)
+	if err := encoder.writeHeader(); err != nil {
+		return err
+	}
+	for i, db := range dbs {
+		if err := encoder.writeDB(i, db); err != nil {
+			return err
+		}
+	}
+	return encoder.writeFooter()
+}
+
+// DBCopy 一个数据库在某一时刻的全部键值对, 由 CopyDBs 生成
+type DBCopy struct {
+	index   int
+	entries []dbCopyEntry
+}
+
+type dbCopyEntry struct {
+	key    string
+	entity *database.DataEntity
+}
+
+// CopyDBs 将全部数据库此刻的键值对复制到预先分配好的切片中, 之后由 WriteCopies 在释放锁之后写成一致的快照.
+// 修改 key 时总是整体替换它的 DataEntity, 因此只需要复制指针. 调用者需要保证复制期间数据库不被修改,
+// 即在复制期间阻塞写命令; 复制的耗时与 key 的数量成正比, 但不分配 key 与值的内存, 也不序列化.
+func CopyDBs(dbs []database.DB) []DBCopy {
+	copies := make([]DBCopy, len(dbs))
+	for i, db := range dbs {
+		entries := make([]dbCopyEntry, 0, db.Size())
+		db.ForEach(func(key string, entity *database.DataEntity) bool {
+			entries = append(entries, dbCopyEntry{key: key, entity: entity})
+			return true
+		})
+		copies[i] = DBCopy{index: i, entries: entries}
+	}
+	return copies
+}
+
+// WriteCopies 将 CopyDBs 复制的数据库以快照格式写入 writer, 格式与 WriteRdb 相同
+func WriteCopies(writer io.Writer, copies []DBCopy) error {
+	encoder := newRdbEncoder(writer)
+	if err := encoder.writeHeader(); err != nil {
+		return err
+	}
+	for _, c := range copies {
+		if len(c.entries) == 0 {
+			continue
+		}
+		if err := encoder.writeDBHeader(c.index, len(c.entries)); err != nil {
+			return err
+		}
+		for _, entry := range c.entries {
+			if err := encoder.writeEntry(entry.key, entry.entity); err != nil {
+				return err
+			}
+		}
+	}
+	return encoder.writeFooter()
+}
+
+// rdbDecoder 解码快照格式, 同时计算校验和
+type rdbDecoder struct {
+	reader *bufio.Reader
+	crc    hash.Hash64
+}
+
+func newRdbDecoder(reader io.Reader) *rdbDecoder {
+	return &rdbDecoder{
+		reader: bufio.NewReader(reader),
+		crc:    crc64.New(crcTable),
+	}
+}
+
+func (d *rdbDecoder) ReadByte() (byte, error) {
+	b, err := d.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	_, _ = d.crc.Write([]byte{b})
+	return b, nil
+}
+
+func (d *rdbDecoder) readFull(buf []byte) error {
+	if _, err := io.ReadFull(d.reader, buf); err != nil {
+		return err
+	}
+	_, _ = d.crc.Write(buf)
+	return nil
+}
+
+func (d *rdbDecoder) readUvarint() (uint64, error) {
+	return binary.ReadUvarint(d)
+}
+
+func (d *rdbDecoder) readVarint() (int64, error) {
+	return binary.ReadVarint(d)
+}
+
+func (d *rdbDecoder) readString() ([]byte, error) {
+	l, err := d.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if l > maxRdbStringLength {
+		return nil, fmt.Errorf("rdb string length %d too large", l)
+	}
+	s := make([]byte, l)
+	return s, d.readFull(s)
+}
+
+func (d *rdbDecoder) readHeader() error {
+	header := make([]byte, len(rdbMagic)+4)
+	if err := d.readFull(header); err != nil {
+		return err
+	}
+	if string(header[:len(rdbMagic)]) != rdbMagic {
+		return errors.New("wrong rdb signature")
+	}
+	version, err := strconv.Atoi(string(header[len(rdbMagic):]))
+	if err != nil || version < 1 || version > rdbVersion {
+		return fmt.Errorf("unsupported rdb version '%s'", header[len(rdbMagic):])
+	}
+	return nil
+}
+
+// readValue 按类型读取一个值
+func (d *rdbDecoder) readValue(valueType byte) (*database.DataEntity, error) {
+	switch valueType {
+	case rdbTypeString:
+		data, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		return &database.DataEntity{Data: data}, nil
+	case rdbTypeInt:
+		n, err := d.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		return &database.DataEntity{Data: int(n)}, nil
+	}
+	return nil, fmt.Errorf("unknown rdb value type %d", valueType)
+}
+
+// verifyChecksum 读取并校验 footer 中的校验和
+func (d *rdbDecoder) verifyChecksum() error {
+	expected := d.crc.Sum64()
+	var checksum [8]byte
+	if _, err := io.ReadFull(d.reader, checksum[:]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint64(checksum[:]) != expected {
+		return ErrRdbChecksum
+	}
+	return nil
+}
+
+// ReadRdb 从 reader 中读取快照, 加载到 dbs 中. 返回加载的键值对的数量.
+// 已经过期的键值对会被跳过.
+func ReadRdb(reader io.Reader, dbs []database.DB) (int, error) {
+	loaded := 0
+	err := ScanRdb(reader, func(dbIndex int, key string, entity *database.DataEntity, expireAt int64) error {
+		if dbIndex >= len(dbs) {
+			return fmt.Errorf("rdb db index %d is out of range", dbIndex)
+		}
+		if expireAt < 0 || expireAt > time.Now().UnixMilli() {
+			dbs[dbIndex].Put(key, entity)
+			loaded++
+		}
+		return nil
+	})
+	return loaded, err
+}
+
+// ScanRdb 逐个读取快照中的键值对并调用 fn, 最后校验文件末尾的校验和. expireAt 是毫秒时间戳, -1 表示没有过期时间.
+// fn 返回错误时停止读取并返回这个错误. 与 ReadRdb 不同, 已经过期的键值对也会传给 fn.
+func ScanRdb(reader io.Reader, fn func(dbIndex int, key string, entity *database.DataEntity, expireAt int64) error) error {
+	decoder := newRdbDecoder(reader)
+	if err := decoder.readHeader(); err != nil {
+		return err
+	}
+
+	dbIndex := 0
+	expireAt := int64(-1)
+	for {
+		op, err := decoder.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case opEOF:
+			return decoder.verifyChecksum()
+		case opSelectDB:
+			index, err := decoder.readUvarint()
+			if err != nil {
+				return err
+			}
+			if index > math.MaxInt32 {
+				return fmt.Errorf("rdb db index %d is out of range", index)
+			}
+			dbIndex = int(index)
+		case opResizeDB:
+			if _, err := decoder.readUvarint(); err != nil {
+				return err
+			}
+		case opExpireTimeMs:
+			if expireAt, err = decoder.readVarint(); err != nil {
+				return err
+			}
+		default:
+			key, err := decoder.readString()
+			if err != nil {
+				return err
+			}
+			entity, err := decoder.readValue(op)
+			if err != nil {
+				return err
+			}
+			if err := fn(dbIndex, string(key), entity, expireAt); err != nil {
+				return err
+			}
+			expireAt = -1
+		}
+	}
+}
+
+// ErrDumpPayload DUMP 的结果的版本或校验和错误
+var ErrDumpPayload = errors.New("ERROR DUMP payload version or checksum are wrong")
+
+// dumpFooterLength DUMP 的结果末尾的版本号 (2 字节) 和校验和 (8 字节) 的长度
+const dumpFooterLength = 2 + 8
+
+// DumpValue 将一个值序列化, 用于 DUMP 与 MIGRATE. 格式与快照中的值相同:
+//
+//	valueType value rdbVersion crc64
+//
+// rdbVersion 为 2 字节, crc64 覆盖之前的全部字节, 均以小端序写入. 不支持的数据类型返回错误.
+func DumpValue(entity *database.DataEntity) ([]byte, error) {
+	valueType, ok := rdbValueType(entity)
+	if !ok {
+		return nil, fmt.Errorf("unsupported value type %T", entity.Data)
+	}
+
+	var buf bytes.Buffer
+	encoder := newRdbEncoder(&buf)
+	if err := encoder.writeByte(valueType); err != nil {
+		return nil, err
+	}
+	if err := encoder.writeValue(entity); err != nil {
+		return nil, err
+	}
+	if err := encoder.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	payload := binary.LittleEndian.AppendUint16(buf.Bytes(), rdbVersion)
+	return binary.LittleEndian.AppendUint64(payload, crc64.Checksum(payload, crcTable)), nil
+}
+
+// RestoreValue 反序列化 DumpValue 的结果, 版本或校验和错误时返回 ErrDumpPayload
+func RestoreValue(payload []byte) (*database.DataEntity, error) {
+	if len(payload) < 1+dumpFooterLength {
+		return nil, ErrDumpPayload
+	}
+	body := payload[:len(payload)-8]
+	version := binary.LittleEndian.Uint16(body[len(body)-2:])
+	if version < 1 || version > rdbVersion || binary.LittleEndian.Uint64(payload[len(body):]) != crc64.Checksum(body, crcTable) {
+		return nil, ErrDumpPayload
+	}
+
+	value := body[:len(body)-2]
+	reader := bytes.NewReader(value[1:])
+	decoder := newRdbDecoder(reader)
+	entity, err := decoder.readValue(value[0])
+	if err != nil {
+		return nil, err
+	}
+	// 值之后不应该有多余的字节
+	if decoder.reader.Buffered() > 0 || reader.Len() > 0 {
+		return nil, ErrDumpPayload
+	}
+	return entity, nil
+}
@@ -0,0 +1,258 @@
+package persistent
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"simple_kvstorage/database"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newRdbTestDBs(n int) []database.DB {
+	dbs := make([]database.DB, n)
+	for i := range dbs {
+		dbs[i] = database.NewConcurrentDB(i)
+	}
+	return dbs
+}
+
+func TestRdb(t *testing.T) {
+	dbs := newRdbTestDBs(3)
+	for i := 0; i < 100; i++ {
+		dbs[0].Put("key"+strconv.Itoa(i), &database.DataEntity{Data: []byte("value" + strconv.Itoa(i))})
+	}
+	dbs[2].Put("int", &database.DataEntity{Data: -1024})
+	dbs[2].Put("empty", &database.DataEntity{Data: []byte{}})
+
+	var buffer bytes.Buffer
+	if err := WriteRdb(&buffer, dbs); err != nil {
+		t.Fatal(err)
+	}
+
+	loadedDBs := newRdbTestDBs(3)
+	loaded, err := ReadRdb(bytes.NewReader(buffer.Bytes()), loadedDBs)
+	if err != nil || loaded != 102 {
+		t.Fatal("快照加载失败.", loaded, err)
+	}
+	for i := 0; i < 100; i++ {
+		entity, exists := loadedDBs[0].Get("key" + strconv.Itoa(i))
+		if !exists || string(entity.Data.([]byte)) != "value"+strconv.Itoa(i) {
+			t.Error("快照加载的数据错误.", i)
+			return
+		}
+	}
+	if entity, _ := loadedDBs[2].Get("int"); entity == nil || entity.Data.(int) != -1024 {
+		t.Error("快照加载的整数错误.")
+	}
+	if entity, _ := loadedDBs[2].Get("empty"); entity == nil || len(entity.Data.([]byte)) != 0 {
+		t.Error("快照加载的空字符串错误.")
+	}
+	if loadedDBs[1].Size() != 0 {
+		t.Error("空数据库加载后应该仍然是空的.")
+	}
+
+	// 数据库数量不够时加载失败
+	if _, err := ReadRdb(bytes.NewReader(buffer.Bytes()), newRdbTestDBs(1)); err == nil {
+		t.Error("数据库序号越界时应该加载失败.")
+	}
+}
+
+func TestCopyDBs(t *testing.T) {
+	dbs := newRdbTestDBs(2)
+	for i := 0; i < 100; i++ {
+		dbs[1].Put("key"+strconv.Itoa(i), &database.DataEntity{Data: []byte("value" + strconv.Itoa(i))})
+	}
+	copies := CopyDBs(dbs)
+
+	// 复制之后的修改不影响副本
+	dbs[0].Put("added", &database.DataEntity{Data: []byte("x")})
+	dbs[1].Put("key0", &database.DataEntity{Data: []byte("changed")})
+	dbs[1].Remove("key1")
+
+	var buffer bytes.Buffer
+	if err := WriteCopies(&buffer, copies); err != nil {
+		t.Fatal(err)
+	}
+	loadedDBs := newRdbTestDBs(2)
+	if loaded, err := ReadRdb(bytes.NewReader(buffer.Bytes()), loadedDBs); err != nil || loaded != 100 {
+		t.Fatal("副本的快照加载失败.", loaded, err)
+	}
+	if loadedDBs[0].Size() != 0 {
+		t.Error("复制之后写入的 key 不应该出现在快照中.")
+	}
+	for _, key := range []string{"key0", "key1"} {
+		if entity, ok := loadedDBs[1].Get(key); !ok || string(entity.Data.([]byte)) != "value"+key[3:] {
+			t.Error("快照应该是复制时的数据.", key)
+		}
+	}
+}
+
+func TestRdb_Corrupted(t *testing.T) {
+	dbs := newRdbTestDBs(1)
+	dbs[0].Put("key", &database.DataEntity{Data: []byte("value")})
+	var buffer bytes.Buffer
+	_ = WriteRdb(&buffer, dbs)
+
+	data := buffer.Bytes()
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-12] ^= 0xFF
+	if _, err := ReadRdb(bytes.NewReader(corrupted), newRdbTestDBs(1)); err == nil {
+		t.Error("校验和不匹配时应该加载失败.")
+	}
+
+	if _, err := ReadRdb(bytes.NewReader(data[:len(data)-3]), newRdbTestDBs(1)); err == nil {
+		t.Error("文件不完整时应该加载失败.")
+	}
+
+	if _, err := ReadRdb(bytes.NewReader([]byte("REDIS0011")), newRdbTestDBs(1)); err == nil {
+		t.Error("文件头错误时应该加载失败.")
+	}
+}
+
+func TestRdb_Expired(t *testing.T) {
+	var buffer bytes.Buffer
+	encoder := newRdbEncoder(&buffer)
+	_ = encoder.writeHeader()
+	_ = encoder.writeByte(opSelectDB)
+	_ = encoder.writeUvarint(0)
+	for i, expireAt := range []int64{time.Now().Add(-time.Hour).UnixMilli(), time.Now().Add(time.Hour).UnixMilli()} {
+		_ = encoder.writeByte(opExpireTimeMs)
+		_ = encoder.writeVarint(expireAt)
+		_ = encoder.writeEntry("key"+strconv.Itoa(i), &database.DataEntity{Data: []byte("value")})
+	}
+	_ = encoder.writeFooter()
+
+	dbs := newRdbTestDBs(1)
+	loaded, err := ReadRdb(&buffer, dbs)
+	if err != nil || loaded != 1 {
+		t.Fatal("快照加载失败.", loaded, err)
+	}
+	if _, exists := dbs[0].Get("key0"); exists {
+		t.Error("已经过期的键值对不应该被加载.")
+	}
+}
+
+func TestDumpValue(t *testing.T) {
+	for _, entity := range []*database.DataEntity{{Data: []byte("hello")}, {Data: -42}} {
+		payload, err := DumpValue(entity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored, err := RestoreValue(payload)
+		if err != nil || !reflect.DeepEqual(restored, entity) {
+			t.Error("反序列化的结果错误.", restored, err)
+		}
+
+		payload[0] ^= 0xFF
+		if _, err := RestoreValue(payload); err != ErrDumpPayload {
+			t.Error("校验和错误时应该拒绝.", err)
+		}
+	}
+	if _, err := RestoreValue([]byte("short")); err != ErrDumpPayload {
+		t.Error("长度不足时应该拒绝.", err)
+	}
+}
+
+func TestParseSaveRules(t *testing.T) {
+	rules, err := ParseSaveRules("900 1 300 10")
+	if err != nil || len(rules) != 2 || rules[1] != (SaveRule{Seconds: 300, Changes: 10}) {
+		t.Error("ParseSaveRules 测试失败.", rules, err)
+	}
+	if rules, err := ParseSaveRules(""); err != nil || len(rules) != 0 {
+		t.Error("ParseSaveRules 测试失败.", rules, err)
+	}
+	for _, value := range []string{"900", "a 1", "0 1"} {
+		if _, err := ParseSaveRules(value); err == nil {
+			t.Error("ParseSaveRules 测试失败.", value)
+		}
+	}
+}
+
+func TestSnapshotter(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.rdb")
+	dbs := newRdbTestDBs(2)
+	snapshotter := NewSnapshotter(&SnapshotConfig{
+		Filename:  filename,
+		SaveRules: []SaveRule{{Seconds: 1, Changes: 1}},
+	}, dbs)
+	defer snapshotter.Close()
+
+	dbs[1].Put("key", &database.DataEntity{Data: []byte("value")})
+	snapshotter.AddDirty(1)
+
+	// 满足自动生成快照的规则
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if stats := snapshotter.Stats(); stats.ChangesSinceLastSave == 0 && !stats.SaveInProgress {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("没有自动生成快照.", snapshotter.Stats())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	dbs[0].Put("another", &database.DataEntity{Data: []byte("value")})
+	if err := snapshotter.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loadedDBs := newRdbTestDBs(2)
+	loaded, err := NewSnapshotter(&SnapshotConfig{Filename: filename}, loadedDBs).Load()
+	if err != nil || loaded != 2 {
+		t.Fatal("快照加载失败.", loaded, err)
+	}
+
+	// 快照文件不存在时什么也不做
+	if loaded, err := NewSnapshotter(&SnapshotConfig{Filename: filename + ".missing"}, loadedDBs).Load(); err != nil || loaded != 0 {
+		t.Error("快照文件不存在时不应该报错.", err)
+	}
+}
+
+func TestSnapshotter_PointInTime(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.rdb")
+	dbs := newRdbTestDBs(1)
+	snapshotter := NewSnapshotter(&SnapshotConfig{Filename: filename}, dbs)
+	defer snapshotter.Close()
+	var worldLock sync.Mutex
+	snapshotter.SetWorldLock(&worldLock)
+	for i := 0; i < 10000; i++ {
+		dbs[0].Put("filler"+strconv.Itoa(i), &database.DataEntity{Data: []byte("value")})
+	}
+
+	// 与 RENAME 相同, token 在持有锁时从一个 key 移到另一个 key, 快照中必须恰好有一个 token
+	dbs[0].Put("token0", &database.DataEntity{Data: []byte("token")})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			worldLock.Lock()
+			entity, _ := dbs[0].Get("token" + strconv.Itoa(i%100))
+			dbs[0].Remove("token" + strconv.Itoa(i%100))
+			dbs[0].Put("token"+strconv.Itoa((i+1)%100), entity)
+			worldLock.Unlock()
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	for i := 0; i < 5; i++ {
+		if err := snapshotter.Save(); err != nil {
+			t.Fatal(err)
+		}
+		loadedDBs := newRdbTestDBs(1)
+		if loaded, err := NewSnapshotter(&SnapshotConfig{Filename: filename}, loadedDBs).Load(); err != nil || loaded != 10001 {
+			t.Fatal("快照不是同一时刻的数据.", loaded, err)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package persistent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"simple_kvstorage/util/logger"
+	"strconv"
+	"time"
+)
+
+// ErrRewriteInProgress 已经有一个重写正在进行中
+var ErrRewriteInProgress = errors.New("ERROR background append only file rewriting already in progress")
+
+// rewriteStats 重写的统计数据
+type rewriteStats struct {
+	count     int64
+	lastError error
+}
+
+// BackgroundRewrite 在后台协程中重写 AOF 文件, 若已经在重写中则返回 ErrRewriteInProgress
+func (p *AofPersistent) BackgroundRewrite() error {
+	if err := p.startRewrite(); err != nil {
+		return err
+	}
+
+	go func() {
+		defer p.background.Done()
+		if err := p.doRewrite(); err != nil {
+			logger.Warn("AOF 重写失败.", err)
+		}
+	}()
+	return nil
+}
+
+// Rewrite 重写 AOF 文件, 直到重写完成才返回
+func (p *AofPersistent) Rewrite() error {
+	if err := p.startRewrite(); err != nil {
+		return err
+	}
+	defer p.background.Done()
+
+	return p.doRewrite()
+}
+
+// startRewrite 标记重写开始, 并将其记入 background, 使 Close 等待重写结束.
+// 已经关闭时返回 ErrAofClosed, 已经在重写中时返回 ErrRewriteInProgress.
+func (p *AofPersistent) startRewrite() error {
+	p.closing.RLock()
+	defer p.closing.RUnlock()
+	if p.closed {
+		return ErrAofClosed
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.rewriting {
+		return ErrRewriteInProgress
+	}
+	p.beginRewrite()
+	p.background.Add(1)
+	return nil
+}
+
+// needAutoRewrite 文件大小是否达到了自动重写的条件, 调用者需要持有 mutex.
+// 满足条件时会直接开始重写, 调用者需要在另一个协程中调用 doRewrite.
+func (p *AofPersistent) needAutoRewrite() bool {
+	if p.rewriting || p.config.AutoRewritePercentage <= 0 || p.currentSize < p.config.AutoRewriteMinSize {
+		return false
+	}
+
+	base := p.baseSize
+	if base == 0 {
+		base = 1
+	}
+	growth := (p.currentSize - base) * 100 / base
+	if growth < int64(p.config.AutoRewritePercentage) {
+		return false
+	}
+
+	logger.Info("AOF 文件大小增长了", strconv.FormatInt(growth, 10)+"%, 开始自动重写.")
+	p.beginRewrite()
+	return true
+}
+
+// beginRewrite 标记重写开始. 调用者需要持有 mutex.
+func (p *AofPersistent) beginRewrite() {
+	p.rewriting = true
+}
+
+// doRewrite 重写 AOF:
+//  1. 持有 worldLock, 在命令流中加入切换增量文件的标记, 并复制此刻的数据库. 标记之前的命令都已经反映在副本中,
+//     之后的命令都写入新的增量文件. 清单中同时保留旧文件, 此时崩溃不会丢失数据.
+//  2. 将副本写入新的基础文件. 这期间服务器照常处理命令.
+//  3. 原子地更新清单, 只保留新的基础文件和新的增量文件, 然后删除旧文件.
+func (p *AofPersistent) doRewrite() (err error) {
+	start := time.Now()
+	var tmpFile *os.File
+	defer func() {
+		if err == nil {
+			logger.Info("AOF 重写完成, 耗时", time.Since(start))
+			return
+		}
+		if tmpFile != nil {
+			_ = tmpFile.Close()
+			_ = os.Remove(tmpFile.Name())
+		}
+		p.mutex.Lock()
+		p.rewriting = false
+		p.rewriteStats.lastError = err
+		p.mutex.Unlock()
+	}()
+
+	// 1. 切换增量文件并复制数据库
+	p.worldLock.Lock()
+	switched := p.requestSwitch()
+	dbs := CopyDBs(p.dbs)
+	p.worldLock.Unlock()
+	if err = <-switched; err != nil {
+		return err
+	}
+
+	// 2. 生成快照
+	dirname := p.config.Dirname
+	tmpFile, err = os.CreateTemp(dirname, filepath.Base(p.config.Filename)+".rewrite-*.tmp")
+	if err != nil {
+		return err
+	}
+	if err = WriteCopies(tmpFile, dbs); err != nil {
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		return err
+	}
+	info, err := tmpFile.Stat()
+	if err != nil {
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// 3. 更新清单
+	base := p.manifest.nextBase()
+	basePath := filepath.Join(dirname, base.name)
+	if err = os.Rename(tmpFile.Name(), basePath); err != nil {
+		return err
+	}
+	manifest := p.manifest.clone()
+	manifest.base = base
+	manifest.incrs = manifest.incrs[len(manifest.incrs)-1:]
+	if err = saveManifest(dirname, manifest); err != nil {
+		tmpFile = nil
+		_ = os.Remove(basePath)
+		return err
+	}
+
+	obsolete := p.manifest.files()
+	obsolete = obsolete[:len(obsolete)-1]
+	p.manifest = manifest
+	p.baseSize = info.Size() + p.incrSize
+	p.currentSize = p.baseSize
+	p.rewriting = false
+	p.rewriteStats.count++
+	p.rewriteStats.lastError = nil
+
+	// 旧文件已经不在清单中了, 删除失败也不影响数据
+	for _, f := range obsolete {
+		if err := os.Remove(filepath.Join(dirname, f.name)); err != nil {
+			logger.Warn("删除旧的 AOF 文件失败.", err)
+		}
+	}
+	return nil
+}
+
+// requestSwitch 在命令流中加入切换增量文件的标记, 返回的管道会收到切换的结果.
+// 关闭之前开始的重写仍然需要完成, 此时不会再有新的命令, 等待持久化协程退出之后直接切换.
+func (p *AofPersistent) requestSwitch() <-chan error {
+	switched := make(chan error, 1)
+	p.closing.RLock()
+	defer p.closing.RUnlock()
+	if !p.closed {
+		p.aofChan <- &aofCmd{switched: switched}
+		return switched
+	}
+
+	<-p.finished
+	p.mutex.Lock()
+	switched <- p.switchIncr()
+	p.mutex.Unlock()
+	return switched
+}
+
+// switchIncr 创建一个新的增量文件并记入清单, 之后的写命令都追加到新文件中. 由持久化协程调用, 调用者需要持有 mutex.
+func (p *AofPersistent) switchIncr() error {
+	manifest := p.manifest.clone()
+	incr := manifest.addIncr()
+	file, err := openAofFile(filepath.Join(p.config.Dirname, incr.name))
+	if err != nil {
+		return err
+	}
+	if err := saveManifest(p.config.Dirname, manifest); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	// 旧文件在关闭前落盘, 之后的 fsync 只需要处理新文件
+	if err := p.aofFile.Sync(); err != nil {
+		logger.Warn("AOF fsync 失败.", err)
+	}
+	_ = p.aofFile.Close()
+	p.aofFile = file
+	p.manifest = manifest
+	p.currentDB = -1
+	p.incrSize = 0
+	return nil
+}
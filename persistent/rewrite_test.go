@@ -0,0 +1,171 @@
+package persistent
+
+import (
+	"os"
+	"simple_kvstorage/database"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// loadTestAof 将 AOF 加载到 n 个新的数据库中
+func loadTestAof(t *testing.T, config *AofConfig, n int) []database.DB {
+	dbs := newRdbTestDBs(n)
+	if _, err := LoadAof(config.Dirname, config.Filename, dbs, &AofLoadConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	return dbs
+}
+
+// getString 返回 key 的字符串值, 不存在时返回空字符串
+func getString(db database.DB, key string) string {
+	entity, ok := db.Get(key)
+	if !ok {
+		return ""
+	}
+	return string(entity.Data.([]byte))
+}
+
+func TestAofPersistent_Rewrite(t *testing.T) {
+	config, _ := newTestAofConfig(t, FsyncAlways)
+	dbs := newRdbTestDBs(2)
+	aof, err := NewAofPersistent(config, dbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := func(dbIndex int, key, value string) {
+		dbs[dbIndex].Put(key, &database.DataEntity{Data: []byte(value)})
+		aof.Persistence(dbIndex, toCmdLine("set", key, value))
+	}
+
+	// 同一个 key 被覆盖了很多次
+	for i := 0; i < 1000; i++ {
+		set(0, "counter", strconv.Itoa(i))
+	}
+	set(1, "a", "1")
+	before := aof.Stats().CurrentSize
+
+	// 重写期间并发写入的命令不能丢失
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			set(1, "concurrent"+strconv.Itoa(i), strconv.Itoa(i))
+		}
+	}()
+	if err := aof.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	set(0, "after", "rewrite")
+	_ = aof.Close()
+
+	stats := aof.Stats()
+	if stats.Rewrites != 1 || stats.RewriteInProgress || stats.BaseSize >= before ||
+		stats.BaseFile != "test.aof.1.base.rdb" || stats.IncrFiles != 1 {
+		t.Error("重写的统计数据错误.", stats)
+	}
+
+	// 旧的增量文件已经被删除, 清单中只有新的基础文件和增量文件
+	entries, _ := os.ReadDir(config.Dirname)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, " ") != "test.aof.1.base.rdb test.aof.2.incr.aof test.aof.manifest" {
+		t.Error("重写后的文件错误.", names)
+	}
+
+	loaded := loadTestAof(t, config, 2)
+	if getString(loaded[0], "counter") != "999" || getString(loaded[0], "after") != "rewrite" ||
+		getString(loaded[1], "a") != "1" || loaded[1].Size() != 101 {
+		t.Error("重写后的数据错误.", loaded[0].Keys(), loaded[1].Size())
+	}
+}
+
+func TestAofPersistent_RewriteAppend(t *testing.T) {
+	config, _ := newTestAofConfig(t, FsyncEverySec)
+	dbs := newRdbTestDBs(1)
+	aof, err := NewAofPersistent(config, dbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var worldLock sync.Mutex
+	aof.SetWorldLock(&worldLock)
+	// 足够多的 key 使生成快照需要一段时间, 期间有命令并发执行
+	for i := 0; i < 10000; i++ {
+		dbs[0].Put("filler"+strconv.Itoa(i), &database.DataEntity{Data: []byte("value")})
+	}
+	appendX := func() {
+		worldLock.Lock()
+		defer worldLock.Unlock()
+		dbs[0].Put("key", &database.DataEntity{Data: []byte(getString(dbs[0], "key") + "x")})
+		aof.Persistence(0, toCmdLine("append", "key", "x"))
+	}
+
+	// 每条 append 命令要么已经反映在快照中, 要么写入新的增量文件, 不能两者都有
+	stop := make(chan struct{})
+	appended := make(chan int)
+	go func() {
+		n := 0
+		for {
+			select {
+			case <-stop:
+				appended <- n
+				return
+			default:
+			}
+			appendX()
+			n++
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if err := aof.Rewrite(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	n := <-appended
+	_ = aof.Close()
+
+	if value := getString(loadTestAof(t, config, 1)[0], "key"); len(value) != n {
+		t.Error("重写之后的数据错误.", len(value), n)
+	}
+}
+
+func TestAofPersistent_AutoRewrite(t *testing.T) {
+	config, _ := newTestAofConfig(t, FsyncAlways)
+	config.AutoRewritePercentage = 100
+	config.AutoRewriteMinSize = 1024
+	dbs := newRdbTestDBs(1)
+	aof, err := NewAofPersistent(config, dbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = aof.Close() }()
+
+	// written 不重写时文件的大小
+	written := 0
+	for i := 0; i < 100; i++ {
+		cmdLine := toCmdLine("set", "key", strconv.Itoa(i))
+		dbs[0].Put("key", &database.DataEntity{Data: cmdLine[2]})
+		aof.Persistence(0, cmdLine)
+		written += len(reply.NewMultiBulkReply(cmdLine).ToBytes())
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if stats := aof.Stats(); stats.Rewrites > 0 && !stats.RewriteInProgress {
+			// 重写期间的写入会追加到新文件中, 所以只检查文件比不重写时小
+			if stats.CurrentSize >= int64(written) {
+				t.Error("自动重写之后文件仍然很大.", stats)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("没有触发自动重写.", aof.Stats())
+}
@@ -0,0 +1,252 @@
+package persistent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"simple_kvstorage/database"
+	"simple_kvstorage/util/logger"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSaveInProgress 已经有一个快照正在生成中
+var ErrSaveInProgress = errors.New("ERROR background save already in progress")
+
+// SaveRule 自动生成快照的规则: 距离上次生成快照至少经过了 Seconds 秒, 且至少有 Changes 次修改
+type SaveRule struct {
+	Seconds int
+	Changes int64
+}
+
+// ParseSaveRules 解析形如 "900 1 300 10 60 10000" 的规则, 空字符串表示不自动生成快照
+func ParseSaveRules(value string) ([]SaveRule, error) {
+	fields := strings.Fields(value)
+	if len(fields)%2 != 0 {
+		return nil, errors.New("invalid save rules '" + value + "'")
+	}
+
+	rules := make([]SaveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.Atoi(fields[i])
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds <= 0 || changes < 0 {
+			return nil, errors.New("invalid save rules '" + value + "'")
+		}
+		rules = append(rules, SaveRule{Seconds: seconds, Changes: changes})
+	}
+	return rules, nil
+}
+
+// SnapshotConfig 快照持久化的配置
+type SnapshotConfig struct {
+	// Filename 快照文件
+	Filename string
+	// SaveRules 自动生成快照的规则
+	SaveRules []SaveRule
+}
+
+// Snapshotter 将全部数据库保存为快照文件.
+// 生成快照时持有 worldLock 将每个数据库的键值对复制到切片中, 期间写命令被阻塞, 耗时与 key 的数量成正比;
+// 释放锁之后再序列化副本并写入文件, 这一步不阻塞写命令.
+type Snapshotter struct {
+	config SnapshotConfig
+	dbs    []database.DB
+	// worldLock 持有时数据库不会被修改, 见 SetWorldLock
+	worldLock sync.Locker
+
+	// dirty 自上次生成快照以来的修改次数
+	dirty int64
+
+	mutex      sync.Mutex
+	saving     bool
+	lastSave   time.Time
+	lastStatus error
+
+	closeChan chan struct{}
+}
+
+// SnapshotStats 快照持久化的统计数据
+type SnapshotStats struct {
+	// ChangesSinceLastSave 自上次生成快照以来的修改次数
+	ChangesSinceLastSave int64
+	// SaveInProgress 是否正在生成快照
+	SaveInProgress bool
+	// LastSave, LastSaveError 上次成功生成快照的时间, 上次生成快照失败的原因
+	LastSave      time.Time
+	LastSaveError error
+}
+
+// NewSnapshotter 创建快照持久化引擎, 若配置了自动生成快照的规则, 则开启一个协程定期检查
+func NewSnapshotter(config *SnapshotConfig, dbs []database.DB) *Snapshotter {
+	s := &Snapshotter{
+		config:    *config,
+		dbs:       dbs,
+		worldLock: new(sync.Mutex),
+		lastSave:  time.Now(),
+		closeChan: make(chan struct{}),
+	}
+	if len(s.config.SaveRules) > 0 {
+		go s.cron()
+	}
+	return s
+}
+
+// Load 加载快照文件, 文件不存在时什么也不做. 返回加载的键值对的数量.
+func (s *Snapshotter) Load() (int, error) {
+	file, err := os.Open(s.config.Filename)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = file.Close() }()
+
+	return ReadRdb(file, s.dbs)
+}
+
+// SetWorldLock 设置修改数据库时使用的锁. 生成快照时持有锁复制数据库, 使快照反映同一时刻的数据.
+func (s *Snapshotter) SetWorldLock(lock sync.Locker) {
+	s.worldLock = lock
+}
+
+// AddDirty 记录修改次数, 每成功执行一个写命令需要调用一次
+func (s *Snapshotter) AddDirty(n int64) {
+	atomic.AddInt64(&s.dirty, n)
+}
+
+// Save 生成快照, 直到快照写入磁盘后才返回
+func (s *Snapshotter) Save() error {
+	if err := s.beginSave(); err != nil {
+		return err
+	}
+	return s.doSave()
+}
+
+// BackgroundSave 在后台协程中生成快照
+func (s *Snapshotter) BackgroundSave() error {
+	if err := s.beginSave(); err != nil {
+		return err
+	}
+	go func() {
+		if err := s.doSave(); err != nil {
+			logger.Warn("生成快照失败.", err)
+		}
+	}()
+	return nil
+}
+
+// LastSave 返回上次成功生成快照的时间
+func (s *Snapshotter) LastSave() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastSave
+}
+
+// Stats 返回快照持久化的统计数据
+func (s *Snapshotter) Stats() SnapshotStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return SnapshotStats{
+		ChangesSinceLastSave: atomic.LoadInt64(&s.dirty),
+		SaveInProgress:       s.saving,
+		LastSave:             s.lastSave,
+		LastSaveError:        s.lastStatus,
+	}
+}
+
+// HasSaveRules 是否配置了自动生成快照的规则
+func (s *Snapshotter) HasSaveRules() bool {
+	return len(s.config.SaveRules) > 0
+}
+
+// Close 停止自动生成快照
+func (s *Snapshotter) Close() {
+	close(s.closeChan)
+}
+
+func (s *Snapshotter) beginSave() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.saving {
+		return ErrSaveInProgress
+	}
+	s.saving = true
+	return nil
+}
+
+// doSave 复制此刻的数据库, 释放锁之后将副本写入临时文件, 刷入磁盘后原子地替换快照文件
+func (s *Snapshotter) doSave() (err error) {
+	start := time.Now()
+	s.worldLock.Lock()
+	dirty := atomic.LoadInt64(&s.dirty)
+	dbs := CopyDBs(s.dbs)
+	s.worldLock.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		s.saving = false
+		s.lastStatus = err
+		if err == nil {
+			s.lastSave = start
+			atomic.AddInt64(&s.dirty, -dirty)
+		}
+		s.mutex.Unlock()
+	}()
+
+	filename := s.config.Filename
+	tmpFile, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".save-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmpFile.Close()
+			_ = os.Remove(tmpFile.Name())
+		}
+	}()
+
+	if err = WriteCopies(tmpFile, dbs); err != nil {
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpFile.Name(), filename); err != nil {
+		return err
+	}
+
+	logger.Info("快照已保存, 耗时", time.Since(start))
+	return nil
+}
+
+// cron 每秒检查一次自动生成快照的规则, 满足任意一条规则时在后台生成快照
+func (s *Snapshotter) cron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			dirty := atomic.LoadInt64(&s.dirty)
+			elapsed := time.Since(s.LastSave())
+			for _, rule := range s.config.SaveRules {
+				if dirty >= rule.Changes && dirty > 0 && elapsed >= time.Duration(rule.Seconds)*time.Second {
+					logger.Info(rule.Changes, "changes in", rule.Seconds, "seconds. Saving...")
+					if err := s.BackgroundSave(); err != nil && err != ErrSaveInProgress {
+						logger.Warn(err)
+					}
+					break
+				}
+			}
+		case <-s.closeChan:
+			return
+		}
+	}
+}
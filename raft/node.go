@@ -0,0 +1,980 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"simple_kvstorage/util/logger"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config 节点的配置
+type Config struct {
+	// ID 节点的 ID, 使用网络传输时是节点的地址
+	ID string
+	// Peers 集群初次启动时的其他成员. 之后的成员以日志和快照中的为准.
+	Peers []string
+	// Join 为 true 时节点以非成员的身份启动, 等待领导者通过成员变更将其加入集群
+	Join bool
+
+	// ElectionTimeout 选举超时的下限, 实际的超时时间在 [ElectionTimeout, 2*ElectionTimeout) 之间随机
+	ElectionTimeout time.Duration
+	// HeartbeatInterval 领导者发送心跳的间隔, 需要远小于 ElectionTimeout
+	HeartbeatInterval time.Duration
+	// SnapshotThreshold 快照之后应用了多少个条目时生成新的快照并压缩日志, 0 表示不压缩
+	SnapshotThreshold uint64
+	// MaxEntriesPerRPC 一次 AppendEntries 最多发送的条目数量
+	MaxEntriesPerRPC int
+
+	Transport    Transport
+	Storage      Storage
+	StateMachine StateMachine
+}
+
+// proposal 等待提交的条目
+type proposal struct {
+	term   uint64
+	done   bool
+	result any
+	err    error
+}
+
+// Node 一个 Raft 节点. 写命令经 Propose 追加到领导者的日志中, 复制到多数成员之后才提交并应用到状态机.
+type Node struct {
+	id     string
+	config Config
+
+	mutex sync.Mutex
+	// changed 状态发生变化时广播, 用于等待提交, 应用, 心跳确认等
+	changed *sync.Cond
+
+	role     Role
+	term     uint64
+	votedFor string
+	leader   string
+	// log 第一个条目是快照的最后一个条目 (只有 Index 与 Term 有意义)
+	log []Entry
+
+	// members 当前的成员 (日志中最后一个成员变更, 不论是否已经提交), configIndex 是该变更的序号
+	members         []string
+	configIndex     uint64
+	snapshotMembers []string
+	bootstrap       []string
+	snapshot        *Snapshot
+	// pendingSnapshot 从领导者收到的, 还没有应用到状态机的快照
+	pendingSnapshot *Snapshot
+
+	commitIndex uint64
+	lastApplied uint64
+
+	// lastContact 上次收到领导者消息或开始选举的时间, electionTimeout 本轮的选举超时
+	lastContact     time.Time
+	electionTimeout time.Duration
+
+	// 领导者的状态
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	// ackTime 每个成员上次成功回复的 AppendEntries 的发送时间, 用于确认领导者的身份
+	ackTime map[string]time.Time
+	// peerSignals 每个成员的复制协程, 有新的条目时发送信号
+	peerSignals map[string]chan struct{}
+	proposals   map[uint64]*proposal
+
+	stopped bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewNode 创建并启动节点. 存储中有快照时会先用它恢复状态机.
+func NewNode(config *Config) (*Node, error) {
+	if config.ElectionTimeout <= 0 {
+		config.ElectionTimeout = time.Second
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = config.ElectionTimeout / 10
+	}
+	if config.MaxEntriesPerRPC <= 0 {
+		config.MaxEntriesPerRPC = 256
+	}
+
+	n := &Node{
+		id:          config.ID,
+		config:      *config,
+		log:         []Entry{{}},
+		lastContact: time.Now(),
+		proposals:   make(map[uint64]*proposal),
+		done:        make(chan struct{}),
+	}
+	n.changed = sync.NewCond(&n.mutex)
+	n.resetElectionTimeout()
+	if !config.Join {
+		n.bootstrap = append([]string{config.ID}, config.Peers...)
+	}
+
+	// 1. 快照
+	snapshot, err := config.Storage.LoadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	if snapshot != nil {
+		if err := config.StateMachine.Restore(snapshot.Data); err != nil {
+			return nil, err
+		}
+		n.snapshot = snapshot
+		n.snapshotMembers = snapshot.Members
+		n.log[0] = Entry{Index: snapshot.Index, Term: snapshot.Term}
+		n.commitIndex = snapshot.Index
+		n.lastApplied = snapshot.Index
+	}
+
+	// 2. 任期与投票
+	state, err := config.Storage.LoadState()
+	if err != nil {
+		return nil, err
+	}
+	if state != nil {
+		n.term, n.votedFor = state.Term, state.VotedFor
+	}
+
+	// 3. 日志, 丢弃已经被快照覆盖的条目
+	entries, err := config.Storage.LoadEntries()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Index == n.lastIndex()+1 {
+			n.log = append(n.log, entry)
+		}
+	}
+	n.updateMembers()
+
+	n.wg.Add(2)
+	go n.runTicker()
+	go n.runApplier()
+	return n, nil
+}
+
+/* --- 日志 --- */
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.log[len(n.log)-1].Term
+}
+
+// termAt 返回 index 处的条目的任期, 条目不存在或已经被压缩时返回 0
+func (n *Node) termAt(index uint64) uint64 {
+	if index < n.log[0].Index || index > n.lastIndex() {
+		return 0
+	}
+	return n.log[index-n.log[0].Index].Term
+}
+
+// entriesFrom 返回从 index 开始的至多 limit 个条目的副本
+func (n *Node) entriesFrom(index uint64, limit int) []Entry {
+	start := index - n.log[0].Index
+	end := uint64(len(n.log))
+	if end-start > uint64(limit) {
+		end = start + uint64(limit)
+	}
+	return append([]Entry(nil), n.log[start:end]...)
+}
+
+// persistState 持久化任期与投票. 调用者需要持有 mutex.
+func (n *Node) persistState() {
+	if err := n.config.Storage.SaveState(&HardState{Term: n.term, VotedFor: n.votedFor}); err != nil {
+		// 无法持久化时继续运行可能违反安全性, 只能退出
+		logger.Fatal("Raft 持久化状态失败.", err)
+	}
+}
+
+// persistEntries 持久化刚刚追加到日志末尾的 entries, 它们覆盖之前保存的序号相同的条目. 调用者需要持有 mutex.
+func (n *Node) persistEntries(entries []Entry) {
+	if err := n.config.Storage.AppendEntries(entries); err != nil {
+		logger.Fatal("Raft 持久化日志失败.", err)
+	}
+}
+
+// persistCompaction 压缩日志之后, 只保留快照之后的条目. 调用者需要持有 mutex.
+func (n *Node) persistCompaction() {
+	if err := n.config.Storage.ResetEntries(n.log[1:]); err != nil {
+		logger.Fatal("Raft 压缩日志失败.", err)
+	}
+}
+
+// updateMembers 根据日志中最后一个成员变更更新成员, 调用者需要持有 mutex
+func (n *Node) updateMembers() {
+	for i := len(n.log) - 1; i > 0; i-- {
+		if n.log[i].Type == EntryConfig {
+			var members []string
+			if err := decode(n.log[i].Data, &members); err == nil {
+				n.members, n.configIndex = members, n.log[i].Index
+				return
+			}
+		}
+	}
+	n.configIndex = 0
+	if n.snapshot != nil {
+		n.members = n.snapshotMembers
+	} else {
+		n.members = n.bootstrap
+	}
+}
+
+// membersAt 返回 index 处生效的成员, 用于生成快照. 调用者需要持有 mutex.
+func (n *Node) membersAt(index uint64) []string {
+	for i := index - n.log[0].Index; i > 0; i-- {
+		if n.log[i].Type == EntryConfig {
+			var members []string
+			if err := decode(n.log[i].Data, &members); err == nil {
+				return members
+			}
+		}
+	}
+	if n.snapshot != nil {
+		return n.snapshotMembers
+	}
+	return n.bootstrap
+}
+
+func (n *Node) isMember(id string) bool {
+	for _, member := range n.members {
+		if member == id {
+			return true
+		}
+	}
+	return false
+}
+
+// quorum 当前成员的多数
+func (n *Node) quorum() int {
+	return len(n.members)/2 + 1
+}
+
+/* --- 角色转换 --- */
+
+func (n *Node) resetElectionTimeout() {
+	n.electionTimeout = n.config.ElectionTimeout + time.Duration(rand.Int63n(int64(n.config.ElectionTimeout)))
+}
+
+// becomeFollower 转为跟随者, term 更大时更新任期并清除投票. 调用者需要持有 mutex.
+func (n *Node) becomeFollower(term uint64) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.persistState()
+	}
+	if n.role == Leader {
+		logger.Info("Raft 节点", n.id, "不再是领导者, 任期", n.term)
+		// 尚未应用的提案的结果未知
+		for index, p := range n.proposals {
+			if !p.done {
+				p.done, p.err = true, ErrLeadershipLost
+				delete(n.proposals, index)
+			}
+		}
+	}
+	n.role = Follower
+	n.peerSignals = nil
+	n.changed.Broadcast()
+}
+
+// startElection 开始一轮选举. 调用者需要持有 mutex.
+func (n *Node) startElection() {
+	n.role = Candidate
+	n.term++
+	n.votedFor = n.id
+	n.leader = ""
+	n.lastContact = time.Now()
+	n.resetElectionTimeout()
+	n.persistState()
+	logger.Info("Raft 节点", n.id, "开始选举, 任期", n.term)
+
+	term := n.term
+	args := &RequestVoteArgs{Term: term, Candidate: n.id, LastLogIndex: n.lastIndex(), LastLogTerm: n.lastTerm()}
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+
+	for _, member := range n.members {
+		if member == n.id {
+			continue
+		}
+		go func(member string) {
+			reply, err := n.config.Transport.RequestVote(member, args)
+			if err != nil {
+				return
+			}
+
+			n.mutex.Lock()
+			defer n.mutex.Unlock()
+			if reply.Term > n.term {
+				n.becomeFollower(reply.Term)
+				return
+			}
+			if n.role != Candidate || n.term != term || !reply.Granted {
+				return
+			}
+			votes++
+			if votes >= n.quorum() {
+				n.becomeLeader()
+			}
+		}(member)
+	}
+}
+
+// becomeLeader 成为领导者, 追加一个空条目并开始向其他成员复制. 调用者需要持有 mutex.
+func (n *Node) becomeLeader() {
+	logger.Info("Raft 节点", n.id, "成为领导者, 任期", n.term)
+	n.role = Leader
+	n.leader = n.id
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.ackTime = make(map[string]time.Time)
+	n.peerSignals = make(map[string]chan struct{})
+	n.appendEntry(EntryNoop, nil)
+	n.changed.Broadcast()
+}
+
+// appendEntry 领导者追加一个条目, 并通知复制协程. 调用者需要持有 mutex.
+func (n *Node) appendEntry(typ EntryType, data []byte) uint64 {
+	index := n.lastIndex() + 1
+	n.log = append(n.log, Entry{Index: index, Term: n.term, Type: typ, Data: data})
+	n.persistEntries(n.log[len(n.log)-1:])
+	if typ == EntryConfig {
+		n.updateMembers()
+	}
+	n.syncPeers()
+	for _, signal := range n.peerSignals {
+		notify(signal)
+	}
+	n.advanceCommitIndex()
+	return index
+}
+
+// syncPeers 为新的成员启动复制协程, 停止已经被移除的成员的复制协程. 调用者需要持有 mutex.
+func (n *Node) syncPeers() {
+	for peer := range n.peerSignals {
+		if !n.isMember(peer) {
+			delete(n.peerSignals, peer)
+		}
+	}
+	for _, member := range n.members {
+		if _, ok := n.peerSignals[member]; ok || member == n.id {
+			continue
+		}
+		signal := make(chan struct{}, 1)
+		n.peerSignals[member] = signal
+		n.nextIndex[member] = n.lastIndex() + 1
+		n.matchIndex[member] = 0
+		n.wg.Add(1)
+		go n.replicate(member, n.term, signal)
+		notify(signal)
+	}
+}
+
+func notify(signal chan struct{}) {
+	select {
+	case signal <- struct{}{}:
+	default:
+	}
+}
+
+// advanceCommitIndex 领导者将复制到多数成员的, 当前任期的最新条目标记为已提交. 调用者需要持有 mutex.
+func (n *Node) advanceCommitIndex() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if n.termAt(index) != n.term {
+			// 只能通过计数提交当前任期的条目
+			break
+		}
+		count := 0
+		for _, member := range n.members {
+			if member == n.id || n.matchIndex[member] >= index {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.commitIndex = index
+			n.changed.Broadcast()
+			break
+		}
+	}
+
+	// 将自己移除的成员变更提交之后, 领导者退位
+	if n.role == Leader && !n.isMember(n.id) && n.commitIndex >= n.configIndex {
+		n.becomeFollower(n.term)
+		n.leader = ""
+	}
+}
+
+/* --- 后台协程 --- */
+
+// runTicker 跟随者和候选人在选举超时后开始新一轮选举
+func (n *Node) runTicker() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.config.ElectionTimeout / 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+
+		n.mutex.Lock()
+		// 不是成员的节点不参与选举
+		if n.role != Leader && n.isMember(n.id) && time.Since(n.lastContact) >= n.electionTimeout {
+			n.startElection()
+		}
+		n.mutex.Unlock()
+	}
+}
+
+// replicate 领导者向 peer 复制日志, 直到不再是 term 的领导者或 peer 被移除
+func (n *Node) replicate(peer string, term uint64, signal chan struct{}) {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-signal:
+		case <-ticker.C:
+		}
+
+		n.mutex.Lock()
+		if n.role != Leader || n.term != term || n.peerSignals[peer] != signal {
+			n.mutex.Unlock()
+			return
+		}
+		next := n.nextIndex[peer]
+		sentAt := time.Now()
+		if next <= n.log[0].Index {
+			args := &InstallSnapshotArgs{Term: term, Leader: n.id, Snapshot: *n.snapshot}
+			n.mutex.Unlock()
+			n.sendSnapshot(peer, args, sentAt)
+		} else {
+			args := &AppendEntriesArgs{
+				Term:         term,
+				Leader:       n.id,
+				PrevLogIndex: next - 1,
+				PrevLogTerm:  n.termAt(next - 1),
+				Entries:      n.entriesFrom(next, n.config.MaxEntriesPerRPC),
+				LeaderCommit: n.commitIndex,
+			}
+			n.mutex.Unlock()
+			n.sendEntries(peer, args, sentAt)
+		}
+
+		// 还有没有发送的条目时立刻继续
+		n.mutex.Lock()
+		if n.role == Leader && n.term == term && n.nextIndex[peer] <= n.lastIndex() {
+			notify(signal)
+		}
+		n.mutex.Unlock()
+	}
+}
+
+func (n *Node) sendEntries(peer string, args *AppendEntriesArgs, sentAt time.Time) {
+	reply, err := n.config.Transport.AppendEntries(peer, args)
+	if err != nil {
+		return
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if reply.Term > n.term {
+		n.becomeFollower(reply.Term)
+		return
+	}
+	if n.role != Leader || n.term != args.Term {
+		return
+	}
+	n.ackTime[peer] = sentAt
+	n.changed.Broadcast()
+
+	if reply.Success {
+		if match := args.PrevLogIndex + uint64(len(args.Entries)); match > n.matchIndex[peer] {
+			n.matchIndex[peer] = match
+			n.advanceCommitIndex()
+		}
+		n.nextIndex[peer] = n.matchIndex[peer] + 1
+		return
+	}
+
+	// 按冲突的任期快速回退
+	next := reply.ConflictIndex
+	if reply.ConflictTerm != 0 {
+		for index := n.lastIndex(); index > n.log[0].Index; index-- {
+			if n.termAt(index) == reply.ConflictTerm {
+				next = index + 1
+				break
+			}
+		}
+	}
+	if next < 1 {
+		next = 1
+	}
+	n.nextIndex[peer] = next
+}
+
+func (n *Node) sendSnapshot(peer string, args *InstallSnapshotArgs, sentAt time.Time) {
+	reply, err := n.config.Transport.InstallSnapshot(peer, args)
+	if err != nil {
+		return
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if reply.Term > n.term {
+		n.becomeFollower(reply.Term)
+		return
+	}
+	if n.role != Leader || n.term != args.Term {
+		return
+	}
+	n.ackTime[peer] = sentAt
+	if args.Snapshot.Index > n.matchIndex[peer] {
+		n.matchIndex[peer] = args.Snapshot.Index
+		n.advanceCommitIndex()
+	}
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	n.changed.Broadcast()
+}
+
+// runApplier 按顺序将已经提交的条目应用到状态机, 并在日志足够长时生成快照
+func (n *Node) runApplier() {
+	defer n.wg.Done()
+	for {
+		n.mutex.Lock()
+		for !n.stopped && n.pendingSnapshot == nil && n.lastApplied >= n.commitIndex {
+			n.changed.Wait()
+		}
+		if n.stopped {
+			n.mutex.Unlock()
+			return
+		}
+
+		// 1. 从领导者收到的快照
+		if snapshot := n.pendingSnapshot; snapshot != nil {
+			n.pendingSnapshot = nil
+			n.mutex.Unlock()
+			if err := n.config.StateMachine.Restore(snapshot.Data); err != nil {
+				logger.Fatal("Raft 应用快照失败.", err)
+			}
+			n.mutex.Lock()
+			if snapshot.Index > n.lastApplied {
+				n.lastApplied = snapshot.Index
+			}
+			n.changed.Broadcast()
+			n.mutex.Unlock()
+			continue
+		}
+
+		// 2. 已经提交的条目
+		entries := n.entriesFrom(n.lastApplied+1, int(n.commitIndex-n.lastApplied))
+		n.mutex.Unlock()
+
+		results := make([]any, len(entries))
+		for i, entry := range entries {
+			if entry.Type == EntryCommand {
+				results[i] = n.config.StateMachine.Apply(entry.Data)
+			}
+		}
+
+		n.mutex.Lock()
+		for i, entry := range entries {
+			if p, ok := n.proposals[entry.Index]; ok {
+				if p.term == entry.Term {
+					p.result = results[i]
+				} else {
+					p.err = ErrLeadershipLost
+				}
+				p.done = true
+				delete(n.proposals, entry.Index)
+			}
+		}
+		if last := entries[len(entries)-1].Index; last > n.lastApplied {
+			n.lastApplied = last
+		}
+		n.changed.Broadcast()
+		compact := n.config.SnapshotThreshold > 0 && n.lastApplied-n.log[0].Index >= n.config.SnapshotThreshold
+		applied := n.lastApplied
+		n.mutex.Unlock()
+
+		// 3. 压缩日志. 状态机只在本协程中被修改, 此时的状态恰好对应 applied.
+		if compact {
+			n.takeSnapshot(applied)
+		}
+	}
+}
+
+// takeSnapshot 生成 index 处的快照并丢弃之前的日志
+func (n *Node) takeSnapshot(index uint64) {
+	data, err := n.config.StateMachine.Snapshot()
+	if err != nil {
+		logger.Warn("Raft 生成快照失败.", err)
+		return
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if index <= n.log[0].Index {
+		// 期间已经安装了更新的快照
+		return
+	}
+	snapshot := &Snapshot{Index: index, Term: n.termAt(index), Members: n.membersAt(index), Data: data}
+	if err := n.config.Storage.SaveSnapshot(snapshot); err != nil {
+		logger.Warn("Raft 保存快照失败.", err)
+		return
+	}
+	n.snapshot = snapshot
+	n.snapshotMembers = snapshot.Members
+	n.log = append([]Entry{{Index: snapshot.Index, Term: snapshot.Term}}, n.log[index-n.log[0].Index+1:]...)
+	n.persistCompaction()
+}
+
+/* --- RPC 的处理 --- */
+
+// HandleRequestVote 处理候选人的投票请求
+func (n *Node) HandleRequestVote(args *RequestVoteArgs) *RequestVoteReply {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	// 最近收到过领导者的消息时忽略投票请求, 避免被移除的节点干扰集群
+	if n.role == Leader || (n.leader != "" && time.Since(n.lastContact) < n.config.ElectionTimeout) {
+		return &RequestVoteReply{Term: n.term}
+	}
+	if args.Term < n.term {
+		return &RequestVoteReply{Term: n.term}
+	}
+	if args.Term > n.term {
+		n.becomeFollower(args.Term)
+	}
+
+	upToDate := args.LastLogTerm > n.lastTerm() || (args.LastLogTerm == n.lastTerm() && args.LastLogIndex >= n.lastIndex())
+	if (n.votedFor == "" || n.votedFor == args.Candidate) && upToDate {
+		n.votedFor = args.Candidate
+		n.persistState()
+		n.lastContact = time.Now()
+		return &RequestVoteReply{Term: n.term, Granted: true}
+	}
+	return &RequestVoteReply{Term: n.term}
+}
+
+// acceptLeader 收到当前任期或更新任期的领导者的消息. 调用者需要持有 mutex.
+func (n *Node) acceptLeader(term uint64, leader string) {
+	if term > n.term || n.role != Follower {
+		n.becomeFollower(term)
+	}
+	n.leader = leader
+	n.lastContact = time.Now()
+}
+
+// HandleAppendEntries 处理领导者复制的日志
+func (n *Node) HandleAppendEntries(args *AppendEntriesArgs) *AppendEntriesReply {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if args.Term < n.term {
+		return &AppendEntriesReply{Term: n.term}
+	}
+	n.acceptLeader(args.Term, args.Leader)
+
+	// 1. 跳过已经被快照覆盖的条目, 它们一定已经提交
+	prevIndex, prevTerm, entries := args.PrevLogIndex, args.PrevLogTerm, args.Entries
+	if prevIndex < n.log[0].Index {
+		skip := n.log[0].Index - prevIndex
+		if skip > uint64(len(entries)) {
+			skip = uint64(len(entries))
+		}
+		entries = entries[skip:]
+		prevIndex, prevTerm = n.log[0].Index, n.log[0].Term
+	}
+
+	// 2. 检查 prevIndex 处的条目是否一致
+	if prevIndex > n.lastIndex() {
+		return &AppendEntriesReply{Term: n.term, ConflictIndex: n.lastIndex() + 1}
+	}
+	if term := n.termAt(prevIndex); term != prevTerm {
+		conflict := prevIndex
+		for conflict-1 > n.log[0].Index && n.termAt(conflict-1) == term {
+			conflict--
+		}
+		return &AppendEntriesReply{Term: n.term, ConflictIndex: conflict, ConflictTerm: term}
+	}
+
+	// 3. 删除冲突的条目, 追加新的条目
+	for i, entry := range entries {
+		if entry.Index <= n.lastIndex() {
+			if n.termAt(entry.Index) == entry.Term {
+				continue
+			}
+			n.log = n.log[:entry.Index-n.log[0].Index]
+		}
+		n.log = append(n.log, entries[i:]...)
+		n.persistEntries(entries[i:])
+		n.updateMembers()
+		break
+	}
+
+	// 4. 更新提交序号
+	if lastNew := prevIndex + uint64(len(entries)); args.LeaderCommit > n.commitIndex {
+		commit := args.LeaderCommit
+		if commit > lastNew {
+			commit = lastNew
+		}
+		if commit > n.commitIndex {
+			n.commitIndex = commit
+			n.changed.Broadcast()
+		}
+	}
+	return &AppendEntriesReply{Term: n.term, Success: true}
+}
+
+// HandleInstallSnapshot 处理领导者发送的快照
+func (n *Node) HandleInstallSnapshot(args *InstallSnapshotArgs) *InstallSnapshotReply {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if args.Term < n.term {
+		return &InstallSnapshotReply{Term: n.term}
+	}
+	n.acceptLeader(args.Term, args.Leader)
+
+	snapshot := args.Snapshot
+	if snapshot.Index <= n.commitIndex {
+		// 已经提交的条目都已经或将要应用, 不需要快照
+		return &InstallSnapshotReply{Term: n.term}
+	}
+	if err := n.config.Storage.SaveSnapshot(&snapshot); err != nil {
+		logger.Warn("Raft 保存快照失败.", err)
+		return &InstallSnapshotReply{Term: n.term}
+	}
+
+	// 日志中包含快照的最后一个条目时保留之后的条目, 否则丢弃全部日志
+	var suffix []Entry
+	if n.termAt(snapshot.Index) == snapshot.Term && snapshot.Index <= n.lastIndex() {
+		suffix = n.log[snapshot.Index-n.log[0].Index+1:]
+	}
+	n.log = append([]Entry{{Index: snapshot.Index, Term: snapshot.Term}}, suffix...)
+	n.snapshot = &snapshot
+	n.snapshotMembers = snapshot.Members
+	n.persistCompaction()
+	n.updateMembers()
+
+	n.commitIndex = snapshot.Index
+	n.pendingSnapshot = &snapshot
+	n.changed.Broadcast()
+	return &InstallSnapshotReply{Term: n.term}
+}
+
+/* --- 客户端接口 --- */
+
+// waitUntil 等待 condition 成立, 或 ctx 结束, 或节点停止. 调用者需要持有 mutex.
+func (n *Node) waitUntil(ctx context.Context, condition func() bool) error {
+	// ctx 结束时唤醒等待
+	if ctx.Done() != nil {
+		finished := make(chan struct{})
+		defer close(finished)
+		go func() {
+			select {
+			case <-ctx.Done():
+				n.mutex.Lock()
+				n.changed.Broadcast()
+				n.mutex.Unlock()
+			case <-finished:
+			}
+		}()
+	}
+
+	for !condition() {
+		if n.stopped {
+			return ErrStopped
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n.changed.Wait()
+	}
+	return nil
+}
+
+// notLeader 不是领导者时返回 NotLeaderError. 调用者需要持有 mutex.
+func (n *Node) notLeader() error {
+	if n.stopped {
+		return ErrStopped
+	}
+	if n.role != Leader {
+		return &NotLeaderError{Leader: n.leader}
+	}
+	return nil
+}
+
+// propose 追加一个条目, 等待其被应用, 返回状态机的结果
+func (n *Node) propose(ctx context.Context, typ EntryType, data []byte) (any, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if err := n.notLeader(); err != nil {
+		return nil, err
+	}
+	if typ == EntryConfig && n.configIndex > n.commitIndex {
+		return nil, ErrConfigChangeInProgress
+	}
+
+	index := n.appendEntry(typ, data)
+	p := &proposal{term: n.term}
+	n.proposals[index] = p
+	if err := n.waitUntil(ctx, func() bool { return p.done }); err != nil {
+		delete(n.proposals, index)
+		return nil, err
+	}
+	return p.result, p.err
+}
+
+// Propose 提交一条命令, 等待其被复制到多数成员并应用到本节点的状态机, 返回 Apply 的结果.
+// 本节点不是领导者时返回 *NotLeaderError. ctx 结束时返回错误, 但命令仍可能被提交.
+func (n *Node) Propose(ctx context.Context, command []byte) (any, error) {
+	return n.propose(ctx, EntryCommand, command)
+}
+
+// ReadBarrier 用于线性一致的读: 确认本节点仍然是领导者, 并等待状态机应用了确认时已经提交的全部条目.
+// 返回 nil 之后读取状态机, 结果不会比此前任何已经完成的写入更旧.
+func (n *Node) ReadBarrier(ctx context.Context) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if err := n.notLeader(); err != nil {
+		return err
+	}
+	term := n.term
+
+	// 1. 当前任期的空条目提交之后, commitIndex 才是最新的
+	if err := n.waitUntil(ctx, func() bool { return n.role != Leader || n.term != term || n.termAt(n.commitIndex) == term }); err != nil {
+		return err
+	}
+	readIndex := n.commitIndex
+
+	// 2. 一轮心跳得到多数成员的确认
+	start := time.Now()
+	for _, signal := range n.peerSignals {
+		notify(signal)
+	}
+	confirmed := func() bool {
+		if n.role != Leader || n.term != term {
+			return true
+		}
+		count := 0
+		for _, member := range n.members {
+			if member == n.id || !n.ackTime[member].Before(start) {
+				count++
+			}
+		}
+		return count >= n.quorum()
+	}
+	if err := n.waitUntil(ctx, confirmed); err != nil {
+		return err
+	}
+	if err := n.notLeader(); err != nil || n.term != term {
+		return &NotLeaderError{Leader: n.leader}
+	}
+
+	// 3. 等待应用到 readIndex
+	return n.waitUntil(ctx, func() bool { return n.lastApplied >= readIndex })
+}
+
+// AddMember 将 id 加入集群. 一次只能变更一个成员, 上一次变更提交之后才能开始下一次.
+func (n *Node) AddMember(ctx context.Context, id string) error {
+	return n.changeMembers(ctx, id, true)
+}
+
+// RemoveMember 将 id 移出集群. 移除领导者自己时, 变更提交之后领导者退位.
+func (n *Node) RemoveMember(ctx context.Context, id string) error {
+	return n.changeMembers(ctx, id, false)
+}
+
+func (n *Node) changeMembers(ctx context.Context, id string, add bool) error {
+	n.mutex.Lock()
+	if err := n.notLeader(); err != nil {
+		n.mutex.Unlock()
+		return err
+	}
+	if n.isMember(id) == add {
+		n.mutex.Unlock()
+		return nil
+	}
+	var members []string
+	for _, member := range n.members {
+		if member != id {
+			members = append(members, member)
+		}
+	}
+	if add {
+		members = append(members, id)
+	}
+	sort.Strings(members)
+	n.mutex.Unlock()
+
+	data, err := encode(members)
+	if err != nil {
+		return err
+	}
+	_, err = n.propose(ctx, EntryConfig, data)
+	if errors.Is(err, ErrLeadershipLost) && !add && id == n.id {
+		// 移除自己时, 退位发生在变更提交之后
+		return nil
+	}
+	return err
+}
+
+// Status 节点的状态
+type Status struct {
+	ID            string
+	Role          Role
+	Term          uint64
+	Leader        string
+	Members       []string
+	LastIndex     uint64
+	CommitIndex   uint64
+	LastApplied   uint64
+	SnapshotIndex uint64
+}
+
+// Status 返回节点当前的状态
+func (n *Node) Status() Status {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return Status{
+		ID:            n.id,
+		Role:          n.role,
+		Term:          n.term,
+		Leader:        n.leader,
+		Members:       append([]string(nil), n.members...),
+		LastIndex:     n.lastIndex(),
+		CommitIndex:   n.commitIndex,
+		LastApplied:   n.lastApplied,
+		SnapshotIndex: n.log[0].Index,
+	}
+}
+
+// Stop 停止节点, 等待后台协程退出
+func (n *Node) Stop() {
+	n.mutex.Lock()
+	if n.stopped {
+		n.mutex.Unlock()
+		return
+	}
+	n.stopped = true
+	close(n.done)
+	n.changed.Broadcast()
+	n.mutex.Unlock()
+	n.wg.Wait()
+}
@@ -0,0 +1,397 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testStateMachine 保存 key=value 命令的状态机
+type testStateMachine struct {
+	mutex sync.Mutex
+	data  map[string]string
+	// applied 应用过的命令的数量, 包括快照中的
+	applied int
+}
+
+func newTestStateMachine() *testStateMachine {
+	return &testStateMachine{data: make(map[string]string)}
+}
+
+func (m *testStateMachine) Apply(command []byte) any {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	kv := strings.SplitN(string(command), "=", 2)
+	m.data[kv[0]] = kv[1]
+	m.applied++
+	return m.applied
+}
+
+func (m *testStateMachine) Snapshot() ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return encode(&testSnapshot{Data: m.data, Applied: m.applied})
+}
+
+func (m *testStateMachine) Restore(data []byte) error {
+	snapshot := &testSnapshot{}
+	if err := decode(data, snapshot); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.data, m.applied = snapshot.Data, snapshot.Applied
+	if m.data == nil {
+		m.data = make(map[string]string)
+	}
+	return nil
+}
+
+func (m *testStateMachine) get(key string) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.data[key]
+}
+
+type testSnapshot struct {
+	Data    map[string]string
+	Applied int
+}
+
+// testCluster 通过 MemoryNetwork 连接的多个节点
+type testCluster struct {
+	t        *testing.T
+	network  *MemoryNetwork
+	nodes    map[string]*Node
+	machines map[string]*testStateMachine
+	storages map[string]*MemoryStorage
+	ids      []string
+}
+
+func newTestCluster(t *testing.T, n int, snapshotThreshold uint64) *testCluster {
+	c := &testCluster{
+		t:        t,
+		network:  NewMemoryNetwork(),
+		nodes:    make(map[string]*Node),
+		machines: make(map[string]*testStateMachine),
+		storages: make(map[string]*MemoryStorage),
+	}
+	for i := 0; i < n; i++ {
+		c.ids = append(c.ids, "node"+strconv.Itoa(i))
+	}
+	for _, id := range c.ids {
+		c.storages[id] = NewMemoryStorage()
+		c.start(id, false, snapshotThreshold)
+	}
+	t.Cleanup(func() {
+		for _, node := range c.nodes {
+			node.Stop()
+		}
+	})
+	return c
+}
+
+// start 启动节点 id, 存储中已有的状态会被恢复
+func (c *testCluster) start(id string, join bool, snapshotThreshold uint64) *Node {
+	var peers []string
+	for _, peer := range c.ids {
+		if peer != id {
+			peers = append(peers, peer)
+		}
+	}
+	if c.storages[id] == nil {
+		c.storages[id] = NewMemoryStorage()
+	}
+	c.machines[id] = newTestStateMachine()
+	node, err := NewNode(&Config{
+		ID:                id,
+		Peers:             peers,
+		Join:              join,
+		ElectionTimeout:   100 * time.Millisecond,
+		HeartbeatInterval: 10 * time.Millisecond,
+		SnapshotThreshold: snapshotThreshold,
+		MaxEntriesPerRPC:  4,
+		Transport:         c.network.Transport(id),
+		Storage:           c.storages[id],
+		StateMachine:      c.machines[id],
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.nodes[id] = node
+	c.network.Register(node)
+	return node
+}
+
+// leader 等待并返回唯一的领导者, 忽略被断开的节点
+func (c *testCluster) leader(excluded ...string) *Node {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var leaders []*Node
+		for id, node := range c.nodes {
+			if contains(excluded, id) {
+				continue
+			}
+			if status := node.Status(); status.Role == Leader && contains(status.Members, id) {
+				leaders = append(leaders, node)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+	}
+	c.t.Fatal("没有选出唯一的领导者.")
+	return nil
+}
+
+func contains(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// propose 向领导者提交命令. 领导者发生变化时向新的领导者重试, 测试中的命令都是幂等的.
+func (c *testCluster) propose(command string, excluded ...string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		_, err := c.leader(excluded...).Propose(ctx, []byte(command))
+		var notLeader *NotLeaderError
+		if errors.As(err, &notLeader) || errors.Is(err, ErrLeadershipLost) {
+			continue
+		}
+		if err != nil {
+			c.t.Fatal("提交失败.", command, err)
+		}
+		return
+	}
+}
+
+// waitApplied 等待节点 id 的状态机中 key 的值为 value
+func (c *testCluster) waitApplied(id, key, value string) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if c.machines[id].get(key) == value {
+			return
+		}
+	}
+	c.t.Fatalf("节点 %s 没有应用 %s=%s", id, key, value)
+}
+
+func TestNode_Replication(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	c.propose("a=1")
+	for _, id := range c.ids {
+		c.waitApplied(id, "a", "1")
+	}
+	leader := c.leader()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if result, err := leader.Propose(ctx, []byte("a=2")); err != nil || result != 2 {
+		t.Fatal("Propose 应该返回状态机的结果.", result, err)
+	}
+
+	// 跟随者拒绝提交和读, 并告知领导者
+	for id, node := range c.nodes {
+		if node == leader {
+			continue
+		}
+		var notLeader *NotLeaderError
+		if _, err := node.Propose(ctx, []byte("b=1")); !errors.As(err, &notLeader) || notLeader.Leader != leader.id {
+			t.Error("跟随者应该拒绝提交.", id, err)
+		}
+		if err := node.ReadBarrier(ctx); !errors.As(err, &notLeader) {
+			t.Error("跟随者应该拒绝读.", id, err)
+		}
+	}
+	if err := leader.ReadBarrier(ctx); err != nil {
+		t.Error("领导者的读失败.", err)
+	}
+}
+
+func TestNode_LeaderFailure(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	c.propose("a=1")
+	old := c.leader()
+
+	// 领导者被隔离, 其余节点选出新的领导者并继续提交
+	c.network.Disconnect(old.id)
+	c.propose("a=2", old.id)
+
+	// 被隔离的领导者无法确认身份, 也无法提交
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := old.ReadBarrier(ctx); err == nil {
+		t.Error("被隔离的领导者不应该能够读.")
+	}
+	if _, err := old.Propose(ctx, []byte("a=3")); err == nil {
+		t.Error("被隔离的领导者不应该能够提交.")
+	}
+
+	// 恢复之后, 旧的领导者退位, 未提交的条目被覆盖
+	c.network.Connect(old.id)
+	c.propose("b=1", old.id)
+	c.waitApplied(old.id, "b", "1")
+	if c.machines[old.id].get("a") != "2" {
+		t.Error("旧的领导者的未提交条目应该被覆盖.")
+	}
+	if old.Status().Role == Leader {
+		t.Error("旧的领导者应该退位.")
+	}
+}
+
+func TestNode_Snapshot(t *testing.T) {
+	c := newTestCluster(t, 3, 5)
+	c.propose("init=1")
+	leader := c.leader()
+
+	var lagging string
+	for _, id := range c.ids {
+		if id != leader.id {
+			lagging = id
+			break
+		}
+	}
+	c.network.Disconnect(lagging)
+	for i := 0; i < 20; i++ {
+		c.propose("k"+strconv.Itoa(i)+"="+strconv.Itoa(i), lagging)
+	}
+	if status := c.leader(lagging).Status(); status.SnapshotIndex == 0 {
+		t.Fatal("日志应该被压缩.", status)
+	}
+
+	// 落后的节点通过快照追上
+	c.network.Connect(lagging)
+	c.waitApplied(lagging, "k19", "19")
+	if c.machines[lagging].get("k0") != "0" {
+		t.Error("快照中的数据丢失.")
+	}
+
+	// 重启之后从快照和日志中恢复
+	c.nodes[lagging].Stop()
+	node := c.start(lagging, false, 5)
+	if node.Status().SnapshotIndex == 0 || c.machines[lagging].get("k0") != "0" {
+		t.Error("重启之后没有从快照中恢复.")
+	}
+	c.propose("after=restart")
+	c.waitApplied(lagging, "after", "restart")
+}
+
+func TestNode_Membership(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	c.propose("a=1")
+	leader := c.leader()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 加入新的节点
+	c.ids = append(c.ids, "node3")
+	c.start("node3", true, 0)
+	if err := leader.AddMember(ctx, "node3"); err != nil {
+		t.Fatal("加入成员失败.", err)
+	}
+	c.waitApplied("node3", "a", "1")
+	if members := c.nodes["node3"].Status().Members; len(members) != 4 {
+		t.Error("新的节点应该知道全部成员.", members)
+	}
+
+	// 移除领导者自己, 其余节点选出新的领导者
+	if err := leader.RemoveMember(ctx, leader.id); err != nil {
+		t.Fatal("移除领导者失败.", err)
+	}
+	if members := c.leader(leader.id).Status().Members; len(members) != 3 {
+		t.Error("成员数量错误.", members)
+	}
+	c.propose("b=1", leader.id)
+	c.waitApplied("node3", "b", "1")
+}
+
+func TestFileStorage(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state, err := storage.LoadState(); state != nil || err != nil {
+		t.Fatal("没有保存过时应该返回 nil.", state, err)
+	}
+
+	if err := storage.SaveState(&HardState{Term: 3, VotedFor: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SaveSnapshot(&Snapshot{Index: 1, Term: 2, Members: []string{"a"}, Data: []byte("s")}); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := storage.LoadState()
+	if err != nil || loaded.Term != 3 || loaded.VotedFor != "a" {
+		t.Error("读取的状态错误.", loaded, err)
+	}
+	snapshot, err := storage.LoadSnapshot()
+	if err != nil || snapshot.Index != 1 || string(snapshot.Data) != "s" {
+		t.Error("读取的快照错误.", snapshot, err)
+	}
+}
+
+func TestFileStorage_Entries(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = storage.Close() }()
+	check := func(message string, want ...string) {
+		t.Helper()
+		entries, err := storage.LoadEntries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, entry := range entries {
+			got = append(got, strconv.FormatUint(entry.Index, 10)+":"+string(entry.Data))
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Error(message, got)
+		}
+	}
+
+	// 1. 追加, 序号重叠的条目覆盖之前的条目
+	entries := []Entry{{Index: 1, Term: 1, Data: []byte("a")}, {Index: 2, Term: 1, Data: []byte("b")}, {Index: 3, Term: 1, Data: []byte("c")}}
+	if err := storage.AppendEntries(entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.AppendEntries([]Entry{{Index: 2, Term: 2, Data: []byte("x")}}); err != nil {
+		t.Fatal(err)
+	}
+	check("覆盖之后的条目错误.", "1:a", "2:x")
+
+	// 2. 崩溃时写了一半的记录被丢弃, 之后追加的条目仍然可以读到
+	_ = storage.Close()
+	file, err := os.OpenFile(filepath.Join(dir, logFilename), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.Write(encodeEntries([]Entry{{Index: 3, Term: 2, Data: []byte("torn")}})[:20])
+	_ = file.Close()
+	check("应该丢弃不完整的记录.", "1:a", "2:x")
+	if err := storage.AppendEntries([]Entry{{Index: 3, Term: 2, Data: []byte("y")}}); err != nil {
+		t.Fatal(err)
+	}
+	check("截断之后追加的条目错误.", "1:a", "2:x", "3:y")
+
+	// 3. 压缩日志
+	if err := storage.ResetEntries([]Entry{{Index: 3, Term: 2, Data: []byte("y")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.AppendEntries([]Entry{{Index: 4, Term: 2, Data: []byte("z")}}); err != nil {
+		t.Fatal(err)
+	}
+	check("压缩之后的条目错误.", "3:y", "4:z")
+}
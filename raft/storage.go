@@ -0,0 +1,348 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Storage 持久化节点的状态, 日志与快照. 崩溃之后, 快照可能比日志新, 加载时会丢弃被快照覆盖的条目.
+type Storage interface {
+	// SaveState 持久化任期与投票, 返回时必须已经落盘
+	SaveState(state *HardState) error
+	// LoadState 读取任期与投票, 从未保存过时返回 nil
+	LoadState() (*HardState, error)
+	// AppendEntries 追加连续的条目, 返回时必须已经落盘.
+	// 第一个条目的序号不大于已经保存的最后一个条目时, 先删除该序号及之后的条目.
+	AppendEntries(entries []Entry) error
+	// ResetEntries 用 entries 替换全部条目, 用于压缩日志, 返回时必须已经落盘
+	ResetEntries(entries []Entry) error
+	// LoadEntries 读取全部条目
+	LoadEntries() ([]Entry, error)
+	// SaveSnapshot 持久化快照, 返回时必须已经落盘
+	SaveSnapshot(snapshot *Snapshot) error
+	// LoadSnapshot 读取快照, 从未保存过时返回 nil
+	LoadSnapshot() (*Snapshot, error)
+}
+
+func encode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// overwriteEntries 将 entries 追加到 log 中, 先删除 log 中与 entries 重叠的条目
+func overwriteEntries(log []Entry, entries []Entry) []Entry {
+	if len(entries) == 0 {
+		return log
+	}
+	keep := len(log)
+	for keep > 0 && log[keep-1].Index >= entries[0].Index {
+		keep--
+	}
+	return append(log[:keep], entries...)
+}
+
+// cloneEntries 复制条目及其数据
+func cloneEntries(entries []Entry) []Entry {
+	clone := make([]Entry, len(entries))
+	for i, entry := range entries {
+		clone[i] = entry
+		clone[i].Data = append([]byte(nil), entry.Data...)
+	}
+	return clone
+}
+
+// MemoryStorage 保存在内存中的 Storage, 用于测试. 保存的是编码后的副本, 不会与节点共享内存.
+type MemoryStorage struct {
+	mutex    sync.Mutex
+	state    []byte
+	entries  []Entry
+	snapshot []byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (s *MemoryStorage) SaveState(state *HardState) error {
+	data, err := encode(state)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.state = data
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *MemoryStorage) LoadState() (*HardState, error) {
+	s.mutex.Lock()
+	data := s.state
+	s.mutex.Unlock()
+	if data == nil {
+		return nil, nil
+	}
+	state := &HardState{}
+	return state, decode(data, state)
+}
+
+func (s *MemoryStorage) AppendEntries(entries []Entry) error {
+	entries = cloneEntries(entries)
+	s.mutex.Lock()
+	s.entries = overwriteEntries(s.entries, entries)
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *MemoryStorage) ResetEntries(entries []Entry) error {
+	entries = cloneEntries(entries)
+	s.mutex.Lock()
+	s.entries = entries
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *MemoryStorage) LoadEntries() ([]Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return cloneEntries(s.entries), nil
+}
+
+func (s *MemoryStorage) SaveSnapshot(snapshot *Snapshot) error {
+	data, err := encode(snapshot)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.snapshot = data
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *MemoryStorage) LoadSnapshot() (*Snapshot, error) {
+	s.mutex.Lock()
+	data := s.snapshot
+	s.mutex.Unlock()
+	if data == nil {
+		return nil, nil
+	}
+	snapshot := &Snapshot{}
+	return snapshot, decode(data, snapshot)
+}
+
+const (
+	stateFilename    = "raft-state"
+	logFilename      = "raft-log"
+	snapshotFilename = "raft-snapshot"
+)
+
+const (
+	// logHeaderSize 日志文件中每条记录的头部: 记录体的长度与 CRC32 校验和
+	logHeaderSize = 8
+	// logEntrySize 记录体中条目数据之前的部分: 序号, 任期与类型
+	logEntrySize = 17
+)
+
+// FileStorage 将状态, 日志与快照分别保存在目录中的三个文件里.
+// 状态与快照每次保存都先写入临时文件, 刷入磁盘后原子地替换; 日志是只追加的文件, 每次只写入新的条目,
+// 删除冲突的条目时追加序号更小的记录, 加载时由后面的记录覆盖前面的记录. 只有压缩日志时才重写整个文件.
+type FileStorage struct {
+	dir string
+	// log 日志文件, 第一次追加时打开
+	log *os.File
+}
+
+// NewFileStorage 创建保存在 dir 中的 Storage, 目录不存在时会被创建
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStorage{dir: dir}, nil
+}
+
+func (s *FileStorage) SaveState(state *HardState) error {
+	data, err := encode(state)
+	if err != nil {
+		return err
+	}
+	return s.save(stateFilename, data)
+}
+
+func (s *FileStorage) LoadState() (*HardState, error) {
+	state := &HardState{}
+	if ok, err := s.load(stateFilename, state); !ok {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *FileStorage) AppendEntries(entries []Entry) error {
+	if s.log == nil {
+		if err := s.openLog(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.log.Write(encodeEntries(entries)); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+func (s *FileStorage) ResetEntries(entries []Entry) error {
+	if s.log != nil {
+		_ = s.log.Close()
+		s.log = nil
+	}
+	return s.save(logFilename, encodeEntries(entries))
+}
+
+func (s *FileStorage) LoadEntries() ([]Entry, error) {
+	entries, _, err := s.readLog()
+	return entries, err
+}
+
+func (s *FileStorage) SaveSnapshot(snapshot *Snapshot) error {
+	data, err := encode(snapshot)
+	if err != nil {
+		return err
+	}
+	return s.save(snapshotFilename, data)
+}
+
+func (s *FileStorage) LoadSnapshot() (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	if ok, err := s.load(snapshotFilename, snapshot); !ok {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Close 关闭日志文件
+func (s *FileStorage) Close() error {
+	if s.log == nil {
+		return nil
+	}
+	err := s.log.Close()
+	s.log = nil
+	return err
+}
+
+// openLog 打开日志文件用于追加. 崩溃时写了一半的记录会被截断, 之后的记录才能被读到.
+func (s *FileStorage) openLog() error {
+	_, size, err := s.readLog()
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(s.dir, logFilename), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(size); err != nil {
+		_ = file.Close()
+		return err
+	}
+	s.log = file
+	return nil
+}
+
+// readLog 读取日志文件中的全部条目, 以及完整的记录的总长度. 遇到不完整或校验失败的记录时停止.
+func (s *FileStorage) readLog() ([]Entry, int64, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, logFilename))
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []Entry
+	offset := 0
+	for len(data)-offset >= logHeaderSize {
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		checksum := binary.BigEndian.Uint32(data[offset+4:])
+		body := data[offset+logHeaderSize:]
+		if size < logEntrySize || size > len(body) || crc32.ChecksumIEEE(body[:size]) != checksum {
+			break
+		}
+		body = body[:size]
+		entry := Entry{
+			Index: binary.BigEndian.Uint64(body),
+			Term:  binary.BigEndian.Uint64(body[8:]),
+			Type:  EntryType(body[16]),
+			Data:  append([]byte(nil), body[logEntrySize:]...),
+		}
+		entries = overwriteEntries(entries, []Entry{entry})
+		offset += logHeaderSize + size
+	}
+	return entries, int64(offset), nil
+}
+
+// encodeEntries 将条目编码为日志文件中的记录
+func encodeEntries(entries []Entry) []byte {
+	var buf []byte
+	for _, entry := range entries {
+		start := len(buf)
+		buf = append(buf, make([]byte, logHeaderSize)...)
+		buf = binary.BigEndian.AppendUint64(buf, entry.Index)
+		buf = binary.BigEndian.AppendUint64(buf, entry.Term)
+		buf = append(buf, byte(entry.Type))
+		buf = append(buf, entry.Data...)
+		body := buf[start+logHeaderSize:]
+		binary.BigEndian.PutUint32(buf[start:], uint32(len(body)))
+		binary.BigEndian.PutUint32(buf[start+4:], crc32.ChecksumIEEE(body))
+	}
+	return buf
+}
+
+func (s *FileStorage) save(filename string, data []byte) error {
+	path := filepath.Join(s.dir, filename)
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dir.Close() }()
+	return dir.Sync()
+}
+
+// load 读取文件并解码到 v 中, 文件不存在时返回 false, nil
+func (s *FileStorage) load(filename string, v any) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, filename))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, decode(data, v)
+}
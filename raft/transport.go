@@ -0,0 +1,196 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"simple_kvstorage/client"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnreachable 目标节点不可达
+var ErrUnreachable = errors.New("raft peer unreachable")
+
+// MemoryNetwork 进程内的网络, 节点之间直接调用彼此的 RPC 处理函数, 用于在一个进程中测试多个节点.
+// 可以断开某个节点以模拟网络分区.
+type MemoryNetwork struct {
+	mutex        sync.RWMutex
+	nodes        map[string]*Node
+	disconnected map[string]bool
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{nodes: make(map[string]*Node), disconnected: make(map[string]bool)}
+}
+
+// Transport 返回节点 id 使用的 Transport
+func (m *MemoryNetwork) Transport(id string) Transport {
+	return &memoryTransport{network: m, from: id}
+}
+
+// Register 将节点接入网络
+func (m *MemoryNetwork) Register(node *Node) {
+	m.mutex.Lock()
+	m.nodes[node.id] = node
+	m.mutex.Unlock()
+}
+
+// Disconnect 断开节点 id, 它发出和收到的 RPC 都会失败
+func (m *MemoryNetwork) Disconnect(id string) {
+	m.mutex.Lock()
+	m.disconnected[id] = true
+	m.mutex.Unlock()
+}
+
+// Connect 恢复节点 id 的连接
+func (m *MemoryNetwork) Connect(id string) {
+	m.mutex.Lock()
+	delete(m.disconnected, id)
+	m.mutex.Unlock()
+}
+
+func (m *MemoryNetwork) route(from, to string) (*Node, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	node, ok := m.nodes[to]
+	if !ok || m.disconnected[from] || m.disconnected[to] {
+		return nil, ErrUnreachable
+	}
+	return node, nil
+}
+
+type memoryTransport struct {
+	network *MemoryNetwork
+	from    string
+}
+
+func (t *memoryTransport) RequestVote(to string, args *RequestVoteArgs) (*RequestVoteReply, error) {
+	node, err := t.network.route(t.from, to)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleRequestVote(args), nil
+}
+
+func (t *memoryTransport) AppendEntries(to string, args *AppendEntriesArgs) (*AppendEntriesReply, error) {
+	node, err := t.network.route(t.from, to)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleAppendEntries(args), nil
+}
+
+func (t *memoryTransport) InstallSnapshot(to string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error) {
+	node, err := t.network.route(t.from, to)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleInstallSnapshot(args), nil
+}
+
+// RPC 的名称, 网络传输时作为 RAFT RPC 命令的参数
+const (
+	rpcRequestVote     = "requestvote"
+	rpcAppendEntries   = "appendentries"
+	rpcInstallSnapshot = "installsnapshot"
+)
+
+// HandleRPC 处理经网络传输的 RPC, payload 与返回值都是 gob 编码的参数与回复
+func (n *Node) HandleRPC(kind string, payload []byte) ([]byte, error) {
+	switch strings.ToLower(kind) {
+	case rpcRequestVote:
+		args := &RequestVoteArgs{}
+		if err := decode(payload, args); err != nil {
+			return nil, err
+		}
+		return encode(n.HandleRequestVote(args))
+	case rpcAppendEntries:
+		args := &AppendEntriesArgs{}
+		if err := decode(payload, args); err != nil {
+			return nil, err
+		}
+		return encode(n.HandleAppendEntries(args))
+	case rpcInstallSnapshot:
+		args := &InstallSnapshotArgs{}
+		if err := decode(payload, args); err != nil {
+			return nil, err
+		}
+		return encode(n.HandleInstallSnapshot(args))
+	}
+	return nil, fmt.Errorf("unknown raft rpc '%s'", kind)
+}
+
+// NetworkTransport 通过 RESP 协议传输 RPC: 发送 RAFT RPC <kind> <payload>, 对方回复编码后的结果.
+// 与每个节点之间使用一个带连接池的客户端.
+type NetworkTransport struct {
+	timeout time.Duration
+	secret  string
+
+	mutex sync.Mutex
+	peers map[string]*client.Client
+}
+
+// NewNetworkTransport 创建网络传输, timeout 是连接与每次 RPC 的超时时间.
+// secret 是节点之间共享的密钥 (raft-secret), 新的连接先以 RAFT AUTH <secret> 认证, 否则对方拒绝 RAFT RPC.
+func NewNetworkTransport(timeout time.Duration, secret string) *NetworkTransport {
+	return &NetworkTransport{timeout: timeout, secret: secret, peers: make(map[string]*client.Client)}
+}
+
+func (t *NetworkTransport) RequestVote(to string, args *RequestVoteArgs) (*RequestVoteReply, error) {
+	reply := &RequestVoteReply{}
+	return reply, t.call(to, rpcRequestVote, args, reply)
+}
+
+func (t *NetworkTransport) AppendEntries(to string, args *AppendEntriesArgs) (*AppendEntriesReply, error) {
+	reply := &AppendEntriesReply{}
+	return reply, t.call(to, rpcAppendEntries, args, reply)
+}
+
+func (t *NetworkTransport) InstallSnapshot(to string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error) {
+	reply := &InstallSnapshotReply{}
+	return reply, t.call(to, rpcInstallSnapshot, args, reply)
+}
+
+func (t *NetworkTransport) call(to string, kind string, args any, result any) error {
+	payload, err := encode(args)
+	if err != nil {
+		return err
+	}
+	data, err := t.getPeer(to).Do(context.Background(), "raft", "rpc", kind, payload).Bytes()
+	if err != nil {
+		return fmt.Errorf("raft rpc to %s failed: %w", to, err)
+	}
+	return decode(data, result)
+}
+
+// getPeer 返回与 addr 之间的客户端. RPC 失败时由 Raft 自己重发, 客户端不重试.
+func (t *NetworkTransport) getPeer(addr string) *client.Client {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if peer, ok := t.peers[addr]; ok {
+		return peer
+	}
+	peer := client.New(client.Options{
+		Addr:         addr,
+		DialTimeout:  t.timeout,
+		ReadTimeout:  t.timeout,
+		MaxIdle:      4,
+		MaxRetries:   -1,
+		InitCommands: [][]any{{"raft", "auth", t.secret}},
+	})
+	t.peers[addr] = peer
+	return peer
+}
+
+// Close 关闭与全部节点之间的连接
+func (t *NetworkTransport) Close() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for addr, peer := range t.peers {
+		_ = peer.Close()
+		delete(t.peers, addr)
+	}
+}
@@ -0,0 +1,142 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Role 节点在 Raft 中的角色
+type Role int
+
+const (
+	Follower Role = iota
+	Candidate
+	Leader
+)
+
+func (r Role) String() string {
+	switch r {
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	}
+	return "follower"
+}
+
+// EntryType 日志条目的类型
+type EntryType uint8
+
+const (
+	// EntryCommand 状态机的命令
+	EntryCommand EntryType = iota
+	// EntryNoop 领导者当选后追加的空条目, 用于尽快提交之前任期的条目
+	EntryNoop
+	// EntryConfig 成员变更, Data 是编码后的新成员列表
+	EntryConfig
+)
+
+// Entry 日志条目
+type Entry struct {
+	Index uint64
+	Term  uint64
+	Type  EntryType
+	Data  []byte
+}
+
+// Snapshot 快照, 包含了 Index 及之前的全部条目应用到状态机之后的状态
+type Snapshot struct {
+	Index   uint64
+	Term    uint64
+	Members []string
+	Data    []byte
+}
+
+// HardState 需要在回复 RPC 之前持久化的任期与投票. 日志由 Storage.AppendEntries 单独持久化.
+type HardState struct {
+	Term     uint64
+	VotedFor string
+}
+
+// StateMachine 被复制的状态机, 其方法只会在同一个协程中被调用
+type StateMachine interface {
+	// Apply 应用一条已经提交的命令, 返回值会交给提交这条命令的 Propose 的调用者
+	Apply(command []byte) any
+	// Snapshot 返回状态机当前的全部状态
+	Snapshot() ([]byte, error)
+	// Restore 用快照替换状态机的全部状态
+	Restore(snapshot []byte) error
+}
+
+// ErrStopped 节点已经停止
+var ErrStopped = errors.New("raft node stopped")
+
+// ErrLeadershipLost 条目提交之前失去了领导者的身份, 条目最终是否被提交未知
+var ErrLeadershipLost = errors.New("leadership lost while committing")
+
+// ErrConfigChangeInProgress 上一次成员变更还没有提交
+var ErrConfigChangeInProgress = errors.New("a membership change is already in progress")
+
+// NotLeaderError 本节点不是领导者, Leader 是已知的领导者, 未知时为空
+type NotLeaderError struct {
+	Leader string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "no leader elected"
+	}
+	return fmt.Sprintf("not leader, leader is %s", e.Leader)
+}
+
+/* --- RPC --- */
+
+// RequestVoteArgs 候选人请求投票
+type RequestVoteArgs struct {
+	Term         uint64
+	Candidate    string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+type RequestVoteReply struct {
+	Term    uint64
+	Granted bool
+}
+
+// AppendEntriesArgs 领导者复制日志, Entries 为空时作为心跳
+type AppendEntriesArgs struct {
+	Term         uint64
+	Leader       string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []Entry
+	LeaderCommit uint64
+}
+
+// AppendEntriesReply 失败时, ConflictTerm 是跟随者在 PrevLogIndex 处的任期 (没有该条目时为 0),
+// ConflictIndex 是跟随者中该任期的第一个条目 (或最后一个条目之后) 的序号, 领导者据此快速回退 nextIndex
+type AppendEntriesReply struct {
+	Term          uint64
+	Success       bool
+	ConflictIndex uint64
+	ConflictTerm  uint64
+}
+
+// InstallSnapshotArgs 领导者发送快照, 用于追赶已经被压缩掉的日志
+type InstallSnapshotArgs struct {
+	Term     uint64
+	Leader   string
+	Snapshot Snapshot
+}
+
+type InstallSnapshotReply struct {
+	Term uint64
+}
+
+// Transport 节点之间的 RPC
+type Transport interface {
+	RequestVote(to string, args *RequestVoteArgs) (*RequestVoteReply, error)
+	AppendEntries(to string, args *AppendEntriesArgs) (*AppendEntriesReply, error)
+	InstallSnapshot(to string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error)
+}
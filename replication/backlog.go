@@ -0,0 +1,54 @@
+package replication
+
+// backlog 复制积压缓冲区, 是一个环形缓冲区, 保存了最近传播给从节点的命令流.
+// 从节点断线重连后, 若其复制偏移量仍在缓冲区中, 只需要发送缺失的部分 (部分重同步).
+type backlog struct {
+	buf []byte
+	// start 缓冲区中第一个字节的复制偏移量, end 最后一个字节之后的复制偏移量
+	start int64
+	end   int64
+}
+
+// newBacklog 创建一个大小为 size 的积压缓冲区, 从复制偏移量 offset 开始写入
+func newBacklog(size int, offset int64) *backlog {
+	if size <= 0 {
+		size = 1
+	}
+	return &backlog{buf: make([]byte, size), start: offset, end: offset}
+}
+
+// write 追加数据, 缓冲区满时覆盖最旧的数据
+func (b *backlog) write(data []byte) {
+	size := int64(len(b.buf))
+	b.end += int64(len(data))
+	if int64(len(data)) > size {
+		data = data[int64(len(data))-size:]
+	}
+
+	pos := (b.end - int64(len(data))) % size
+	n := copy(b.buf[pos:], data)
+	copy(b.buf, data[n:])
+
+	if b.end-b.start > size {
+		b.start = b.end - size
+	}
+}
+
+// readFrom 返回复制偏移量 offset 之后的全部数据. offset 不在缓冲区中时返回 false.
+func (b *backlog) readFrom(offset int64) ([]byte, bool) {
+	if offset < b.start || offset > b.end {
+		return nil, false
+	}
+
+	size := int64(len(b.buf))
+	data := make([]byte, b.end-offset)
+	pos := offset % size
+	n := copy(data, b.buf[pos:])
+	copy(data[n:], b.buf)
+	return data, true
+}
+
+// histLen 缓冲区中数据的字节数
+func (b *backlog) histLen() int64 {
+	return b.end - b.start
+}
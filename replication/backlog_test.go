@@ -0,0 +1,40 @@
+package replication
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBacklog(t *testing.T) {
+	b := newBacklog(8, 100)
+	if data, ok := b.readFrom(100); !ok || len(data) != 0 {
+		t.Error("空的积压缓冲区应该可以从起始偏移量读取.")
+	}
+
+	b.write([]byte("abcde"))
+	if data, ok := b.readFrom(102); !ok || string(data) != "cde" {
+		t.Error("读取积压缓冲区失败.", string(data))
+	}
+
+	// 写满之后覆盖最旧的数据
+	b.write([]byte("fghij"))
+	if b.start != 102 || b.end != 110 || b.histLen() != 8 {
+		t.Error("积压缓冲区的偏移量错误.", b.start, b.end)
+	}
+	if _, ok := b.readFrom(101); ok {
+		t.Error("被覆盖的偏移量不能读取.")
+	}
+	if data, ok := b.readFrom(102); !ok || string(data) != "cdefghij" {
+		t.Error("读取环绕的积压缓冲区失败.", string(data))
+	}
+	if _, ok := b.readFrom(111); ok {
+		t.Error("超过末尾的偏移量不能读取.")
+	}
+
+	// 一次写入超过缓冲区大小的数据
+	large := bytes.Repeat([]byte("0123456789"), 3)
+	b.write(large)
+	if data, ok := b.readFrom(b.start); !ok || !bytes.Equal(data, large[len(large)-8:]) {
+		t.Error("写入大量数据之后读取失败.", string(data))
+	}
+}
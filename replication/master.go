@@ -0,0 +1,222 @@
+package replication
+
+import (
+	"bytes"
+	"simple_kvstorage/persistent"
+	"simple_kvstorage/util/logger"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// replicaOutputLimit 从节点输出缓冲区的上限, 从节点跟不上主节点的写入速度时会被断开
+const replicaOutputLimit = 256 << 20
+
+// ReplicaConn 主节点上与从节点的连接
+type ReplicaConn interface {
+	Write(bytes []byte) error
+	Close() error
+}
+
+// replica 主节点上的一个从节点. 命令流先追加到 pending 中, 再由单独的协程写给从节点,
+// 这样传播命令时不会被慢速的从节点阻塞.
+type replica struct {
+	conn ReplicaConn
+	addr string
+
+	mutex   sync.Mutex
+	pending []byte
+	// signal 有新的数据需要发送
+	signal chan struct{}
+	closed bool
+	// online 是否已经发送完快照
+	online    bool
+	ackOffset int64
+	ackTime   time.Time
+}
+
+func newReplica(conn ReplicaConn, addr string) *replica {
+	return &replica{conn: conn, addr: addr, signal: make(chan struct{}, 1), ackTime: time.Now()}
+}
+
+// send 将数据追加到输出缓冲区中. 超过上限时关闭连接并返回 false.
+func (r *replica) send(data []byte) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return false
+	}
+	if int64(len(r.pending)+len(data)) > replicaOutputLimit {
+		r.close()
+		return false
+	}
+	r.pending = append(r.pending, data...)
+	select {
+	case r.signal <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// run 先发送 prefix (同步的回复和快照, 或积压缓冲区中的数据), 再不断发送输出缓冲区中的命令流, 直到连接关闭
+func (r *replica) run(prefix []byte) {
+	if err := r.conn.Write(prefix); err != nil {
+		r.mutex.Lock()
+		r.close()
+		r.mutex.Unlock()
+		return
+	}
+	r.mutex.Lock()
+	r.online = true
+	r.mutex.Unlock()
+
+	for range r.signal {
+		r.mutex.Lock()
+		data := r.pending
+		r.pending = nil
+		closed := r.closed
+		r.mutex.Unlock()
+		if closed {
+			return
+		}
+
+		if err := r.conn.Write(data); err != nil {
+			logger.Warn("向从节点", r.addr, "发送命令流失败.", err)
+			r.mutex.Lock()
+			r.close()
+			r.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// close 关闭连接, 调用者需要持有 mutex
+func (r *replica) close() {
+	if r.closed {
+		return
+	}
+	r.closed = true
+	close(r.signal)
+	go func() { _ = r.conn.Close() }()
+}
+
+func (r *replica) stats() ReplicaStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	state := "wait_bgsave"
+	if r.online {
+		state = "online"
+	}
+	return ReplicaStats{Addr: r.addr, State: state, AckOffset: r.ackOffset, Lag: time.Since(r.ackTime)}
+}
+
+// Psync 处理从节点的 PSYNC replID offset 请求. addr 是从节点的地址, 用于展示.
+// 若能够从 offset 处继续复制, 则回复 +CONTINUE 并发送积压缓冲区中缺失的部分;
+// 否则回复 +FULLRESYNC replID offset, 然后发送快照. 之后持续发送命令流.
+func (m *Manager) Psync(conn ReplicaConn, addr string, replID string, offset int64) error {
+	// 持有 worldLock 期间没有命令被执行和传播, 快照恰好是 offset 处的数据
+	m.worldLock.Lock()
+	m.mutex.Lock()
+	if m.role != RoleMaster {
+		m.mutex.Unlock()
+		m.worldLock.Unlock()
+		return ErrChainedReplication
+	}
+
+	r := newReplica(conn, addr)
+	if data, ok := m.tryPartialSync(replID, offset); ok {
+		m.replicas[conn] = r
+		m.stats.partialSyncs++
+		prefix := []byte("+CONTINUE " + m.replID + "\r\n")
+		m.mutex.Unlock()
+		m.worldLock.Unlock()
+
+		logger.Info("从节点", addr, "部分重同步, 从偏移量", offset, "继续复制.")
+		go r.run(append(prefix, data...))
+		return nil
+	}
+
+	// 全量同步. 之后传播的命令先积累在输出缓冲区中, 等快照发送完之后再发送
+	if replID != "?" {
+		m.stats.partialSyncErrors++
+	}
+	m.stats.fullSyncs++
+	if m.backlog == nil {
+		m.backlog = newBacklog(m.config.BacklogSize, m.offset)
+	}
+	// 命令流的下一条命令之前一定有 select, 从节点不需要知道之前选择的数据库
+	m.currentDB = -1
+	m.replicas[conn] = r
+	prefix := "+FULLRESYNC " + m.replID + " " + strconv.FormatInt(m.offset, 10) + "\r\n"
+	m.mutex.Unlock()
+	dbs := persistent.CopyDBs(m.dbs)
+	m.worldLock.Unlock()
+
+	logger.Info("从节点", addr, "开始全量同步.")
+	var snapshot bytes.Buffer
+	if err := persistent.WriteCopies(&snapshot, dbs); err != nil {
+		m.RemoveReplica(conn)
+		return err
+	}
+
+	data := make([]byte, 0, len(prefix)+snapshot.Len()+32)
+	data = append(data, prefix...)
+	data = append(data, "$"+strconv.Itoa(snapshot.Len())+"\r\n"...)
+	data = append(data, snapshot.Bytes()...)
+	go r.run(data)
+	return nil
+}
+
+// tryPartialSync 检查能否从 replID 的 offset 处继续复制, 能则返回缺失的命令流. 调用者需要持有 mutex.
+func (m *Manager) tryPartialSync(replID string, offset int64) ([]byte, bool) {
+	if m.backlog == nil {
+		return nil, false
+	}
+	if replID != m.replID && (replID != m.replID2 || offset > m.secondOffset) {
+		return nil, false
+	}
+	return m.backlog.readFrom(offset)
+}
+
+// Ack 记录从节点确认的复制偏移量
+func (m *Manager) Ack(conn ReplicaConn, offset int64) {
+	m.mutex.Lock()
+	r, ok := m.replicas[conn]
+	m.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	r.mutex.Lock()
+	r.ackOffset = offset
+	r.ackTime = time.Now()
+	r.mutex.Unlock()
+}
+
+// RemoveReplica 连接断开后移除从节点
+func (m *Manager) RemoveReplica(conn ReplicaConn) {
+	m.mutex.Lock()
+	r, ok := m.replicas[conn]
+	delete(m.replicas, conn)
+	m.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	r.mutex.Lock()
+	r.close()
+	r.mutex.Unlock()
+	logger.Info("从节点", r.addr, "断开连接.")
+}
+
+// disconnectReplicas 断开全部从节点, 调用者需要持有 mutex
+func (m *Manager) disconnectReplicas() {
+	for conn, r := range m.replicas {
+		r.mutex.Lock()
+		r.close()
+		r.mutex.Unlock()
+		delete(m.replicas, conn)
+	}
+}
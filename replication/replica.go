@@ -0,0 +1,336 @@
+package replication
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/persistent"
+	"simple_kvstorage/resp"
+	"simple_kvstorage/resp/reply"
+	"simple_kvstorage/util/logger"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// dialTimeout 连接主节点的超时时间
+	dialTimeout = 5 * time.Second
+	// handshakeTimeout 握手阶段等待主节点回复的超时时间
+	handshakeTimeout = 60 * time.Second
+	// reconnectInterval 与主节点断开之后, 重新连接的间隔
+	reconnectInterval = time.Second
+	// ackInterval 从节点向主节点确认复制偏移量的间隔
+	ackInterval = time.Second
+)
+
+// masterLink 从节点与主节点之间的连接, 断开后会自动重连, 直到 stop 被调用
+type masterLink struct {
+	addr string
+
+	mutex   sync.Mutex
+	conn    net.Conn
+	stopped bool
+	done    chan struct{}
+	// up 连接是否已经完成同步, syncing 是否正在全量同步, lastIO 上次收到主节点数据的时间
+	up      bool
+	syncing bool
+	lastIO  time.Time
+	// dbIndex 命令流中最后一次 select 的数据库, 部分重同步之后继续使用
+	dbIndex int
+}
+
+func newMasterLink(addr string) *masterLink {
+	return &masterLink{addr: addr, done: make(chan struct{})}
+}
+
+// stop 停止复制, 关闭与主节点的连接
+func (l *masterLink) stop() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.stopped {
+		return
+	}
+	l.stopped = true
+	close(l.done)
+	if l.conn != nil {
+		_ = l.conn.Close()
+	}
+}
+
+// setConn 记录当前的连接, 已经停止时返回 false
+func (l *masterLink) setConn(conn net.Conn) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.stopped {
+		return false
+	}
+	l.conn = conn
+	return true
+}
+
+func (l *masterLink) setState(up, syncing bool) {
+	l.mutex.Lock()
+	l.up = up
+	l.syncing = syncing
+	l.lastIO = time.Now()
+	l.mutex.Unlock()
+}
+
+func (l *masterLink) touch() {
+	l.mutex.Lock()
+	l.lastIO = time.Now()
+	l.mutex.Unlock()
+}
+
+func (l *masterLink) status() (up, syncing bool, lastIO time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.lastIO.IsZero() {
+		return l.up, l.syncing, -1
+	}
+	return l.up, l.syncing, time.Since(l.lastIO)
+}
+
+// runLink 不断与主节点同步, 断开后等待一段时间重连, 直到 link 被停止
+func (m *Manager) runLink(link *masterLink) {
+	for {
+		err := m.syncWithMaster(link)
+		link.setState(false, false)
+
+		select {
+		case <-link.done:
+			return
+		default:
+		}
+		logger.Warn("与主节点", link.addr, "的连接断开.", err)
+
+		select {
+		case <-link.done:
+			return
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+// syncWithMaster 连接主节点, 握手并同步, 然后持续执行主节点传播的命令流, 直到连接断开
+func (m *Manager) syncWithMaster(link *masterLink) error {
+	conn, err := net.DialTimeout("tcp", link.addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	if !link.setConn(conn) {
+		return nil
+	}
+
+	// 1. 握手
+	reader := bufio.NewReader(conn)
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if m.config.MasterAuth != "" {
+		if _, err := sendCommand(conn, reader, "auth", m.config.MasterAuth); err != nil {
+			return err
+		}
+	}
+	if _, err := sendCommand(conn, reader, "ping"); err != nil {
+		return err
+	}
+	if _, err := sendCommand(conn, reader, "replconf", "listening-port", strconv.Itoa(m.config.ListeningPort)); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	replID, offset := m.replID, m.offset
+	m.mutex.Unlock()
+	line, err := sendCommand(conn, reader, "psync", replID, strconv.FormatInt(offset, 10))
+	if err != nil {
+		return err
+	}
+
+	// 2. 全量同步或部分重同步
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		masterOffset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid FULLRESYNC reply '%s'", line)
+		}
+		link.setState(false, true)
+		if err := m.loadSnapshot(reader); err != nil {
+			return err
+		}
+
+		m.mutex.Lock()
+		m.replID = fields[1]
+		m.offset = masterOffset
+		m.replID2 = ""
+		m.secondOffset = -1
+		m.backlog = newBacklog(m.config.BacklogSize, masterOffset)
+		m.mutex.Unlock()
+		link.dbIndex = 0
+		logger.Info("从主节点", link.addr, "全量同步完成, 复制偏移量", masterOffset)
+		if m.onFullSync != nil {
+			m.onFullSync()
+		}
+	case len(fields) == 2 && fields[0] == "CONTINUE":
+		m.mutex.Lock()
+		if fields[1] != m.replID {
+			// 主节点发生了切换, 新的主节点认可原来的复制 ID
+			m.replID2 = m.replID
+			m.secondOffset = m.offset
+			m.replID = fields[1]
+		}
+		m.mutex.Unlock()
+		logger.Info("与主节点", link.addr, "部分重同步, 从偏移量", offset, "继续复制.")
+	default:
+		return fmt.Errorf("unexpected PSYNC reply '%s'", line)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	link.setState(true, false)
+
+	// 3. 定期向主节点确认复制偏移量
+	go m.sendAcks(link, conn)
+
+	// 4. 执行命令流
+	return m.applyStream(link, reader)
+}
+
+// sendCommand 发送一条命令并读取一行回复, 返回去掉了类型前缀的回复. 错误回复会被转换为 error.
+func sendCommand(conn net.Conn, reader *bufio.Reader, args ...string) (string, error) {
+	if _, err := conn.Write(reply.NewMultiBulkReply(toCmdLine(args...)).ToBytes()); err != nil {
+		return "", err
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 || line[0] != '+' {
+		return "", fmt.Errorf("master replied '%s' to %s", line, args[0])
+	}
+	return line[1:], nil
+}
+
+// loadSnapshot 读取主节点发送的快照 ($长度\r\n快照), 替换全部数据库中的数据
+func (m *Manager) loadSnapshot(reader *bufio.Reader) error {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 || line[0] != '$' {
+		return fmt.Errorf("unexpected snapshot header '%s'", line)
+	}
+	size, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid snapshot length '%s'", line)
+	}
+
+	for _, db := range m.dbs {
+		db.Flush()
+	}
+	snapshot := io.LimitReader(reader, size)
+	loaded, err := persistent.ReadRdb(snapshot, m.dbs)
+	if err != nil {
+		return err
+	}
+	// ReadRdb 可能没有读完缓冲的部分, 跳过快照剩余的字节
+	if _, err := io.Copy(io.Discard, snapshot); err != nil {
+		return err
+	}
+	logger.Info("从主节点的快照中加载了", loaded, "个键值对.")
+	return nil
+}
+
+// applyStream 不断执行主节点传播的命令, 并将命令流追加到积压缓冲区中, 直到连接断开
+func (m *Manager) applyStream(link *masterLink, reader io.Reader) error {
+	parseChan := resp.CreateParser(reader)
+	defer func() {
+		for range parseChan {
+		}
+	}()
+
+	for payload := range parseChan {
+		if payload.Error != nil {
+			return payload.Error
+		}
+		multiBulk, ok := payload.Data.(*reply.MultiBulkReply)
+		if !ok || len(multiBulk.Args) == 0 {
+			return errors.New("unexpected data in replication stream")
+		}
+		link.touch()
+
+		cmdLine := multiBulk.Args
+		if strings.ToLower(string(cmdLine[0])) == "select" {
+			index := -1
+			if len(cmdLine) == 2 {
+				index, _ = strconv.Atoi(string(cmdLine[1]))
+			}
+			if index < 0 || index >= len(m.dbs) {
+				return fmt.Errorf("invalid DB index '%s' in replication stream", cmdLine[1:])
+			}
+			link.dbIndex = index
+		} else {
+			m.apply(link.dbIndex, cmdLine)
+		}
+
+		// 主节点与 AOF 使用相同的编码, 重新编码的结果与收到的字节相同
+		m.mutex.Lock()
+		m.feedBacklog(multiBulk.ToBytes())
+		m.mutex.Unlock()
+	}
+	return io.EOF
+}
+
+// apply 执行主节点传播的一条命令
+func (m *Manager) apply(dbIndex int, cmdLine executor.CmdLine) {
+	if m.applyLock != nil {
+		m.applyLock.Lock()
+		defer m.applyLock.Unlock()
+	}
+	result := executor.Exec(m.dbs[dbIndex], cmdLine)
+	if reply.IsErrorReply(result) {
+		logger.Warn("执行主节点传播的命令失败.", strings.TrimSpace(string(result.ToBytes())))
+		return
+	}
+	if m.onApply != nil {
+		m.onApply(dbIndex, cmdLine)
+	}
+}
+
+// sendAcks 每隔 ackInterval 向主节点发送 REPLCONF ACK offset, 直到连接关闭
+func (m *Manager) sendAcks(link *masterLink, conn net.Conn) {
+	ticker := time.NewTicker(ackInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-link.done:
+			return
+		case <-ticker.C:
+		}
+
+		m.mutex.Lock()
+		offset := m.offset
+		m.mutex.Unlock()
+		ack := reply.NewMultiBulkReply(toCmdLine("replconf", "ack", strconv.FormatInt(offset, 10))).ToBytes()
+		if _, err := conn.Write(ack); err != nil {
+			return
+		}
+	}
+}
+
+func toCmdLine(args ...string) [][]byte {
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	return cmdLine
+}
@@ -0,0 +1,292 @@
+package replication
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"simple_kvstorage/database"
+	"simple_kvstorage/executor"
+	"simple_kvstorage/persistent"
+	"simple_kvstorage/util/logger"
+	"sync"
+	"time"
+)
+
+// Role 节点在主从复制中的角色
+type Role int
+
+const (
+	RoleMaster Role = iota
+	RoleReplica
+)
+
+func (r Role) String() string {
+	if r == RoleReplica {
+		return "slave"
+	}
+	return "master"
+}
+
+// ErrChainedReplication 从节点不能再拥有从节点
+var ErrChainedReplication = errors.New("ERROR chained replication is not supported")
+
+// ErrReadOnly 从节点拒绝执行客户端的写命令
+var ErrReadOnly = errors.New("READONLY You can't write against a read only replica.")
+
+// Config 主从复制的配置
+type Config struct {
+	// BacklogSize 复制积压缓冲区的大小
+	BacklogSize int
+	// ListeningPort 本节点的服务端口, 作为从节点时告知主节点
+	ListeningPort int
+	// MasterAuth 主节点设置的密码 (requirepass), 作为从节点时先以它认证, 空字符串表示不认证
+	MasterAuth string
+}
+
+// Manager 管理本节点的主从复制.
+// 作为主节点时, 将写命令传播给全部从节点, 并保存在积压缓冲区中以支持部分重同步;
+// 作为从节点时, 从主节点同步快照, 然后持续执行主节点传播的命令流.
+type Manager struct {
+	config Config
+	dbs    []database.DB
+
+	// onApply 从节点执行了主节点传播的命令之后调用, onFullSync 从节点加载了主节点的快照之后调用
+	onApply    func(dbIndex int, cmdLine executor.CmdLine)
+	onFullSync func()
+	// applyLock 执行主节点传播的命令及调用 onApply 期间持有, 见 SetApplyLock
+	applyLock sync.Locker
+	// worldLock 持有时数据库不会被修改, 也不会有命令被传播, 见 SetWorldLock
+	worldLock sync.Locker
+
+	mutex sync.Mutex
+	role  Role
+	// replID 当前的复制 ID, offset 当前的复制偏移量
+	replID string
+	offset int64
+	// replID2 与 secondOffset 成为主节点之前所属的复制 ID 与偏移量, 原来的兄弟节点可以凭此部分重同步
+	replID2      string
+	secondOffset int64
+	// backlog 第一个从节点连接时才创建
+	backlog *backlog
+
+	// 主节点的状态
+	// currentDB 命令流中最后一次 select 的数据库
+	currentDB int
+	replicas  map[ReplicaConn]*replica
+	stats     masterStats
+
+	// 从节点的状态
+	link *masterLink
+}
+
+// masterStats 主节点的统计数据
+type masterStats struct {
+	fullSyncs         int64
+	partialSyncs      int64
+	partialSyncErrors int64
+}
+
+// NewManager 创建主从复制管理器, 初始角色为主节点
+func NewManager(config *Config, dbs []database.DB) *Manager {
+	return &Manager{
+		config:       *config,
+		dbs:          dbs,
+		worldLock:    new(sync.Mutex),
+		replID:       newReplID(),
+		secondOffset: -1,
+		currentDB:    -1,
+		replicas:     make(map[ReplicaConn]*replica),
+	}
+}
+
+// newReplID 生成一个随机的 40 位十六进制复制 ID
+func newReplID() string {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		logger.Warn("生成复制 ID 失败.", err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// OnApply 设置从节点执行了主节点传播的命令之后的回调, 用于持久化
+func (m *Manager) OnApply(callback func(dbIndex int, cmdLine executor.CmdLine)) {
+	m.onApply = callback
+}
+
+// SetApplyLock 设置从节点执行主节点传播的命令时持有的锁, 使命令的执行与持久化不会与其他需要独占数据库的操作交错
+func (m *Manager) SetApplyLock(lock sync.Locker) {
+	m.applyLock = lock
+}
+
+// SetWorldLock 设置主节点修改数据库时使用的锁. 修改数据库的一方在持有锁期间执行命令并调用 Propagate,
+// 全量同步时持有锁记录复制偏移量并复制数据库, 使快照与之后的命令流恰好衔接.
+func (m *Manager) SetWorldLock(lock sync.Locker) {
+	m.worldLock = lock
+}
+
+// OnFullSync 设置从节点加载了主节点的快照之后的回调
+func (m *Manager) OnFullSync(callback func()) {
+	m.onFullSync = callback
+}
+
+// Role 返回本节点当前的角色
+func (m *Manager) Role() Role {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.role
+}
+
+// IsReplica 本节点是否是从节点. 从节点拒绝执行客户端的写命令.
+func (m *Manager) IsReplica() bool {
+	return m.Role() == RoleReplica
+}
+
+// Propagate 将主节点上执行成功的写命令传播给全部从节点. 本节点是从节点时什么也不做.
+func (m *Manager) Propagate(dbIndex int, cmdLine executor.CmdLine) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// 还没有从节点连接过, 不需要维护命令流
+	if m.role != RoleMaster || m.backlog == nil {
+		return
+	}
+
+	data := persistent.EncodeCmd(nil, &m.currentDB, dbIndex, cmdLine)
+	m.feedBacklog(data)
+	for conn, r := range m.replicas {
+		if !r.send(data) {
+			logger.Warn("从节点", r.addr, "的输出缓冲区超过了上限, 断开连接.")
+			delete(m.replicas, conn)
+		}
+	}
+}
+
+// feedBacklog 将命令流追加到积压缓冲区中, 并增加复制偏移量. 调用者需要持有 mutex.
+func (m *Manager) feedBacklog(data []byte) {
+	if m.backlog == nil {
+		m.backlog = newBacklog(m.config.BacklogSize, m.offset)
+	}
+	m.backlog.write(data)
+	m.offset += int64(len(data))
+}
+
+// ReplicaOf 成为 host:port 的从节点. 已经是它的从节点时什么也不做.
+// 原来的从节点都会被断开, 数据会在同步完成后被主节点的快照替换.
+func (m *Manager) ReplicaOf(addr string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.role == RoleReplica {
+		if m.link.addr == addr {
+			return
+		}
+		m.link.stop()
+	}
+	m.disconnectReplicas()
+
+	m.role = RoleReplica
+	m.link = newMasterLink(addr)
+	go m.runLink(m.link)
+	logger.Info("成为", addr, "的从节点.")
+}
+
+// ReplicaOfNoOne 断开与主节点的连接, 成为主节点. 数据保持不变.
+// 原来的复制 ID 被记为 replID2, 原来的兄弟节点之后可以向本节点发起部分重同步.
+func (m *Manager) ReplicaOfNoOne() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.role == RoleMaster {
+		return
+	}
+	m.link.stop()
+	m.link = nil
+
+	m.role = RoleMaster
+	m.replID2 = m.replID
+	m.secondOffset = m.offset
+	m.replID = newReplID()
+	m.currentDB = -1
+	logger.Info("成为主节点, 新的复制 ID 为", m.replID)
+}
+
+// Close 断开全部主从复制的连接
+func (m *Manager) Close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.link != nil {
+		m.link.stop()
+	}
+	m.disconnectReplicas()
+}
+
+// Stats 主从复制的统计数据
+type Stats struct {
+	Role         Role
+	ReplID       string
+	ReplID2      string
+	Offset       int64
+	SecondOffset int64
+	// BacklogActive 积压缓冲区是否已经创建, BacklogSize 其大小, BacklogFirstOffset 其中第一个字节的偏移量, BacklogHistLen 其中数据的字节数
+	BacklogActive      bool
+	BacklogSize        int
+	BacklogFirstOffset int64
+	BacklogHistLen     int64
+
+	// 主节点的统计数据
+	Replicas          []ReplicaStats
+	FullSyncs         int64
+	PartialSyncs      int64
+	PartialSyncErrors int64
+
+	// 从节点的统计数据
+	MasterAddr           string
+	MasterLinkUp         bool
+	MasterSyncInProgress bool
+	// MasterLastIO 距离上次收到主节点数据的时间
+	MasterLastIO time.Duration
+}
+
+// ReplicaStats 一个从节点的统计数据
+type ReplicaStats struct {
+	Addr  string
+	State string
+	// AckOffset 从节点确认的复制偏移量, Lag 距离上次确认的时间
+	AckOffset int64
+	Lag       time.Duration
+}
+
+// Stats 返回主从复制的统计数据
+func (m *Manager) Stats() Stats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stats := Stats{
+		Role:              m.role,
+		ReplID:            m.replID,
+		ReplID2:           m.replID2,
+		Offset:            m.offset,
+		SecondOffset:      m.secondOffset,
+		BacklogSize:       m.config.BacklogSize,
+		FullSyncs:         m.stats.fullSyncs,
+		PartialSyncs:      m.stats.partialSyncs,
+		PartialSyncErrors: m.stats.partialSyncErrors,
+	}
+	if stats.ReplID2 == "" {
+		stats.ReplID2 = "0000000000000000000000000000000000000000"
+	}
+	if m.backlog != nil {
+		stats.BacklogActive = true
+		stats.BacklogFirstOffset = m.backlog.start
+		stats.BacklogHistLen = m.backlog.histLen()
+	}
+	for _, r := range m.replicas {
+		stats.Replicas = append(stats.Replicas, r.stats())
+	}
+	if m.link != nil {
+		stats.MasterAddr = m.link.addr
+		stats.MasterLinkUp, stats.MasterSyncInProgress, stats.MasterLastIO = m.link.status()
+	}
+	return stats
+}
@@ -0,0 +1,136 @@
+package resp
+
+import (
+	"io"
+	"simple_kvstorage/resp/reply"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// conformanceFixture 一段字节流, 以及解析之后按 RESP2 重新编码的每个报文. 错误的报文以 "-" 开头.
+type conformanceFixture struct {
+	name     string
+	input    string
+	expected []string
+}
+
+// conformanceFixtures RESP2 的一致性测试, 重新编码的结果必须与输入的字节完全相同
+var conformanceFixtures = []conformanceFixture{
+	{"simple string", "+OK\r\n", []string{"+OK\r\n"}},
+	{"empty simple string", "+\r\n", []string{"+\r\n"}},
+	{"error", "-ERR unknown command\r\n", []string{"-ERR unknown command\r\n"}},
+	{"integer", ":1024\r\n", []string{":1024\r\n"}},
+	{"negative integer", ":-1\r\n", []string{":-1\r\n"}},
+	{"bulk", "$5\r\nhello\r\n", []string{"$5\r\nhello\r\n"}},
+	{"bulk with CRLF", "$7\r\nhe\r\nllo\r\n", []string{"$7\r\nhe\r\nllo\r\n"}},
+	{"bulk starting with $", "$3\r\n$-1\r\n", []string{"$3\r\n$-1\r\n"}},
+	{"empty bulk", "$0\r\n\r\n", []string{"$0\r\n\r\n"}},
+	{"null bulk", "$-1\r\n", []string{"$-1\r\n"}},
+	{"empty array", "*0\r\n", []string{"*0\r\n"}},
+	{"null array", "*-1\r\n", []string{"*-1\r\n"}},
+	{"array", "*2\r\n$3\r\nget\r\n$3\r\nkey\r\n", []string{"*2\r\n$3\r\nget\r\n$3\r\nkey\r\n"}},
+	{"array with empty bulk", "*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$0\r\n\r\n", []string{"*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$0\r\n\r\n"}},
+	{"array with null bulk", "*3\r\n$5\r\nhello\r\n$-1\r\n$5\r\nworld\r\n", []string{"*3\r\n$5\r\nhello\r\n$-1\r\n$5\r\nworld\r\n"}},
+	{"nested array", "*2\r\n:1\r\n*2\r\n$1\r\na\r\n*0\r\n", []string{"*2\r\n:1\r\n*2\r\n$1\r\na\r\n*0\r\n"}},
+	{"array with null after integer", "*3\r\n$1\r\na\r\n:2\r\n$-1\r\n", []string{"*3\r\n$1\r\na\r\n:2\r\n$-1\r\n"}},
+	{"pipeline", "+OK\r\n$0\r\n\r\n*-1\r\n:0\r\n", []string{"+OK\r\n", "$0\r\n\r\n", "*-1\r\n", ":0\r\n"}},
+	{"invalid bulk length", "$-2\r\n", []string{"-ERROR Protocol error: '$-2\r\n'\r\n"}},
+	{"invalid array length", "*-2\r\n", []string{"-ERROR Protocol error: '*-2\r\n'\r\n"}},
+	{"missing CR", "+OK\n", []string{"-ERROR Protocol error: '+OK\n'\r\n"}},
+	// 长度与内容不符时, 读取的 length + 2 个字节不以 CRLF 结尾, 剩余部分被当作下一个报文
+	{"bulk length mismatch", "$2\r\nhello\r\n", []string{"-ERROR Protocol error: 'hell'\r\n", "-ERROR Protocol error: 'o\r\n'\r\n"}},
+}
+
+// parseAll 解析全部报文并按 RESP2 重新编码, 直到 EOF
+func parseAll(t *testing.T, payloads <-chan *Payload) []string {
+	var result []string
+	for payload := range payloads {
+		switch {
+		case payload.Error == nil:
+			result = append(result, string(payload.Data.ToBytes()))
+		case isIOError(payload.Error):
+			return result
+		default:
+			errorReply, ok := payload.Error.(reply.ErrorReply)
+			if !ok {
+				t.Fatal("解析错误不是 ErrorReply.", payload.Error)
+			}
+			result = append(result, string(errorReply.ToBytes()))
+		}
+	}
+	return result
+}
+
+// decodeAll 用 decoder 解析全部报文, 直到解析停止. 协议错误转换为其编码, IO 错误不包括在结果中.
+func decodeAll(t *testing.T, decoder *Decoder) []string {
+	var result []string
+	for {
+		theReply, err := decoder.Decode()
+		switch {
+		case err == nil:
+			result = append(result, string(theReply.ToBytes()))
+			continue
+		case !isIOError(err):
+			result = append(result, string(err.(reply.ErrorReply).ToBytes()))
+		}
+		if decoder.Err() != nil {
+			return result
+		}
+	}
+}
+
+func isIOError(err error) bool {
+	_, ok := err.(reply.ErrorReply)
+	return !ok
+}
+
+func TestConformance(t *testing.T) {
+	for _, fixture := range conformanceFixtures {
+		// 一次读取一个字节, 报文被拆分到多次读取中时结果相同
+		for _, oneByte := range []bool{false, true} {
+			var reader io.Reader = strings.NewReader(fixture.input)
+			if oneByte {
+				reader = iotest.OneByteReader(reader)
+			}
+			actual := parseAll(t, CreateParser(reader))
+			if strings.Join(actual, "|") != strings.Join(fixture.expected, "|") {
+				t.Errorf("%s (one byte: %v): %q, 期望 %q", fixture.name, oneByte, actual, fixture.expected)
+			}
+		}
+	}
+}
+
+func TestConformance_NullAndEmpty(t *testing.T) {
+	payloads := CreateParser(strings.NewReader("$0\r\n\r\n$-1\r\n*2\r\n$0\r\n\r\n$-1\r\n*-1\r\n*0\r\n"))
+
+	if bulk, ok := (<-payloads).Data.(*reply.BulkReply); !ok || bulk.Bytes() == nil || len(bulk.Bytes()) != 0 {
+		t.Error("$0 应该是空字符串.")
+	}
+	if _, ok := (<-payloads).Data.(*reply.NullBulkReply); !ok {
+		t.Error("$-1 应该是 nil.")
+	}
+	if multiBulk, ok := (<-payloads).Data.(*reply.MultiBulkReply); !ok || multiBulk.Args[0] == nil || multiBulk.Args[1] != nil {
+		t.Error("数组中的空字符串与 nil 应该被区分.")
+	}
+	if _, ok := (<-payloads).Data.(*reply.NullMultiBulkReply); !ok {
+		t.Error("*-1 应该是 Null Array.")
+	}
+	if _, ok := (<-payloads).Data.(*reply.EmptyMultiBulkReply); !ok {
+		t.Error("*0 应该是空数组.")
+	}
+}
+
+func TestConformance_Request(t *testing.T) {
+	// 客户端的命令可以有空字符串参数, 但不能有 nil 参数, 也不能有 Bulk 以外的元素
+	input := "*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$0\r\n\r\n" + "*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$-1\r\n" + "*2\r\n$3\r\nget\r\n:1\r\n"
+	actual := decodeAll(t, NewRequestDecoder(strings.NewReader(input), nil))
+	expected := []string{
+		"*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$0\r\n\r\n",
+		"-ERROR Protocol error: 'null bulk in request'\r\n",
+		"-ERROR Protocol error: ':1\r\n'\r\n",
+	}
+	if strings.Join(actual, "|") != strings.Join(expected, "|") {
+		t.Errorf("%q, 期望 %q", actual, expected)
+	}
+}
@@ -0,0 +1,123 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"simple_kvstorage/resp/reply"
+)
+
+// readBufferSize 读缓冲区的大小, 与 Redis 的 PROTO_IOBUF_LEN 相同. 管道中连续发送的命令一次读入缓冲区, 逐个解析.
+const readBufferSize = 16 * 1024
+
+// Decoder 在调用者的协程上同步地解析 RESP 报文, 每次调用 Decode 解析一个完整的报文.
+// 与 CreateParser 相比没有协程与管道的开销, 管道中的多条命令从同一个读缓冲区中解析, 只有缓冲区读完时才读取底层的连接.
+type Decoder struct {
+	reader *bufio.Reader
+	// limits 解析客户端命令时的限制, nil 表示解析的是服务端的回复
+	limits *Limits
+	// offset 已经读取的字节数, 见 Payload.Offset
+	offset int64
+	// err 使解析停止的错误, 即 IO 错误或超过限制的错误, 之后的 Decode 都返回此错误
+	err error
+	// state 每个报文复用的解析状态
+	state parseState
+}
+
+// NewDecoder 创建一个解析 RESP 报文的 Decoder, 与 CreateParser 解析的内容相同
+func NewDecoder(reader io.Reader) *Decoder {
+	return newDecoder(reader, nil)
+}
+
+// NewRequestDecoder 创建一个解析客户端命令的 Decoder. 不以 `*` 开头的行被当作 inline 命令,
+// 如在 telnet 或 nc 中输入的 "PING\r\n", 解析为与 Multi Bulk 相同的回复, 空行会被忽略. 命令中的 nil 参数是协议错误.
+// 命令超过 limits 时 (nil 表示使用 DefaultLimits) 返回协议错误, 之后不再继续解析.
+func NewRequestDecoder(reader io.Reader, limits *Limits) *Decoder {
+	if limits == nil {
+		limits = &DefaultLimits
+	}
+	return newDecoder(reader, limits)
+}
+
+func newDecoder(reader io.Reader, limits *Limits) *Decoder {
+	return &Decoder{reader: bufio.NewReaderSize(reader, readBufferSize), limits: limits}
+}
+
+// Decode 解析下一个完整的报文. 返回的错误若不是 reply.ErrorReply 则是 IO 错误.
+// 协议错误之后可以继续调用 Decode 解析后面的报文, Err 不为 nil 时则不能继续解析了.
+func (d *Decoder) Decode() (reply.Reply, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	for {
+		// 1. inline 命令, 空行被忽略
+		if d.limits != nil {
+			first, err := d.reader.Peek(1)
+			if err != nil {
+				d.err = err
+				return nil, err
+			}
+			if first[0] != '*' {
+				args, n, err, isIOError := readInline(d.reader)
+				d.offset += n
+				if err != nil {
+					if isIOError {
+						d.err = err
+					}
+					return nil, err
+				}
+				if len(args) == 0 {
+					continue
+				}
+				return reply.NewMultiBulkReply(args), nil
+			}
+		}
+
+		// 2. 解析一个完整的报文
+		d.state = parseState{limits: d.limits}
+		theReply, err := parseMessage(d.nextLine, &d.state)
+		if err != nil {
+			if isFatal(err) {
+				d.err = err
+			}
+			return nil, err
+		}
+
+		// 3. 客户端的命令中不能有 nil 参数
+		if d.limits != nil {
+			if multiBulk, ok := theReply.(*reply.MultiBulkReply); ok {
+				for _, arg := range multiBulk.Args {
+					if arg == nil {
+						return nil, reply.NewProtocolErrorReply("null bulk in request")
+					}
+				}
+			}
+		}
+		return theReply, nil
+	}
+}
+
+// nextLine 供 parseMessage 读取下一行报文, 发生 IO 错误时停止解析
+func (d *Decoder) nextLine(state *parseState) ([]byte, error) {
+	line, err, isIOError := readLine(d.reader, state)
+	d.offset += int64(len(line))
+	if isIOError {
+		d.err = err
+	}
+	return line, err
+}
+
+// Err 返回使解析停止的错误, 为 nil 时可以继续解析
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// Offset 返回已经读取的字节数
+func (d *Decoder) Offset() int64 {
+	return d.offset
+}
+
+// Buffered 返回读缓冲区中尚未解析的字节数
+func (d *Decoder) Buffered() int {
+	return d.reader.Buffered()
+}
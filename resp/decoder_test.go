@@ -0,0 +1,102 @@
+package resp
+
+import (
+	"bytes"
+	"io"
+	"simple_kvstorage/resp/reply"
+	"strings"
+	"testing"
+)
+
+func TestDecoder(t *testing.T) {
+	// 管道中连续发送的命令, 中间有一个协议错误和一个空行
+	input := "*2\r\n$3\r\nget\r\n$1\r\na\r\n" +
+		"*1\r\n$x\r\n" +
+		"\r\n" +
+		"PING\r\n" +
+		"*3\r\n$3\r\nset\r\n$1\r\nb\r\n$0\r\n\r\n"
+	decoder := NewRequestDecoder(strings.NewReader(input), nil)
+
+	expected := []string{"get a", "", "ping", "set b "}
+	for i, want := range expected {
+		theReply, err := decoder.Decode()
+		if want == "" {
+			if _, ok := err.(reply.ErrorReply); !ok || decoder.Err() != nil {
+				t.Fatalf("第 %d 个报文应该是可以恢复的协议错误: %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("第 %d 个报文解析失败: %v", i, err)
+		}
+		args := theReply.(*reply.MultiBulkReply).Args
+		if got := strings.ToLower(string(bytes.Join(args, []byte(" ")))); got != want {
+			t.Errorf("第 %d 个报文应该是 %q, 实际是 %q", i, want, got)
+		}
+	}
+	if decoder.Offset() != int64(len(input)) {
+		t.Errorf("已读取的字节数应该是 %d, 实际是 %d", len(input), decoder.Offset())
+	}
+
+	// 流结束之后, 一直返回 EOF
+	for i := 0; i < 2; i++ {
+		if _, err := decoder.Decode(); err != io.EOF || decoder.Err() != io.EOF {
+			t.Fatal("流结束之后应该返回 EOF.", err)
+		}
+	}
+}
+
+func TestDecoder_Fatal(t *testing.T) {
+	input := "*1\r\n$9999999999\r\n*1\r\n$4\r\nPING\r\n"
+	decoder := NewRequestDecoder(strings.NewReader(input), &Limits{MaxBulkLen: 16})
+	if _, err := decoder.Decode(); err != errInvalidBulkLength {
+		t.Fatal("超过限制时应该返回 invalid bulk length.", err)
+	}
+	if _, err := decoder.Decode(); err != errInvalidBulkLength {
+		t.Fatal("超过限制之后不应该继续解析.", err)
+	}
+}
+
+// repeatReader 无限重复同一段数据
+type repeatReader struct {
+	data   []byte
+	offset int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		copied := copy(p[n:], r.data[r.offset:])
+		n += copied
+		r.offset = (r.offset + copied) % len(r.data)
+	}
+	return n, nil
+}
+
+func TestDecoder_Allocs(t *testing.T) {
+	// 每条命令分配参数的切片, 每个参数的内容与回复对象, 解析首部不分配内存
+	command := reply.NewMultiBulkReply([][]byte{[]byte("set"), []byte("key:000000000001"), []byte("xxx")}).ToBytes()
+	decoder := NewRequestDecoder(&repeatReader{data: command}, nil)
+	allocs := testing.AllocsPerRun(1000, func() {
+		if _, err := decoder.Decode(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 3+2 {
+		t.Error("解析一条命令的分配次数过多.", allocs)
+	}
+}
+
+// BenchmarkDecoder_Decode 解析管道中的 SET 命令, 每条命令分配 参数个数 + 2 次, 见 TestDecoder_Allocs
+func BenchmarkDecoder_Decode(b *testing.B) {
+	command := reply.NewMultiBulkReply([][]byte{[]byte("set"), []byte("key:000000000001"), []byte("xxx")}).ToBytes()
+	decoder := NewRequestDecoder(&repeatReader{data: command}, nil)
+	b.SetBytes(int64(len(command)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := decoder.Decode(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+)
+
+// maxInlineLength inline 命令一行的最大长度, 超过时回复错误并丢弃这一行, 不会把整行读入内存
+const maxInlineLength = 64 * 1024
+
+// readInline 读取一行 inline 命令, 如 telnet 或 nc 中输入的 "SET key \"hello world\"\r\n".
+// 行尾可以是 CRLF 或 LF. 返回解析出的参数 (空行返回 nil), 读取的字节数, 具体的错误, 以及错误是否是 IO 错误.
+func readInline(bufferReader *bufio.Reader) ([][]byte, int64, error, bool) {
+	var line []byte
+	var n int64
+	tooLong := false
+	for {
+		fragment, err := bufferReader.ReadSlice('\n')
+		n += int64(len(fragment))
+		if !tooLong {
+			if len(line)+len(fragment) > maxInlineLength+2 {
+				tooLong = true
+				line = nil
+			} else {
+				line = append(line, fragment...)
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, n, err, true
+		}
+		break
+	}
+
+	if tooLong {
+		return nil, n, reply.NewProtocolErrorReply("too big inline request"), false
+	}
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte{'\n'}), []byte{'\r'})
+	args, err := splitInlineArgs(line)
+	if err != nil {
+		return nil, n, err, false
+	}
+	return args, n, nil, false
+}
+
+// SplitInlineArgs 按 inline 命令的规则分割一行命令, 供命令行客户端解析用户的输入, 见 splitInlineArgs
+func SplitInlineArgs(line string) ([][]byte, error) {
+	return splitInlineArgs([]byte(line))
+}
+
+// splitInlineArgs 按空白字符分割 inline 命令, 与 redis-cli 的规则相同:
+// 双引号中支持 \n \r \t \b \a \\ \" 与 \xHH 转义, 单引号中只支持 \' 转义, 引号结束之后必须是空白字符或行尾.
+func splitInlineArgs(line []byte) ([][]byte, error) {
+	var args [][]byte
+	i := 0
+	for {
+		for i < len(line) && isInlineSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg []byte
+		inDoubleQuotes, inSingleQuotes := false, false
+		for done := false; !done; {
+			if inDoubleQuotes {
+				switch {
+				case i == len(line):
+					return nil, reply.NewProtocolErrorReply("unbalanced quotes in request")
+				case line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]):
+					b, _ := strconv.ParseUint(string(line[i+2:i+4]), 16, 8)
+					arg = append(arg, byte(b))
+					i += 3
+				case line[i] == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				case line[i] == '"':
+					// 引号之后必须是空白字符或行尾
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, reply.NewProtocolErrorReply("unbalanced quotes in request")
+					}
+					done = true
+				default:
+					arg = append(arg, line[i])
+				}
+			} else if inSingleQuotes {
+				switch {
+				case i == len(line):
+					return nil, reply.NewProtocolErrorReply("unbalanced quotes in request")
+				case line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					arg = append(arg, '\'')
+				case line[i] == '\'':
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, reply.NewProtocolErrorReply("unbalanced quotes in request")
+					}
+					done = true
+				default:
+					arg = append(arg, line[i])
+				}
+			} else {
+				switch {
+				case i == len(line) || isInlineSpace(line[i]):
+					done = true
+				case line[i] == '"':
+					inDoubleQuotes = true
+				case line[i] == '\'':
+					inSingleQuotes = true
+				default:
+					arg = append(arg, line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		if arg == nil {
+			arg = []byte{}
+		}
+		args = append(args, arg)
+	}
+}
+
+func isInlineSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+func isHexDigit(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
@@ -0,0 +1,69 @@
+package resp
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitInlineArgs(t *testing.T) {
+	testCases := []struct {
+		line string
+		args []string
+	}{
+		{"PING", []string{"PING"}},
+		{"  set   key\tvalue  ", []string{"set", "key", "value"}},
+		{`set key "hello world"`, []string{"set", "key", "hello world"}},
+		{`set key "a\"b\\c\n\x41"`, []string{"set", "key", "a\"b\\c\nA"}},
+		{`set key 'it\'s \n'`, []string{"set", "key", `it's \n`}},
+		{`set key ""`, []string{"set", "key", ""}},
+		{"", nil},
+	}
+	for _, testCase := range testCases {
+		args, err := splitInlineArgs([]byte(testCase.line))
+		if err != nil {
+			t.Error("分割失败.", testCase.line, err)
+			continue
+		}
+		var strs []string
+		for _, arg := range args {
+			strs = append(strs, string(arg))
+		}
+		if !reflect.DeepEqual(strs, testCase.args) {
+			t.Errorf("分割结果错误. %q: %q, 期望 %q", testCase.line, strs, testCase.args)
+		}
+	}
+
+	for _, line := range []string{`get "key`, `get 'key`, `get "key"x`, `get 'key'x`} {
+		if _, err := splitInlineArgs([]byte(line)); err == nil {
+			t.Error("引号不匹配时应该返回错误.", line)
+		}
+	}
+}
+
+func TestRequestDecoder_Inline(t *testing.T) {
+	input := "PING\r\n" +
+		"\r\n" +
+		"set key \"hello world\"\n" +
+		"*2\r\n$3\r\nget\r\n$3\r\nkey\r\n" +
+		"get \"key\r\n" +
+		"echo " + strings.Repeat("x", maxInlineLength) + "\r\n" +
+		"get key\r\n"
+	expected := []string{
+		"*1\r\n$4\r\nPING\r\n",
+		"*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$11\r\nhello world\r\n",
+		"*2\r\n$3\r\nget\r\n$3\r\nkey\r\n",
+		"-ERROR Protocol error: 'unbalanced quotes in request'\r\n",
+		"-ERROR Protocol error: 'too big inline request'\r\n",
+		"*2\r\n$3\r\nget\r\n$3\r\nkey\r\n",
+	}
+
+	decoder := NewRequestDecoder(strings.NewReader(input), nil)
+	if actual := decodeAll(t, decoder); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("解析的报文错误. %q, 期望 %q", actual, expected)
+	}
+	if decoder.Err() != io.EOF || decoder.Offset() != int64(len(input)) {
+		t.Error("读取的字节数错误.", decoder.Err(), decoder.Offset(), len(input))
+	}
+}
@@ -0,0 +1,100 @@
+package resp
+
+import (
+	"bufio"
+	"simple_kvstorage/resp/reply"
+)
+
+// Limits 解析客户端命令时的限制, 防止客户端声明的长度耗尽服务器的内存. 值为 0 的字段表示不限制.
+// 超过限制时解析器回复协议错误并停止解析, 服务器随后关闭这个连接.
+type Limits struct {
+	// MaxBulkLen 单个 Bulk 的最大长度, 对应配置 proto-max-bulk-len
+	MaxBulkLen int64
+	// MaxMultiBulkLen 数组的最大元素数量, 对应配置 proto-max-multibulk-len
+	MaxMultiBulkLen int64
+	// QueryBufferLimit 一条命令中全部 Bulk 的总长度上限, 对应配置 client-query-buffer-limit
+	QueryBufferLimit int64
+}
+
+// DefaultLimits 默认的限制, 与 Redis 相同
+var DefaultLimits = Limits{
+	MaxBulkLen:       512 << 20,
+	MaxMultiBulkLen:  1024 * 1024,
+	QueryBufferLimit: 1 << 30,
+}
+
+const (
+	// maxArgsPrealloc 按数组首部预先分配的元素数量的上限, 更多的元素随着数据的到达再扩容
+	maxArgsPrealloc = 1024
+	// maxBulkPrealloc 按 Bulk 首部预先分配的字节数的上限
+	maxBulkPrealloc = 64 * 1024
+)
+
+// 超过限制时的错误, 这些错误发生之后无法再从流中找到下一个命令的开始, 需要关闭连接
+var (
+	errInvalidBulkLength      = reply.NewProtocolErrorReply("invalid bulk length")
+	errInvalidMultiBulkLength = reply.NewProtocolErrorReply("invalid multibulk length")
+	errQueryBufferLimit       = reply.NewProtocolErrorReply("query buffer limit exceeded")
+	errTooBigCountString      = reply.NewProtocolErrorReply("too big count string")
+)
+
+// isFatal 错误发生之后是否需要停止解析
+func isFatal(err error) bool {
+	return err == errInvalidBulkLength || err == errInvalidMultiBulkLength ||
+		err == errQueryBufferLimit || err == errTooBigCountString
+}
+
+// checkBulkLen 校验一个 Bulk 的长度, 并累计到命令的总长度中
+func (s *parseState) checkBulkLen(length int64) error {
+	if s.limits == nil || length < 0 {
+		return nil
+	}
+	if s.limits.MaxBulkLen > 0 && length > s.limits.MaxBulkLen {
+		return errInvalidBulkLength
+	}
+	s.size += length
+	if s.limits.QueryBufferLimit > 0 && s.size > s.limits.QueryBufferLimit {
+		return errQueryBufferLimit
+	}
+	return nil
+}
+
+// checkMultiBulkLen 校验数组的元素数量
+func (s *parseState) checkMultiBulkLen(count int64) error {
+	if s.limits != nil && s.limits.MaxMultiBulkLen > 0 && count > s.limits.MaxMultiBulkLen {
+		return errInvalidMultiBulkLength
+	}
+	return nil
+}
+
+// readLimitedLine 读取以 LF 结尾的一行, 超过 limit 个字节时返回 errTooBigCountString, 不会把整行读入内存.
+// limit 不大于 0 时不限制. 一行在读缓冲区中时直接返回缓冲区的切片, 只在下一次读取之前有效.
+// 返回的第三个值表示错误是否是 IO 错误.
+func readLimitedLine(bufferReader *bufio.Reader, limit int) ([]byte, error, bool) {
+	var line []byte
+	for {
+		fragment, err := bufferReader.ReadSlice('\n')
+		if limit > 0 && len(line)+len(fragment) > limit {
+			return nil, errTooBigCountString, false
+		}
+		if line == nil && err != bufio.ErrBufferFull {
+			line = fragment
+		} else {
+			line = append(line, fragment...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return line, err, true
+		}
+		return line, nil, false
+	}
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,50 @@
+package resp
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRequestDecoder_Limits(t *testing.T) {
+	limits := &Limits{MaxBulkLen: 8, MaxMultiBulkLen: 3, QueryBufferLimit: 12}
+	testCases := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"bulk", "*2\r\n$3\r\nget\r\n$9\r\n123456789\r\n", "invalid bulk length"},
+		{"multibulk", "*4\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n", "invalid multibulk length"},
+		{"query buffer", "*3\r\n$3\r\nset\r\n$5\r\nkey01\r\n$5\r\nvalue\r\n", "query buffer limit exceeded"},
+		{"count string", "*" + strings.Repeat("1", maxInlineLength) + "\r\n", "too big count string"},
+	}
+
+	for _, testCase := range testCases {
+		// 合法的命令之后是超过限制的命令, 之后的命令不再被解析
+		input := "*1\r\n$4\r\nping\r\n" + testCase.input + "*1\r\n$4\r\nping\r\n"
+		actual := decodeAll(t, NewRequestDecoder(strings.NewReader(input), limits))
+		expected := []string{"*1\r\n$4\r\nping\r\n", "-ERROR Protocol error: '" + testCase.err + "'\r\n"}
+		if strings.Join(actual, "|") != strings.Join(expected, "|") {
+			t.Errorf("%s: %q, 期望 %q", testCase.name, actual, expected)
+		}
+	}
+
+	// 不超过限制的命令
+	input := "*3\r\n$3\r\nset\r\n$1\r\nk\r\n$8\r\n12345678\r\n"
+	if actual := decodeAll(t, NewRequestDecoder(strings.NewReader(input), limits)); len(actual) != 1 || actual[0] != input {
+		t.Error("没有超过限制的命令解析错误.", actual)
+	}
+}
+
+func TestParser_IncrementalAllocation(t *testing.T) {
+	// 首部声明了很大的长度, 但实际只发送了很少的数据, 解析器不应该按声明的长度分配内存
+	input := "$1000000000\r\nhello" + "*100000000\r\n$1\r\na\r\n"
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	parseAll(t, CreateParser(strings.NewReader(input)))
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Error("解析器按声明的长度分配了内存.", allocated)
+	}
+}
@@ -2,45 +2,42 @@ package resp
 
 import (
 	"bufio"
+	"bytes"
 	"io"
+	"math"
+	"math/big"
 	"runtime/debug"
 	"simple_kvstorage/resp/reply"
 	"simple_kvstorage/util/logger"
 	"strconv"
+	"strings"
 )
 
 // Payload 解析器的输出
 type Payload struct {
 	Data  reply.Reply
 	Error error
+	// Offset 解析完此报文之后, 在流中已经读取的字节数.
+	// 对于 IO 错误, 包括了错误发生前读到的不完整的部分.
+	Offset int64
 }
 
 type parseState struct {
-	// 当前解析的报文的类型 `+ - * $ :`
-	msgType byte
+	// Bulk 中字节的数量, readingBulk 下一行是否是 Bulk 的内容 (此时读取 bulkLen + 2 个字节, 包括空字符串)
+	bulkLen     int64
+	readingBulk bool
 
-	// readingMultiLine 当前解析的报文是否为多行的, 即 Bulk 或 Multi Bulk
-	readingMultiLine bool
-
-	// Bulk 中字节的数量
-	bulkLen int64
-
-	// expectedArgsCount 预期的参数数量, 即当前指令应该需要的参数的数量
-	// 等于 MultiBulk 中 Bulk 的数量
-	expectedArgsCount int
-
-	// 已经解析好的部分
-	args [][]byte
+	// limits 解析客户端命令时的限制, nil 表示不限制. size 当前命令中已经声明的 Bulk 的总长度.
+	limits *Limits
+	size   int64
 }
 
-// finished 是否解析完毕
-func (s *parseState) finished() bool {
-	return s.expectedArgsCount > 0 && len(s.args) == s.expectedArgsCount
-}
+// maxNestingDepth 聚合类型嵌套的最大层数, 防止恶意的报文耗尽协程的栈
+const maxNestingDepth = 64
 
 // CreateParser 创建一个 RESP 协议的解析器, 其工作在另一个协程上.
 // 不断将 reader 中的字节流解析为 Payload 放入管道 parseChan 中.
-// 直到遇到 EOF 或其他 IO 错误, 才退出.
+// 直到遇到 EOF 或其他 IO 错误, 才退出. 解析客户端的命令使用 NewRequestDecoder.
 func CreateParser(reader io.Reader) <-chan *Payload {
 	parseChan := make(chan *Payload)
 	go func() {
@@ -55,114 +52,181 @@ func CreateParser(reader io.Reader) <-chan *Payload {
 	return parseChan
 }
 
+// parseToChan 用 Decoder 不断解析报文放入管道, 直到发生 IO 错误
 func parseToChan(reader io.Reader, parseChan chan<- *Payload) {
-	// 客户端连接在, 这个流就一直在
-	var bufferReader = bufio.NewReader(reader)
-
-	// 每一轮 for 循环, 调用一次 parse0 函数, 解析一个完整的命令请求报文
-	for continueParsing := true; continueParsing; {
-		// 1. 解析一个完整的报文
-		theReply, err := parse0(
-			func(state *parseState) ([]byte, error) {
-				line, err, isIOError := readLine(bufferReader, state)
-				if isIOError {
-					// 发生 IO 错误, 则需要关闭这个客户端了
-					continueParsing = false
-				}
-				return line, err
-			},
-		)
-
-		// 2. 解析出错则将错误放入管道
+	decoder := NewDecoder(reader)
+	for {
+		theReply, err := decoder.Decode()
 		if err != nil {
-			parseChan <- &Payload{Error: err}
+			parseChan <- &Payload{Error: err, Offset: decoder.Offset()}
+			if decoder.Err() != nil {
+				return
+			}
 			continue
 		}
-
-		// 3. 将解析正确的结果放入管道
-		parseChan <- &Payload{Data: theReply}
+		parseChan <- &Payload{Data: theReply, Offset: decoder.Offset()}
 	}
 }
 
 // parse0 解析一次完整的命令请求报文
 // nextLine func(*parseState) ([]byte, error) 用于获取下一行报文
+// limits 解析客户端命令时的限制, nil 表示不限制
 // 当 parse0 函数返回时, 表示其读取到了一个完整的报文
-func parse0(nextLine func(*parseState) ([]byte, error)) (reply.Reply, error) {
-	// 记录当前命令的解析状态
-	var state = parseState{}
+func parse0(nextLine func(*parseState) ([]byte, error), limits *Limits) (reply.Reply, error) {
+	return parseMessage(nextLine, &parseState{limits: limits})
+}
 
-	//  每一轮 for 循环解析一行报文
-	for {
-		line, err := nextLine(&state)
+// parseMessage 与 parse0 相同, state 是调用者提供的初始的解析状态, 供 Decoder 复用以减少内存分配
+func parseMessage(nextLine func(*parseState) ([]byte, error), state *parseState) (reply.Reply, error) {
+	line, err := nextLine(state)
+	if err != nil {
+		return nil, err
+	}
+	return parseValue(line, nextLine, state, 0)
+}
+
+// parseValue 解析以 header 这一行开始的一个报文, depth 是报文所在的聚合类型的层数
+func parseValue(header []byte, nextLine func(*parseState) ([]byte, error), state *parseState, depth int) (reply.Reply, error) {
+	switch getType(header) {
+	case '*', '%', '~', '>': // Multi Bulk, 以及 RESP3 的 Map, Set, Push
+		return parseAggregate(header, nextLine, state, depth)
+	case '$', '=', '!': // Bulk, 以及 RESP3 的 Verbatim String, Bulk Error
+		body, err := readBulk(header, nextLine, state)
+		if err != nil {
+			return nil, err
+		}
+		if body == nil {
+			return reply.GetNullBulkReply(), nil
+		}
+		switch getType(header) {
+		case '=':
+			// Verbatim String 的内容为 "格式:文本", 格式固定为三个字符
+			if len(body) < 4 || body[3] != ':' {
+				return nil, reply.NewProtocolErrorReply(string(body))
+			}
+			return reply.NewVerbatimReply(string(body[:3]), body[4:]), nil
+		case '!':
+			return reply.NewStandardErrorReply(string(body)), nil
+		}
+		return reply.NewBulkReply(body), nil
+	default:
+		return parserSingle(header)
+	}
+}
+
+// parseAggregate 解析聚合类型的首部与全部元素.
+// 元素都是 Bulk 时 (如客户端的命令) 保存为 [][]byte; 出现其他类型的元素时, 如 COMMAND INFO 的嵌套数组与
+// HELLO 的整数, 保存为回复的切片. 客户端的命令中只能有 Bulk.
+func parseAggregate(header []byte, nextLine func(*parseState) ([]byte, error), state *parseState, depth int) (reply.Reply, error) {
+	count, err := parseMultiBulkHeader(header, state)
+	if err != nil {
+		return nil, err
+	}
+	msgType := getType(header)
+	if count == -1 {
+		return reply.GetNullMultiBulkReply(), nil
+	}
+	if count == 0 {
+		return aggregateReply(msgType, nil), nil
+	}
+
+	args := make([][]byte, 0, min64(count, maxArgsPrealloc))
+	var elements []reply.Reply
+	for i := int64(0); i < count; i++ {
+		line, err := nextLine(state)
 		if err != nil {
 			return nil, err
 		}
 
-		if !state.readingMultiLine {
-			switch getType(line) {
-			case '*': // Multi Bulk
-				err := parseMultiBulkHeader(line, &state)
+		if elements == nil {
+			if getType(line) == '$' {
+				arg, err := readBulk(line, nextLine, state)
 				if err != nil {
 					return nil, err
 				}
-				if state.expectedArgsCount == 0 {
-					return reply.GetEmptyMultiBulkReply(), nil
-				}
-				// continue parse Multi Bulk Body
-			case '$': // Bulk
-				err := parseBulkHeader(line, &state)
-				if err != nil {
-					return nil, err
-				}
-				if state.bulkLen == -1 {
-					return reply.GetNullBulkReply(), nil
-				}
-				// continue parse Bulk Body
-			default:
-				single, err := parserSingle(line)
-				return single, err
+				args = append(args, arg)
+				continue
 			}
-		} else {
-			err := readBody(line, &state)
-			if err != nil {
-				return nil, err
+			if state.limits != nil || depth >= maxNestingDepth {
+				return nil, reply.NewProtocolErrorReply(string(line))
 			}
-
-			if state.finished() {
-				switch state.msgType {
-				case '*':
-					return reply.NewMultiBulkReply(state.args), nil
-				case '$':
-					return reply.NewBulkReply(state.args[0]), nil
-				}
+			// 之前的元素转换为回复
+			elements = make([]reply.Reply, 0, min64(count, maxArgsPrealloc))
+			for _, arg := range args {
+				elements = append(elements, bulkElement(arg))
 			}
+		}
 
-			// continue parse
+		element, err := parseValue(line, nextLine, state, depth+1)
+		if err != nil {
+			return nil, err
 		}
+		elements = append(elements, element)
 	}
+
+	if elements == nil {
+		return aggregateReply(msgType, args), nil
+	}
+	return aggregateOf(msgType, elements), nil
+}
+
+// readBulk 解析 Bulk 的首部并读取其内容, 内容是新分配的. $-1 时返回 nil.
+func readBulk(header []byte, nextLine func(*parseState) ([]byte, error), state *parseState) ([]byte, error) {
+	length, err := parseBulkHeader(header, state)
+	if err != nil || length == -1 {
+		return nil, err
+	}
+
+	state.bulkLen, state.readingBulk = length, true
+	body, err := nextLine(state)
+	state.bulkLen, state.readingBulk = 0, false
+	if err != nil {
+		return nil, err
+	}
+	return body[:len(body)-2], nil
 }
 
 // readLine 读取一行字符串. 可能是 Header (Multi Bulk Header, Bulk Header), Simple String 或 Bulk without Header.
 //
 // 分两种情况, 若不是多行字符串 (Bulk without Header), 则按 CRLF 为结尾划分; 若当前是多行字符串, 则读取给定的字节数.
 // 返回 一行报文的字节数组, 是否发生 IO 异常, 具体的异常.
+// 发生 IO 异常时, 返回的字节数组是异常发生前读到的不完整的部分.
+// 单行的报文可能直接引用读缓冲区, 只在下一次读取之前有效; Bulk 的内容是新分配的, 可以被命令保存.
 func readLine(bufferReader *bufio.Reader, state *parseState) ([]byte, error, bool) {
 	var line []byte
 	var err error
 
 	// 1. 根据情况来读取一行字符串
-	if state.bulkLen == 0 {
-		// Simple String: +......CRLF
-		line, err = bufferReader.ReadBytes('\n')
-	} else if state.bulkLen > 0 {
-		// Bulk: $字节长度CRLF......CRLF
-		line = make([]byte, state.bulkLen+2) // 为 CRLF 预留两字节空间
-		_, err = io.ReadFull(bufferReader, line)
-		state.bulkLen = 0
+	if !state.readingBulk {
+		// Simple String: +......CRLF. 客户端的命令中只有首部是单行的, 长度有限
+		limit := 0
+		if state.limits != nil {
+			limit = maxInlineLength
+		}
+		var isIOError bool
+		line, err, isIOError = readLimitedLine(bufferReader, limit)
+		if err != nil && !isIOError {
+			return nil, err, false
+		}
+	} else if state.bulkLen+2 <= maxBulkPrealloc {
+		// Bulk: $字节长度CRLF......CRLF, 为 CRLF 预留两字节空间. 较短的 Bulk 一次分配所需的空间
+		line = make([]byte, state.bulkLen+2)
+		var n int
+		n, err = io.ReadFull(bufferReader, line)
+		line = line[:n]
+	} else {
+		// 较长的 Bulk 的缓冲区随着数据的到达逐渐扩大, 不会按首部中声明的长度一次分配.
+		buffer := bytes.NewBuffer(make([]byte, 0, maxBulkPrealloc))
+		_, err = io.CopyN(buffer, bufferReader, state.bulkLen+2)
+		line = buffer.Bytes()
+		if err == io.EOF && len(line) > 0 {
+			// 与 io.ReadFull 相同, 读到部分数据之后遇到 EOF
+			err = io.ErrUnexpectedEOF
+		}
 	}
 
 	if err != nil {
-		return nil, err, true
+		return line, err, true
 	}
 
 	// 2. 若所读到的一行字符串不是以 CRLF 结尾, 则表示客户端发送的数据不符合协议
@@ -173,71 +237,78 @@ func readLine(bufferReader *bufio.Reader, state *parseState) ([]byte, error, boo
 	return line, nil, false
 }
 
-// parseMultiBulkHeader 解析 MultiBulk 的首部
+// parseMultiBulkHeader 解析 MultiBulk 的首部, 返回元素的个数, -1 表示 Null Array
 // MultiBulk: *元素个数CRLF   (Multi Bulk Header)
 //
 //	Bulk CRLF
 //	Bulk CRLF
 //	......
-func parseMultiBulkHeader(multiBulk []byte, state *parseState) error {
-	if len(multiBulk) < 4 || getType(multiBulk) != '*' || !isEndWithCRLF(multiBulk) {
-		return reply.NewProtocolErrorReply(string(multiBulk))
+//
+// RESP3 的 Map (%), Set (~), Push (>) 的首部格式相同, 其中 Map 的元素个数是键值对的数量, 返回的是键与值的总数.
+func parseMultiBulkHeader(multiBulk []byte, state *parseState) (int64, error) {
+	if len(multiBulk) < 4 || !isEndWithCRLF(multiBulk) {
+		return 0, reply.NewProtocolErrorReply(string(multiBulk))
 	}
 
 	// 读取 MultiBulk 中的元素个数, 即后续 Bulk 的数量
 	expectedBulkCount, err := strconv.ParseInt(string(multiBulk[1:len(multiBulk)-2]), 10, 32)
 	if err != nil || expectedBulkCount < -1 {
-		return reply.NewProtocolErrorReply(string(multiBulk))
+		return 0, reply.NewProtocolErrorReply(string(multiBulk))
 	}
 
 	switch {
-	case expectedBulkCount == -1: // *-1CRLF, 此处对 RESP 协议的实现将不识别 Null Array.
-		return reply.NewProtocolErrorReply(string(multiBulk))
-	case expectedBulkCount == 0: // *0CRLF 表示空数组 []
-		state.expectedArgsCount = 0
+	case expectedBulkCount == -1: // *-1CRLF 表示 Null Array
+		if getType(multiBulk) != '*' {
+			return 0, reply.NewProtocolErrorReply(string(multiBulk))
+		}
 	case expectedBulkCount > 0:
-		state.msgType = getType(multiBulk)
-		state.readingMultiLine = true // ?
-		state.expectedArgsCount = int(expectedBulkCount)
-		state.args = make([][]byte, 0, expectedBulkCount)
+		if getType(multiBulk) == '%' {
+			expectedBulkCount *= 2
+		}
+		if err := state.checkMultiBulkLen(expectedBulkCount); err != nil {
+			return 0, err
+		}
 	}
-	return nil
+	// *0CRLF 表示空数组 []
+	return expectedBulkCount, nil
 }
 
-// parseBulkHeader 解析 Bulk 的首部
+// parseBulkHeader 解析 Bulk 的首部, 返回内容的字节长度, -1 表示 nil
 // Bulk: $字节长度CRLF   (Bulk Header)
 //
 //	String CRLF
-//	String CRLF
-//	......
-func parseBulkHeader(bulk []byte, state *parseState) error {
-	if len(bulk) < 4 || getType(bulk) != '$' || !isEndWithCRLF(bulk) {
-		return reply.NewProtocolErrorReply(string(bulk))
+//
+// RESP3 的 Verbatim String (=) 与 Bulk Error (!) 的格式与 Bulk 相同
+func parseBulkHeader(bulk []byte, state *parseState) (int64, error) {
+	if len(bulk) < 4 || !isEndWithCRLF(bulk) {
+		return 0, reply.NewProtocolErrorReply(string(bulk))
 	}
 
 	// 读取 Bulk 中的的字节长度, 即后续 String 的长度
 	expectedStringLength, err := strconv.ParseInt(string(bulk[1:len(bulk)-2]), 10, 64)
 	if err != nil || expectedStringLength < -1 {
-		return reply.NewProtocolErrorReply(string(bulk))
+		return 0, reply.NewProtocolErrorReply(string(bulk))
 	}
 
-	switch {
-	case expectedStringLength == -1: // $-1CRLF 表示 nil
-		state.bulkLen = -1
-	case expectedStringLength == 0: // $0CRLF 表示空字符串 "", 此处对 RESP 协议的实现将不识别空字符串.
-		return reply.NewProtocolErrorReply(string(bulk))
-	default: // expectedStringLength > 0
-		state.bulkLen = expectedStringLength
-		state.msgType = getType(bulk)
-		state.readingMultiLine = true
-		state.expectedArgsCount = 1
-		state.args = make([][]byte, 0, 1)
+	if expectedStringLength == -1 { // $-1CRLF 表示 nil
+		if getType(bulk) != '$' {
+			return 0, reply.NewProtocolErrorReply(string(bulk))
+		}
+		return -1, nil
+	}
+	// $0CRLFCRLF 表示空字符串 ""
+	if err := state.checkBulkLen(expectedStringLength); err != nil {
+		return 0, err
 	}
-	return nil
+	return expectedStringLength, nil
 }
 
-// parserSingle 解析简单的单行报文, 包括 "+OK\r\n", "-Error message\r\n", ":1024\r\n"
+// parserSingle 解析简单的单行报文, 包括 "+OK\r\n", "-Error message\r\n", ":1024\r\n",
+// 以及 RESP3 的 "_\r\n", "#t\r\n", ",3.14\r\n", "(3492890328409238509324850943850943825024385\r\n"
 func parserSingle(single []byte) (reply.Reply, error) {
+	if len(single) == 3 && getType(single) == '_' && isEndWithCRLF(single) {
+		return reply.GetNullBulkReply(), nil
+	}
 	if len(single) < 3 || !isEndWithCRLF(single) {
 		return nil, reply.NewProtocolErrorReply(string(single))
 	}
@@ -255,38 +326,84 @@ func parserSingle(single []byte) (reply.Reply, error) {
 			return nil, reply.NewProtocolErrorReply(string(single))
 		}
 		return reply.NewIntReply(i), nil
+	case '#':
+		switch msg {
+		case "t":
+			return reply.NewBooleanReply(true), nil
+		case "f":
+			return reply.NewBooleanReply(false), nil
+		}
+		return nil, reply.NewProtocolErrorReply(string(single))
+	case ',':
+		f, err := parseDouble(msg)
+		if err != nil {
+			return nil, reply.NewProtocolErrorReply(string(single))
+		}
+		return reply.NewDoubleReply(f), nil
+	case '(':
+		n, ok := new(big.Int).SetString(msg, 10)
+		if !ok {
+			return nil, reply.NewProtocolErrorReply(string(single))
+		}
+		return reply.NewBigNumberReply(n), nil
 	default:
 		return nil, reply.NewProtocolErrorReply(string(single))
 	}
 }
 
-// readBody 读取 Multi Bulk 或 Bulk 的剩余部分
-func readBody(body []byte, state *parseState) error {
-	if getType(body) == '$' {
-		// 在 Multi Bulk Header 之后调用了 readBody
+// parseDouble 解析 RESP3 的浮点数, 包括 inf, -inf 与 nan
+func parseDouble(msg string) (float64, error) {
+	switch strings.ToLower(msg) {
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(msg, 64)
+}
 
-		if len(body) < 4 || !isEndWithCRLF(body) {
-			return reply.NewProtocolErrorReply(string(body))
+// aggregateReply 由解析好的 Bulk 元素构造聚合类型的回复
+func aggregateReply(msgType byte, args [][]byte) reply.Reply {
+	if msgType == '*' {
+		if len(args) == 0 {
+			return reply.GetEmptyMultiBulkReply()
 		}
+		return reply.NewMultiBulkReply(args)
+	}
 
-		expectedStringLength, err := strconv.ParseInt(string(body[1:len(body)-2]), 10, 64)
-		if err != nil || expectedStringLength < -1 {
-			return reply.NewProtocolErrorReply(string(body))
-		}
+	elements := make([]reply.Reply, len(args))
+	for i, arg := range args {
+		elements[i] = bulkElement(arg)
+	}
+	return aggregateOf(msgType, elements)
+}
 
-		switch {
-		case expectedStringLength <= 0: // expectedStringLength = -1 || 0
-			state.bulkLen = 0
-			state.args = append(state.args, []byte{})
-		default: // expectedStringLength > 0
-			state.bulkLen = expectedStringLength
+// aggregateOf 由任意类型的元素构造聚合类型的回复
+func aggregateOf(msgType byte, elements []reply.Reply) reply.Reply {
+	switch msgType {
+	case '*':
+		return reply.NewMultiRawReply(elements)
+	case '%':
+		entries := make([]reply.MapEntry, 0, len(elements)/2)
+		for i := 0; i+1 < len(elements); i += 2 {
+			entries = append(entries, reply.MapEntry{Key: elements[i], Value: elements[i+1]})
 		}
-	} else {
-		// 在 Bulk Header 之后调用了 readBody. 不会出现 getType(body) 为 * + - : 的情况
+		return reply.NewMapReply(entries)
+	case '~':
+		return reply.NewSetReply(elements)
+	default: // '>'
+		return reply.NewPushReply(elements)
+	}
+}
 
-		state.args = append(state.args, body[0:len(body)-2])
+// bulkElement 数组中的 Bulk 元素, nil 是 Null Bulk
+func bulkElement(arg []byte) reply.Reply {
+	if arg == nil {
+		return reply.GetNullBulkReply()
 	}
-	return nil
+	return reply.NewBulkReply(arg)
 }
 
 func isEndWithCRLF(s []byte) bool {
@@ -294,7 +411,7 @@ func isEndWithCRLF(s []byte) bool {
 	return l >= 2 && s[l-2] == '\r' && s[l-1] == '\n'
 }
 
-// getType 获取当前行的类型, `+ - * $ :`
+// getType 获取当前行的类型, `+ - * $ :` 以及 RESP3 的 `_ # , ( % ~ > = !`
 func getType(line []byte) byte {
 	return line[0]
 }
@@ -2,6 +2,7 @@ package resp
 
 import (
 	"io"
+	"simple_kvstorage/resp/reply"
 	"strings"
 	"testing"
 )
@@ -37,7 +38,7 @@ func TestParse0(t *testing.T) {
 	}
 
 	for i < len(testCases) {
-		reply, err := parse0(nextLine)
+		reply, err := parse0(nextLine, nil)
 		if err != nil {
 			t.Log(err)
 		} else {
@@ -81,3 +82,54 @@ func TestCreateParser(t *testing.T) {
 
 	t.Log(payloads)
 }
+
+func TestCreateParser_Resp3(t *testing.T) {
+	// 解析之后按 RESP3 重新编码, 应该与原来的报文相同
+	testCases := []string{
+		"_\r\n",
+		"#t\r\n",
+		"#f\r\n",
+		",3.14\r\n",
+		",-inf\r\n",
+		"(3492890328409238509324850943850943825024385\r\n",
+		"=15\r\ntxt:Some string\r\n",
+		"%2\r\n$5\r\nfirst\r\n$1\r\n1\r\n$6\r\nsecond\r\n$1\r\n2\r\n",
+		"~2\r\n$6\r\norange\r\n$5\r\napple\r\n",
+		">2\r\n$7\r\nmessage\r\n$5\r\nhello\r\n",
+		"%0\r\n",
+		// 嵌套与非 Bulk 的元素, 如 HELLO 3 与 COMMAND INFO 的回复
+		"%2\r\n$5\r\nproto\r\n:3\r\n$7\r\nmodules\r\n*0\r\n",
+		"*2\r\n*3\r\n$3\r\nget\r\n:2\r\n~1\r\n+readonly\r\n_\r\n",
+		"~2\r\n:1\r\n#t\r\n",
+	}
+
+	builder := strings.Builder{}
+	for _, testCase := range testCases {
+		builder.WriteString(testCase)
+	}
+	i := 0
+	for payload := range CreateParser(strings.NewReader(builder.String())) {
+		if payload.Error == io.EOF {
+			break
+		}
+		if payload.Error != nil {
+			t.Fatal("解析失败.", testCases[i], payload.Error)
+		}
+		if encoded := string(reply.Encode(payload.Data, reply.Resp3)); encoded != testCases[i] {
+			t.Errorf("解析结果错误. %q, 期望 %q", encoded, testCases[i])
+		}
+		i++
+	}
+	if i != len(testCases) {
+		t.Error("解析的报文数量错误.", i)
+	}
+
+	// Bulk Error 被解析为错误回复, 不合法的布尔值是协议错误
+	payloads := CreateParser(strings.NewReader("!5\r\nERROR\r\n#x\r\n"))
+	if payload := <-payloads; payload.Error != nil || !reply.IsErrorReply(payload.Data) {
+		t.Error("Bulk Error 解析错误.", payload)
+	}
+	if payload := <-payloads; payload.Error == nil {
+		t.Error("不合法的布尔值应该是协议错误.")
+	}
+}
@@ -10,6 +10,7 @@ var (
 	ok             = []byte("+OK" + CRLF)
 	nullBulk       = []byte("$-1" + CRLF)
 	emptyMultiBulk = []byte("*0" + CRLF)
+	nullMultiBulk  = []byte("*-1" + CRLF)
 	no             = []byte("")
 )
 
@@ -19,6 +20,7 @@ var (
 	okReply             = &OkReply{}
 	nullBulkReply       = &NullBulkReply{}
 	emptyMultiBulkReply = &EmptyMultiBulkReply{}
+	nullMultiBulkReply  = &NullMultiBulkReply{}
 	noReply             = &NoReply{}
 )
 
@@ -74,6 +76,19 @@ func GetEmptyMultiBulkReply() *EmptyMultiBulkReply {
 	return emptyMultiBulkReply
 }
 
+// NullMultiBulkReply 回复 nullMultiBulk (Null Array), 与空数组不同
+type NullMultiBulkReply struct {
+}
+
+func (*NullMultiBulkReply) ToBytes() []byte {
+	return nullMultiBulk
+}
+
+// GetNullMultiBulkReply 获取一个 NullMultiBulkReply 对象 (全局单例的)
+func GetNullMultiBulkReply() *NullMultiBulkReply {
+	return nullMultiBulkReply
+}
+
 // NoReply 没有回复
 type NoReply struct {
 }
@@ -10,13 +10,13 @@ type Reply interface {
 	ToBytes() []byte
 }
 
-// BulkReply 回复一个字符串
+// BulkReply 回复一个字符串. s 为 nil 时表示 nil, 长度为 0 的非 nil 切片表示空字符串.
 type BulkReply struct {
 	s []byte
 }
 
 func (r *BulkReply) ToBytes() []byte {
-	if len(r.s) == 0 {
+	if r.s == nil {
 		return nullBulk
 	}
 
@@ -27,6 +27,12 @@ func NewBulkReply(s []byte) *BulkReply {
 	return &BulkReply{s: s}
 }
 
+// Bytes 返回字符串的内容
+func (r *BulkReply) Bytes() []byte {
+	return r.s
+}
+
+// MultiBulkReply 回复一个元素都是字符串的数组, 值为 nil 的元素表示 nil
 type MultiBulkReply struct {
 	Args [][]byte
 }
@@ -48,6 +54,26 @@ func NewMultiBulkReply(args [][]byte) *MultiBulkReply {
 	return &MultiBulkReply{Args: args}
 }
 
+// MultiRawReply 回复一个数组, 其元素可以是任意类型的回复, 用于嵌套的数组
+type MultiRawReply struct {
+	Replies []Reply
+}
+
+func (r *MultiRawReply) ToBytes() []byte {
+	var bytesBuffer bytes.Buffer
+
+	bytesBuffer.WriteString("*" + strconv.Itoa(len(r.Replies)) + CRLF)
+	for _, reply := range r.Replies {
+		bytesBuffer.Write(reply.ToBytes())
+	}
+
+	return bytesBuffer.Bytes()
+}
+
+func NewMultiRawReply(replies []Reply) *MultiRawReply {
+	return &MultiRawReply{Replies: replies}
+}
+
 // StatusReply 回复状态
 type StatusReply struct {
 	Status string
@@ -1,6 +1,8 @@
 package reply
 
 import (
+	"math"
+	"math/big"
 	"testing"
 )
 
@@ -56,3 +58,37 @@ func TestErrorReply(t *testing.T) {
 		}
 	}
 }
+
+func TestResp3Reply(t *testing.T) {
+	bulk := func(s string) Reply { return NewBulkReply([]byte(s)) }
+	testCases := []struct {
+		reply Reply
+		resp2 string
+		resp3 string
+	}{
+		{NewMapReply([]MapEntry{{bulk("a"), NewIntReply(1)}}), "*2\r\n$1\r\na\r\n:1\r\n", "%1\r\n$1\r\na\r\n:1\r\n"},
+		{NewSetReply([]Reply{bulk("x")}), "*1\r\n$1\r\nx\r\n", "~1\r\n$1\r\nx\r\n"},
+		{NewPushReply([]Reply{bulk("message")}), "*1\r\n$7\r\nmessage\r\n", ">1\r\n$7\r\nmessage\r\n"},
+		{NewDoubleReply(1.5), "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{NewDoubleReply(math.Inf(-1)), "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{NewBooleanReply(true), ":1\r\n", "#t\r\n"},
+		{NewBigNumberReply(new(big.Int).Lsh(big.NewInt(1), 64)), "$20\r\n18446744073709551616\r\n", "(18446744073709551616\r\n"},
+		{NewVerbatimReply("txt", []byte("hi")), "$2\r\nhi\r\n", "=6\r\ntxt:hi\r\n"},
+		{GetNullBulkReply(), "$-1\r\n", "_\r\n"},
+		{NewAttributeReply([]MapEntry{{bulk("ttl"), NewIntReply(3)}}, NewBooleanReply(false)),
+			":0\r\n", "|1\r\n$3\r\nttl\r\n:3\r\n#f\r\n"},
+		// 聚合类型中的元素按相同的协议版本编码
+		{NewMultiRawReply([]Reply{NewMapReply([]MapEntry{{bulk("k"), GetNullBulkReply()}})}),
+			"*1\r\n*2\r\n$1\r\nk\r\n$-1\r\n", "*1\r\n%1\r\n$1\r\nk\r\n_\r\n"},
+		{NewStatusReply("OK"), "+OK\r\n", "+OK\r\n"},
+	}
+
+	for _, testCase := range testCases {
+		if resp2 := string(Encode(testCase.reply, Resp2)); resp2 != testCase.resp2 {
+			t.Errorf("RESP2 编码错误. %q, 期望 %q", resp2, testCase.resp2)
+		}
+		if resp3 := string(Encode(testCase.reply, Resp3)); resp3 != testCase.resp3 {
+			t.Errorf("RESP3 编码错误. %q, 期望 %q", resp3, testCase.resp3)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package reply
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// 协议版本, 客户端通过 HELLO 命令协商, 默认为 RESP2
+const (
+	Resp2 = 2
+	Resp3 = 3
+)
+
+var (
+	resp3Null  = []byte("_" + CRLF)
+	resp3True  = []byte("#t" + CRLF)
+	resp3False = []byte("#f" + CRLF)
+)
+
+// Resp3Reply 在 RESP3 下有原生类型的回复. ToBytes 返回 RESP2 的编码, 以保持与 RESP2 客户端的兼容.
+type Resp3Reply interface {
+	Reply
+	ToResp3Bytes() []byte
+}
+
+// Encode 按协议版本序列化回复
+func Encode(r Reply, protocol int) []byte {
+	if protocol == Resp3 {
+		if resp3Reply, ok := r.(Resp3Reply); ok {
+			return resp3Reply.ToResp3Bytes()
+		}
+	}
+	return r.ToBytes()
+}
+
+// encodeAggregate 序列化聚合类型的首部与全部元素, 元素按相同的协议版本序列化
+func encodeAggregate(prefix byte, count int, elements []Reply, protocol int) []byte {
+	var bytesBuffer bytes.Buffer
+	bytesBuffer.WriteString(string(prefix) + strconv.Itoa(count) + CRLF)
+	for _, element := range elements {
+		bytesBuffer.Write(Encode(element, protocol))
+	}
+	return bytesBuffer.Bytes()
+}
+
+func (r *MultiRawReply) ToResp3Bytes() []byte {
+	return encodeAggregate('*', len(r.Replies), r.Replies, Resp3)
+}
+
+// ToResp3Bytes RESP3 中的空值为 "_"
+func (*NullBulkReply) ToResp3Bytes() []byte {
+	return resp3Null
+}
+
+func (*NullMultiBulkReply) ToResp3Bytes() []byte {
+	return resp3Null
+}
+
+func (r *BulkReply) ToResp3Bytes() []byte {
+	if r.s == nil {
+		return resp3Null
+	}
+	return r.ToBytes()
+}
+
+func (r *MultiBulkReply) ToResp3Bytes() []byte {
+	var bytesBuffer bytes.Buffer
+	bytesBuffer.WriteString("*" + strconv.Itoa(len(r.Args)) + CRLF)
+	for _, arg := range r.Args {
+		bytesBuffer.Write((&BulkReply{s: arg}).ToResp3Bytes())
+	}
+	return bytesBuffer.Bytes()
+}
+
+// MapEntry MapReply 中的一个键值对
+type MapEntry struct {
+	Key   Reply
+	Value Reply
+}
+
+// MapReply 回复一个有序的映射, 如 HGETALL 与 HELLO 的结果. RESP2 下是键值交替排列的数组.
+type MapReply struct {
+	Entries []MapEntry
+}
+
+func (r *MapReply) flatten() []Reply {
+	elements := make([]Reply, 0, 2*len(r.Entries))
+	for _, entry := range r.Entries {
+		elements = append(elements, entry.Key, entry.Value)
+	}
+	return elements
+}
+
+func (r *MapReply) ToBytes() []byte {
+	return encodeAggregate('*', 2*len(r.Entries), r.flatten(), Resp2)
+}
+
+func (r *MapReply) ToResp3Bytes() []byte {
+	return encodeAggregate('%', len(r.Entries), r.flatten(), Resp3)
+}
+
+func NewMapReply(entries []MapEntry) *MapReply {
+	return &MapReply{Entries: entries}
+}
+
+// SetReply 回复一个无序的集合. RESP2 下是数组.
+type SetReply struct {
+	Members []Reply
+}
+
+func (r *SetReply) ToBytes() []byte {
+	return encodeAggregate('*', len(r.Members), r.Members, Resp2)
+}
+
+func (r *SetReply) ToResp3Bytes() []byte {
+	return encodeAggregate('~', len(r.Members), r.Members, Resp3)
+}
+
+func NewSetReply(members []Reply) *SetReply {
+	return &SetReply{Members: members}
+}
+
+// PushReply 服务端主动推送的消息, 如发布订阅的消息. RESP2 下是数组.
+type PushReply struct {
+	Data []Reply
+}
+
+func (r *PushReply) ToBytes() []byte {
+	return encodeAggregate('*', len(r.Data), r.Data, Resp2)
+}
+
+func (r *PushReply) ToResp3Bytes() []byte {
+	return encodeAggregate('>', len(r.Data), r.Data, Resp3)
+}
+
+func NewPushReply(data []Reply) *PushReply {
+	return &PushReply{Data: data}
+}
+
+// AttributeReply 为回复附加的辅助信息, 如 key 的访问频率. RESP2 下只发送回复本身.
+type AttributeReply struct {
+	Attributes []MapEntry
+	Reply      Reply
+}
+
+func (r *AttributeReply) ToBytes() []byte {
+	return Encode(r.Reply, Resp2)
+}
+
+func (r *AttributeReply) ToResp3Bytes() []byte {
+	attributes := &MapReply{Entries: r.Attributes}
+	attributesBytes := attributes.ToResp3Bytes()
+	attributesBytes[0] = '|'
+	return append(attributesBytes, Encode(r.Reply, Resp3)...)
+}
+
+func NewAttributeReply(attributes []MapEntry, reply Reply) *AttributeReply {
+	return &AttributeReply{Attributes: attributes, Reply: reply}
+}
+
+// DoubleReply 回复一个浮点数, 如 ZSCORE 的结果. RESP2 下是字符串.
+type DoubleReply struct {
+	Value float64
+}
+
+// formatDouble 按 RESP3 的格式输出浮点数, 无穷大为 inf 与 -inf
+func formatDouble(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "inf"
+	case math.IsInf(value, -1):
+		return "-inf"
+	case math.IsNaN(value):
+		return "nan"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func (r *DoubleReply) ToBytes() []byte {
+	return NewBulkReply([]byte(formatDouble(r.Value))).ToBytes()
+}
+
+func (r *DoubleReply) ToResp3Bytes() []byte {
+	return []byte("," + formatDouble(r.Value) + CRLF)
+}
+
+func NewDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{Value: value}
+}
+
+// BooleanReply 回复一个布尔值. RESP2 下是整数 1 或 0.
+type BooleanReply struct {
+	Value bool
+}
+
+func (r *BooleanReply) ToBytes() []byte {
+	if r.Value {
+		return []byte(":1" + CRLF)
+	}
+	return []byte(":0" + CRLF)
+}
+
+func (r *BooleanReply) ToResp3Bytes() []byte {
+	if r.Value {
+		return resp3True
+	}
+	return resp3False
+}
+
+func NewBooleanReply(value bool) *BooleanReply {
+	return &BooleanReply{Value: value}
+}
+
+// BigNumberReply 回复一个超出 64 位范围的整数. RESP2 下是字符串.
+type BigNumberReply struct {
+	Value *big.Int
+}
+
+func (r *BigNumberReply) ToBytes() []byte {
+	return NewBulkReply([]byte(r.Value.String())).ToBytes()
+}
+
+func (r *BigNumberReply) ToResp3Bytes() []byte {
+	return []byte("(" + r.Value.String() + CRLF)
+}
+
+func NewBigNumberReply(value *big.Int) *BigNumberReply {
+	return &BigNumberReply{Value: value}
+}
+
+// VerbatimReply 回复一段需要原样展示给用户的文本, 如 INFO 的结果. Format 是三个字符的格式, 如 txt 与 mkd.
+// RESP2 下是字符串.
+type VerbatimReply struct {
+	Format string
+	Text   []byte
+}
+
+func (r *VerbatimReply) ToBytes() []byte {
+	return NewBulkReply(r.Text).ToBytes()
+}
+
+func (r *VerbatimReply) ToResp3Bytes() []byte {
+	return []byte("=" + strconv.Itoa(len(r.Format)+1+len(r.Text)) + CRLF + r.Format + ":" + string(r.Text) + CRLF)
+}
+
+// NewVerbatimReply 创建 VerbatimReply, format 不是三个字符时使用 txt
+func NewVerbatimReply(format string, text []byte) *VerbatimReply {
+	if len(format) != 3 {
+		format = "txt"
+	}
+	return &VerbatimReply{Format: format, Text: text}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// closeTimeout 连接关闭时, 等待客户端读取剩余回复的最长时间
+const closeTimeout = time.Second
+
+// bufferedConn 内存连接中服务端的一侧. net.Pipe 没有缓冲区, 写操作要等到对方读取才返回:
+// 客户端一次发送一大批命令时, 服务端写回复会等待正在写命令的客户端, 两边互相等待.
+// 因此回复先放入缓冲区, 由单独的协程写入管道, 与 TCP 连接的发送缓冲区的作用相同.
+type bufferedConn struct {
+	net.Conn
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	pending []byte
+	closed  bool
+	// err 写入管道时发生的错误, 之后的写操作都返回它
+	err error
+}
+
+func newBufferedConn(conn net.Conn) *bufferedConn {
+	c := &bufferedConn{Conn: conn}
+	c.cond = sync.NewCond(&c.mutex)
+	go c.writeLoop()
+	return c
+}
+
+func (c *bufferedConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	c.pending = append(c.pending, b...)
+	c.cond.Signal()
+	return len(b), nil
+}
+
+// writeLoop 将缓冲区中的回复写入管道. 连接关闭之后写完剩余的回复再关闭管道.
+func (c *bufferedConn) writeLoop() {
+	var data []byte
+	for {
+		c.mutex.Lock()
+		for len(c.pending) == 0 && !c.closed && c.err == nil {
+			c.cond.Wait()
+		}
+		if len(c.pending) == 0 || c.err != nil {
+			c.mutex.Unlock()
+			_ = c.Conn.Close()
+			return
+		}
+		// 交换缓冲区, 写管道时不持有锁
+		data, c.pending = c.pending, data[:0]
+		c.mutex.Unlock()
+
+		if _, err := c.Conn.Write(data); err != nil {
+			c.mutex.Lock()
+			c.err = err
+			c.mutex.Unlock()
+		}
+	}
+}
+
+// Close 关闭连接. 还没有写入的回复在 closeTimeout 之内发送给客户端.
+func (c *bufferedConn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	c.closed = true
+	// 客户端不再读取时, 最后的写操作在截止时间返回
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	c.cond.Signal()
+	return nil
+}
+
+// SetWriteDeadline 写操作只是放入缓冲区, 不会阻塞, 因此忽略写的截止时间
+func (c *bufferedConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// SetDeadline 只设置读的截止时间, 见 SetWriteDeadline
+func (c *bufferedConn) SetDeadline(t time.Time) error {
+	return c.Conn.SetReadDeadline(t)
+}
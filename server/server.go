@@ -0,0 +1,349 @@
+// Package server 组装服务器: 数据库, 持久化, 命令处理器, 以及主从复制, 集群与 Raft 模式.
+//
+// main.go 由配置文件创建 Server 之后在 TCP 上提供服务. 测试与命令行工具也可以在进程内嵌入 Server,
+// 不监听网络, 用 Do 执行命令, 或用 Conn 建立内存中的连接直接收发 RESP:
+//
+//	s, err := server.Open(server.Options{})
+//	defer s.Close()
+//	err = s.Do(ctx, "set", "key", "value").Err()
+//	value, err := s.Client().Get(ctx, "key")
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"simple_kvstorage/client"
+	"simple_kvstorage/cluster"
+	"simple_kvstorage/config"
+	"simple_kvstorage/core"
+	"simple_kvstorage/database"
+	"simple_kvstorage/erasure"
+	_ "simple_kvstorage/executor/command" // For execute the `init` function
+	"simple_kvstorage/persistent"
+	"simple_kvstorage/raft"
+	"simple_kvstorage/replication"
+	"simple_kvstorage/resp"
+	"simple_kvstorage/util/logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrClosed 服务器已经关闭
+var ErrClosed = errors.New("server: closed")
+
+// Options 嵌入模式的配置, 值为 0 的字段使用默认值
+type Options struct {
+	// Dir 存放 AOF 目录与快照文件的目录, 不存在时创建. 为空时不持久化, 也不加载任何文件, 数据只保存在内存中.
+	Dir string
+	// Databases 数据库的数量, 默认 16
+	Databases int
+	// AppendOnly 是否开启 AOF, AppendFsync AOF 的刷盘策略, 默认 everysec. 只在 Dir 不为空时有效.
+	AppendOnly  bool
+	AppendFsync string
+	// Save 自动生成快照的规则, 形如 "900 1 300 10", 为空时不自动生成快照. 只在 Dir 不为空时有效.
+	Save string
+	// MaxMemory 内存上限, 0 表示不限制. MaxMemoryPolicy 达到上限时的淘汰策略, 默认 noeviction.
+	MaxMemory       int64
+	MaxMemoryPolicy string
+
+	// Config 完整的服务器配置, 与配置文件的含义相同, 文件路径按原样使用. 不为 nil 时忽略以上字段,
+	// 并按配置开启主从复制, 集群与 Raft 模式.
+	Config *config.ServerProperties
+}
+
+// properties 将 Options 转换为完整的配置, 第二个返回值表示是否加载与写入持久化文件
+func (o *Options) properties() (*config.ServerProperties, bool, error) {
+	if o.Config != nil {
+		return o.Config, true, nil
+	}
+	props := config.Default()
+	if o.Databases > 0 {
+		props.Databases = o.Databases
+	}
+	if o.AppendFsync != "" {
+		props.AppendFsync = o.AppendFsync
+	}
+	if o.MaxMemoryPolicy != "" {
+		props.MaxMemoryPolicy = o.MaxMemoryPolicy
+	}
+	props.MaxMemory = o.MaxMemory
+	if o.Dir == "" {
+		return props, false, nil
+	}
+	if err := os.MkdirAll(o.Dir, 0755); err != nil {
+		return nil, false, err
+	}
+	props.AppendOnly = o.AppendOnly
+	props.AppendDirname = filepath.Join(o.Dir, props.AppendDirname)
+	props.DbFilename = filepath.Join(o.Dir, props.DbFilename)
+	props.Save = o.Save
+	return props, true, nil
+}
+
+// Server 组装好的服务器
+type Server struct {
+	handler *core.Handler
+	dbs     []database.DB
+	// requirePass 设置了密码时, 内存连接上的客户端先以它认证
+	requirePass string
+
+	// client 在内存连接上执行 Do 的客户端, 第一次使用时创建
+	clientOnce sync.Once
+	client     *client.Client
+
+	mutex  sync.Mutex
+	closed bool
+}
+
+// Open 按配置创建服务器: 加载持久化的数据, 创建命令处理器, 开启配置的各种模式. 不监听网络.
+func Open(opts Options) (*Server, error) {
+	props, persist, err := opts.properties()
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. 创建数据存储引擎
+	dbs := make([]database.DB, props.Databases)
+	for i := range dbs {
+		dbs[i] = database.NewConcurrentDB(i)
+	}
+
+	// 2. 加载持久化的数据并创建持久化引擎. Raft 模式下数据由 Raft 的日志和快照恢复与持久化.
+	var aofPersistent persistent.Persistent
+	var snapshotter *persistent.Snapshotter
+	if persist && !props.RaftEnabled {
+		if aofPersistent, snapshotter, err = setupPersistence(props, dbs); err != nil {
+			return nil, err
+		}
+	}
+
+	// 3. 创建命令处理器
+	coreHandler := core.NewHandler(dbs, aofPersistent)
+	coreHandler.SetRequirePass(props.RequirePass)
+	coreHandler.SetProtocolLimits(resp.Limits{
+		MaxBulkLen:       props.ProtoMaxBulkLen,
+		MaxMultiBulkLen:  int64(props.ProtoMaxMultiBulkLen),
+		QueryBufferLimit: props.ClientQueryBufferLimit,
+	})
+	coreHandler.SetSlowLog(time.Duration(props.SlowLogLogSlowerThan)*time.Microsecond, props.SlowLogMaxLen)
+	if outputLimit, err := core.ParseOutputBufferLimit(props.ClientOutputBufferLimit); err != nil {
+		logger.Warn(err, "不限制客户端的输出缓冲区.")
+	} else {
+		coreHandler.SetOutputBufferLimit(outputLimit)
+	}
+	policy, err := database.ParseEvictionPolicy(props.MaxMemoryPolicy)
+	if err != nil {
+		logger.Warn(err, "使用 noeviction.")
+	}
+	if props.RaftEnabled && policy != database.NoEviction {
+		// 淘汰不经过 Raft 日志, 会使各节点的数据不一致
+		logger.Warn("Raft 模式下使用 noeviction.")
+		policy = database.NoEviction
+	}
+	coreHandler.SetEvictor(database.NewEvictor(dbs, props.MaxMemory, policy, props.MaxMemorySamples))
+	if snapshotter != nil {
+		coreHandler.SetSnapshotter(snapshotter)
+	}
+
+	// 3.1. Raft 模式, 写命令经 Raft 日志复制到 self 与 peers 组成的集群, 不使用主从复制与集群模式
+	if props.RaftEnabled {
+		if err := setupRaft(props, coreHandler); err != nil {
+			_ = coreHandler.Close()
+			return nil, fmt.Errorf("开启 Raft 模式失败. %w", err)
+		}
+	}
+
+	// 4. 主从复制
+	if !props.RaftEnabled {
+		masterAuth := props.MasterAuth
+		if masterAuth == "" {
+			masterAuth = props.RequirePass
+		}
+		replicationManager := replication.NewManager(&replication.Config{
+			BacklogSize:   int(props.ReplBacklogSize),
+			ListeningPort: props.Port,
+			MasterAuth:    masterAuth,
+		}, dbs)
+		coreHandler.SetReplication(replicationManager)
+		if master := strings.Fields(props.ReplicaOf); len(master) == 2 {
+			replicationManager.ReplicaOf(net.JoinHostPort(master[0], master[1]))
+		}
+	}
+
+	// 4.1. 数据主体擦除
+	if props.ErasureRules != "" {
+		rules, err := erasure.LoadRules(props.ErasureRules)
+		if err != nil {
+			_ = coreHandler.Close()
+			return nil, fmt.Errorf("读取擦除规则失败. %w", err)
+		}
+		coreHandler.SetErasure(rules, []byte(props.ErasureSigningKey))
+	}
+
+	// 4.2. 集群模式
+	if !props.RaftEnabled && props.Self != "" && len(props.Peers) > 0 {
+		c, err := cluster.New(&cluster.Config{
+			Self:     props.Self,
+			Peers:    props.Peers,
+			Forward:  props.ClusterForward,
+			Password: props.RequirePass,
+		}, dbs[0])
+		if err != nil {
+			_ = coreHandler.Close()
+			return nil, fmt.Errorf("开启集群模式失败. %w", err)
+		}
+		coreHandler.SetRouter(c)
+	}
+
+	return &Server{handler: coreHandler, dbs: dbs, requirePass: props.RequirePass}, nil
+}
+
+// setupPersistence 加载持久化的数据, 然后创建持久化引擎.
+// 与 Redis 相同, 只从一处加载: 开启 AOF 时加载 AOF, 否则加载快照. AOF 记录了完整的数据, 若在快照之上重放,
+// 生成快照之后删除的 key 会重新出现.
+func setupPersistence(props *config.ServerProperties, dbs []database.DB) (persistent.Persistent, *persistent.Snapshotter, error) {
+	saveRules, err := persistent.ParseSaveRules(props.Save)
+	if err != nil {
+		logger.Warn(err, "不自动生成快照.")
+	}
+	snapshotter := persistent.NewSnapshotter(&persistent.SnapshotConfig{
+		Filename:  props.DbFilename,
+		SaveRules: saveRules,
+	}, dbs)
+
+	if props.AppendOnly {
+		_, err = persistent.LoadAof(props.AppendDirname, props.AppendFilename, dbs, &persistent.AofLoadConfig{
+			LoadTruncated:    props.AofLoadTruncated,
+			IgnoreCorruption: props.AofLoadIgnoreCorruption,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("AOF 加载失败, 服务器拒绝启动. %w", err)
+		}
+	} else if loaded, err := snapshotter.Load(); err != nil {
+		return nil, nil, fmt.Errorf("快照加载失败. %w", err)
+	} else if loaded > 0 {
+		logger.Info("从快照中加载了", loaded, "个键值对.")
+	}
+
+	// 创建持久化引擎, 在加载之后打开 AOF 文件, 因为加载时可能会截断它
+	var aofPersistent persistent.Persistent
+	if props.AppendOnly {
+		fsync, err := persistent.ParseFsyncPolicy(props.AppendFsync)
+		if err != nil {
+			logger.Warn(err, "使用 everysec.")
+		}
+		aof, err := persistent.NewAofPersistent(&persistent.AofConfig{
+			Dirname:               props.AppendDirname,
+			Filename:              props.AppendFilename,
+			Fsync:                 fsync,
+			AutoRewritePercentage: props.AutoAofRewritePercentage,
+			AutoRewriteMinSize:    props.AutoAofRewriteMinSize,
+		}, dbs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("AOF 文件打开失败. %w", err)
+		}
+		aofPersistent = aof
+	}
+
+	return aofPersistent, snapshotter, nil
+}
+
+// setupRaft 创建 Raft 节点, 节点的 ID 是 self, 初始成员是 self 与 peers
+func setupRaft(props *config.ServerProperties, coreHandler *core.Handler) error {
+	if props.Self == "" {
+		return errors.New("raft mode requires 'self'")
+	}
+	if props.RaftSecret == "" {
+		return errors.New("raft mode requires 'raft-secret'")
+	}
+	storage, err := raft.NewFileStorage(props.RaftDir)
+	if err != nil {
+		return err
+	}
+
+	var peers []string
+	for _, peer := range props.Peers {
+		if peer = strings.TrimSpace(peer); peer != "" && peer != props.Self {
+			peers = append(peers, peer)
+		}
+	}
+	node, err := raft.NewNode(&raft.Config{
+		ID:                props.Self,
+		Peers:             peers,
+		Join:              props.RaftJoin,
+		ElectionTimeout:   time.Second,
+		HeartbeatInterval: 100 * time.Millisecond,
+		SnapshotThreshold: uint64(props.RaftSnapshotThreshold),
+		Transport:         raft.NewNetworkTransport(500*time.Millisecond, props.RaftSecret),
+		Storage:           storage,
+		StateMachine:      coreHandler.RaftStateMachine(),
+	})
+	if err != nil {
+		return err
+	}
+	coreHandler.SetRaft(node, props.RaftSecret)
+	return nil
+}
+
+// Handler 返回命令处理器, 用于在 TCP 等连接上提供服务
+func (s *Server) Handler() *core.Handler {
+	return s.handler
+}
+
+// DB 返回第 index 个数据库
+func (s *Server) DB(index int) database.DB {
+	return s.dbs[index]
+}
+
+// Conn 建立一个内存中的连接, 与 TCP 连接一样收发 RESP, 连接上的状态 (数据库, 协议版本, 名字等) 与其他连接相互独立.
+// 使用之后需要关闭.
+func (s *Server) Conn() (net.Conn, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	serverSide, clientSide := net.Pipe()
+	go s.handler.Handle(newBufferedConn(serverSide), context.Background())
+	return clientSide, nil
+}
+
+// Client 返回使用内存连接的客户端, 可以被多个协程同时使用. 它在服务器关闭时被关闭.
+func (s *Server) Client() *client.Client {
+	s.clientOnce.Do(func() {
+		opts := client.Options{Dialer: s.Conn, MaxRetries: -1}
+		if s.requirePass != "" {
+			opts.InitCommands = [][]any{{"auth", s.requirePass}}
+		}
+		s.client = client.New(opts)
+	})
+	return s.client
+}
+
+// Do 在内存连接上执行一条命令, 参数与 client.Client.Do 相同. 命令的执行与 TCP 连接上的命令完全相同.
+func (s *Server) Do(ctx context.Context, args ...any) *client.Cmd {
+	return s.Client().Do(ctx, args...)
+}
+
+// Close 关闭全部内存连接, 关闭持久化引擎与各种模式. 之后不能再使用服务器.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrClosed
+	}
+	s.closed = true
+	s.mutex.Unlock()
+
+	// 等待可能正在进行的 Client 创建完成, 之后 s.client 不再改变
+	s.clientOnce.Do(func() {})
+	if s.client != nil {
+		_ = s.client.Close()
+	}
+	return s.handler.Close()
+}
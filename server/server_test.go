@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"simple_kvstorage/config"
+	"simple_kvstorage/resp"
+	"simple_kvstorage/resp/reply"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestServer(t *testing.T, opts Options) *Server {
+	s, err := Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestServer_Do(t *testing.T) {
+	s := openTestServer(t, Options{Databases: 2})
+	ctx := context.Background()
+
+	if err := s.Do(ctx, "set", "a", 1).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Client().Get(ctx, "a"); err != nil || v != "1" {
+		t.Error("GET 错误.", v, err)
+	}
+	if _, ok := s.DB(0).Get("a"); !ok {
+		t.Error("应该写入 DB 0.")
+	}
+	if err := s.Do(ctx, "select", 2).Err(); err == nil {
+		t.Error("只有 2 个数据库.")
+	}
+	if err := s.Do(ctx, "nosuchcommand").Err(); err == nil {
+		t.Error("未知的命令应该返回错误.")
+	}
+
+	// 嵌套的回复与 TCP 连接上的相同
+	info, err := s.Do(ctx, "command", "info", "get").Slice()
+	if err != nil || len(info) != 1 {
+		t.Fatal("COMMAND INFO 错误.", info, err)
+	}
+	if fields, ok := info[0].([]any); !ok || len(fields) != 7 || fields[0] != "get" || fields[1] != int64(2) {
+		t.Error("GET 的信息错误.", info[0])
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := s.Do(canceled, "get", "a").Err(); err != context.Canceled {
+		t.Error("ctx 被取消时应该返回 ctx 的错误.", err)
+	}
+}
+
+func TestServer_Conn(t *testing.T) {
+	s := openTestServer(t, Options{})
+	conn, err := s.Conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// 与客户端一样先写完一批命令再读取回复. 命令与回复都远大于输出缓冲区, 两边不应该互相等待.
+	const n = 20000
+	var batch bytes.Buffer
+	for i := 0; i < n; i++ {
+		key := []byte("key:" + strconv.Itoa(i))
+		batch.Write(reply.NewMultiBulkReply([][]byte{[]byte("set"), key, key}).ToBytes())
+		batch.Write(reply.NewMultiBulkReply([][]byte{[]byte("get"), key}).ToBytes())
+	}
+	if _, err := conn.Write(batch.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	decoder := resp.NewDecoder(conn)
+	for i := 0; i < n; i++ {
+		if r, err := decoder.Decode(); err != nil {
+			t.Fatal(i, err)
+		} else if _, ok := r.(*reply.StatusReply); !ok {
+			t.Fatal("SET 的回复错误.", i, string(r.ToBytes()))
+		}
+		r, err := decoder.Decode()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if bulk, ok := r.(*reply.BulkReply); !ok || string(bulk.Bytes()) != "key:"+strconv.Itoa(i) {
+			t.Fatal("GET 的回复错误.", i, string(r.ToBytes()))
+		}
+	}
+	if s.DB(0).Size() != n {
+		t.Error("key 的数量错误.", s.DB(0).Size())
+	}
+
+	// 每个连接的状态相互独立
+	if _, err := conn.Write(reply.NewMultiBulkReply([][]byte{[]byte("select"), []byte("1")}).ToBytes()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decoder.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Client().Get(context.Background(), "key:0"); err != nil || v != "key:0" {
+		t.Error("其他连接应该仍然使用 DB 0.", v, err)
+	}
+}
+
+func TestServer_RequirePass(t *testing.T) {
+	props := config.Default()
+	props.RequirePass = "secret"
+	props.DbFilename = filepath.Join(t.TempDir(), "dump.rdb")
+	s := openTestServer(t, Options{Config: props})
+
+	// Do 使用的客户端自动认证, 其他内存连接需要先认证
+	if err := s.Do(context.Background(), "set", "a", 1).Err(); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := s.Conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(reply.NewMultiBulkReply([][]byte{[]byte("get"), []byte("a")}).ToBytes()); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := resp.NewDecoder(conn).Decode(); err != nil || !strings.HasPrefix(string(r.ToBytes()), "-NOAUTH") {
+		t.Error("没有认证的连接应该回复 NOAUTH.", r, err)
+	}
+}
+
+func TestServer_Persistence(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	ctx := context.Background()
+
+	s, err := Open(Options{Dir: dir, AppendOnly: true, AppendFsync: "always"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Do(ctx, "set", "a", "persisted").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != ErrClosed {
+		t.Error("重复关闭应该返回 ErrClosed.", err)
+	}
+	if _, err := s.Conn(); err != ErrClosed {
+		t.Error("关闭之后不能再建立连接.", err)
+	}
+	if err := s.Do(ctx, "get", "a").Err(); err == nil {
+		t.Error("关闭之后命令应该失败.")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "appendonlydir")); err != nil {
+		t.Error("AOF 应该写入 Dir 中.", err)
+	}
+
+	s = openTestServer(t, Options{Dir: dir, AppendOnly: true})
+	if v, err := s.Client().Get(ctx, "a"); err != nil || v != "persisted" {
+		t.Error("重新打开之后应该加载 AOF 中的数据.", v, err)
+	}
+
+	// 不指定 Dir 时不读写任何文件
+	memory := openTestServer(t, Options{AppendOnly: true})
+	if _, ok := memory.DB(0).Get("a"); ok {
+		t.Error("内存模式不应该加载文件.")
+	}
+	if err := memory.Do(ctx, "save").Err(); err == nil {
+		t.Error("内存模式没有快照.")
+	}
+}
+
+func TestServer_LoadOneSource(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	ctx := context.Background()
+
+	// 快照之后删除的 key 只记录在 AOF 中, 重写之后的基础文件也不包含它
+	s := openTestServer(t, Options{Dir: dir, AppendOnly: true})
+	for _, args := range [][]any{{"set", "k", "v"}, {"save"}, {"del", "k"}, {"set", "a", "1"}, {"bgrewriteaof"}} {
+		if err := s.Do(ctx, args...).Err(); err != nil {
+			t.Fatal(args, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 开启 AOF 时只加载 AOF
+	s = openTestServer(t, Options{Dir: dir, AppendOnly: true})
+	if _, ok := s.DB(0).Get("k"); ok {
+		t.Error("快照之后删除的 key 不应该重新出现.")
+	}
+	if _, ok := s.DB(0).Get("a"); !ok {
+		t.Error("应该加载 AOF 中的数据.")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 关闭 AOF 时只加载快照
+	s = openTestServer(t, Options{Dir: dir})
+	if _, ok := s.DB(0).Get("k"); !ok {
+		t.Error("应该加载快照中的数据.")
+	}
+	if _, ok := s.DB(0).Get("a"); ok {
+		t.Error("关闭 AOF 时不应该加载 AOF.")
+	}
+}
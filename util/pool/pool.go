@@ -0,0 +1,175 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrClosed 连接池已经关闭
+var ErrClosed = errors.New("pool closed")
+
+// Config 连接池的配置
+type Config struct {
+	// MaxIdle 最多保留的空闲对象数量
+	MaxIdle int
+	// MaxActive 最多同时存在的对象数量 (包括空闲的), 0 表示不限制
+	MaxActive int
+}
+
+// Pool 对象池, 用于复用与其他节点的连接.
+// 对象数量达到 MaxActive 时, Get 会等待其他协程归还对象.
+type Pool[T any] struct {
+	config    Config
+	factory   func() (T, error)
+	finalizer func(T)
+
+	mutex  sync.Mutex
+	idles  []T
+	active int
+	closed bool
+	// waiting 等待对象的协程, 归还对象时唤醒第一个
+	waiting []chan T
+}
+
+// New 创建对象池, factory 创建新的对象, finalizer 销毁对象
+func New[T any](factory func() (T, error), finalizer func(T), config Config) *Pool[T] {
+	return &Pool[T]{config: config, factory: factory, finalizer: finalizer}
+}
+
+// Get 取出一个对象, 没有空闲对象时创建一个
+func (p *Pool[T]) Get() (T, error) {
+	return p.GetContext(context.Background())
+}
+
+// GetContext 与 Get 相同, 等待其他协程归还对象时可以被 ctx 取消
+func (p *Pool[T]) GetContext(ctx context.Context) (T, error) {
+	var zero T
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return zero, ErrClosed
+	}
+	if n := len(p.idles); n > 0 {
+		x := p.idles[n-1]
+		p.idles = p.idles[:n-1]
+		p.mutex.Unlock()
+		return x, nil
+	}
+	if p.config.MaxActive > 0 && p.active >= p.config.MaxActive {
+		wait := make(chan T, 1)
+		p.waiting = append(p.waiting, wait)
+		p.mutex.Unlock()
+		select {
+		case x, ok := <-wait:
+			if !ok {
+				return zero, ErrClosed
+			}
+			return x, nil
+		case <-ctx.Done():
+			return zero, p.cancelWait(wait, ctx.Err())
+		}
+	}
+	p.active++
+	p.mutex.Unlock()
+
+	x, err := p.factory()
+	if err != nil {
+		p.mutex.Lock()
+		p.active--
+		p.mutex.Unlock()
+		return zero, err
+	}
+	return x, nil
+}
+
+// cancelWait 放弃等待. 对象已经交给了 wait 时将它归还, 以免名额丢失.
+func (p *Pool[T]) cancelWait(wait chan T, err error) error {
+	p.mutex.Lock()
+	for i, w := range p.waiting {
+		if w == wait {
+			p.waiting = append(p.waiting[:i], p.waiting[i+1:]...)
+			p.mutex.Unlock()
+			return err
+		}
+	}
+	p.mutex.Unlock()
+
+	if x, ok := <-wait; ok {
+		p.Put(x)
+	}
+	return err
+}
+
+// Put 归还一个对象
+func (p *Pool[T]) Put(x T) {
+	p.mutex.Lock()
+	if p.closed {
+		p.active--
+		p.mutex.Unlock()
+		p.finalizer(x)
+		return
+	}
+	if len(p.waiting) > 0 {
+		wait := p.waiting[0]
+		p.waiting = p.waiting[1:]
+		p.mutex.Unlock()
+		wait <- x
+		return
+	}
+	if len(p.idles) >= p.config.MaxIdle {
+		p.active--
+		p.mutex.Unlock()
+		p.finalizer(x)
+		return
+	}
+	p.idles = append(p.idles, x)
+	p.mutex.Unlock()
+}
+
+// Discard 销毁一个已经损坏的对象, 而不是归还它
+func (p *Pool[T]) Discard(x T) {
+	p.finalizer(x)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.waiting) > 0 && !p.closed {
+		// 空出了一个名额, 为等待的协程创建一个新的对象
+		wait := p.waiting[0]
+		p.waiting = p.waiting[1:]
+		go func() {
+			x, err := p.factory()
+			if err != nil {
+				p.mutex.Lock()
+				p.active--
+				p.mutex.Unlock()
+				close(wait)
+				return
+			}
+			wait <- x
+		}()
+		return
+	}
+	p.active--
+}
+
+// Close 关闭对象池, 销毁全部空闲对象. 之后归还的对象会被直接销毁.
+func (p *Pool[T]) Close() {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return
+	}
+	p.closed = true
+	idles := p.idles
+	p.idles = nil
+	p.active -= len(idles)
+	for _, wait := range p.waiting {
+		close(wait)
+	}
+	p.waiting = nil
+	p.mutex.Unlock()
+
+	for _, x := range idles {
+		p.finalizer(x)
+	}
+}
@@ -0,0 +1,69 @@
+package pool
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	created, destroyed := 0, 0
+	p := New(func() (int, error) {
+		created++
+		return created, nil
+	}, func(int) {
+		destroyed++
+	}, Config{MaxIdle: 1, MaxActive: 2})
+
+	a, _ := p.Get()
+	b, _ := p.Get()
+	if a == b || created != 2 {
+		t.Fatal("应该创建两个对象.")
+	}
+
+	// 达到 MaxActive 时等待其他协程归还
+	got := make(chan int)
+	go func() {
+		x, _ := p.Get()
+		got <- x
+	}()
+	select {
+	case <-got:
+		t.Fatal("达到 MaxActive 时不应该创建新的对象.")
+	case <-time.After(50 * time.Millisecond):
+	}
+	p.Put(a)
+	if x := <-got; x != a {
+		t.Fatal("应该得到归还的对象.")
+	}
+
+	// 空闲对象超过 MaxIdle 时被销毁
+	p.Put(a)
+	p.Put(b)
+	if destroyed != 1 {
+		t.Error("超过 MaxIdle 的对象应该被销毁.", destroyed)
+	}
+	p.Close()
+	if destroyed != 2 {
+		t.Error("关闭时应该销毁空闲对象.", destroyed)
+	}
+	if _, err := p.Get(); err != ErrClosed {
+		t.Error("关闭后不能再取出对象.", err)
+	}
+}
+
+func TestPool_GetContext(t *testing.T) {
+	p := New(func() (int, error) { return 1, nil }, func(int) {}, Config{MaxIdle: 1, MaxActive: 1})
+	a, _ := p.Get()
+
+	// 等待时被取消, 不会占用名额
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.GetContext(ctx); err != context.DeadlineExceeded {
+		t.Fatal("等待应该被取消.", err)
+	}
+	p.Put(a)
+	if x, err := p.GetContext(context.Background()); err != nil || x != a {
+		t.Error("取消等待之后应该可以取出归还的对象.", x, err)
+	}
+}